@@ -0,0 +1,143 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// accountConfig is an account in the accounts file:
+//
+//	[[account]]
+//	id = "123456789012"
+//	alias = "production"
+//	role_name = "OrganizationAccountAccessRole"
+//	regions = ["us-east-1", "eu-west-1"]
+type accountConfig struct {
+	ID         string   `toml:"id"`
+	Alias      string   `toml:"alias"`
+	RoleName   string   `toml:"role_name"`
+	ExternalID string   `toml:"external_id"`
+	Regions    []string `toml:"regions"`
+}
+
+type accountsFile struct {
+	Accounts []*accountConfig `toml:"account"`
+}
+
+var accountIDPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+var (
+	accountsFilePath string
+	accountsList     []string
+	allAccounts      bool
+)
+
+func defaultAccountsFilePath() string {
+	return path.Join(GetUserHomeDir(), ".config", "yawsi", "accounts.toml")
+}
+
+func (a *accountConfig) roleArn() string {
+	return fmt.Sprintf("arn:aws:iam::%s:role/%s", a.ID, a.RoleName)
+}
+
+// name is how the account is shown in the Account column
+func (a *accountConfig) name() string {
+	if len(a.Alias) != 0 {
+		return a.Alias
+	}
+	return a.ID
+}
+
+func loadAccounts(filePath string) ([]*accountConfig, error) {
+	var f accountsFile
+	if _, err := toml.DecodeFile(filePath, &f); err != nil {
+		return nil, newUsageError("Error reading accounts file %s: %v", filePath, err)
+	}
+	for i, account := range f.Accounts {
+		if !accountIDPattern.MatchString(account.ID) {
+			return nil, newUsageError("Invalid account id %q for account %d in %s", account.ID, i+1, filePath)
+		}
+		if len(account.RoleName) == 0 {
+			return nil, newUsageError("No role_name specified for account %s in %s", account.name(), filePath)
+		}
+	}
+	return f.Accounts, nil
+}
+
+// selectedAccounts returns the accounts specified via --accounts (by alias
+// or ID) or all the accounts for --all-accounts
+func selectedAccounts() ([]*accountConfig, error) {
+	filePath := accountsFilePath
+	if len(filePath) == 0 {
+		filePath = defaultAccountsFilePath()
+	}
+	accounts, err := loadAccounts(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if allAccounts {
+		return accounts, nil
+	}
+
+	var selected []*accountConfig
+	for _, name := range accountsList {
+		name = strings.TrimSpace(name)
+		var found *accountConfig
+		for _, account := range accounts {
+			if account.ID == name || account.Alias == name {
+				found = account
+				break
+			}
+		}
+		if found == nil {
+			return nil, newUsageError("Account %q not found in %s", name, filePath)
+		}
+		selected = append(selected, found)
+	}
+	return selected, nil
+}
+
+// The credentials for each account role are shared by the sessions for all
+// the regions, so that we assume the role once per account
+var accountCredentials = struct {
+	sync.Mutex
+	creds map[string]*credentials.Credentials
+}{creds: make(map[string]*credentials.Credentials)}
+
+// createAccountSession returns a session for the region using the role of
+// the account, which is assumed using the credentials from createSession()
+func createAccountSession(account *accountConfig, region string) *session.Session {
+	sess := createSession(region)
+
+	accountCredentials.Lock()
+	creds, ok := accountCredentials.creds[account.ID]
+	if !ok {
+		creds = assumeRoleCredentials(sess, account.roleArn(), account.ExternalID, roleSessionName)
+		accountCredentials.creds[account.ID] = creds
+	}
+	accountCredentials.Unlock()
+
+	return sess.Copy(&aws.Config{Credentials: creds})
+}
@@ -15,17 +15,83 @@
 package cmd
 
 import (
-	"log"
+	"fmt"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/spf13/cobra"
 )
 
+type asgOutput struct {
+	resourceLocation  `yaml:",inline"`
+	Name              string   `json:"name" yaml:"name"`
+	MinSize           int64    `json:"minSize" yaml:"minSize"`
+	MaxSize           int64    `json:"maxSize" yaml:"maxSize"`
+	DesiredCapacity   int64    `json:"desiredCapacity" yaml:"desiredCapacity"`
+	AvailabilityZones []string `json:"availabilityZones" yaml:"availabilityZones"`
+	InstanceIds       []string `json:"instanceIds" yaml:"instanceIds"`
+}
+
+func buildAutoScalingGroupList(loc resourceLocation, autoScalingGroups []*autoscaling.Group) ([]asgOutput, *tableOutput) {
+	items := []asgOutput{}
+	table := newTableOutput("Name", "Min", "Max", "Desired", "Instances", "AvailabilityZones").in(loc)
+
+	for _, group := range autoScalingGroups {
+		item := asgOutput{
+			resourceLocation:  loc,
+			Name:              *group.AutoScalingGroupName,
+			MinSize:           *group.MinSize,
+			MaxSize:           *group.MaxSize,
+			DesiredCapacity:   *group.DesiredCapacity,
+			AvailabilityZones: aws.StringValueSlice(group.AvailabilityZones),
+			InstanceIds:       []string{},
+		}
+		for _, instance := range group.Instances {
+			item.InstanceIds = append(item.InstanceIds, *instance.InstanceId)
+		}
+		items = append(items, item)
+		table.addRow(
+			item.Name,
+			fmt.Sprintf("%d", item.MinSize),
+			fmt.Sprintf("%d", item.MaxSize),
+			fmt.Sprintf("%d", item.DesiredCapacity),
+			fmt.Sprintf("%d", len(item.InstanceIds)),
+			strings.Join(item.AvailabilityZones, ","),
+		)
+	}
+	return items, table
+}
+
+func displayAutoScalingGroups(autoScalingGroups []*autoscaling.Group) error {
+	items, table := buildAutoScalingGroupList(resourceLocation{}, autoScalingGroups)
+	return renderItems("AutoScalingGroupList", items, table)
+}
+
+func listAllAutoScalingGroups() error {
+	return renderAll("AutoScalingGroupList", func(loc resourceLocation, c awsClientProvider) (interface{}, *tableOutput, error) {
+		var autoScalingGroups []*autoscaling.Group
+		err := c.AutoScaling().DescribeAutoScalingGroupsPages(&autoscaling.DescribeAutoScalingGroupsInput{},
+			func(result *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+				autoScalingGroups = append(autoScalingGroups, result.AutoScalingGroups...)
+				return !lastPage
+			})
+		if err != nil {
+			return nil, nil, err
+		}
+		items, table := buildAutoScalingGroupList(loc, autoScalingGroups)
+		return items, table, nil
+	})
+}
+
 // listAsgCmd represents the listAsg command
 var listAsgCmd = &cobra.Command{
 	Use:   "list-asgs",
 	Short: "List Autoscaling Groups",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if isMultiTarget() {
+			return listAllAutoScalingGroups()
+		}
 
 		// Default to 100 here, not sure how this works
 		// with paging when we have  more than 100 ASGs
@@ -33,11 +99,15 @@ var listAsgCmd = &cobra.Command{
 		params := &autoscaling.DescribeAutoScalingGroupsInput{
 			MaxRecords: &maxSize,
 		}
-		autoScalingGroups := getAutoScalingGroups(params)
-		log.Printf("%v\n", autoScalingGroups)
+		autoScalingGroups, err := getAutoScalingGroups(getClients().AutoScaling(), params)
+		if err != nil {
+			return err
+		}
+		return displayAutoScalingGroups(autoScalingGroups)
 	},
 }
 
 func init() {
 	asgCmd.AddCommand(listAsgCmd)
+	addListingFlags(listAsgCmd)
 }
@@ -1,7 +1,68 @@
 package cmd
 
-import "github.com/spf13/cobra"
-import "os"
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/spf13/cobra"
+)
+
+// completionFunctions are the custom bash completion functions. They get
+// the resource IDs via `yawsi __complete`, which uses the response cache.
+const completionFunctions = `__yawsi_complete()
+{
+    local values
+    values=$("${words[0]}" __complete "$1" 2>/dev/null) || return
+    COMPREPLY=( $(compgen -W "${values}" -- "$cur") )
+}
+
+__yawsi_instance_ids()
+{
+    __yawsi_complete instance-ids
+}
+
+__yawsi_vpc_ids()
+{
+    __yawsi_complete vpc-ids
+}
+
+__custom_func()
+{
+    case ${last_command} in
+        yawsi_ec2_inspect | yawsi_ec2_inspect_connectivity | yawsi_ec2_inspect_routing-tables | \
+        yawsi_ec2_ssh-linux | yawsi_ec2_rdp-windows | yawsi_ec2_get-windows-password | yawsi_ec2_launch-more-like)
+            __yawsi_instance_ids
+            ;;
+    esac
+}
+`
+
+// completionValues returns the IDs of a kind of resource for the shell
+// completion
+func completionValues(svc ec2iface.EC2API, kind string) ([]string, error) {
+	var values []string
+	switch kind {
+	case "instance-ids":
+		var instanceIDs []*string
+		if err := getEC2InstanceIDs(svc, nil, &instanceIDs); err != nil {
+			return nil, err
+		}
+		values = aws.StringValueSlice(instanceIDs)
+	case "vpc-ids":
+		result, err := getVpcs(svc)
+		if err != nil {
+			return nil, err
+		}
+		for _, vpc := range result.Vpcs {
+			values = append(values, *vpc.VpcId)
+		}
+	default:
+		return nil, newUsageError("Unknown kind of resource %q", kind)
+	}
+	return values, nil
+}
 
 // completionCmd represents the completion command
 var bashCompletionCmd = &cobra.Command{
@@ -15,12 +76,34 @@ To configure your bash shell to load completions for each session add to your ba
 
 # ~/.bashrc or ~/.profile
 . <(yawsi generate-bash-completion)
+
+Instance and VPC IDs are completed using the response cache (see yawsi cache).
 `,
-	Run: func(cmd *cobra.Command, args []string) {
-		RootCmd.GenBashCompletion(os.Stdout)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RootCmd.GenBashCompletion(os.Stdout)
+	},
+}
+
+// completeCmd is called by the completion functions
+var completeCmd = &cobra.Command{
+	Use:    "__complete <instance-ids|vpc-ids>",
+	Short:  "List resource IDs for the shell completion",
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		values, err := completionValues(getClients().CachedEC2(), args[0])
+		if err != nil {
+			return err
+		}
+		for _, value := range values {
+			fmt.Fprintln(outputWriter, value)
+		}
+		return nil
 	},
+	Args: cobra.ExactArgs(1),
 }
 
 func init() {
 	RootCmd.AddCommand(bashCompletionCmd)
+	RootCmd.AddCommand(completeCmd)
+	RootCmd.BashCompletionFunction = completionFunctions
 }
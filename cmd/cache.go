@@ -0,0 +1,287 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const defaultCacheTTL = 5 * time.Minute
+
+// Set via the global flags on RootCmd
+var (
+	noCache      bool
+	refreshCache bool
+	cacheTTL     time.Duration
+)
+
+// cacheDirPath is where the responses are stored, tests point it at a
+// temporary directory
+var cacheDirPath string
+
+func getCacheDirPath() string {
+	if len(cacheDirPath) != 0 {
+		return cacheDirPath
+	}
+	if p := os.Getenv("YAWSI_CACHE_DIR"); len(p) != 0 {
+		return p
+	}
+	return path.Join(GetUserHomeDir(), ".cache", "yawsi")
+}
+
+// cacheEntry is the file stored for each response
+type cacheEntry struct {
+	Scope     string          `json:"scope"`
+	API       string          `json:"api"`
+	FetchedAt time.Time       `json:"fetchedAt"`
+	Response  json.RawMessage `json:"response"`
+}
+
+// responseCache stores AWS API responses on disk, keyed by the account and
+// region (the scope), the API call and its input. Entries older than the
+// TTL are fetched again, as are all the entries when refresh is set.
+type responseCache struct {
+	dir     string
+	ttl     time.Duration
+	refresh bool
+}
+
+// validateCacheFlags rejects a negative --cache-ttl. A TTL of 0 doesn't use
+// the cache, the same as --no-cache.
+func validateCacheFlags() error {
+	if cacheTTL < 0 {
+		return fmt.Errorf("Invalid --cache-ttl %v, it can't be negative (use 0 to not cache the responses)", cacheTTL)
+	}
+	return nil
+}
+
+func cacheDisabled() bool {
+	return noCache || cacheTTL == 0
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{dir: getCacheDirPath(), ttl: cacheTTL, refresh: refreshCache}
+}
+
+func (c *responseCache) entryPath(scope string, api string, input interface{}) (string, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	key := sha256.Sum256([]byte(scope + "\n" + api + "\n" + string(data)))
+	return path.Join(c.dir, fmt.Sprintf("%x.json", key)), nil
+}
+
+func (c *responseCache) read(filePath string, output interface{}) bool {
+	if c.refresh {
+		return false
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || time.Since(entry.FetchedAt) > c.ttl {
+		return false
+	}
+	return json.Unmarshal(entry.Response, output) == nil
+}
+
+// write stores the entry via a temporary file, so that the fuzzy finder
+// goroutines never read a partially written entry
+func (c *responseCache) write(filePath string, entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(c.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filePath)
+}
+
+// fetch populates output with the cached response for the call or, when
+// there isn't a fresh one, with the response returned by call. Failing to
+// use the cache isn't an error, we just talk to AWS.
+func (c *responseCache) fetch(scope string, api string, input interface{}, output interface{}, call func() (interface{}, error)) error {
+	filePath, err := c.entryPath(scope, api, input)
+	if err == nil && c.read(filePath, output) {
+		return nil
+	}
+
+	response, err := call()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	if len(filePath) != 0 {
+		c.write(filePath, &cacheEntry{Scope: scope, API: api, FetchedAt: time.Now(), Response: data})
+	}
+	return json.Unmarshal(data, output)
+}
+
+// cacheFilePattern matches the names of the entries (see entryPath) and of
+// the temporary files left behind by an interrupted write
+var cacheFilePattern = regexp.MustCompile(`^([0-9a-f]{64}\.json|tmp-[0-9]+)$`)
+
+// clear removes the cached responses. Only the files written by the cache
+// are removed, the directory could be shared via YAWSI_CACHE_DIR.
+func (c *responseCache) clear() error {
+	files, err := ioutil.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || !cacheFilePattern.MatchString(f.Name()) {
+			continue
+		}
+		if err := os.Remove(path.Join(c.dir, f.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// cachedEC2 serves the describe calls used by the interactive pickers and
+// shell completion from the cache. The other calls go to AWS.
+type cachedEC2 struct {
+	ec2iface.EC2API
+	cache *responseCache
+	scope string
+}
+
+func newCachedEC2(svc ec2iface.EC2API, cache *responseCache, scope string) *cachedEC2 {
+	return &cachedEC2{EC2API: svc, cache: cache, scope: scope}
+}
+
+// uncachedEC2 returns the client talking to AWS for svc, used where stale
+// data would be a problem such as the details of the instance selected
+func uncachedEC2(svc ec2iface.EC2API) ec2iface.EC2API {
+	if c, ok := svc.(*cachedEC2); ok {
+		return c.EC2API
+	}
+	return svc
+}
+
+func (c *cachedEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	output := &ec2.DescribeInstancesOutput{}
+	err := c.cache.fetch(c.scope, "ec2:DescribeInstances", input, output, func() (interface{}, error) {
+		return c.EC2API.DescribeInstances(input)
+	})
+	return output, err
+}
+
+// DescribeInstancesPages fetches all the pages, which are then handed to fn
+// in turn
+func (c *cachedEC2) DescribeInstancesPages(input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	var pages []*ec2.DescribeInstancesOutput
+	err := c.cache.fetch(c.scope, "ec2:DescribeInstancesPages", input, &pages, func() (interface{}, error) {
+		var pages []*ec2.DescribeInstancesOutput
+		err := c.EC2API.DescribeInstancesPages(input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			pages = append(pages, page)
+			return true
+		})
+		return pages, err
+	})
+	if err != nil {
+		return err
+	}
+	for i, page := range pages {
+		if !fn(page, i == len(pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (c *cachedEC2) DescribeNetworkInterfaces(input *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	output := &ec2.DescribeNetworkInterfacesOutput{}
+	err := c.cache.fetch(c.scope, "ec2:DescribeNetworkInterfaces", input, output, func() (interface{}, error) {
+		return c.EC2API.DescribeNetworkInterfaces(input)
+	})
+	return output, err
+}
+
+func (c *cachedEC2) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	output := &ec2.DescribeSubnetsOutput{}
+	err := c.cache.fetch(c.scope, "ec2:DescribeSubnets", input, output, func() (interface{}, error) {
+		return c.EC2API.DescribeSubnets(input)
+	})
+	return output, err
+}
+
+func (c *cachedEC2) DescribeRouteTables(input *ec2.DescribeRouteTablesInput) (*ec2.DescribeRouteTablesOutput, error) {
+	output := &ec2.DescribeRouteTablesOutput{}
+	err := c.cache.fetch(c.scope, "ec2:DescribeRouteTables", input, output, func() (interface{}, error) {
+		return c.EC2API.DescribeRouteTables(input)
+	})
+	return output, err
+}
+
+func (c *cachedEC2) DescribeVpcs(input *ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
+	output := &ec2.DescribeVpcsOutput{}
+	err := c.cache.fetch(c.scope, "ec2:DescribeVpcs", input, output, func() (interface{}, error) {
+		return c.EC2API.DescribeVpcs(input)
+	})
+	return output, err
+}
+
+// cacheScope returns the account and region the session talks to. The
+// account ID is looked up via STS using the access key of the session, and
+// is itself cached.
+func (c *sessionClients) cacheScope(cache *responseCache) (string, error) {
+	creds, err := c.sess.Config.Credentials.Get()
+	if err != nil {
+		return "", err
+	}
+	identity := &sts.GetCallerIdentityOutput{}
+	err = cache.fetch("credentials/"+creds.AccessKeyID, "sts:GetCallerIdentity", &sts.GetCallerIdentityInput{}, identity, func() (interface{}, error) {
+		return sts.New(c.sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(identity.Account) + "/" + aws.StringValue(c.sess.Config.Region), nil
+}
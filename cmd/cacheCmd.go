@@ -0,0 +1,58 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the response cache",
+	Long: `The interactive pickers (e.g. ec2 describe-instances, ec2 ssh-linux, vpc list --details) and the
+shell completion cache the responses from AWS in ~/.cache/yawsi (or YAWSI_CACHE_DIR) for --cache-ttl,
+per account and region.
+
+Use --refresh to fetch the responses again, or --no-cache (or --cache-ttl 0) to not use the cache at all:
+
+	$ yawsi ec2 ssh-linux --refresh
+	$ yawsi --no-cache vpc list --details
+
+The TTL can be set in the config file:
+
+	[defaults]
+	cache-ttl = "10m"
+`,
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove all the cached responses",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cache := newResponseCache()
+		if err := cache.clear(); err != nil {
+			return fmt.Errorf("Couldn't clear the cache: %w", err)
+		}
+		return nil
+	},
+	Args: cobra.NoArgs,
+}
+
+func init() {
+	RootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+}
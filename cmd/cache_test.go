@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingEC2 counts the calls which reach the fake
+type countingEC2 struct {
+	ec2iface.EC2API
+	calls int
+}
+
+func (c *countingEC2) DescribeVpcs(input *ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
+	c.calls++
+	return c.EC2API.DescribeVpcs(input)
+}
+
+func (c *countingEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	c.calls++
+	return c.EC2API.DescribeInstances(input)
+}
+
+func (c *countingEC2) DescribeInstancesPages(input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	c.calls++
+	return c.EC2API.DescribeInstancesPages(input, fn)
+}
+
+func newTestCache(t *testing.T) (*responseCache, func()) {
+	dir, err := ioutil.TempDir("", "yawsi-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &responseCache{dir: dir, ttl: time.Minute}, func() { os.RemoveAll(dir) }
+}
+
+func TestCachedEC2(t *testing.T) {
+	cache, cleanup := newTestCache(t)
+	defer cleanup()
+
+	c := newConnectivityFixture()
+	c.ec2.Vpcs = []*ec2.Vpc{{VpcId: aws.String("vpc-1"), CidrBlock: aws.String("10.0.0.0/16")}}
+	svc := &countingEC2{EC2API: c.EC2()}
+	cached := newCachedEC2(svc, cache, "123456789012/us-east-1")
+
+	for i := 0; i < 2; i++ {
+		result, err := getVpcs(cached)
+		if assert.NoError(t, err) && assert.Len(t, result.Vpcs, 1) {
+			assert.Equal(t, "10.0.0.0/16", *result.Vpcs[0].CidrBlock)
+		}
+	}
+	assert.Equal(t, 1, svc.calls)
+
+	// All the pages are cached
+	for i := 0; i < 2; i++ {
+		states, err := getBasicEC2InstanceData(cached, nil, aws.String("i-dst"))
+		if assert.NoError(t, err) && assert.Len(t, states, 1) {
+			assert.Equal(t, "running", states[0].State)
+		}
+	}
+	assert.Equal(t, 2, svc.calls)
+
+	// The responses are cached per account and region
+	getVpcs(newCachedEC2(svc, cache, "123456789012/eu-west-1"))
+	assert.Equal(t, 3, svc.calls)
+
+	// Not for the details of the selected instance
+	_, err := getBasicEC2InstanceData(uncachedEC2(cached), nil, aws.String("i-dst"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, svc.calls)
+
+	cache.refresh = true
+	getVpcs(cached)
+	assert.Equal(t, 5, svc.calls)
+
+	// A response older than the TTL is fetched again
+	cache.refresh, cache.ttl = false, 0
+	getVpcs(cached)
+	assert.Equal(t, 6, svc.calls)
+
+	// Only the entries are removed, not the other files in the directory
+	other := path.Join(cache.dir, "notes.json")
+	assert.NoError(t, ioutil.WriteFile(other, []byte("{}"), 0600))
+	assert.NoError(t, cache.clear())
+	files, err := ioutil.ReadDir(cache.dir)
+	if assert.NoError(t, err) && assert.Len(t, files, 1) {
+		assert.Equal(t, "notes.json", files[0].Name())
+	}
+	getVpcs(cached)
+	assert.Equal(t, 7, svc.calls)
+
+	cache.dir = path.Join(cache.dir, "missing")
+	assert.NoError(t, cache.clear())
+}
+
+func TestCompletionValues(t *testing.T) {
+	c := newConnectivityFixture()
+
+	values, err := completionValues(c.EC2(), "instance-ids")
+	if assert.NoError(t, err) {
+		assert.ElementsMatch(t, []string{"i-src", "i-dst"}, values)
+	}
+	_, err = completionValues(c.EC2(), "buckets")
+	assert.Equal(t, exitUsage, exitCode(err))
+}
+
+func TestInstanceListingRows(t *testing.T) {
+	c := newConnectivityFixture()
+	c.ec2.Instances[0].Tags = []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("web")}}
+	svc := &countingEC2{EC2API: c.EC2()}
+
+	// The rows of the picker come from the listing, without describing each
+	// instance again
+	var listing instanceListing
+	assert.NoError(t, listEC2Instances(svc, nil, &listing))
+	assert.Equal(t, []string{"i-src", "i-dst"}, aws.StringValueSlice(listing.IDs))
+	assert.Equal(t, "[i-src] - web - running", listing.row(aws.String("i-src")))
+	assert.Equal(t, "[i-dst] -  - running", listing.row(aws.String("i-dst")))
+	assert.Equal(t, "[i-gone]", listing.row(aws.String("i-gone")))
+	assert.Equal(t, 1, svc.calls)
+}
+
+func TestCacheTTL(t *testing.T) {
+	defer func() { cacheTTL = 0 }()
+
+	// A TTL of 0 doesn't use the cache
+	cacheTTL = 0
+	assert.NoError(t, validateCacheFlags())
+	_, cached := newSessionClients(session.New()).CachedEC2().(*cachedEC2)
+	assert.False(t, cached)
+
+	cacheTTL = -time.Minute
+	if assert.Error(t, validateCacheFlags()) {
+		assert.Equal(t, "Invalid --cache-ttl -1m0s, it can't be negative (use 0 to not cache the responses)", validateCacheFlags().Error())
+	}
+
+	cacheTTL = time.Minute
+	assert.NoError(t, validateCacheFlags())
+	assert.Equal(t, time.Minute, newResponseCache().ttl)
+}
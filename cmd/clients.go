@@ -0,0 +1,139 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/cloudtrail/cloudtrailiface"
+	"github.com/aws/aws-sdk-go/service/databasemigrationservice"
+	"github.com/aws/aws-sdk-go/service/databasemigrationservice/databasemigrationserviceiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// awsClientProvider hands out the AWS service clients used by the
+// commands. Helpers accept the *iface interfaces rather than creating their
+// own clients, so that a fakeClients can be used instead of talking to AWS.
+// CachedEC2 is used by the interactive pickers and shell completion, see
+// responseCache.
+type awsClientProvider interface {
+	EC2() ec2iface.EC2API
+	CachedEC2() ec2iface.EC2API
+	AutoScaling() autoscalingiface.AutoScalingAPI
+	EKS() eksiface.EKSAPI
+	IAM() iamiface.IAMAPI
+	CloudTrail() cloudtrailiface.CloudTrailAPI
+	DMS() databasemigrationserviceiface.DatabaseMigrationServiceAPI
+	Route53() route53iface.Route53API
+	RDS() rdsiface.RDSAPI
+	Lambda() lambdaiface.LambdaAPI
+	ELBV2() elbv2iface.ELBV2API
+	STS() stsiface.STSAPI
+}
+
+// sessionClients creates clients backed by a real AWS session
+type sessionClients struct {
+	sess *session.Session
+}
+
+func newSessionClients(sess *session.Session) *sessionClients {
+	return &sessionClients{sess: sess}
+}
+
+func (c *sessionClients) EC2() ec2iface.EC2API {
+	return ec2.New(c.sess)
+}
+
+// CachedEC2 falls back to EC2() with --no-cache, --cache-ttl 0 or when we
+// can't find out the account to cache the responses for
+func (c *sessionClients) CachedEC2() ec2iface.EC2API {
+	if cacheDisabled() {
+		return c.EC2()
+	}
+	cache := newResponseCache()
+	scope, err := c.cacheScope(cache)
+	if err != nil {
+		return c.EC2()
+	}
+	return newCachedEC2(c.EC2(), cache, scope)
+}
+
+func (c *sessionClients) AutoScaling() autoscalingiface.AutoScalingAPI {
+	return autoscaling.New(c.sess)
+}
+
+func (c *sessionClients) EKS() eksiface.EKSAPI {
+	return eks.New(c.sess)
+}
+
+func (c *sessionClients) IAM() iamiface.IAMAPI {
+	return iam.New(c.sess)
+}
+
+func (c *sessionClients) CloudTrail() cloudtrailiface.CloudTrailAPI {
+	return cloudtrail.New(c.sess)
+}
+
+func (c *sessionClients) DMS() databasemigrationserviceiface.DatabaseMigrationServiceAPI {
+	return databasemigrationservice.New(c.sess)
+}
+
+func (c *sessionClients) Route53() route53iface.Route53API {
+	return route53.New(c.sess)
+}
+
+func (c *sessionClients) RDS() rdsiface.RDSAPI {
+	return rds.New(c.sess)
+}
+
+func (c *sessionClients) Lambda() lambdaiface.LambdaAPI {
+	return lambda.New(c.sess)
+}
+
+func (c *sessionClients) ELBV2() elbv2iface.ELBV2API {
+	return elbv2.New(c.sess)
+}
+
+func (c *sessionClients) STS() stsiface.STSAPI {
+	return sts.New(c.sess)
+}
+
+// clients is the provider used by the commands. It is created on first
+// use, tests replace it with a fakeClients.
+var clients awsClientProvider
+
+func getClients() awsClientProvider {
+	if clients == nil {
+		clients = newSessionClients(createSession())
+	}
+	return clients
+}
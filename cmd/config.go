@@ -0,0 +1,238 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// configSettings are flag values, keyed by the flag name
+type configSettings map[string]interface{}
+
+// configScope holds the defaults for all the commands and for specific
+// commands, keyed by the command path without "yawsi" (e.g. "ec2 ssh-linux")
+type configScope struct {
+	Defaults configSettings            `toml:"defaults,omitempty"`
+	Commands map[string]configSettings `toml:"commands,omitempty"`
+}
+
+// yawsiConfig is the user configuration file:
+//
+//	[defaults]
+//	region = "eu-west-1"
+//
+//	[commands."ec2 ssh-linux"]
+//	username = "ec2-user"
+//
+//	[environments.production.defaults]
+//	profile = "production"
+type yawsiConfig struct {
+	configScope
+	Environments map[string]*configScope `toml:"environments,omitempty"`
+}
+
+// Flags which are used to find the configuration itself
+var (
+	configFilePath     string
+	configEnvironment  string
+	configIgnoredFlags = map[string]bool{"config": true, "env": true, "help": true}
+)
+
+// Other environment variables which set a flag, checked after YAWSI_<FLAG>
+var flagEnvAliases = map[string][]string{
+	"profile":            {"AWS_PROFILE"},
+	"region":             {"AWS_REGION"},
+	"cloudflare-api-key": {"CF_API_KEY"},
+	"cloudflare-email":   {"CF_API_EMAIL"},
+}
+
+func defaultConfigFilePath() string {
+	return path.Join(GetUserHomeDir(), ".config", "yawsi", "config.toml")
+}
+
+func getConfigFilePath() string {
+	if len(configFilePath) != 0 {
+		return configFilePath
+	}
+	if p := os.Getenv("YAWSI_CONFIG"); len(p) != 0 {
+		return p
+	}
+	return defaultConfigFilePath()
+}
+
+func getConfigEnvironment() string {
+	if len(configEnvironment) != 0 {
+		return configEnvironment
+	}
+	return os.Getenv("YAWSI_ENV")
+}
+
+// loadConfig reads the configuration file, a missing file is the same as
+// an empty one
+func loadConfig(filePath string) (*yawsiConfig, error) {
+	config := &yawsiConfig{}
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return config, nil
+	}
+	if _, err := toml.DecodeFile(filePath, config); err != nil {
+		return nil, fmt.Errorf("Error reading config file %s: %w", filePath, err)
+	}
+	return config, nil
+}
+
+// saveConfig writes the configuration file. It is only readable by the
+// user since it can hold the Cloudflare API key.
+func saveConfig(filePath string, config *yawsiConfig) error {
+	if err := os.MkdirAll(path.Dir(filePath), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Files written by earlier versions were created world readable
+	if err := f.Chmod(0600); err != nil {
+		return err
+	}
+	return toml.NewEncoder(f).Encode(config)
+}
+
+// scope returns the configuration for the environment, or the top level
+// configuration if environment is empty
+func (c *yawsiConfig) scope(environment string) (*configScope, error) {
+	if len(environment) == 0 {
+		return &c.configScope, nil
+	}
+	s, ok := c.Environments[environment]
+	if !ok {
+		return nil, fmt.Errorf("Environment %q not found in the config file", environment)
+	}
+	return s, nil
+}
+
+// configSource is a place a value can come from, in order of precedence
+type configSource struct {
+	name     string
+	settings configSettings
+}
+
+func (c *yawsiConfig) sources(environment string, commandPath string) []configSource {
+	var sources []configSource
+	if s, ok := c.Environments[environment]; ok && len(environment) != 0 {
+		prefix := fmt.Sprintf("environments.%s.", environment)
+		sources = append(sources,
+			configSource{fmt.Sprintf("%scommands.%q", prefix, commandPath), s.Commands[commandPath]},
+			configSource{prefix + "defaults", s.Defaults},
+		)
+	}
+	sources = append(sources,
+		configSource{fmt.Sprintf("commands.%q", commandPath), c.Commands[commandPath]},
+		configSource{"defaults", c.Defaults},
+	)
+	return sources
+}
+
+func flagEnvVars(flagName string) []string {
+	name := "YAWSI_" + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+	return append([]string{name}, flagEnvAliases[flagName]...)
+}
+
+func configValueString(value interface{}) string {
+	if values, ok := value.([]interface{}); ok {
+		var s []string
+		for _, v := range values {
+			s = append(s, fmt.Sprint(v))
+		}
+		return strings.Join(s, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+// lookupSetting returns the value for a flag which isn't specified on the
+// command line from the environment variables or the config file, along
+// with where the value came from
+func (c *yawsiConfig) lookupSetting(environment string, commandPath string, flagName string) (string, string, bool) {
+	for _, name := range flagEnvVars(flagName) {
+		if value, ok := os.LookupEnv(name); ok && len(value) != 0 {
+			return value, "env " + name, true
+		}
+	}
+	for _, source := range c.sources(environment, commandPath) {
+		if value, ok := source.settings[flagName]; ok {
+			return configValueString(value), "config " + source.name, true
+		}
+	}
+	return "", "", false
+}
+
+// configCommandPath is the command path as used in the config file
+func configCommandPath(cmd *cobra.Command) string {
+	return strings.TrimSpace(strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()))
+}
+
+// applyConfig sets the flags of the command which weren't specified on the
+// command line, so the precedence is flag > environment variable > config
+func applyConfig(cmd *cobra.Command) error {
+	config, err := loadConfig(getConfigFilePath())
+	if err != nil {
+		return err
+	}
+	environment := getConfigEnvironment()
+	commandPath := configCommandPath(cmd)
+	// `config set` creates the environment if it doesn't exist
+	if _, err := config.scope(environment); err != nil && commandPath != "config set" {
+		return err
+	}
+
+	var setErr error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed || configIgnoredFlags[f.Name] || setErr != nil {
+			return
+		}
+		value, source, ok := config.lookupSetting(environment, commandPath, f.Name)
+		if !ok {
+			return
+		}
+		if err := cmd.Flags().Set(f.Name, value); err != nil {
+			setErr = fmt.Errorf("Invalid value %q for --%s from %s: %v", value, f.Name, source, err)
+		}
+	})
+	return setErr
+}
+
+// parseConfigValue converts a value for `config set` to the type of the flag
+func parseConfigValue(f *pflag.Flag, value string) (interface{}, error) {
+	if f == nil {
+		return value, nil
+	}
+	switch f.Value.Type() {
+	case "bool":
+		return strconv.ParseBool(value)
+	case "int", "int64":
+		return strconv.ParseInt(value, 10, 64)
+	case "stringSlice":
+		return strings.Split(value, ","), nil
+	}
+	return value, nil
+}
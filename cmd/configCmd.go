@@ -0,0 +1,293 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type configSettingOutput struct {
+	Scope string `json:"scope" yaml:"scope"`
+	Key   string `json:"key" yaml:"key"`
+	Value string `json:"value" yaml:"value"`
+}
+
+type effectiveSettingOutput struct {
+	Flag   string `json:"flag" yaml:"flag"`
+	Value  string `json:"value" yaml:"value"`
+	Source string `json:"source" yaml:"source"`
+}
+
+// findConfigCommand returns the command for a command path such as
+// "ec2 ssh-linux", or the root command for an empty path
+func findConfigCommand(commandPath string) (*cobra.Command, error) {
+	args := strings.Fields(commandPath)
+	if len(args) == 0 {
+		return RootCmd, nil
+	}
+	target, rest, err := RootCmd.Find(args)
+	if err != nil || len(rest) != 0 || target == RootCmd {
+		return nil, fmt.Errorf("Unknown command %q", commandPath)
+	}
+	return target, nil
+}
+
+func sortedKeys(settings configSettings) []string {
+	var keys []string
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// displayConfigFile shows all the settings in the config file
+func displayConfigFile(config *yawsiConfig) error {
+	items := []configSettingOutput{}
+	table := newTableOutput("Scope", "Key", "Value")
+
+	addSettings := func(scope string, settings configSettings) {
+		for _, key := range sortedKeys(settings) {
+			item := configSettingOutput{Scope: scope, Key: key, Value: configValueString(settings[key])}
+			items = append(items, item)
+			table.addRow(item.Scope, item.Key, item.Value)
+		}
+	}
+	addScope := func(prefix string, s *configScope) {
+		addSettings(prefix+"defaults", s.Defaults)
+		var commands []string
+		for commandPath := range s.Commands {
+			commands = append(commands, commandPath)
+		}
+		sort.Strings(commands)
+		for _, commandPath := range commands {
+			addSettings(fmt.Sprintf("%scommands.%q", prefix, commandPath), s.Commands[commandPath])
+		}
+	}
+
+	addScope("", &config.configScope)
+	var environments []string
+	for environment := range config.Environments {
+		environments = append(environments, environment)
+	}
+	sort.Strings(environments)
+	for _, environment := range environments {
+		addScope(fmt.Sprintf("environments.%s.", environment), config.Environments[environment])
+	}
+	return renderItems("ConfigSettingList", items, table)
+}
+
+// effectiveSettings returns the value of each flag of the command when it is
+// run without specifying the flag on the command line
+func effectiveSettings(config *yawsiConfig, environment string, target *cobra.Command) []effectiveSettingOutput {
+	items := []effectiveSettingOutput{}
+	commandPath := configCommandPath(target)
+
+	// Merges the persistent flags of the parents into Flags()
+	target.InheritedFlags()
+	target.Flags().VisitAll(func(f *pflag.Flag) {
+		if configIgnoredFlags[f.Name] {
+			return
+		}
+		item := effectiveSettingOutput{Flag: f.Name, Value: f.DefValue, Source: "default"}
+		if value, source, ok := config.lookupSetting(environment, commandPath, f.Name); ok {
+			item.Value, item.Source = value, source
+		}
+		items = append(items, item)
+	})
+	return items
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and update the yawsi configuration file",
+	Long: `yawsi reads default flag values from ~/.config/yawsi/config.toml (or the file specified via --config
+or the YAWSI_CONFIG environment variable):
+
+	[defaults]
+	region = "eu-west-1"
+
+	[commands."ec2 ssh-linux"]
+	key-path = "/home/user/.ssh/ec2.pem"
+	username = "ec2-user"
+
+	[environments.production.defaults]
+	profile = "production"
+
+	[environments.production.commands."ec2 ssh-linux"]
+	username = "admin"
+
+The keys are flag names. A flag specified on the command line takes precedence over the YAWSI_<FLAG>
+environment variable (e.g. YAWSI_KEY_PATH) which takes precedence over the config file. Within the
+config file, the environment selected via --env or YAWSI_ENV takes precedence and the command
+settings take precedence over the defaults.
+`,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show [command]",
+	Short: "Show the configuration file or the effective flag values for a command",
+	Long: `Show all the settings in the configuration file:
+
+	$ yawsi config show
+
+Show the value each flag of a command will have when it isn't specified on the command line and where
+the value comes from:
+
+	$ yawsi config show ec2 ssh-linux
+	$ yawsi --env production config show ec2 ssh-linux
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := loadConfig(getConfigFilePath())
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return displayConfigFile(config)
+		}
+
+		target, err := findConfigCommand(strings.Join(args, " "))
+		if err != nil {
+			return &usageError{err.Error()}
+		}
+		items := effectiveSettings(config, getConfigEnvironment(), target)
+		table := newTableOutput("Flag", "Value", "Source")
+		for _, item := range items {
+			table.addRow(item.Flag, item.Value, item.Source)
+		}
+		return renderItems("EffectiveConfig", items, table)
+	},
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Show the effective value of a setting",
+	Long: `Show the value a flag will have when it isn't specified on the command line:
+
+	$ yawsi config get region
+	$ yawsi config get username --command "ec2 ssh-linux"
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := loadConfig(getConfigFilePath())
+		if err != nil {
+			return err
+		}
+		target, err := findConfigCommand(configCommand)
+		if err != nil {
+			return &usageError{err.Error()}
+		}
+
+		var setting *effectiveSettingOutput
+		for _, item := range effectiveSettings(config, getConfigEnvironment(), target) {
+			if item.Flag == args[0] {
+				setting = &item
+				break
+			}
+		}
+		if setting == nil {
+			return newUsageError("Unknown setting %q for %s", args[0], target.CommandPath())
+		}
+		if isTableOutput() {
+			fmt.Fprintln(outputWriter, setting.Value)
+			return nil
+		}
+		return renderItems("EffectiveConfig", []effectiveSettingOutput{*setting}, nil)
+	},
+	Args: cobra.ExactArgs(1),
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a value in the configuration file",
+	Long: `Set a default for all the commands, a command or an environment (via --env):
+
+	$ yawsi config set region eu-west-1
+	$ yawsi config set username ec2-user --command "ec2 ssh-linux"
+	$ yawsi --env production config set profile production
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filePath := getConfigFilePath()
+		config, err := loadConfig(filePath)
+		if err != nil {
+			return err
+		}
+		target, err := findConfigCommand(configCommand)
+		if err != nil {
+			return &usageError{err.Error()}
+		}
+
+		key := args[0]
+		f := target.Flag(key)
+		if len(configCommand) != 0 && f == nil {
+			return newUsageError("Unknown setting %q for %s", key, target.CommandPath())
+		}
+		if configIgnoredFlags[key] {
+			return newUsageError("%q cannot be set in the config file", key)
+		}
+		value, err := parseConfigValue(f, args[1])
+		if err != nil {
+			return newUsageError("Invalid value %q for %s: %v", args[1], key, err)
+		}
+
+		environment := getConfigEnvironment()
+		if len(environment) != 0 {
+			if config.Environments == nil {
+				config.Environments = make(map[string]*configScope)
+			}
+			if config.Environments[environment] == nil {
+				config.Environments[environment] = &configScope{}
+			}
+		}
+		scope, _ := config.scope(environment)
+		scope.set(configCommand, key, value)
+
+		return saveConfig(filePath, config)
+	},
+	Args: cobra.ExactArgs(2),
+}
+
+func (s *configScope) set(commandPath string, key string, value interface{}) {
+	if len(commandPath) == 0 {
+		if s.Defaults == nil {
+			s.Defaults = make(configSettings)
+		}
+		s.Defaults[key] = value
+		return
+	}
+	commandPath = strings.Join(strings.Fields(commandPath), " ")
+	if s.Commands == nil {
+		s.Commands = make(map[string]configSettings)
+	}
+	if s.Commands[commandPath] == nil {
+		s.Commands[commandPath] = make(configSettings)
+	}
+	s.Commands[commandPath][key] = value
+}
+
+var configCommand string
+
+func init() {
+	RootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configGetCmd.Flags().StringVarP(&configCommand, "command", "", "", "Command the setting is for (Example: \"ec2 ssh-linux\")")
+	configSetCmd.Flags().StringVarP(&configCommand, "command", "", "", "Command the setting is for (Example: \"ec2 ssh-linux\")")
+}
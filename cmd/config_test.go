@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfigFile = `
+[defaults]
+region = "eu-west-1"
+username = "ubuntu"
+
+[commands."ec2 ssh-linux"]
+username = "ec2-user"
+key-path = "/keys/default.pem"
+
+[environments.production.defaults]
+profile = "production"
+
+[environments.production.commands."ec2 ssh-linux"]
+key-path = "/keys/production.pem"
+`
+
+func writeConfigFile(t *testing.T, contents string) func() {
+	dir, err := ioutil.TempDir("", "yawsi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFilePath = path.Join(dir, "config.toml")
+	if len(contents) != 0 {
+		ioutil.WriteFile(configFilePath, []byte(contents), 0600)
+	}
+	return func() {
+		os.RemoveAll(dir)
+		configFilePath, configEnvironment = "", ""
+	}
+}
+
+func TestConfigLookupPrecedence(t *testing.T) {
+	defer writeConfigFile(t, testConfigFile)()
+	config, err := loadConfig(getConfigFilePath())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	value, source, ok := config.lookupSetting("", "ec2 ssh-linux", "username")
+	assert.True(t, ok)
+	assert.Equal(t, "ec2-user", value)
+	assert.Equal(t, `config commands."ec2 ssh-linux"`, source)
+
+	value, _, _ = config.lookupSetting("", "vpc list", "username")
+	assert.Equal(t, "ubuntu", value)
+
+	value, source, _ = config.lookupSetting("production", "ec2 ssh-linux", "key-path")
+	assert.Equal(t, "/keys/production.pem", value)
+	assert.Equal(t, `config environments.production.commands."ec2 ssh-linux"`, source)
+	value, _, _ = config.lookupSetting("production", "ec2 ssh-linux", "username")
+	assert.Equal(t, "ec2-user", value)
+
+	// Environment variables take precedence over the config file
+	os.Setenv("YAWSI_KEY_PATH", "/keys/env.pem")
+	defer os.Unsetenv("YAWSI_KEY_PATH")
+	value, source, _ = config.lookupSetting("production", "ec2 ssh-linux", "key-path")
+	assert.Equal(t, "/keys/env.pem", value)
+	assert.Equal(t, "env YAWSI_KEY_PATH", source)
+
+	_, _, ok = config.lookupSetting("", "ec2 ssh-linux", "list-format")
+	assert.False(t, ok)
+}
+
+func newConfigTestCommand(keyPath *string, port *int64) *cobra.Command {
+	root := &cobra.Command{Use: "yawsi"}
+	root.PersistentFlags().StringVarP(&configEnvironment, "env", "", "", "")
+	root.PersistentFlags().String("profile", "", "")
+	cmd := &cobra.Command{Use: "ssh-linux", Run: func(cmd *cobra.Command, args []string) {}}
+	cmd.Flags().StringVarP(keyPath, "key-path", "k", "", "")
+	cmd.Flags().Int64VarP(port, "port", "", 22, "")
+	ec2 := &cobra.Command{Use: "ec2"}
+	ec2.AddCommand(cmd)
+	root.AddCommand(ec2)
+	return cmd
+}
+
+func TestApplyConfig(t *testing.T) {
+	defer writeConfigFile(t, testConfigFile+"\n[commands.\"ec2 ssh-linux\".port]\n")()
+
+	var keyPath string
+	var port int64
+	cmd := newConfigTestCommand(&keyPath, &port)
+	cmd.Root().SetArgs([]string{"ec2", "ssh-linux", "--env", "production"})
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error { return applyConfig(cmd) }
+
+	// A value of the wrong type is reported
+	assert.Error(t, cmd.Root().Execute())
+
+	ioutil.WriteFile(configFilePath, []byte(testConfigFile+"port = 2222\n"), 0600)
+	if assert.NoError(t, cmd.Root().Execute()) {
+		assert.Equal(t, "/keys/production.pem", keyPath)
+		assert.Equal(t, int64(2222), port)
+		assert.Equal(t, "production", cmd.Flag("profile").Value.String())
+	}
+
+	// A flag on the command line takes precedence
+	cmd = newConfigTestCommand(&keyPath, &port)
+	cmd.Root().SetArgs([]string{"ec2", "ssh-linux", "-k", "/keys/flag.pem"})
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error { return applyConfig(cmd) }
+	if assert.NoError(t, cmd.Root().Execute()) {
+		assert.Equal(t, "/keys/flag.pem", keyPath)
+	}
+
+	cmd = newConfigTestCommand(&keyPath, &port)
+	cmd.Root().SetArgs([]string{"ec2", "ssh-linux", "--env", "staging"})
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error { return applyConfig(cmd) }
+	assert.Error(t, cmd.Root().Execute())
+}
+
+func TestSaveConfig(t *testing.T) {
+	defer writeConfigFile(t, "")()
+
+	config, err := loadConfig(getConfigFilePath())
+	if !assert.NoError(t, err) {
+		return
+	}
+	config.set("", "region", "us-west-2")
+	config.Environments = map[string]*configScope{"staging": {}}
+	config.Environments["staging"].set("ec2  ssh-linux", "username", "admin")
+	if !assert.NoError(t, saveConfig(getConfigFilePath(), config)) {
+		return
+	}
+
+	config, err = loadConfig(getConfigFilePath())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "us-west-2", config.Defaults["region"])
+		value, _, _ := config.lookupSetting("staging", "ec2 ssh-linux", "username")
+		assert.Equal(t, "admin", value)
+	}
+}
+
+func TestSaveConfigPermissions(t *testing.T) {
+	defer writeConfigFile(t, testConfigFile)()
+	assert.NoError(t, os.Chmod(configFilePath, 0644))
+
+	config, err := loadConfig(getConfigFilePath())
+	if !assert.NoError(t, err) {
+		return
+	}
+	config.set("", "cf-api-key", "secret")
+	if !assert.NoError(t, saveConfig(getConfigFilePath(), config)) {
+		return
+	}
+
+	info, err := os.Stat(configFilePath)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+)
+
+// connectivityDestination is what the connectivity checks are run against:
+// an IP address or CIDR block, an instance or a database
+type connectivityDestination struct {
+	// state is nil for an IP address or CIDR block
+	state    *instanceState
+	database *databaseTarget
+	// The addresses to check, see resolveNetworks
+	networks []*net.IPNet
+	// port is the default destination port, e.g. the port of a database
+	port int64
+}
+
+// isInstanceOrInterface reports whether the source or destination is an
+// instance or a network interface
+func isInstanceOrInterface(s string) bool {
+	return strings.HasPrefix(s, "i-") || strings.HasPrefix(s, "eni-")
+}
+
+// getEndpointStates returns the states of the instances and network
+// interfaces, in the same order. The instances are described together, as
+// are the network interfaces.
+func getEndpointStates(svc ec2iface.EC2API, ids ...string) ([]*instanceState, error) {
+	var instanceIDs, networkInterfaceIDs []string
+	for _, id := range ids {
+		if strings.HasPrefix(id, "eni-") {
+			networkInterfaceIDs = append(networkInterfaceIDs, id)
+		} else {
+			instanceIDs = append(instanceIDs, id)
+		}
+	}
+
+	found := make(map[string]*instanceState)
+	if len(instanceIDs) != 0 {
+		instanceData, err := getEC2InstanceData(svc, nil, aws.StringSlice(instanceIDs)...)
+		if err != nil {
+			return nil, err
+		}
+		for _, state := range instanceData {
+			found[state.InstanceId] = state
+		}
+	}
+	if len(networkInterfaceIDs) != 0 {
+		networkInterfaces, err := describeNetworkInterfaces(svc, networkInterfaceIDs)
+		if err != nil {
+			return nil, err
+		}
+		for id, ni := range networkInterfaces {
+			found[id] = networkInterfaceState(ni)
+		}
+	}
+
+	var states []*instanceState
+	for _, id := range ids {
+		state, ok := found[id]
+		switch {
+		case !ok && strings.HasPrefix(id, "eni-"):
+			return nil, newNotFoundError("Network interface %s not found", id)
+		case !ok:
+			return nil, newNotFoundError("Instance %s not found", id)
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// forInterface returns the state of the instance as seen from one of its
+// network interfaces: the subnet, addresses and security groups of the
+// interface and the network ACL, route tables and security group rules which
+// go with them. The state must have been enriched.
+func (s *instanceState) forInterface(networkInterfaceID string) *instanceState {
+	iface, ok := s.Interfaces[networkInterfaceID]
+	if !ok {
+		return s
+	}
+	narrowed := *s
+	narrowed.SubnetIds = []string{iface.SubnetID}
+	narrowed.NetworkInterfaces = map[string]string{networkInterfaceID: iface.SubnetID}
+	narrowed.Interfaces = map[string]*interfaceState{networkInterfaceID: iface}
+	narrowed.PrivateIPAddresses = iface.PrivateIPAddresses
+	narrowed.IPv6Addresses = iface.IPv6Addresses
+	narrowed.SecurityGroups = iface.SecurityGroups
+	// The public IP address of an instance is the one of its primary
+	// network interface
+	if len(iface.PublicIP) != 0 || iface.DeviceIndex != 0 {
+		narrowed.PublicIP = iface.PublicIP
+	}
+
+	narrowed.AddressSecurityGroups = make(map[string][]*ec2.GroupIdentifier)
+	for _, ip := range append(append([]string{}, iface.PrivateIPAddresses...), iface.IPv6Addresses...) {
+		narrowed.AddressSecurityGroups[ip] = iface.SecurityGroups
+	}
+	groups := make(map[string]bool)
+	for _, group := range iface.SecurityGroups {
+		groups[aws.StringValue(group.GroupId)] = true
+	}
+	narrowed.SecurityGroupRules = nil
+	for _, rule := range s.SecurityGroupRules {
+		if len(rule.groupID) == 0 || groups[rule.groupID] {
+			narrowed.SecurityGroupRules = append(narrowed.SecurityGroupRules, rule)
+		}
+	}
+
+	narrowed.SubnetCIDRs, narrowed.SubnetIPv6CIDRs, narrowed.NetworkAcls = nil, nil, nil
+	if cidr, ok := s.SubnetCIDRs[iface.SubnetID]; ok {
+		narrowed.SubnetCIDRs = map[string]string{iface.SubnetID: cidr}
+	}
+	if cidr, ok := s.SubnetIPv6CIDRs[iface.SubnetID]; ok {
+		narrowed.SubnetIPv6CIDRs = map[string]string{iface.SubnetID: cidr}
+	}
+	if acl, ok := s.NetworkAcls[iface.SubnetID]; ok {
+		narrowed.NetworkAcls = map[string]*ec2.NetworkAcl{iface.SubnetID: acl}
+	}
+	narrowed.Routes = nil
+	for _, routeTable := range s.Routes {
+		if routeTable.SubnetID == iface.SubnetID {
+			narrowed.Routes = append(narrowed.Routes, routeTable)
+		}
+	}
+	return &narrowed
+}
+
+// interfaceWithAddress returns the ID of the network interface with the
+// private, IPv6 or public IP address
+func (s *instanceState) interfaceWithAddress(ip string) string {
+	for id, iface := range s.Interfaces {
+		if iface.PublicIP == ip {
+			return id
+		}
+		for _, address := range append(append([]string{}, iface.PrivateIPAddresses...), iface.IPv6Addresses...) {
+			if address == ip {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// forAddress returns the state of the network interface with the address
+// the traffic is for. An instance with one network interface, or a network
+// which isn't one of its addresses, uses the state of the whole instance.
+func (s *instanceState) forAddress(network *net.IPNet) *instanceState {
+	if s == nil || len(s.Interfaces) < 2 {
+		return s
+	}
+	if ones, bits := network.Mask.Size(); ones != bits {
+		return s
+	}
+	if id := s.interfaceWithAddress(network.IP.String()); len(id) != 0 {
+		return s.forInterface(id)
+	}
+	return s
+}
+
+// forTrafficTo returns the state of the network interface the traffic to
+// the network leaves from: the interface in a subnet containing the network
+// or else the primary interface, which the default route of the instance
+// uses
+func (s *instanceState) forTrafficTo(network *net.IPNet) *instanceState {
+	if s == nil || len(s.Interfaces) < 2 {
+		return s
+	}
+	var ids []string
+	for id := range s.Interfaces {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	primary := ""
+	for _, id := range ids {
+		iface := s.Interfaces[id]
+		subnetCIDR := s.SubnetCIDRs[iface.SubnetID]
+		if isIPv6Network(network) {
+			subnetCIDR = s.SubnetIPv6CIDRs[iface.SubnetID]
+		}
+		if cidrContains(&subnetCIDR, network) {
+			return s.forInterface(id)
+		}
+		if iface.DeviceIndex == 0 {
+			primary = id
+		}
+	}
+	if len(primary) != 0 {
+		return s.forInterface(primary)
+	}
+	return s
+}
+
+// getConnectivityDestination looks up the destination. The address of an
+// instance or network interface destination can be specified: a private IP
+// address or, with publicIP, its public IP address. Otherwise all the
+// private IP addresses of an instance are checked and a network interface
+// defaults to its primary private IP address.
+func getConnectivityDestination(svc ec2iface.EC2API, rdsSvc rdsiface.RDSAPI, destination string, privateIP string, publicIP bool) (*connectivityDestination, error) {
+	switch {
+	case isDatabaseDestination(destination):
+		database, err := getDatabaseTarget(rdsSvc, destination)
+		if err != nil {
+			return nil, err
+		}
+		return &connectivityDestination{state: database.state, database: database, port: database.port}, nil
+	case isInstanceOrInterface(destination):
+		states, err := getEndpointStates(svc, destination)
+		if err != nil {
+			return nil, err
+		}
+		state := states[0]
+		d := &connectivityDestination{state: state}
+		switch {
+		case publicIP:
+			if len(state.PublicIP) == 0 {
+				return nil, newNotFoundError("%s doesn't have a public IP address", destination)
+			}
+			d.networks = addressNetworks([]string{state.PublicIP})
+		case len(privateIP) != 0:
+			if len(state.Interfaces) != 0 && len(state.interfaceWithAddress(privateIP)) == 0 {
+				return nil, newUsageError("%s isn't an address of %s", privateIP, destination)
+			}
+			d.networks = addressNetworks([]string{privateIP})
+		case strings.HasPrefix(destination, "eni-") && len(state.PrivateIPAddresses) != 0:
+			d.networks = addressNetworks(state.PrivateIPAddresses[:1])
+		}
+		return d, nil
+	}
+
+	network, err := parseNetwork(destination)
+	if err != nil {
+		return nil, newUsageError("Unrecognized destination specification: %s", destination)
+	}
+	return &connectivityDestination{networks: []*net.IPNet{network}}, nil
+}
+
+// resolveNetworks returns the addresses to check, once the state of the
+// destination has been enriched
+func (d *connectivityDestination) resolveNetworks() []*net.IPNet {
+	if d.networks == nil {
+		if d.database != nil {
+			d.networks = d.database.resolveNetworks()
+		} else if d.state != nil {
+			d.networks = addressNetworks(allAddresses(d.state))
+		}
+	}
+	return d.networks
+}
+
+// checkConnectivityToDestination runs the checks for traffic from
+// sourceNetworks, the addresses of the source, to the destination. Only the
+// source side is checked for an IP address or CIDR block. The addresses of a
+// dual-stack destination are only checked for the IP versions the source
+// has.
+func checkConnectivityToDestination(source *instanceState, sourceNetworks []*net.IPNet, dest *connectivityDestination, protocol string, ports *ec2.PortRange, ephemeralPorts *ec2.PortRange) []*checkResult {
+	var result []*checkResult
+
+	networks := dest.resolveNetworks()
+	if dest.state != nil {
+		var sameVersion []*net.IPNet
+		for _, network := range networks {
+			if len(sameVersionNetworks(sourceNetworks, network)) != 0 {
+				sameVersion = append(sameVersion, network)
+			}
+		}
+		if len(sameVersion) != 0 {
+			networks = sameVersion
+		}
+	}
+	for _, network := range networks {
+		// Only the network interfaces the traffic leaves from and arrives at
+		// are checked on an instance with more than one
+		from, fromNetworks := source.forTrafficTo(network), sourceNetworks
+		if from != source {
+			fromNetworks = addressNetworks(allAddresses(from))
+		}
+		if dest.state == nil {
+			// Security group rules are state preserving, so the return traffic
+			// is allowed, network ACLs aren't
+			result = append(result, checkSecurityGroupEgressToNetwork(from, network, nil, protocol, ports)...)
+			result = append(result, checkNACLToNetwork(from, network, protocol, ports, ephemeralPorts)...)
+			result = append(result, checkRouteToNetwork(from, nil, network)...)
+			continue
+		}
+		result = append(result, checkConnectivityToState(from, fromNetworks, dest.state.forAddress(network), network, protocol, ports, ephemeralPorts)...)
+	}
+	return result
+}
+
+// checkConnectivityFromAddress runs the checks for traffic from an IP
+// address or CIDR block to the destination instance: the network ACLs of
+// the destination, its route back and its security groups, for each address
+// of the destination of the same IP version
+func checkConnectivityFromAddress(sourceNetwork *net.IPNet, dest *connectivityDestination, protocol string, ports *ec2.PortRange, ephemeralPorts *ec2.PortRange) []*checkResult {
+	var result []*checkResult
+	for _, destNetwork := range sameVersionNetworks(dest.resolveNetworks(), sourceNetwork) {
+		result = append(result, checkConnectivityFromNetwork(nil, sourceNetwork, dest.state.forAddress(destNetwork), destNetwork, protocol, ports, ephemeralPorts)...)
+	}
+	return result
+}
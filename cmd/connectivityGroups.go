@@ -0,0 +1,260 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// autoScalingGroupTag is the tag the instances of an auto scaling group
+// get, with the name of the group
+const autoScalingGroupTag = "aws:autoscaling:groupName"
+
+// connectivityPair is the result of the connectivity checks from a source
+// to a destination of a group check
+type connectivityPair struct {
+	Source      string         `json:"source" yaml:"source"`
+	Destination string         `json:"destination" yaml:"destination"`
+	Allowed     bool           `json:"allowed" yaml:"allowed"`
+	BlockedBy   string         `json:"blockedBy,omitempty" yaml:"blockedBy,omitempty"`
+	Checks      []*checkResult `json:"checks" yaml:"checks"`
+}
+
+// getInstanceGroup returns the running instances with the tags (a comma
+// separated list of key:value) and in the auto scaling group, either can be
+// empty
+func getInstanceGroup(svc ec2iface.EC2API, tags string, autoScalingGroup string) ([]*instanceState, error) {
+	filters := []*ec2.Filter{{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"running"})}}
+	if len(tags) != 0 {
+		tagFilters, err := parseTagFilters(tags)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, tagFilters...)
+	}
+	if len(autoScalingGroup) != 0 {
+		filters = append(filters, &ec2.Filter{Name: aws.String("tag:" + autoScalingGroupTag), Values: aws.StringSlice([]string{autoScalingGroup})})
+	}
+
+	states, err := getEC2InstanceData(svc, filters)
+	if err != nil {
+		return nil, err
+	}
+	if len(states) == 0 {
+		var selectors []string
+		if len(tags) != 0 {
+			selectors = append(selectors, "the tags "+tags)
+		}
+		if len(autoScalingGroup) != 0 {
+			selectors = append(selectors, "the auto scaling group "+autoScalingGroup)
+		}
+		return nil, newNotFoundError("No running instances with %s", strings.Join(selectors, " in "))
+	}
+	return states, nil
+}
+
+// groupDestinations returns the instances of a group as destinations, named
+// by their instance IDs. With publicIP, the public IP address of each
+// instance is checked instead of its private addresses.
+func groupDestinations(states []*instanceState, publicIP bool) ([]*connectivityDestination, []string, error) {
+	var dests []*connectivityDestination
+	var names []string
+	for _, state := range states {
+		dest := &connectivityDestination{state: state}
+		if publicIP {
+			if len(state.PublicIP) == 0 {
+				return nil, nil, newNotFoundError("%s doesn't have a public IP address", state.InstanceId)
+			}
+			dest.networks = addressNetworks([]string{state.PublicIP})
+		}
+		dests = append(dests, dest)
+		names = append(names, state.InstanceId)
+	}
+	return dests, names, nil
+}
+
+// pairBlockingRule describes what blocked the traffic of a failed check in the
+// same way for every pair it blocks, so that the pairs can be grouped by it:
+// the network ACL entry, the security groups without a matching rule or the
+// route table
+func pairBlockingRule(r *checkResult) string {
+	name, ok := stageNames[r.Stage]
+	if !ok {
+		return r.DisplayText
+	}
+	if decision, ok := r.Metadata["DecidingRule"].(naclDecision); ok {
+		return fmt.Sprintf("%s %s %s", name, r.Metadata["NetworkAclId"], decision)
+	}
+	if ids, ok := r.Metadata["SecurityGroupIds"].([]string); ok && len(ids) != 0 {
+		return fmt.Sprintf("%s, no rule of %s matched", name, strings.Join(ids, ", "))
+	}
+	if routeTableID, ok := r.Metadata["RouteTableId"].(string); ok {
+		if routes, ok := r.Metadata["MatchedRoutes"].([]*ec2.Route); ok && len(routes) != 0 {
+			return fmt.Sprintf("%s %s, route %s", name, routeTableID, describeRoute(routes[0]))
+		}
+		return fmt.Sprintf("%s %s, no route", name, routeTableID)
+	}
+	return r.DisplayText
+}
+
+// checkConnectivityPairs runs the connectivity checks from every source to
+// every destination concurrently, other than from an instance to itself.
+// The destinations are named by destNames.
+func checkConnectivityPairs(sources []*instanceState, dests []*connectivityDestination, destNames []string, protocol string, ports *ec2.PortRange, ephemeralPorts *ec2.PortRange) []*connectivityPair {
+	type task struct {
+		source *instanceState
+		dest   int
+	}
+	var tasks []task
+	for _, source := range sources {
+		for i, dest := range dests {
+			if dest.state != nil && dest.state.InstanceId == source.InstanceId {
+				continue
+			}
+			// The addresses are resolved up front, the checks only read the
+			// destinations
+			dest.resolveNetworks()
+			tasks = append(tasks, task{source: source, dest: i})
+		}
+	}
+
+	pairs := make([]*connectivityPair, len(tasks))
+	runWorkers(len(tasks), func(i int) {
+		t := tasks[i]
+		pair := &connectivityPair{Source: t.source.InstanceId, Destination: destNames[t.dest]}
+		pair.Checks = checkConnectivityToDestination(t.source, addressNetworks(allAddresses(t.source)), dests[t.dest], protocol, ports, ephemeralPorts)
+		pair.Allowed = len(pair.Checks) != 0 && summarizeResults(pair.Checks...)
+		if len(pair.Checks) == 0 {
+			pair.BlockedBy = "No addresses of the same IP version"
+		}
+		for _, r := range pair.Checks {
+			if !r.Result {
+				pair.BlockedBy = pairBlockingRule(r)
+				break
+			}
+		}
+		pairs[i] = pair
+	})
+	return pairs
+}
+
+// renderConnectivityPairs writes how many of the pairs are allowed and the
+// pairs which aren't, grouped by what blocked them
+func renderConnectivityPairs(pairs []*connectivityPair) error {
+	allowed := 0
+	var rules []string
+	blocked := make(map[string][]*connectivityPair)
+	for _, pair := range pairs {
+		if pair.Allowed {
+			allowed++
+			continue
+		}
+		if _, ok := blocked[pair.BlockedBy]; !ok {
+			rules = append(rules, pair.BlockedBy)
+		}
+		blocked[pair.BlockedBy] = append(blocked[pair.BlockedBy], pair)
+	}
+	summary := allowed == len(pairs)
+
+	if isTableOutput() {
+		fmt.Fprintf(outputWriter, "%d/%d pairs allowed\n", allowed, len(pairs))
+		for _, rule := range rules {
+			fmt.Fprintf(outputWriter, "Blocked by %s:\n", rule)
+			for _, pair := range blocked[rule] {
+				fmt.Fprintf(outputWriter, "  %s -> %s\n", pair.Source, pair.Destination)
+			}
+		}
+	} else {
+		err := writeDocument(outputDocument{
+			APIVersion: outputAPIVersion,
+			Kind:       "ConnectivityPairList",
+			Result:     &summary,
+			Items:      pairs,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !summary {
+		return errCheckFailed
+	}
+	return nil
+}
+
+// checkConnectivityGroups runs the connectivity checks between the groups
+// of instances selected by --from-tag/--from-asg and --to-tag/--to-asg. The
+// source is an instance or network interface when it isn't a group, the
+// destination is --to.
+func checkConnectivityGroups(source string, ephemeralPorts *ec2.PortRange) error {
+	svc := getClients().EC2()
+
+	var sources []*instanceState
+	var err error
+	switch {
+	case len(source) == 0:
+		sources, err = getInstanceGroup(svc, fromTags, fromASG)
+	case isInstanceOrInterface(source):
+		sources, err = getEndpointStates(svc, source)
+	default:
+		return newUsageError("The source of a group check must be an instance or a network interface: %s", source)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The port of a single database destination defaults to its endpoint
+	// port
+	var singleDest *connectivityDestination
+	var dests []*connectivityDestination
+	var destNames []string
+	if len(toDest) != 0 {
+		singleDest, err = getConnectivityDestination(svc, getClients().RDS(), toDest, destPrivateIPAddress, usingPublicIP)
+		if err != nil {
+			return err
+		}
+		dests, destNames = []*connectivityDestination{singleDest}, []string{toDest}
+	} else {
+		destStates, err := getInstanceGroup(svc, toTags, toASG)
+		if err != nil {
+			return err
+		}
+		dests, destNames, err = groupDestinations(destStates, usingPublicIP)
+		if err != nil {
+			return err
+		}
+	}
+
+	states := append([]*instanceState{}, sources...)
+	for _, dest := range dests {
+		if dest.state != nil {
+			states = append(states, dest.state)
+		}
+	}
+	if err := enrichInstanceStates(svc, states...); err != nil {
+		return err
+	}
+
+	ports := trafficPortRange(singleDest)
+	pairs := checkConnectivityPairs(sources, dests, destNames, protocol, &ports, ephemeralPorts)
+	if len(pairs) == 0 {
+		return newNotFoundError("No pairs of instances to check")
+	}
+	return renderConnectivityPairs(pairs)
+}
@@ -17,20 +17,99 @@ package cmd
 import (
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/databasemigrationservice"
 	"github.com/spf13/cobra"
 )
 
+type dmsTaskOutput struct {
+	resourceLocation        `yaml:",inline"`
+	Identifier              string `json:"identifier" yaml:"identifier"`
+	Arn                     string `json:"arn" yaml:"arn"`
+	Status                  string `json:"status" yaml:"status"`
+	MigrationType           string `json:"migrationType" yaml:"migrationType"`
+	StopReason              string `json:"stopReason,omitempty" yaml:"stopReason,omitempty"`
+	FullLoadProgressPercent int64  `json:"fullLoadProgressPercent" yaml:"fullLoadProgressPercent"`
+	TablesErrored           int64  `json:"tablesErrored" yaml:"tablesErrored"`
+	TablesLoaded            int64  `json:"tablesLoaded" yaml:"tablesLoaded"`
+	TablesLoading           int64  `json:"tablesLoading" yaml:"tablesLoading"`
+	TablesQueued            int64  `json:"tablesQueued" yaml:"tablesQueued"`
+}
+
+func buildDMSTaskList(loc resourceLocation, tasksData []*databasemigrationservice.ReplicationTask) ([]dmsTaskOutput, *tableOutput) {
+	items := []dmsTaskOutput{}
+	table := newTableOutput("Identifier", "Status", "MigrationType", "FullLoad%", "TablesErrored").in(loc)
+
+	for _, task := range tasksData {
+		item := dmsTaskOutput{
+			resourceLocation: loc,
+			Identifier:       aws.StringValue(task.ReplicationTaskIdentifier),
+			Arn:              aws.StringValue(task.ReplicationTaskArn),
+			Status:           aws.StringValue(task.Status),
+			MigrationType:    aws.StringValue(task.MigrationType),
+			StopReason:       aws.StringValue(task.StopReason),
+		}
+		// The stats are only partially filled in while a task is starting
+		if stats := task.ReplicationTaskStats; stats != nil {
+			item.FullLoadProgressPercent = aws.Int64Value(stats.FullLoadProgressPercent)
+			item.TablesErrored = aws.Int64Value(stats.TablesErrored)
+			item.TablesLoaded = aws.Int64Value(stats.TablesLoaded)
+			item.TablesLoading = aws.Int64Value(stats.TablesLoading)
+			item.TablesQueued = aws.Int64Value(stats.TablesQueued)
+		}
+		items = append(items, item)
+		table.addRow(item.Identifier, item.Status, item.MigrationType,
+			fmt.Sprintf("%d", item.FullLoadProgressPercent), fmt.Sprintf("%d", item.TablesErrored))
+	}
+	return items, table
+}
+
+func displayDMSTasks(tasksData []*databasemigrationservice.ReplicationTask) error {
+	items, table := buildDMSTaskList(resourceLocation{}, tasksData)
+	return renderItems("ReplicationTaskList", items, table)
+}
+
+func listAllDMSTasks() error {
+	return renderAll("ReplicationTaskList", func(loc resourceLocation, c awsClientProvider) (interface{}, *tableOutput, error) {
+		var tasksData []*databasemigrationservice.ReplicationTask
+		err := c.DMS().DescribeReplicationTasksPages(&databasemigrationservice.DescribeReplicationTasksInput{},
+			func(result *databasemigrationservice.DescribeReplicationTasksOutput, lastPage bool) bool {
+				tasksData = append(tasksData, result.ReplicationTasks...)
+				return !lastPage
+			})
+		if err != nil {
+			return nil, nil, err
+		}
+		items, table := buildDMSTaskList(loc, tasksData)
+		return items, table, nil
+	})
+}
+
 var dmsTaskStatusCmd = &cobra.Command{
 	Use:   "replication-task-status",
 	Short: "Show the status for a replication task",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Replication tasks in several regions are always listed
+		if isMultiTarget() {
+			return listAllDMSTasks()
+		}
 
-		tasksData := getDMSReplicationTasks()
-		fmt.Printf("%v\n", tasksData)
-		displayDMSTaskStatusInteractive(tasksData)
+		svc := getClients().DMS()
+		tasksData, err := getDMSReplicationTasks(svc)
+		if err != nil {
+			return err
+		}
+		if listDMSTasks || !isTableOutput() {
+			return displayDMSTasks(tasksData)
+		}
+		return displayDMSTaskStatusInteractive(svc, tasksData)
 	},
 }
 
+var listDMSTasks bool
+
 func init() {
 	dmsCmd.AddCommand(dmsTaskStatusCmd)
+	dmsTaskStatusCmd.Flags().BoolVarP(&listDMSTasks, "list", "", false, "List the replication tasks instead of selecting one interactively")
+	addListingFlags(dmsTaskStatusCmd)
 }
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/databasemigrationservice"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildDMSTaskListPartialStats(t *testing.T) {
+	tasks := []*databasemigrationservice.ReplicationTask{{
+		ReplicationTaskIdentifier: aws.String("task-1"),
+		ReplicationTaskArn:        aws.String("arn:aws:dms:us-east-1:123456789012:task:task-1"),
+		Status:                    aws.String("starting"),
+		MigrationType:             aws.String("full-load"),
+		ReplicationTaskStats: &databasemigrationservice.ReplicationTaskStats{
+			TablesQueued: aws.Int64(3),
+		},
+	}}
+
+	items, table := buildDMSTaskList(resourceLocation{}, tasks)
+	assert.Len(t, items, 1)
+	assert.Equal(t, int64(3), items[0].TablesQueued)
+	assert.Equal(t, int64(0), items[0].FullLoadProgressPercent)
+	assert.Equal(t, []string{"task-1", "starting", "full-load", "0", "0"}, table.Rows[0])
+}
@@ -16,8 +16,6 @@ package cmd
 
 import (
 	"fmt"
-	"log"
-	"os"
 	"reflect"
 	"strings"
 	"text/template"
@@ -26,7 +24,6 @@ import (
 	fuzzyfinder "github.com/ktr0731/go-fuzzyfinder"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/spf13/cobra"
@@ -38,14 +35,20 @@ type listInstanceData struct {
 	instanceState
 }
 
-func displayFixedInstanceDetails(instancesData ...*instanceState) {
+func displayFixedInstanceDetails(instancesData ...*instanceState) error {
+
+	if !isTableOutput() {
+		if instancesData == nil {
+			instancesData = []*instanceState{}
+		}
+		return renderItems("InstanceList", instancesData, nil)
+	}
 
 	tmpl := template.New("fixedEC2InstanceDetails")
 
 	tmpl, err := tmpl.Parse(listInstancesFormat)
 	if err != nil {
-		log.Fatal("Error Parsing template: ", err)
-		return
+		return newUsageError("Error parsing --list-format: %v", err)
 	}
 
 	for _, instance := range instancesData {
@@ -62,13 +65,61 @@ func displayFixedInstanceDetails(instancesData ...*instanceState) {
 
 		d.instanceState = *instance
 
-		err1 := tmpl.Execute(os.Stdout, d)
+		err1 := tmpl.Execute(outputWriter, d)
 		if err1 != nil {
-			log.Fatal("Error executing template: ", err1)
+			return newUsageError("Error executing --list-format: %v", err1)
+		}
+		fmt.Fprintln(outputWriter)
+	}
+	return nil
+}
 
+// listAllInstances lists the instances matching the filters in each account
+// and region, the template output is prefixed with the location unless a
+// custom --list-format is specified
+func listAllInstances(ec2Filters []*ec2.Filter, instanceIds []*string, customFormat bool) error {
+	l, err := listAll(func(loc resourceLocation, c awsClientProvider) (interface{}, *tableOutput, error) {
+		instancesData, err := getEC2InstanceData(c.EC2(), ec2Filters, instanceIds...)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, instance := range instancesData {
+			instance.resourceLocation = loc
 		}
-		fmt.Println()
+		return instancesData, newTableOutput(), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	items, _ := l.merge()
+	if !isTableOutput() {
+		return l.writeDocument("InstanceList", items)
 	}
+
+	var instancesData []*instanceState
+	for _, item := range items {
+		instancesData = append(instancesData, item.(*instanceState))
+	}
+	if !customFormat {
+		if isMultiAccount() {
+			listInstancesFormat = "{{.Account}} {{.Region}} " + listInstancesFormat
+		} else {
+			listInstancesFormat = "{{.Region}} " + listInstancesFormat
+		}
+	}
+	if err := displayFixedInstanceDetails(instancesData...); err != nil {
+		return err
+	}
+	l.reportErrors()
+	return nil
+}
+
+type asgInstanceOutput struct {
+	InstanceId           string `json:"instanceId" yaml:"instanceId"`
+	AutoScalingGroupName string `json:"autoScalingGroupName" yaml:"autoScalingGroupName"`
+	AvailabilityZone     string `json:"availabilityZone" yaml:"availabilityZone"`
+	ProtectedFromScaleIn bool   `json:"protectedFromScaleIn" yaml:"protectedFromScaleIn"`
 }
 
 // listInstancesCmd represents the listInstances command
@@ -76,11 +127,13 @@ var describeInstancesCmd = &cobra.Command{
 	Use:   "describe-instances",
 	Short: "Describe EC2 instances",
 	Long:  `Describe EC2 instances. Filter by tags (tag1:value1, tag2:value2), auto scaling group, interactive selection and more`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		var ec2Filters []*ec2.Filter
 		var inputInstanceIds []*string
 		var inputInstanceIdsMap = make(map[string]bool)
 
+		svc := getClients().EC2()
+
 		if listInstancesFormatHelp {
 			fmt.Print("Available format fields:\n\n")
 			instanceData := listInstanceData{}
@@ -97,11 +150,19 @@ var describeInstancesCmd = &cobra.Command{
 			s = reflect.ValueOf(&instanceState).Elem()
 			typeOfT = s.Type()
 			for i := 0; i < s.NumField(); i++ {
+				// Account and Region
+				if typeOfT.Field(i).Anonymous {
+					embedded := typeOfT.Field(i).Type
+					for j := 0; j < embedded.NumField(); j++ {
+						fmt.Printf("%s \n", embedded.Field(j).Name)
+					}
+					continue
+				}
 				fmt.Printf("%s \n", typeOfT.Field(i).Name)
 			}
 
 			fmt.Println()
-			os.Exit(0)
+			return nil
 		}
 
 		if len(instanceIds) != 0 {
@@ -114,23 +175,23 @@ var describeInstancesCmd = &cobra.Command{
 		}
 
 		if len(tags) != 0 {
-			for _, tag := range strings.Split(tags, ",") {
-				tag = strings.TrimSpace(tag)
-				key := tag[0:strings.LastIndex(tag, ":")]
-				value := tag[strings.LastIndex(tag, ":")+1 : len(tag)]
-
-				ec2Filters = append(ec2Filters, &ec2.Filter{
-					Name: aws.String("tag:" + key),
-					Values: []*string{
-						aws.String(value),
-					},
-				})
+			tagFilters, err := parseTagFilters(tags)
+			if err != nil {
+				return err
 			}
+			ec2Filters = append(ec2Filters, tagFilters...)
+		}
+
+		if isMultiTarget() {
+			if instanceAsgFilter || len(asgName) != 0 {
+				return newUsageError("--asg and --filter-by-asg cannot be used when listing across accounts or regions")
+			}
+			// Instances in several regions are always listed
+			return listAllInstances(ec2Filters, inputInstanceIds, cmd.Flags().Changed("list-format"))
 		}
 
 		if instanceAsgFilter && len(asgName) != 0 {
-			cmd.Usage()
-			log.Fatal("Only one of --instance-asg-filter and --asg must be specified")
+			return newUsageError("Only one of --filter-by-asg and --asg must be specified")
 		}
 
 		if instanceAsgFilter {
@@ -138,23 +199,32 @@ var describeInstancesCmd = &cobra.Command{
 			params := &autoscaling.DescribeAutoScalingGroupsInput{
 				MaxRecords: &maxSize,
 			}
-			autoScalingGroups := getAutoScalingGroups(params)
-			idx, _ := fuzzyfinder.Find(autoScalingGroups, func(i int) string {
+			autoScalingGroups, err := getAutoScalingGroups(getClients().AutoScaling(), params)
+			if err != nil {
+				return err
+			}
+			idx, err := fuzzyfinder.Find(autoScalingGroups, func(i int) string {
 				return fmt.Sprintf("%s", *autoScalingGroups[i].AutoScalingGroupName)
 			})
+			if err != nil {
+				return err
+			}
 			asgName = *autoScalingGroups[idx].AutoScalingGroupName
 		}
 
 		// Not filtering by ASG name
-		var instanceIDs []*string
 		if len(asgName) == 0 {
 			if listInstances {
-				instancesData := getEC2InstanceData(ec2Filters, inputInstanceIds...)
-				displayFixedInstanceDetails(instancesData...)
-			} else {
-				go getEC2InstanceIDs(ec2Filters, &instanceIDs)
-				displayEC2Interactive(&instanceIDs)
+				instancesData, err := getEC2InstanceData(svc, ec2Filters, inputInstanceIds...)
+				if err != nil {
+					return err
+				}
+				return displayFixedInstanceDetails(instancesData...)
 			}
+			cachedSvc := getClients().CachedEC2()
+			var instances instanceListing
+			go listEC2Instances(cachedSvc, ec2Filters, &instances)
+			return displayEC2Interactive(cachedSvc, &instances)
 		}
 
 		if len(asgName) != 0 {
@@ -167,8 +237,9 @@ var describeInstancesCmd = &cobra.Command{
 				AutoScalingGroupNames: asgNames,
 				MaxRecords:            &maxSize,
 			}
-			sess := createSession()
-			svc := autoscaling.New(sess)
+			svc := getClients().AutoScaling()
+			asgInstances := []asgInstanceOutput{}
+			var instancesErr error
 			err := svc.DescribeAutoScalingGroupsPages(params,
 				func(result *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
 					// When we support multiple ASG names, this will be a way
@@ -183,31 +254,23 @@ var describeInstancesCmd = &cobra.Command{
 								}
 								result, err := svc.DescribeAutoScalingInstances(input)
 								if err != nil {
-									if aerr, ok := err.(awserr.Error); ok {
-										switch aerr.Code() {
-										case autoscaling.ErrCodeInvalidNextToken:
-											fmt.Println(autoscaling.ErrCodeInvalidNextToken, aerr.Error())
-										case autoscaling.ErrCodeResourceContentionFault:
-											fmt.Println(autoscaling.ErrCodeResourceContentionFault, aerr.Error())
-										default:
-											fmt.Println(aerr.Error())
-										}
-									} else {
-										// Print the error, cast err to awserr.Error to get the Code and
-										// Message from an error.
-										fmt.Println(err.Error())
-									}
+									instancesErr = fmt.Errorf("Couldn't describe auto scaling instance %s: %w", *instance.InstanceId, err)
+									return false
 								}
 								for _, currentInstance := range result.AutoScalingInstances {
 									// If we are filtering by instance IDs, only show the details for the specified
 									// instance IDs
 									if len(inputInstanceIds) != 0 {
-										if _, ok := inputInstanceIdsMap[*currentInstance.InstanceId]; ok {
-											fmt.Println(*currentInstance.InstanceId, ":", *currentInstance.AutoScalingGroupName, ":", *currentInstance.AvailabilityZone, ":", *currentInstance.ProtectedFromScaleIn)
+										if _, ok := inputInstanceIdsMap[*currentInstance.InstanceId]; !ok {
+											continue
 										}
-									} else {
-										fmt.Println(*currentInstance.InstanceId, ":", *currentInstance.AutoScalingGroupName, ":", *currentInstance.AvailabilityZone, ":", *currentInstance.ProtectedFromScaleIn)
 									}
+									asgInstances = append(asgInstances, asgInstanceOutput{
+										InstanceId:           *currentInstance.InstanceId,
+										AutoScalingGroupName: *currentInstance.AutoScalingGroupName,
+										AvailabilityZone:     *currentInstance.AvailabilityZone,
+										ProtectedFromScaleIn: *currentInstance.ProtectedFromScaleIn,
+									})
 								}
 							}
 						} else {
@@ -217,18 +280,19 @@ var describeInstancesCmd = &cobra.Command{
 					return lastPage
 				})
 			if err != nil {
-				if aerr, ok := err.(awserr.Error); ok {
-					switch aerr.Code() {
-					case autoscaling.ErrCodeInvalidNextToken:
-						fmt.Println(autoscaling.ErrCodeInvalidNextToken, aerr.Error())
-					case autoscaling.ErrCodeResourceContentionFault:
-						fmt.Println(autoscaling.ErrCodeResourceContentionFault, aerr.Error())
-					default:
-						fmt.Println(aerr.Error())
-					}
-				}
+				return fmt.Errorf("Couldn't describe auto scaling group %s: %w", asgName, err)
+			}
+			if instancesErr != nil {
+				return instancesErr
+			}
+
+			table := newTableOutput("InstanceID", "AutoScalingGroup", "AvailabilityZone", "ProtectedFromScaleIn")
+			for _, i := range asgInstances {
+				table.addRow(i.InstanceId, i.AutoScalingGroupName, i.AvailabilityZone, fmt.Sprintf("%v", i.ProtectedFromScaleIn))
 			}
+			return renderItems("AutoScalingInstanceList", asgInstances, table)
 		}
+		return nil
 	},
 }
 
@@ -247,7 +311,9 @@ func init() {
 	describeInstancesCmd.Flags().StringVarP(&listInstancesFormat, "list-format", "", "{{.Name}} {{.Uptime}} {{.PrivateIPAddresses}}", "List instances format string")
 	describeInstancesCmd.Flags().BoolVarP(&listInstancesFormatHelp, "list-format-help", "", false, "List all valid format fields")
 	describeInstancesCmd.Flags().StringVarP(&instanceIds, "instance-id", "i", "", "Show details of the specified instance(s) (Example: i-a121aas, i=1212aa)")
+	describeInstancesCmd.MarkFlagCustom("instance-id", "__yawsi_instance_ids")
 	describeInstancesCmd.Flags().StringVarP(&tags, "tags", "t", "", "Tags to filter by (tag1:value1, tag2:value2)")
 	describeInstancesCmd.Flags().StringVarP(&asgName, "asg", "a", "", "List instances attached to this ASG")
 	describeInstancesCmd.Flags().BoolVarP(&instanceAsgFilter, "filter-by-asg", "", false, "Select instances attached to an Auto Scaling Group")
+	addListingFlags(describeInstancesCmd)
 }
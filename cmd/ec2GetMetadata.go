@@ -15,8 +15,8 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"reflect"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -26,13 +26,13 @@ import (
 )
 
 type ec2Metadata struct {
-	AWSRegion    string `env:"AWS_REGION"`
-	AWSAz        string `env:"AWS_AZ"`
-	InstanceID   string `env:"INSTANCE_ID"`
-	InstanceType string `env:"INSTANCE_TYPE"`
-	Name         string `env:"INSTANCE_NAME"`
-	PrivateIP    string `env:"PRIVATE_IP"`
-	AMI          string `env:"AMI"`
+	AWSRegion    string `env:"AWS_REGION" json:"region" yaml:"region"`
+	AWSAz        string `env:"AWS_AZ" json:"availabilityZone" yaml:"availabilityZone"`
+	InstanceID   string `env:"INSTANCE_ID" json:"instanceId" yaml:"instanceId"`
+	InstanceType string `env:"INSTANCE_TYPE" json:"instanceType" yaml:"instanceType"`
+	Name         string `env:"INSTANCE_NAME" json:"name" yaml:"name"`
+	PrivateIP    string `env:"PRIVATE_IP" json:"privateIp" yaml:"privateIp"`
+	AMI          string `env:"AMI" json:"ami" yaml:"ami"`
 }
 
 // FIXME: have a refresh loop
@@ -42,7 +42,7 @@ var ec2GetMetadataCmd = &cobra.Command{
 	Use:   "get-metadata",
 	Short: "Get EC2 Metadata of the current instance",
 	Long:  `Get EC2 metadata`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		// To get the instance details (for tags), we need to make a call to the
 		// AWS API for which we need to set a region which we can get from the
@@ -54,14 +54,14 @@ var ec2GetMetadataCmd = &cobra.Command{
 		ec2MetadataSvc := ec2metadata.New(sess)
 
 		if !ec2MetadataSvc.Available() {
-			log.Fatal("Could not access EC2 metadata service")
+			return errors.New("Could not access EC2 metadata service")
 		}
 
 		md := ec2Metadata{}
 
 		idDoc, err := ec2MetadataSvc.GetInstanceIdentityDocument()
 		if err != nil {
-			log.Fatal("Error retrieving instance Identity document", err.Error())
+			return fmt.Errorf("Error retrieving instance identity document: %w", err)
 		}
 
 		md.AWSRegion = idDoc.Region
@@ -72,7 +72,7 @@ var ec2GetMetadataCmd = &cobra.Command{
 		md.AMI = idDoc.ImageID
 
 		// To get the name tag we have to use the AWS API
-		ec2Svc := ec2.New(createSession(md.AWSRegion))
+		ec2Svc := newSessionClients(createSession(md.AWSRegion)).EC2()
 
 		input := &ec2.DescribeInstancesInput{
 			InstanceIds: []*string{
@@ -82,7 +82,7 @@ var ec2GetMetadataCmd = &cobra.Command{
 
 		result, err := ec2Svc.DescribeInstances(input)
 		if err != nil {
-			log.Fatal("Error getting instance details", err.Error())
+			return fmt.Errorf("Error getting instance details: %w", err)
 		}
 		for _, r := range result.Reservations {
 			for _, instance := range r.Instances {
@@ -104,8 +104,14 @@ var ec2GetMetadataCmd = &cobra.Command{
 				fmt.Printf("export %s=%s\n", envVar, valueField.Interface())
 			}
 		} else {
-			fmt.Printf("%#v\n", md)
+			table := newTableOutput("Key", "Value")
+			val := reflect.ValueOf(&md).Elem()
+			for i := 0; i < val.NumField(); i++ {
+				table.addRow(val.Type().Field(i).Name, fmt.Sprintf("%v", val.Field(i).Interface()))
+			}
+			return renderItems("InstanceMetadata", []ec2Metadata{md}, table)
 		}
+		return nil
 	},
 }
 
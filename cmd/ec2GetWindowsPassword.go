@@ -24,8 +24,13 @@ import (
 var getWindowsPassword = &cobra.Command{
 	Use:   "get-windows-password",
 	Short: "Get Windows Password",
-	Run: func(cmd *cobra.Command, args []string) {
-		log.Printf("%s\n", getWindowsPasswordHelper(args[0], KeyPath))
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, err := getWindowsPasswordHelper(getClients().EC2(), args[0], KeyPath)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s\n", password)
+		return nil
 	},
 	Args: cobra.ExactArgs(1),
 }
@@ -15,13 +15,6 @@
 package cmd
 
 import (
-	//"fmt"
-
-	// "github.com/aws/aws-sdk-go/aws/awserr"
-	"fmt"
-	"log"
-	"os"
-
 	"github.com/spf13/cobra"
 )
 
@@ -91,8 +84,9 @@ func checkPublic(state *instanceState) *checkResult {
 
 	result.Metadata = ingressResult.Metadata
 	for k, v := range egressResult.Metadata {
-		if result.Metadata[k] != nil && len(result.Metadata[k].(string)) != 0 {
-			log.Fatal("Duplicate key, Fix it!")
+		// The ingress check's metadata is kept if both checks set a key
+		if _, ok := result.Metadata[k]; ok {
+			continue
 		}
 		result.Metadata[k] = v
 	}
@@ -132,22 +126,35 @@ Check if the outside world can initiate communication with a EC2 instance and vi
 	yawsi.exe ec2  inspect i-06d80024e0df241da --public --verbose
 	✖ Outside world cannot initiate connection with the instance.
 	false
+
+The result can also be consumed by scripts:
+
+	yawsi --output json ec2 inspect i-06d80024e0df241da --public-egress
+
+The checks can be run against a snapshot created via "yawsi snapshot capture":
+
+	yawsi ec2 inspect i-06d80024e0df241da --public-egress --from-snapshot prod.json
 	`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		var inputInstanceIds []*string
 
 		if !(publicIngress || publicEgress || public) {
-			cmd.Help()
-			os.Exit(1)
+			return newUsageError("Specify one of --public-ingress, --public-egress or --public")
 		}
 
+		svc := getClients().EC2()
 		inputInstanceIds = append(inputInstanceIds, &args[0])
-		instanceData := getEC2InstanceData(nil, inputInstanceIds...)
+		instanceData, err := getEC2InstanceData(svc, nil, inputInstanceIds...)
+		if err != nil {
+			return err
+		}
 
 		if len(instanceData) != 1 {
-			panic("Couldn't retrieve instance data")
+			return newNotFoundError("Couldn't retrieve instance data for %s", args[0])
+		}
+		if instanceData[0].Routes, err = getRoutes(svc, instanceData[0].SubnetIds...); err != nil {
+			return err
 		}
-		instanceData[0].Routes = getRoutes(instanceData[0].SubnetIds...)
 
 		var result *checkResult
 
@@ -164,7 +171,7 @@ Check if the outside world can initiate communication with a EC2 instance and vi
 		}
 
 		displayResult(result)
-		fmt.Printf("%v\n", summarizeResults(result))
+		return renderCheckResults("InspectionReport", result)
 	},
 	Args: cobra.ExactArgs(1),
 }
@@ -176,6 +183,7 @@ var public bool
 
 func init() {
 	ec2Cmd.AddCommand(inspectInstancesCmd)
+	addSnapshotFlag(inspectInstancesCmd)
 	inspectInstancesCmd.Flags().BoolVarP(&publicIngress, "public-ingress", "", false, "Am I visible to the outside world (do I have a public IP)?")
 	inspectInstancesCmd.Flags().BoolVarP(&publicEgress, "public-egress", "", false, "Can I see the outside world?")
 	inspectInstancesCmd.Flags().BoolVarP(&public, "public", "", false, "Can the outside world see me and vice-versa?")
@@ -0,0 +1,642 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// networkContract is the file of connectivity assertions `ec2 inspect
+// assert` checks, in YAML or TOML
+type networkContract struct {
+	Assertions []connectivityAssertion `yaml:"assertions" toml:"assertions"`
+}
+
+// connectivityAssertion is the expected result of traffic from each of the
+// sources to each of the destinations
+type connectivityAssertion struct {
+	Name        string           `yaml:"name" toml:"name"`
+	Source      endpointSelector `yaml:"source" toml:"source"`
+	Destination endpointSelector `yaml:"destination" toml:"destination"`
+	Protocol    string           `yaml:"protocol" toml:"protocol"`
+	Port        int64            `yaml:"port" toml:"port"`
+	// The ICMP type and code with protocol icmp or icmpv6, an echo request
+	// by default
+	ICMPType *int64 `yaml:"icmpType" toml:"icmpType"`
+	ICMPCode int64  `yaml:"icmpCode" toml:"icmpCode"`
+	// allow or deny
+	Expect string `yaml:"expect" toml:"expect"`
+}
+
+// endpointSelector selects the instances with the IDs, the running
+// instances with all the tags and the IP addresses or CIDR blocks
+type endpointSelector struct {
+	Instances []string          `yaml:"instances" toml:"instances"`
+	Tags      map[string]string `yaml:"tags" toml:"tags"`
+	IPs       []string          `yaml:"ips" toml:"ips"`
+}
+
+const (
+	expectAllow = "allow"
+	expectDeny  = "deny"
+)
+
+// assertionEndpoint is an instance or an IP address or CIDR block a
+// selector selected
+type assertionEndpoint struct {
+	name    string
+	state   *instanceState
+	network *net.IPNet
+}
+
+// assertionResult is the result of an assertion for a source and a
+// destination, Error is set when it couldn't be checked
+type assertionResult struct {
+	Assertion   string         `json:"assertion" yaml:"assertion"`
+	Source      string         `json:"source" yaml:"source"`
+	Destination string         `json:"destination" yaml:"destination"`
+	Traffic     string         `json:"traffic" yaml:"traffic"`
+	Expected    string         `json:"expected" yaml:"expected"`
+	Actual      string         `json:"actual,omitempty" yaml:"actual,omitempty"`
+	Passed      bool           `json:"passed" yaml:"passed"`
+	Error       string         `json:"error,omitempty" yaml:"error,omitempty"`
+	Checks      []*checkResult `json:"checks,omitempty" yaml:"checks,omitempty"`
+}
+
+// reason explains a failed result: the error, the first check which blocked
+// traffic which should be allowed, or that traffic which should be denied
+// is allowed
+func (r *assertionResult) reason() string {
+	switch {
+	case r.Passed:
+		return ""
+	case len(r.Error) != 0:
+		return r.Error
+	case r.Actual == expectAllow:
+		return "All the checks passed"
+	}
+	for _, c := range r.Checks {
+		if !c.Result {
+			return c.DisplayText
+		}
+	}
+	return "No addresses to check"
+}
+
+// loadNetworkContract reads the assertions from a YAML or, when the file
+// name ends with .toml, a TOML file and validates them
+func loadNetworkContract(filePath string) (*networkContract, error) {
+	contract := &networkContract{}
+	if strings.EqualFold(filepath.Ext(filePath), ".toml") {
+		if _, err := toml.DecodeFile(filePath, contract); err != nil {
+			return nil, newUsageError("Error reading %s: %v", filePath, err)
+		}
+	} else {
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, newUsageError("Error reading %s: %v", filePath, err)
+		}
+		if err := yaml.UnmarshalStrict(data, contract); err != nil {
+			return nil, newUsageError("Error reading %s: %v", filePath, err)
+		}
+	}
+	if len(contract.Assertions) == 0 {
+		return nil, newUsageError("No assertions in %s", filePath)
+	}
+
+	for i := range contract.Assertions {
+		a := &contract.Assertions[i]
+		if len(a.Name) == 0 {
+			a.Name = fmt.Sprintf("assertion %d", i+1)
+		}
+		a.Protocol = strings.ToLower(a.Protocol)
+		a.Expect = strings.ToLower(a.Expect)
+		switch a.Protocol {
+		case "tcp", "udp":
+			if a.Port < 1 || a.Port > 65535 {
+				return nil, newUsageError("%s: specify a port between 1 and 65535", a.Name)
+			}
+		case "icmp", "icmpv6":
+			if a.Port != 0 {
+				return nil, newUsageError("%s: port can't be used with %s, specify icmpType and icmpCode", a.Name, a.Protocol)
+			}
+		default:
+			return nil, newUsageError("%s: unsupported protocol %q, must be one of tcp, udp, icmp or icmpv6", a.Name, a.Protocol)
+		}
+		if a.Expect != expectAllow && a.Expect != expectDeny {
+			return nil, newUsageError("%s: expect must be %s or %s", a.Name, expectAllow, expectDeny)
+		}
+		if a.Source.empty() || a.Destination.empty() {
+			return nil, newUsageError("%s: specify the instances, tags or IPs of the source and the destination", a.Name)
+		}
+		if len(a.Source.Instances) == 0 && len(a.Source.Tags) == 0 && len(a.Destination.Instances) == 0 && len(a.Destination.Tags) == 0 {
+			return nil, newUsageError("%s: the source or the destination must be an instance", a.Name)
+		}
+		for _, ip := range append(append([]string{}, a.Source.IPs...), a.Destination.IPs...) {
+			if _, err := parseNetwork(ip); err != nil {
+				return nil, newUsageError("%s: invalid IP address or CIDR block %q", a.Name, ip)
+			}
+		}
+	}
+	return contract, nil
+}
+
+func (s endpointSelector) empty() bool {
+	return len(s.Instances) == 0 && len(s.Tags) == 0 && len(s.IPs) == 0
+}
+
+// ports returns the destination port of the traffic or, for ICMP, the type
+// and code (see trafficPortRange)
+func (a *connectivityAssertion) ports() ec2.PortRange {
+	if isICMPProtocol(a.Protocol) {
+		icmpType := defaultICMPType(a.Protocol)
+		if a.ICMPType != nil {
+			icmpType = *a.ICMPType
+		}
+		return ec2.PortRange{From: aws.Int64(icmpType), To: aws.Int64(a.ICMPCode)}
+	}
+	return ec2.PortRange{From: aws.Int64(a.Port), To: aws.Int64(a.Port)}
+}
+
+// selectorFilters returns the filters for the running instances with all
+// the tags of the selector
+func selectorFilters(tags map[string]string) []*ec2.Filter {
+	var keys []string
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	filters := []*ec2.Filter{{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"running"})}}
+	for _, key := range keys {
+		filters = append(filters, &ec2.Filter{Name: aws.String("tag:" + key), Values: aws.StringSlice([]string{tags[key]})})
+	}
+	return filters
+}
+
+// resolveSelector returns the endpoints of a selector. The instances are
+// looked up in states first and added to it, so that each instance is
+// described and enriched once.
+func resolveSelector(svc ec2iface.EC2API, selector endpointSelector, states map[string]*instanceState) ([]*assertionEndpoint, error) {
+	var endpoints []*assertionEndpoint
+	seen := make(map[string]bool)
+	add := func(state *instanceState) {
+		if existing, ok := states[state.InstanceId]; ok {
+			state = existing
+		}
+		states[state.InstanceId] = state
+		if !seen[state.InstanceId] {
+			seen[state.InstanceId] = true
+			endpoints = append(endpoints, &assertionEndpoint{name: state.InstanceId, state: state})
+		}
+	}
+
+	if len(selector.Instances) != 0 {
+		// Filtering by ID doesn't fail for the instances which don't exist
+		filters := []*ec2.Filter{{Name: aws.String("instance-id"), Values: aws.StringSlice(selector.Instances)}}
+		instanceData, err := getEC2InstanceData(svc, filters)
+		if err != nil {
+			return nil, err
+		}
+		for _, state := range instanceData {
+			add(state)
+		}
+		for _, instanceID := range selector.Instances {
+			if !seen[instanceID] {
+				return nil, newNotFoundError("Instance %s not found", instanceID)
+			}
+		}
+	}
+	if len(selector.Tags) != 0 {
+		instanceData, err := getEC2InstanceData(svc, selectorFilters(selector.Tags))
+		if err != nil {
+			return nil, err
+		}
+		if len(instanceData) == 0 {
+			return nil, newNotFoundError("No running instances with the tags %v", selector.Tags)
+		}
+		for _, state := range instanceData {
+			add(state)
+		}
+	}
+	for _, ip := range selector.IPs {
+		network, err := parseNetwork(ip)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, &assertionEndpoint{name: ip, network: network})
+	}
+	return endpoints, nil
+}
+
+// checkAssertionPair runs the connectivity checks from the source to the
+// destination, for traffic from an IP address or CIDR block only the
+// destination side is checked
+func checkAssertionPair(source *assertionEndpoint, dest *assertionEndpoint, protocol string, ports *ec2.PortRange, ephemeralPorts *ec2.PortRange) []*checkResult {
+	if source.state != nil {
+		destination := &connectivityDestination{state: dest.state}
+		if dest.state == nil {
+			destination.networks = []*net.IPNet{dest.network}
+		}
+		return checkConnectivityToDestination(source.state, addressNetworks(allAddresses(source.state)), destination, protocol, ports, ephemeralPorts)
+	}
+
+	return checkConnectivityFromAddress(source.network, &connectivityDestination{state: dest.state}, protocol, ports, ephemeralPorts)
+}
+
+// ruleNetworks returns the CIDR blocks of the network ACL entries, security
+// group rules, prefix lists and routes of the state
+func ruleNetworks(state *instanceState) []*net.IPNet {
+	var cidrs []string
+	for _, acl := range state.NetworkAcls {
+		for _, entry := range acl.Entries {
+			cidrs = append(cidrs, aws.StringValue(naclEntryCIDR(entry)))
+		}
+	}
+	for _, rule := range state.SecurityGroupRules {
+		for _, ipRange := range rule.permission.IpRanges {
+			cidrs = append(cidrs, aws.StringValue(ipRange.CidrIp))
+		}
+		for _, ipRange := range rule.permission.Ipv6Ranges {
+			cidrs = append(cidrs, aws.StringValue(ipRange.CidrIpv6))
+		}
+	}
+	for _, blocks := range state.PrefixLists {
+		cidrs = append(cidrs, blocks...)
+	}
+	for _, routeTable := range state.Routes {
+		for _, route := range routeTable.Routes {
+			cidrs = append(cidrs, routeDestinationCIDRs(route, state.PrefixLists)...)
+		}
+	}
+
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+// splitNetwork splits the network into the blocks which each of the rule
+// networks either contains or doesn't overlap, so that all the addresses of
+// a block are treated alike
+func splitNetwork(network *net.IPNet, rules []*net.IPNet) []*net.IPNet {
+	for _, rule := range rules {
+		if !networkContains(network, rule) || networkContains(rule, network) {
+			continue
+		}
+		ones, bits := network.Mask.Size()
+		mask := net.CIDRMask(ones+1, bits)
+		lower := &net.IPNet{IP: network.IP.Mask(network.Mask), Mask: mask}
+		upper := &net.IPNet{IP: append(net.IP{}, lower.IP...), Mask: mask}
+		upper.IP[ones/8] |= 0x80 >> uint(ones%8)
+		return append(splitNetwork(lower, rules), splitNetwork(upper, rules)...)
+	}
+	return []*net.IPNet{network}
+}
+
+// checkAnyAddress runs the checks for the blocks of the source network from
+// splitNetwork and returns those of the first block whose traffic is
+// allowed, nil when no address of the network is allowed
+func checkAnyAddress(source *assertionEndpoint, dest *assertionEndpoint, protocol string, ports *ec2.PortRange, ephemeralPorts *ec2.PortRange) []*checkResult {
+	for _, block := range splitNetwork(source.network, ruleNetworks(dest.state)) {
+		checks := checkAssertionPair(&assertionEndpoint{name: block.String(), network: block}, dest, protocol, ports, ephemeralPorts)
+		if len(checks) != 0 && summarizeResults(checks...) {
+			return checks
+		}
+	}
+	return nil
+}
+
+// checkAssertions checks each assertion for every pair of its sources and
+// destinations. An assertion whose selectors can't be resolved fails with
+// the error, the other errors are returned.
+func checkAssertions(svc ec2iface.EC2API, contract *networkContract, ephemeralPorts *ec2.PortRange) ([]*assertionResult, error) {
+	type resolved struct {
+		sources, destinations []*assertionEndpoint
+		err                   error
+	}
+	states := make(map[string]*instanceState)
+	endpoints := make([]resolved, len(contract.Assertions))
+	for i, a := range contract.Assertions {
+		var r resolved
+		r.sources, r.err = resolveSelector(svc, a.Source, states)
+		if r.err == nil {
+			r.destinations, r.err = resolveSelector(svc, a.Destination, states)
+		}
+		var notFoundErr *notFoundError
+		if r.err != nil && !errors.As(r.err, &notFoundErr) {
+			return nil, r.err
+		}
+		endpoints[i] = r
+	}
+
+	var all []*instanceState
+	for _, state := range states {
+		all = append(all, state)
+	}
+	if len(all) != 0 {
+		if err := enrichInstanceStates(svc, all...); err != nil {
+			return nil, err
+		}
+	}
+
+	var results []*assertionResult
+	for i, a := range contract.Assertions {
+		a := a
+		ports := a.ports()
+		newResult := func(source, destination string) *assertionResult {
+			return &assertionResult{
+				Assertion:   a.Name,
+				Source:      source,
+				Destination: destination,
+				Traffic:     describeTraffic(a.Protocol, &ports),
+				Expected:    a.Expect,
+			}
+		}
+		if err := endpoints[i].err; err != nil {
+			r := newResult(a.Source.String(), a.Destination.String())
+			r.Error = err.Error()
+			results = append(results, r)
+			continue
+		}
+		for _, source := range endpoints[i].sources {
+			for _, dest := range endpoints[i].destinations {
+				if source.state != nil && source.state == dest.state {
+					continue
+				}
+				r := newResult(source.name, dest.name)
+				if source.state == nil && dest.state == nil {
+					r.Error = "Traffic between IP addresses can't be checked, the source or the destination must be an instance"
+					results = append(results, r)
+					continue
+				}
+				r.Checks = checkAssertionPair(source, dest, a.Protocol, &ports, ephemeralPorts)
+				if len(r.Checks) == 0 {
+					r.Error = "The source and the destination have no addresses of the same IP version"
+					results = append(results, r)
+					continue
+				}
+				r.Actual = expectDeny
+				if summarizeResults(r.Checks...) {
+					r.Actual = expectAllow
+				} else if a.Expect == expectDeny && source.state == nil {
+					// A CIDR block source is only allowed when all of
+					// its addresses are, the traffic of a deny assertion
+					// mustn't be allowed from any of them
+					if checks := checkAnyAddress(source, dest, a.Protocol, &ports, ephemeralPorts); checks != nil {
+						r.Checks = checks
+						r.Actual = expectAllow
+					}
+				}
+				r.Passed = r.Actual == r.Expected
+				results = append(results, r)
+			}
+		}
+	}
+	return results, nil
+}
+
+// String describes the selector for the results of an assertion which
+// couldn't be resolved
+func (s endpointSelector) String() string {
+	var parts []string
+	parts = append(parts, s.Instances...)
+	var keys []string
+	for key := range s.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts = append(parts, key+":"+s.Tags[key])
+	}
+	parts = append(parts, s.IPs...)
+	return strings.Join(parts, ",")
+}
+
+// countAssertions returns the number of assertions and of the ones with a
+// failed result
+func countAssertions(results []*assertionResult) (int, int) {
+	var names []string
+	failed := make(map[string]bool)
+	for _, r := range results {
+		if _, ok := failed[r.Assertion]; !ok {
+			names = append(names, r.Assertion)
+			failed[r.Assertion] = false
+		}
+		if !r.Passed {
+			failed[r.Assertion] = true
+		}
+	}
+	violations := 0
+	for _, name := range names {
+		if failed[name] {
+			violations++
+		}
+	}
+	return len(names), violations
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnitReport writes the results as a JUnit XML test suite named after
+// the contract, with a test case for each source and destination
+func writeJUnitReport(filePath string, suiteName string, results []*assertionResult) error {
+	suite := junitTestSuite{Name: suiteName, Tests: len(results)}
+	for _, r := range results {
+		testCase := junitTestCase{
+			Name:      fmt.Sprintf("%s -> %s %s", r.Source, r.Destination, r.Traffic),
+			ClassName: r.Assertion,
+		}
+		switch {
+		case len(r.Error) != 0:
+			testCase.Error = &junitFailure{Message: r.Error}
+			suite.Errors++
+		case !r.Passed:
+			var failed []string
+			for _, c := range r.Checks {
+				if !c.Result {
+					failed = append(failed, c.DisplayText)
+				}
+			}
+			testCase.Failure = &junitFailure{
+				Message: fmt.Sprintf("Expected %s, got %s: %s", r.Expected, r.Actual, r.reason()),
+				Text:    strings.Join(failed, "\n"),
+			}
+			suite.Failures++
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(xml.Header), data...)
+	if err := ioutil.WriteFile(filePath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("Couldn't write the JUnit report: %w", err)
+	}
+	return nil
+}
+
+var contractFilePath string
+var junitFilePath string
+
+var inspectAssertCmd = &cobra.Command{
+	Use:   "assert",
+	Short: "Check the connectivity assertions of a network contract",
+	Long: `Check that traffic the network contract expects to be allowed is allowed and traffic it expects to be
+denied isn't. The contract is a YAML (or, with a .toml extension, TOML) file of assertions, each with the
+source and destination instances (by ID or by tags) or IP addresses and CIDR blocks, the protocol, the port
+and the expected result:
+
+	assertions:
+	- name: app tier reaches the database
+	  source:
+	    tags:
+	      Tier: app
+	  destination:
+	    instances: [i-03fb71646161e8626]
+	  protocol: tcp
+	  port: 5432
+	  expect: allow
+	- name: database isn't reachable from the internet
+	  source:
+	    ips: [0.0.0.0/0]
+	  destination:
+	    tags:
+	      Tier: db
+	  protocol: tcp
+	  port: 5432
+	  expect: deny
+
+The selectors of a source or destination are combined: the instances with the IDs, the running instances
+with all the tags and the IP addresses. Every source is checked against every destination, with the same
+checks as ec2 inspect connectivity, and an assertion is violated when the result of any of them isn't the
+expected one. For traffic from an IP address or CIDR block only the destination side is checked: an allow
+assertion needs every address of the block to be allowed, a deny assertion is violated by any allowed one. ICMP
+assertions use icmpType and icmpCode instead of port, an echo request by default.
+
+The command exits with 1 when an assertion is violated, --junit writes the results as JUnit XML for CI:
+
+	yawsi ec2 inspect assert -f network-contract.yaml --junit network-contract.xml
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(contractFilePath) == 0 {
+			return newUsageError("Specify the network contract via -f")
+		}
+		contract, err := loadNetworkContract(contractFilePath)
+		if err != nil {
+			return err
+		}
+		ephemeralPorts, err := parseEphermalPortRange()
+		if err != nil {
+			return err
+		}
+
+		results, err := checkAssertions(getClients().EC2(), contract, &ephemeralPorts)
+		if err != nil {
+			return err
+		}
+		if len(junitFilePath) != 0 {
+			suiteName := strings.TrimSuffix(filepath.Base(contractFilePath), filepath.Ext(contractFilePath))
+			if err := writeJUnitReport(junitFilePath, suiteName, results); err != nil {
+				return err
+			}
+		}
+
+		assertions, violations := countAssertions(results)
+		summary := violations == 0
+		if isTableOutput() {
+			table := newTableOutput("Assertion", "Source", "Destination", "Traffic", "Expected", "Actual", "Result", "Reason")
+			for _, r := range results {
+				result := "PASS"
+				if !r.Passed {
+					result = "FAIL"
+				}
+				table.addRow(r.Assertion, r.Source, r.Destination, r.Traffic, r.Expected, r.Actual, result, r.reason())
+			}
+			if err := writeTable(table); err != nil {
+				return err
+			}
+			fmt.Fprintf(outputWriter, "%d of %d assertions passed, %d violated\n", assertions-violations, assertions, violations)
+		} else {
+			if results == nil {
+				results = []*assertionResult{}
+			}
+			err := writeDocument(outputDocument{
+				APIVersion: outputAPIVersion,
+				Kind:       "AssertionReport",
+				Result:     &summary,
+				Items:      results,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if !summary {
+			return errCheckFailed
+		}
+		return nil
+	},
+	Args: cobra.NoArgs,
+}
+
+func init() {
+	inspectInstancesCmd.AddCommand(inspectAssertCmd)
+	addSnapshotFlag(inspectAssertCmd)
+	inspectAssertCmd.Flags().StringVarP(&contractFilePath, "file", "f", "", "Network contract (YAML or TOML)")
+	inspectAssertCmd.Flags().StringVarP(&junitFilePath, "junit", "", "", "Write the results as JUnit XML to this file")
+	inspectAssertCmd.Flags().StringVarP(&customEphermalPortRange, "override-ephermal-port-range", "", "", "Override ephermal port range")
+}
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeContract(t *testing.T, dir string, name string, contents string) string {
+	filePath := path.Join(dir, name)
+	if err := ioutil.WriteFile(filePath, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestLoadNetworkContract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yawsi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	contract, err := loadNetworkContract(writeContract(t, dir, "contract.yaml", `
+assertions:
+- source:
+    tags:
+      Tier: app
+  destination:
+    instances: [i-dst]
+  protocol: TCP
+  port: 5432
+  expect: Allow
+- name: ping
+  source:
+    instances: [i-src]
+  destination:
+    ips: [10.0.2.0/24]
+  protocol: icmp
+  expect: deny
+`))
+	if assert.NoError(t, err) && assert.Len(t, contract.Assertions, 2) {
+		assert.Equal(t, "assertion 1", contract.Assertions[0].Name)
+		assert.Equal(t, "tcp", contract.Assertions[0].Protocol)
+		assert.Equal(t, expectAllow, contract.Assertions[0].Expect)
+		assert.Equal(t, map[string]string{"Tier": "app"}, contract.Assertions[0].Source.Tags)
+		assert.Equal(t, ec2.PortRange{From: aws.Int64(8), To: aws.Int64(0)}, contract.Assertions[1].ports())
+	}
+
+	contract, err = loadNetworkContract(writeContract(t, dir, "contract.toml", `
+[[assertions]]
+name = "app to db"
+protocol = "tcp"
+port = 5432
+expect = "allow"
+[assertions.source]
+instances = ["i-src"]
+[assertions.destination]
+tags = { Tier = "db" }
+`))
+	if assert.NoError(t, err) && assert.Len(t, contract.Assertions, 1) {
+		assert.Equal(t, []string{"i-src"}, contract.Assertions[0].Source.Instances)
+		assert.Equal(t, map[string]string{"Tier": "db"}, contract.Assertions[0].Destination.Tags)
+	}
+
+	for _, invalid := range []string{
+		"assertions: []",
+		"assertions:\n- {source: {instances: [i-src]}, destination: {instances: [i-dst]}, protocol: tcp, expect: allow}",
+		"assertions:\n- {source: {instances: [i-src]}, destination: {instances: [i-dst]}, protocol: tcp, port: 22, expect: maybe}",
+		"assertions:\n- {source: {ips: [10.0.0.1]}, destination: {ips: [10.0.0.2]}, protocol: tcp, port: 22, expect: deny}",
+		"assertions:\n- {source: {instance: [i-src]}, destination: {instances: [i-dst]}, protocol: tcp, port: 22, expect: deny}",
+	} {
+		_, err := loadNetworkContract(writeContract(t, dir, "invalid.yaml", invalid))
+		assert.Equal(t, exitUsage, exitCode(err), invalid)
+	}
+}
+
+func TestCheckAssertions(t *testing.T) {
+	c := newConnectivityFixture()
+	c.ec2.Instances[0].Tags = []*ec2.Tag{{Key: aws.String("Tier"), Value: aws.String("app")}}
+
+	contract := &networkContract{Assertions: []connectivityAssertion{
+		{
+			Name:        "app reaches db",
+			Source:      endpointSelector{Tags: map[string]string{"Tier": "app"}},
+			Destination: endpointSelector{Instances: []string{"i-dst"}},
+			Protocol:    "tcp",
+			Port:        5432,
+			Expect:      expectAllow,
+		},
+		{
+			Name:        "no ssh to db",
+			Source:      endpointSelector{Instances: []string{"i-src"}, IPs: []string{"10.0.3.7"}},
+			Destination: endpointSelector{Instances: []string{"i-dst"}},
+			Protocol:    "tcp",
+			Port:        22,
+			Expect:      expectDeny,
+		},
+		{
+			Name:        "db reaches app",
+			Source:      endpointSelector{Instances: []string{"i-dst"}},
+			Destination: endpointSelector{Tags: map[string]string{"Tier": "app"}},
+			Protocol:    "tcp",
+			Port:        5432,
+			Expect:      expectAllow,
+		},
+		{
+			Name:        "missing instance",
+			Source:      endpointSelector{Instances: []string{"i-missing"}},
+			Destination: endpointSelector{Instances: []string{"i-dst"}},
+			Protocol:    "tcp",
+			Port:        5432,
+			Expect:      expectAllow,
+		},
+	}}
+
+	results, err := checkAssertions(c.EC2(), contract, &defaultEphermalPortRange)
+	if !assert.NoError(t, err) || !assert.Len(t, results, 5) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{"i-src", "i-dst", expectAllow}, []string{results[0].Source, results[0].Destination, results[0].Actual})
+	assert.True(t, results[0].Passed)
+	assert.Equal(t, []string{"i-src", "10.0.3.7"}, []string{results[1].Source, results[2].Source})
+	assert.True(t, results[1].Passed)
+	assert.True(t, results[2].Passed)
+	assert.False(t, results[3].Passed)
+	assert.Equal(t, expectDeny, results[3].Actual)
+	assert.NotEmpty(t, results[3].reason())
+	assert.False(t, results[4].Passed)
+	assert.Equal(t, "Instance i-missing not found", results[4].Error)
+
+	assertions, violations := countAssertions(results)
+	assert.Equal(t, 4, assertions)
+	assert.Equal(t, 2, violations)
+
+	dir, err := ioutil.TempDir("", "yawsi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	reportPath := path.Join(dir, "report.xml")
+	assert.NoError(t, writeJUnitReport(reportPath, "network-contract", results))
+	data, err := ioutil.ReadFile(reportPath)
+	assert.NoError(t, err)
+	report := string(data)
+	assert.True(t, strings.HasPrefix(report, `<?xml version="1.0" encoding="UTF-8"?>`))
+	assert.Contains(t, report, `<testsuite name="network-contract" tests="5" failures="1" errors="1">`)
+	assert.Contains(t, report, `<testcase name="i-src -&gt; i-dst tcp 5432" classname="app reaches db"></testcase>`)
+	assert.Contains(t, report, `<failure message="Expected allow, got deny: `)
+	assert.Contains(t, report, `<error message="Instance i-missing not found"></error>`)
+}
+
+func TestCheckAssertionsWithoutChecks(t *testing.T) {
+	c := newConnectivityFixture()
+
+	// i-dst has no IPv6 address, so there is nothing to check rather than
+	// the traffic being denied
+	contract := &networkContract{Assertions: []connectivityAssertion{{
+		Name:        "no ipv6 to db",
+		Source:      endpointSelector{IPs: []string{"2600:1f18::7"}},
+		Destination: endpointSelector{Instances: []string{"i-dst"}},
+		Protocol:    "tcp",
+		Port:        5432,
+		Expect:      expectDeny,
+	}}}
+	results, err := checkAssertions(c.EC2(), contract, &defaultEphermalPortRange)
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.False(t, results[0].Passed)
+		assert.Empty(t, results[0].Actual)
+		assert.Equal(t, "The source and the destination have no addresses of the same IP version", results[0].Error)
+	}
+}
+
+func TestCheckAssertionsDenyFromCIDR(t *testing.T) {
+	c := newConnectivityFixture()
+	contract := &networkContract{Assertions: []connectivityAssertion{{
+		Name:        "app subnet can't reach db",
+		Source:      endpointSelector{IPs: []string{"10.0.1.0/24"}},
+		Destination: endpointSelector{Instances: []string{"i-dst"}},
+		Protocol:    "tcp",
+		Port:        5432,
+		Expect:      expectDeny,
+	}}}
+
+	// sg-dst only allows sg-src
+	results, err := checkAssertions(c.EC2(), contract, &defaultEphermalPortRange)
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.True(t, results[0].Passed)
+		assert.Equal(t, expectDeny, results[0].Actual)
+	}
+
+	// One /28 of the subnet being allowed violates the assertion
+	c.ec2.SecurityGroups[1].IpPermissions[0].IpRanges = []*ec2.IpRange{{CidrIp: aws.String("10.0.1.16/28")}}
+	results, err = checkAssertions(c.EC2(), contract, &defaultEphermalPortRange)
+	if assert.NoError(t, err) && assert.Len(t, results, 1) {
+		assert.False(t, results[0].Passed)
+		assert.Equal(t, expectAllow, results[0].Actual)
+		assert.Contains(t, results[0].Checks[len(results[0].Checks)-1].DisplayText, "10.0.1.16/28")
+	}
+}
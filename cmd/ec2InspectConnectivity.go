@@ -15,394 +15,228 @@
 package cmd
 
 import (
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws" //"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/fatih/color"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/spf13/cobra"
-	"log"
 	"net"
-	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
-func getSubnetCIDR(svc *ec2.EC2, subnetIDs ...string) map[string]string {
+// getSubnetCIDR returns the CIDR block of each subnet, describing the subnets
+// in batches
+func getSubnetCIDR(svc ec2iface.EC2API, subnetIDs ...string) (map[string]string, error) {
+	subnetCIDR, _, err := getSubnetCIDRs(svc, subnetIDs...)
+	return subnetCIDR, err
+}
+
+// getSubnetCIDRs returns the IPv4 and the IPv6 CIDR block of each subnet,
+// subnets without an IPv6 CIDR block are left out of the latter
+func getSubnetCIDRs(svc ec2iface.EC2API, subnetIDs ...string) (map[string]string, map[string]string, error) {
 
+	var mu sync.Mutex
 	var subnetCIDR = make(map[string]string)
+	var subnetIPv6CIDR = make(map[string]string)
 
-	for _, subnetID := range subnetIDs {
+	err := describeInBatches(subnetIDs, func(batch []string) error {
 		input := &ec2.DescribeSubnetsInput{
-			SubnetIds: []*string{
-				aws.String(subnetID),
-			},
+			SubnetIds: aws.StringSlice(batch),
+		}
+		subnets, err := getSubnets(svc, input)
+		if err != nil {
+			return err
 		}
-		subnets := getSubnets(input)
-		if len(subnets) == 1 {
-			subnetCIDR[subnetID] = *subnets[0].CidrBlock
+		mu.Lock()
+		defer mu.Unlock()
+		for _, subnet := range subnets {
+			subnetCIDR[*subnet.SubnetId] = *subnet.CidrBlock
+			for _, association := range subnet.Ipv6CidrBlockAssociationSet {
+				if association.Ipv6CidrBlockState == nil || aws.StringValue(association.Ipv6CidrBlockState.State) == ec2.SubnetCidrBlockStateCodeAssociated {
+					subnetIPv6CIDR[*subnet.SubnetId] = aws.StringValue(association.Ipv6CidrBlock)
+				}
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
-	return subnetCIDR
+	return subnetCIDR, subnetIPv6CIDR, nil
 }
 
-func getNetworkAcls(svc *ec2.EC2, subnetIDs ...string) map[string]*ec2.NetworkAcl {
+// getNetworkAcls returns the network ACL associated with each subnet,
+// describing the network ACLs in batches
+func getNetworkAcls(svc ec2iface.EC2API, subnetIDs ...string) (map[string]*ec2.NetworkAcl, error) {
 
+	var mu sync.Mutex
 	var networkACLs = make(map[string]*ec2.NetworkAcl)
 
-	for _, subnetID := range subnetIDs {
+	err := describeInBatches(subnetIDs, func(batch []string) error {
 		input := &ec2.DescribeNetworkAclsInput{
 			Filters: []*ec2.Filter{
 				{
-					Name: aws.String("association.subnet-id"),
-					Values: []*string{
-						aws.String(subnetID),
-					},
+					Name:   aws.String("association.subnet-id"),
+					Values: aws.StringSlice(batch),
 				},
 			},
 		}
 
 		result, err := svc.DescribeNetworkAcls(input)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("Couldn't describe the network ACLs of %v: %w", batch, err)
 		}
-		if len(result.NetworkAcls) == 1 {
-			networkACLs[subnetID] = result.NetworkAcls[0]
-		} else {
-			log.Fatal("Expected 1 network acl entry")
+		mu.Lock()
+		defer mu.Unlock()
+		for _, acl := range result.NetworkAcls {
+			for _, association := range acl.Associations {
+				networkACLs[aws.StringValue(association.SubnetId)] = acl
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return networkACLs
-}
-
-func getSecurityGroupRules(svc *ec2.EC2, securityGroups []*ec2.GroupIdentifier) []*SecurityGroupRule {
 
-	var securityGroupIds []*string
-	for _, group := range securityGroups {
-		securityGroupIds = append(securityGroupIds, aws.String(*group.GroupId))
-	}
-	input := &ec2.DescribeSecurityGroupsInput{
-		GroupIds: securityGroupIds,
+	// A network ACL is usually associated with other subnets too, only keep
+	// the ones we were asked about
+	requested := make(map[string]*ec2.NetworkAcl)
+	for _, subnetID := range subnetIDs {
+		if networkACLs[subnetID] == nil {
+			return nil, fmt.Errorf("Expected 1 network ACL for %s, found 0", subnetID)
+		}
+		requested[subnetID] = networkACLs[subnetID]
 	}
+	return requested, nil
+}
 
-	result, err := svc.DescribeSecurityGroups(input)
-	if err != nil {
-		log.Fatal(err.Error())
-	}
+// securityGroupRules returns the rules of the groups, looked up in
+// securityGroups (keyed by group ID)
+func securityGroupRules(groups []*ec2.GroupIdentifier, securityGroups map[string]*ec2.SecurityGroup) []*SecurityGroupRule {
 	var rules []*SecurityGroupRule
-	for _, group := range result.SecurityGroups {
+	seen := make(map[string]bool)
+	for _, identifier := range groups {
+		group, ok := securityGroups[aws.StringValue(identifier.GroupId)]
+		if !ok || seen[*group.GroupId] {
+			continue
+		}
+		seen[*group.GroupId] = true
 		for _, ingressPermission := range group.IpPermissions {
-			rule := SecurityGroupRule{egress: false, permission: ingressPermission}
+			rule := SecurityGroupRule{groupID: *group.GroupId, egress: false, permission: ingressPermission}
 			rules = append(rules, &rule)
 		}
-		for _, ingressPermission := range group.IpPermissionsEgress {
-			rule := SecurityGroupRule{egress: true, permission: ingressPermission}
+		for _, egressPermission := range group.IpPermissionsEgress {
+			rule := SecurityGroupRule{groupID: *group.GroupId, egress: true, permission: egressPermission}
 			rules = append(rules, &rule)
 		}
 	}
 	return rules
 }
 
-// check if source can send outgoing traffic to destination over specified port and protocol
-func checkNACLEgressAllow(source *instanceState, destination *instanceState, protocol string, destPortRange *ec2.PortRange) []*checkResult {
-
-	var result []*checkResult
-
-	var destIP string
+func getSecurityGroupRules(svc ec2iface.EC2API, securityGroups []*ec2.GroupIdentifier) ([]*SecurityGroupRule, error) {
 
-	if len(destPrivateIPAddress) != 0 {
-		destIP = destPrivateIPAddress
-	} else {
-
-		if usingPublicIP {
-			destIP = destination.PublicIP
-		}
+	var securityGroupIds []string
+	for _, group := range securityGroups {
+		securityGroupIds = append(securityGroupIds, *group.GroupId)
 	}
-
-	for subnetID, nacl := range source.NetworkAcls {
-		r := newCheckResult()
-		r.DisplayText = "Egress ACL from Subnet " + subnetID
-
-		for _, entry := range nacl.Entries {
-			if *entry.Egress && (protocolMapping[*entry.Protocol] == protocol || protocolMapping[*entry.Protocol] == "all") {
-				_, allowedIpv4Net, err := net.ParseCIDR(*entry.CidrBlock)
-				if err != nil {
-					log.Fatal(err)
-				}
-				if allowedIpv4Net.Contains(net.ParseIP(destIP)) {
-					// This condition here will also match the "default" rule with *, but it's rule
-					// number is 32767, so it will be overridden by a lower numberd matching rule here
-					if protocolMapping[*entry.Protocol] == "all" || (*destPortRange.From >= *entry.PortRange.From && *destPortRange.To <= *entry.PortRange.To) {
-						if r.Metadata["MatchedACL"] != nil {
-							acl := r.Metadata["MatchedACL"].(ec2.NetworkAclEntry)
-							if *entry.RuleNumber < *acl.RuleNumber {
-								r.Metadata["MatchedACL"] = *entry
-							}
-						} else {
-							r.Metadata["MatchedACL"] = *entry
-						}
-						r.Result = true
-					}
-				}
-			}
-		}
-		result = append(result, &r)
+	groups, err := describeSecurityGroups(svc, securityGroupIds)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe security groups: %w", err)
 	}
-	return result
+	return securityGroupRules(securityGroups, groups), nil
 }
 
-// check if destination allows incoming traffic from source over specified port and protocol
-func checkNACLIngressAllow(source *instanceState, destination *instanceState, protocol string, destPortRange *ec2.PortRange) []*checkResult {
-
-	var result []*checkResult
-
-	var sourceIPAddresses []string
-
-	if len(destPrivateIPAddress) != 0 {
-		sourceIPAddresses = source.PrivateIPAddresses
-	} else {
-		if usingPublicIP {
-			// The source must either have a public IP or a NAT instance IP
-			if len(source.PublicIP) != 0 {
-				sourceIPAddresses = append(sourceIPAddresses, source.PublicIP)
-			} else {
-				// NAT IP
-
-			}
-		}
+// sortedSubnetIDs returns the subnets of the network ACLs in order
+func sortedSubnetIDs(networkACLs map[string]*ec2.NetworkAcl) []string {
+	var subnetIDs []string
+	for subnetID := range networkACLs {
+		subnetIDs = append(subnetIDs, subnetID)
 	}
-
-	for _, sourceIP := range sourceIPAddresses {
-
-		r := newCheckResult()
-		r.DisplayText = "Ingress ACL at Destination from " + sourceIP
-
-		for subnetId, acl := range destination.NetworkAcls {
-			// check the ACLs for the relevant subnet for the destination
-			_, destinationSubnetCIDR, _ := net.ParseCIDR(destination.SubnetCIDRs[subnetId])
-			if destinationSubnetCIDR.Contains(net.ParseIP(destPrivateIPAddress)) {
-				for _, entry := range acl.Entries {
-					//log.Printf("%v\n", entry)
-					if !*entry.Egress && (protocolMapping[*entry.Protocol] == protocol || protocolMapping[*entry.Protocol] == "all") {
-						_, allowedIpv4Net, err := net.ParseCIDR(*entry.CidrBlock)
-						if err != nil {
-							log.Fatal(err)
-						}
-
-						if allowedIpv4Net.Contains(net.ParseIP(sourceIP)) {
-							// This condition here will also match the "default" rule with *, but it's rule
-							// number is 32767, so it will be overridden by a lower numberd matching rule here
-							if protocolMapping[*entry.Protocol] == "all" || (*destPortRange.From >= *entry.PortRange.From && *destPortRange.To <= *entry.PortRange.To) {
-								if r.Metadata["MatchedACL"] != nil {
-									acl := r.Metadata["MatchedACL"].(ec2.NetworkAclEntry)
-									if *entry.RuleNumber < *acl.RuleNumber {
-										r.Metadata["MatchedACL"] = *entry
-									}
-								} else {
-									r.Metadata["MatchedACL"] = *entry
-								}
-								if *entry.RuleAction == "allow" {
-									r.Result = true
-								}
-							}
-						}
-					}
-				}
-				result = append(result, &r)
-			}
-		}
-
-	}
-	return result
+	sort.Strings(subnetIDs)
+	return subnetIDs
 }
 
-func checkInstanceEgressAllow(source *instanceState, dest *instanceState, protocol string, destPortRange *ec2.PortRange) []*checkResult {
-
-	var result []*checkResult
-
-	r := newCheckResult()
-	r.DisplayText = "Security Group at Source allows Egress Traffic"
-
-	for _, rule := range source.SecurityGroupRules {
-		if rule.egress && (protocolMapping[*rule.permission.IpProtocol] == protocol || protocolMapping[*rule.permission.IpProtocol] == "all") {
-
-			for _, ipRange := range rule.permission.IpRanges {
-				_, allowedIpv4Net, err := net.ParseCIDR(*ipRange.CidrIp)
-				if err != nil {
-					log.Fatal(err)
-				}
-				if allowedIpv4Net.Contains(net.ParseIP(destPrivateIPAddress)) {
-					if protocolMapping[*rule.permission.IpProtocol] == "all" {
-						r.Metadata["MatchedSecurityGroupRule"] = rule
-						r.Result = true
-						result = append(result, &r)
-						return result
-					}
-
-					if *destPortRange.From >= *rule.permission.FromPort && *destPortRange.To <= *rule.permission.ToPort {
-						r.Metadata["MatchedSecurityGroupRule"] = rule
-						r.Result = true
-						result = append(result, &r)
-						return result
-					}
-
-				}
-			}
-
-			for _, userIDGroupPair := range rule.permission.UserIdGroupPairs {
-				for _, sg := range dest.SecurityGroups {
-					if userIDGroupPair.GroupId == sg.GroupId {
-						r.Metadata["MatchedSecurityGroupRule"] = rule
-						r.Result = true
-						result = append(result, &r)
-						return result
-					}
-				}
-			}
-
+// trafficPortRange returns the destination port of the traffic or, for ICMP,
+// its type and code (an echo request by default). The port is --dport or,
+// when it isn't specified, the port of the destination (the endpoint port
+// of a database).
+func trafficPortRange(dest *connectivityDestination) ec2.PortRange {
+	if isICMPProtocol(protocol) {
+		icmpTypeValue := icmpType
+		if icmpTypeValue == -1 {
+			icmpTypeValue = defaultICMPType(protocol)
 		}
+		return ec2.PortRange{From: aws.Int64(icmpTypeValue), To: aws.Int64(icmpCode)}
+	}
+	port := destPort
+	if port == -1 && dest != nil {
+		port = dest.port
 	}
-	return result
+	return ec2.PortRange{From: aws.Int64(port), To: aws.Int64(port)}
 }
 
-func checkInstanceIngressAllow(source *instanceState, dest *instanceState, protocol string, destPortRange *ec2.PortRange) []*checkResult {
-	var result []*checkResult
-
-	for _, sourceIP := range source.PrivateIPAddresses {
-		r := newCheckResult()
-		r.DisplayText = "Security Group at Destination allows Ingress traffic from " + sourceIP
-
-		for _, rule := range dest.SecurityGroupRules {
-			if !rule.egress && (protocolMapping[*rule.permission.IpProtocol] == protocol || protocolMapping[*rule.permission.IpProtocol] == "all") {
-
-				// Check for source IP ranges
-				for _, ipRange := range rule.permission.IpRanges {
-
-					_, allowedIpv4Net, err := net.ParseCIDR(*ipRange.CidrIp)
-					if err != nil {
-						log.Fatal(err)
-					}
-
-					if allowedIpv4Net.Contains(net.ParseIP(sourceIP)) {
-						if protocolMapping[*rule.permission.IpProtocol] == "all" {
-							r.Metadata["MatchedSecurityGroupRule"] = rule
-							r.Result = true
-						}
-
-						if *destPortRange.From >= *rule.permission.FromPort && *destPortRange.To <= *rule.permission.ToPort {
-							r.Metadata["MatchedSecurityGroupRule"] = rule
-							r.Result = true
-						}
-
-					}
-					if r.Result {
-						result = append(result, &r)
-						return result
-					}
-				}
-			}
-		}
+// parseEphermalPortRange returns the ephermal port range specified via
+// --override-ephermal-port-range, the default one otherwise
+func parseEphermalPortRange() (ec2.PortRange, error) {
+	if len(customEphermalPortRange) == 0 {
+		return defaultEphermalPortRange, nil
 	}
-
-	for _, sg := range source.SecurityGroups {
-
-		r := newCheckResult()
-		r.DisplayText = "Security Group at Destination allows Ingress traffic from security group " + *sg.GroupId
-
-		for _, rule := range dest.SecurityGroupRules {
-			if !rule.egress && (protocolMapping[*rule.permission.IpProtocol] == protocol || protocolMapping[*rule.permission.IpProtocol] == "all") {
-
-				// Check for source security groups
-				for _, userIDGroupPair := range rule.permission.UserIdGroupPairs {
-
-					if *destPortRange.From >= *rule.permission.FromPort && *destPortRange.To <= *rule.permission.ToPort {
-						// While using public IP address to connect to the destination instance in a VPC
-						// allowing source security group doesn't allow access
-						if *userIDGroupPair.GroupId == *sg.GroupId {
-
-							if len(dest.SubnetIds) != 0 && usingPublicIP {
-								continue
-							}
-							r.Metadata["MatchedSecurityGroupRule"] = rule
-							r.Result = true
-						}
-					}
-				}
-			}
-		}
-		result = append(result, &r)
-		// If we have a matching rule, we return early
-		if r.Result {
-			return result
-		}
+	portRange := strings.Split(customEphermalPortRange, ",")
+	if len(portRange) != 2 {
+		return ec2.PortRange{}, newUsageError("Invalid port range specified: %s", customEphermalPortRange)
 	}
-	return result
-}
-
-func checkHasRoute(source *instanceState, dest *instanceState, displayText string) []*checkResult {
-
-	// TODO: dest is not currently being used
-
-	var result []*checkResult
-
-	r := newCheckResult()
-	r.DisplayText = displayText
-
-	var routes []*ec2.Route
-	for _, routeTable := range source.Routes {
-
-		for _, route := range routeTable.Routes {
-			if route.DestinationCidrBlock != nil {
-				_, destIpv4Net, err := net.ParseCIDR(*route.DestinationCidrBlock)
-				if err != nil {
-					log.Fatal(err)
-				}
-				if destIpv4Net.Contains(net.ParseIP(destPrivateIPAddress)) {
-					routes = append(routes, route)
-				}
-			}
-		}
+	lower, err := strconv.ParseInt(portRange[0], 10, 64)
+	if err != nil {
+		return ec2.PortRange{}, newUsageError("Invalid port range specified: %v", err)
 	}
-	if len(routes) > 0 {
-		r.Metadata["MatchedRoutes"] = routes
-
-		r.Result = true
+	higher, err := strconv.ParseInt(portRange[1], 10, 64)
+	if err != nil {
+		return ec2.PortRange{}, newUsageError("Invalid port range specified: %v", err)
 	}
-	result = append(result, &r)
-	return result
+	return ec2.PortRange{From: &lower, To: &higher}, nil
 }
 
 var inspectConnectivityCmd = &cobra.Command{
 	Use:   "connectivity",
-	Short: "Check connectivity between two EC2 instances on a port",
+	Short: "Check connectivity from an EC2 instance, network interface or IP address to an EC2 instance, network interface, IP address, CIDR block or database on a port",
 	Long: `This sub-command allows for running more fine grained connectivity checks. Examples follow:
 
 Can we connect to instance i-03fb71646161e8626 from i-06d80024e0df241da on TCP port 5985 using the
 destination's private IP address:
 
 
-
 	$ yawsi ec2  inspect connectivity i-06d80024e0df241da --to i-03fb71646161e8626 --dport 5985 --protocol tcp --using-private-ip
 	true
 
 
-The --verbose flag gives us more information about the checks that are run:
+The --verbose flag gives us more information about the checks that are run, and --explain shows them
+hop by hop along the path the traffic takes (--graph dot or --graph mermaid draws the path):
 
 
 	yawsi ec2 inspect connectivity i-0a80024e0df241da --to i-03fb71646161e8626 --dport 5985 --protocol tcp --destination-private-ip 172.31.13.182 --verbose
-	✔ Egress ACL from Subnet subnet-ecd74e89
-	✔ Route exists from Source to Destination
-	✔ Ingress ACL at Destination from 172.31.41.185
-	✔ Egress ACL from Subnet subnet-157b9470
-	✔ Egress ACL from Subnet subnet-0e57366b
-	✔ Route exists from Destination to Source
-	✔ Security Group at Source allows Egress Traffic
-	✔ Security Group at Destination allows Ingress traffic from 172.31.41.185
-	true
+	✔ Security Group at Source allows Egress Traffic to 172.31.13.182/32
+	✔ Egress ACL from Subnet subnet-ecd74e89 to 172.31.13.182/32 (rule #100 allow 0.0.0.0/0)
+	...
+	✔ Security Group at Destination allows Ingress traffic from 172.31.41.185/32
 	true
+	yawsi ec2 inspect connectivity i-06d80024e0df241da --to i-03fb71646161e8626 --dport 5432 --protocol tcp --explain
+
+
+The source and destination can also be network interfaces, IP addresses (IPv4 or IPv6) or CIDR blocks, the
+destination an RDS database and the source a Lambda function. Groups of instances are checked against each
+other with --from-tag/--to-tag and --from-asg/--to-asg:
 
 
-This command also has logic around non-obvious issues. For example, if by mistake, we are trying to
-use the Public IP address of an instance in a VPC to connect from another instance and we are relying on
-ingress security group rules to allow access, it will not work - we will have to use the private IP address.
+	yawsi ec2 inspect connectivity eni-0a1b2c3d4e5f --to eni-0f9e8d7c6b5a --dport 5432 --protocol tcp --verbose
+	yawsi ec2 inspect connectivity i-06d80024e0df241da --to 10.50.0.0/16 --dport 443 --protocol tcp
+	yawsi ec2 inspect connectivity i-06d80024e0df241da --to db:orders --verbose
+	yawsi ec2 inspect connectivity lambda:my-fn --to i-03fb71646161e8626 --dport 443 --protocol tcp --using-public-ip
+	yawsi ec2 inspect connectivity --from-tag Role:web --to-asg db-asg --dport 5432 --protocol tcp
+	yawsi ec2 inspect connectivity i-06d80024e0df241da --to i-03fb71646161e8626 --protocol icmp --destination-ip 172.31.13.182
 
 
 Since AWS Network ACLs are stateless and your network setup may be setup to explicitly allow a certain range
@@ -412,302 +246,208 @@ of ephermal ports for incoming connections, you can specify a custom ephermal po
 
 	yawsi ec2 inspect connectivity i-03fb71646161e8626 --to i-d3ed150c --dport 20014 --protocol TCP \
 		--destination-private-ip 172.31.13.182 --override-ephermal-port-range 49152,65535 --verbose
-	`,
-	Run: func(cmd *cobra.Command, args []string) {
-		var ec2Filters []*ec2.Filter
-		var inputInstanceIds []*string
-		var ephermalPortRange ec2.PortRange
 
-		// Array of check results
-		var (
-			checkResults []*checkResult
-			result       []*checkResult
-		)
 
-		sourceInstanceState := instanceState{}
-		destInstanceState := instanceState{}
+See the "Connectivity checks" section of the README for how the checks are evaluated.
+	`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var ephermalPortRange ec2.PortRange
 
-		if len(toDest) == 0 {
-			cmd.Usage()
-			os.Exit(1)
+		var checkResults []*checkResult
+
+		// Groups of instances are selected by tags or auto scaling group
+		groupSource := len(fromTags) != 0 || len(fromASG) != 0
+		groupDest := len(toTags) != 0 || len(toASG) != 0
+		switch {
+		case groupSource && len(args) != 0:
+			return newUsageError("Specify the source as an argument or via --from-tag/--from-asg, not both")
+		case !groupSource && len(args) != 1:
+			return newUsageError("Specify the source as an argument or via --from-tag/--from-asg")
+		case groupDest && len(toDest) != 0:
+			return newUsageError("Specify the destination via --to or via --to-tag/--to-asg, not both")
+		}
+		if len(toDest) == 0 && !groupDest {
+			return newUsageError("Specify the destination via --to")
+		}
+		if (groupSource || groupDest) && (explainOutput || len(graphFormat) != 0) {
+			return newUsageError("--explain and --graph can't be used with --from-tag, --from-asg, --to-tag or --to-asg")
+		}
+		if len(graphFormat) != 0 && graphFormat != graphDot && graphFormat != graphMermaid {
+			return newUsageError("Unsupported graph format %s, must be %s or %s", graphFormat, graphDot, graphMermaid)
 		}
 
-		if !(usingPublicIP || len(destPrivateIPAddress) != 0) || (usingPublicIP && len(destPrivateIPAddress) != 0) {
-			log.Printf("Must specify --using-private-ip or --using-public-ip")
-			cmd.Usage()
-			os.Exit(1)
+		// The checks from an instance or IP address to an instance need to know
+		// which address of the destination is used, the addresses of the other
+		// destinations are looked up (see getConnectivityDestination)
+		fromLambda := !groupSource && isLambdaSource(args[0])
+		toDatabase := isDatabaseDestination(toDest)
+		// A network interface destination defaults to its primary private IP
+		// address
+		destIPRequired := strings.HasPrefix(toDest, "i-") && !(usingPublicIP || len(destPrivateIPAddress) != 0)
+		if isInstanceOrInterface(toDest) && !fromLambda && !groupSource && (destIPRequired || (usingPublicIP && len(destPrivateIPAddress) != 0)) {
+			return newUsageError("Must specify --destination-ip or --using-public-ip")
+		}
+		if len(destPrivateIPAddress) != 0 && net.ParseIP(destPrivateIPAddress) == nil {
+			return newUsageError("Invalid destination IP address: %s", destPrivateIPAddress)
+		}
+		if (toDatabase || fromLambda) && len(snapshotFilePath) != 0 {
+			return newUsageError("Snapshots don't include RDS or Lambda, databases and functions can't be used with --from-snapshot")
 		}
 
-		sess := createSession()
-		svc := ec2.New(sess)
+		svc := getClients().EC2()
 
-		if len(toDest) > 0 {
-			if destPort == -1 || len(protocol) == 0 {
-				cmd.Usage()
-				os.Exit(1)
+		if len(toDest) > 0 || groupDest {
+			// The port of a database defaults to its endpoint port
+			if toDatabase && len(protocol) == 0 {
+				protocol = "tcp"
 			}
 			protocol = strings.ToLower(protocol)
-
-			if len(customEphermalPortRange) == 0 {
-				ephermalPortRange = defaultEphermalPortRange
-			} else {
-				portRange := strings.Split(customEphermalPortRange, ",")
-				lower, err := strconv.ParseInt(portRange[0], 10, 64)
-				if err != nil {
-					log.Fatal("Invalid port range specified", err)
-				}
-				higher, err := strconv.ParseInt(portRange[1], 10, 64)
-				if err != nil {
-					log.Fatal("Invalid port range specified", err)
+			if (destPort == -1 && !toDatabase && !isICMPProtocol(protocol)) || len(protocol) == 0 {
+				return newUsageError("Specify the destination port and protocol via --dport and --protocol")
+			}
+			switch protocol {
+			case "tcp", "udp":
+			case "icmp", "icmpv6":
+				if destPort != -1 {
+					return newUsageError("--dport can't be used with %s, specify --icmp-type and --icmp-code", protocol)
 				}
+			default:
+				return newUsageError("Unsupported protocol %s, must be one of tcp, udp, icmp or icmpv6", protocol)
+			}
 
-				ephermalPortRange = ec2.PortRange{From: &lower, To: &higher}
+			var err error
+			if ephermalPortRange, err = parseEphermalPortRange(); err != nil {
+				return err
 			}
-			fromSource := args[0]
-			// EC2 instance -> EC2 instance
-			if strings.HasPrefix(fromSource, "i-") && strings.HasPrefix(toDest, "i-") {
-				inputInstanceIds = append(inputInstanceIds, &fromSource)
-				inputInstanceIds = append(inputInstanceIds, &toDest)
-
-				params := &ec2.DescribeInstancesInput{
-					DryRun:      aws.Bool(false),
-					Filters:     ec2Filters,
-					InstanceIds: inputInstanceIds,
+			if groupSource || groupDest {
+				var source string
+				if !groupSource {
+					source = args[0]
 				}
-				err := svc.DescribeInstancesPages(params,
-					func(result *ec2.DescribeInstancesOutput, lastPage bool) bool {
-						for _, r := range result.Reservations {
-							for _, instance := range r.Instances {
-
-								if *instance.InstanceId == args[0] {
-									instanceData := getEC2InstanceData(nil, instance.InstanceId)
-									if len(instanceData) != 1 {
-										panic("Expected size of instanceData to be 1")
-									}
-									sourceInstanceState = *instanceData[0]
-								}
-								if *instance.InstanceId == toDest {
-									instanceData := getEC2InstanceData(nil, instance.InstanceId)
-									if len(instanceData) != 1 {
-										panic("Expected size of instanceData to be 1")
-									}
-									destInstanceState = *instanceData[0]
-								}
-							}
-						}
-						return lastPage
-					})
+				return checkConnectivityGroups(source, &ephermalPortRange)
+			}
+			fromSource := args[0]
+			if fromLambda {
+				// Lambda function -> anything, from each subnet of the function
+				fn, err := getLambdaFunction(getClients().Lambda(), fromSource)
 				if err != nil {
-					log.Fatal(err)
+					return err
 				}
-
-				// If using public IP, the source must have a public IP address or have a NAT IP
-
-				// If using public IP, the destination must have a public IP address
-				if len(destInstanceState.PublicIP) == 0 {
-
+				dest, err := getConnectivityDestination(svc, getClients().RDS(), toDest, destPrivateIPAddress, usingPublicIP)
+				if err != nil {
+					return err
 				}
+				destPortRange := trafficPortRange(dest)
 
-				// If using private IP, the destination must have a private IP address
-				// (EC2 classic accounts wouldn't have private IP address)
-
-				// Get Subnet CIDR for each subnet
-				// Not currently used
-				if len(sourceInstanceState.SubnetIds) != 0 {
-					sourceInstanceState.SubnetCIDRs = getSubnetCIDR(svc, sourceInstanceState.SubnetIds...)
-					sourceInstanceState.NetworkAcls = getNetworkAcls(svc, sourceInstanceState.SubnetIds...)
-				}
-				if len(destInstanceState.SubnetIds) != 0 {
-					destInstanceState.SubnetCIDRs = getSubnetCIDR(svc, destInstanceState.SubnetIds...)
-					destInstanceState.NetworkAcls = getNetworkAcls(svc, destInstanceState.SubnetIds...)
+				sources := lambdaSubnetStates(fn)
+				states := sources
+				if dest.state != nil {
+					states = append(states, dest.state)
 				}
-
-				// Get SG rules for each EC2 instance
-				sourceInstanceState.SecurityGroupRules = getSecurityGroupRules(svc, sourceInstanceState.SecurityGroups)
-				destInstanceState.SecurityGroupRules = getSecurityGroupRules(svc, destInstanceState.SecurityGroups)
-
-				destPortRange := ec2.PortRange{From: &destPort, To: &destPort}
-
-				// 1. Check egress acl for source subnet
-				if len(sourceInstanceState.SubnetCIDRs) != 0 {
-					result = checkNACLEgressAllow(&sourceInstanceState, &destInstanceState, protocol, &destPortRange)
-					if verboseOutput || debugOutput {
-						displayResult(result...)
+				if len(states) != 0 {
+					if err := enrichInstanceStates(svc, states...); err != nil {
+						return err
 					}
-
-					for _, r := range result {
-						checkResults = append(checkResults, r)
-					}
-
-					//log.Printf("%v", *result1)
 				}
-
-				// 2. Check if we have a route to the destination
-				if len(sourceInstanceState.SubnetIds) != 0 {
-					sourceInstanceState.Routes = getRoutes(sourceInstanceState.SubnetIds...)
-					result = checkHasRoute(&sourceInstanceState, &destInstanceState, "Route exists from Source to Destination")
-					if verboseOutput || debugOutput {
-						displayResult(result...)
-					}
-					for _, r := range result {
-						checkResults = append(checkResults, r)
-					}
+				if len(sources) == 0 {
+					checkResults = checkLambdaServiceNetwork(fn, dest, protocol, &destPortRange, &ephermalPortRange)
+				} else {
+					checkResults = checkLambdaConnectivity(sources, dest, protocol, &destPortRange, &ephermalPortRange)
 				}
+				displayResult(checkResults...)
 
-				// 3. Check ingress acl for destination subnet
-				if len(destInstanceState.SubnetCIDRs) != 0 {
-					result = checkNACLIngressAllow(&sourceInstanceState, &destInstanceState, protocol, &destPortRange)
-					//log.Printf("%v", *result2)
-					displayResult(result...)
-
-					for _, r := range result {
-						checkResults = append(checkResults, r)
-					}
-
-					// 4. Check egress acl for destination subnet
-					result = checkNACLEgressAllow(&destInstanceState, &sourceInstanceState, protocol, &ephermalPortRange)
-					//log.Printf("%v", *result3)
-
-					displayResult(result...)
-
-					for _, r := range result {
-						checkResults = append(checkResults, r)
-					}
-
-					// 5. Check if destination has a route to the source
-					destInstanceState.Routes = getRoutes(destInstanceState.SubnetIds...)
-					result = checkHasRoute(&destInstanceState, &sourceInstanceState, "Route exists from Destination to Source")
-
-					displayResult(result...)
-
-					for _, r := range result {
-						checkResults = append(checkResults, r)
-					}
+				return renderConnectivityReport(fromSource, nil, toDest, describeTraffic(protocol, &destPortRange), checkResults...)
+			} else if sourceIP := net.ParseIP(fromSource); sourceIP != nil && isInstanceOrInterface(toDest) {
+				// IP address -> EC2 instance, only the destination side is checked
+				dest, err := getConnectivityDestination(svc, getClients().RDS(), toDest, destPrivateIPAddress, usingPublicIP)
+				if err != nil {
+					return err
 				}
-
-				if len(sourceInstanceState.SubnetCIDRs) != 0 {
-					// 6. Check ingress acl for source subnet
-					result = checkNACLIngressAllow(&destInstanceState, &sourceInstanceState, protocol, &ephermalPortRange)
-					//log.Printf("%v", *result4)
-					displayResult(result...)
-
-					for _, r := range result {
-						checkResults = append(checkResults, r)
-					}
+				if err := enrichInstanceStates(svc, dest.state); err != nil {
+					return err
 				}
 
-				// Security group rules are state preserving, so need to check:
-				// 1. If egress rules on source allows traffic out
-				// 2. If ingress rules on dest allows traffic in
-				result = checkInstanceEgressAllow(&sourceInstanceState, &destInstanceState, protocol, &destPortRange)
-
-				displayResult(result...)
-
-				for _, r := range result {
-					checkResults = append(checkResults, r)
+				destPortRange := trafficPortRange(dest)
+				sourceNetwork, _ := parseNetwork(fromSource)
+				checkResults = checkConnectivityFromAddress(sourceNetwork, dest, protocol, &destPortRange, &ephermalPortRange)
+				if len(checkResults) == 0 {
+					return newNotFoundError("%s doesn't have an address of the same IP version as %s", toDest, fromSource)
 				}
+				displayResult(checkResults...)
 
-				result = checkInstanceIngressAllow(&sourceInstanceState, &destInstanceState, protocol, &destPortRange)
-
-				displayResult(result...)
-
-				for _, r := range result {
-					checkResults = append(checkResults, r)
+				return renderConnectivityReport(fromSource, nil, toDest, describeTraffic(protocol, &destPortRange), checkResults...)
+			} else if isInstanceOrInterface(fromSource) {
+				// EC2 instance -> EC2 instance, IP address, CIDR block or database
+				dest, err := getConnectivityDestination(svc, getClients().RDS(), toDest, destPrivateIPAddress, usingPublicIP)
+				if err != nil {
+					return err
 				}
-
-				if summarizeResults(checkResults...) {
-					color.Green("true\n")
-				} else {
-					color.Red("false\n")
+				sources, err := getEndpointStates(svc, fromSource)
+				if err != nil {
+					return err
 				}
-			} else if sourceIP := net.ParseIP(fromSource); sourceIP != nil && strings.HasPrefix(toDest, "i-") {
-				// Source is an IP address and destination is an EC2 instance
-				sourceInstanceState.PublicIP = sourceIP.String()
-				sourceInstanceState.PrivateIPAddresses = append(sourceInstanceState.PrivateIPAddresses, sourceIP.String())
-				instanceData := getEC2InstanceData(nil, &toDest)
-				if len(instanceData) != 1 {
-					panic("Expected size of instanceData to be 1")
+				source := sources[0]
+				states := []*instanceState{source}
+				if dest.state != nil {
+					states = append(states, dest.state)
 				}
-				destInstanceState = *instanceData[0]
-
-				destPortRange := ec2.PortRange{From: &destPort, To: &destPort}
-
-				// 1. Check ingress acl for destination subnet
-				if len(destInstanceState.SubnetCIDRs) != 0 {
-					result = checkNACLIngressAllow(&sourceInstanceState, &destInstanceState, protocol, &destPortRange)
-					//log.Printf("%v", *result2)
-
-					displayResult(result...)
-
-					for _, r := range result {
-						checkResults = append(checkResults, r)
-					}
-
-					// 2. Check egress acl for destination subnet
-					result = checkNACLEgressAllow(&destInstanceState, &sourceInstanceState, protocol, &ephermalPortRange)
-					//log.Printf("%v", *result3)
-
-					displayResult(result...)
-
-					for _, r := range result {
-						checkResults = append(checkResults, r)
-					}
-
-					// 3. Check if destination has a route to the public internet
-					destInstanceState.Routes = getRoutes(destInstanceState.SubnetIds...)
-					result = checkHasRoute(&destInstanceState, &sourceInstanceState, "Route exists from Destination to Source")
-
-					displayResult(result...)
-
-					for _, r := range result {
-						checkResults = append(checkResults, r)
-					}
+				if err := enrichInstanceStates(svc, states...); err != nil {
+					return err
 				}
 
-				// Security group rules are state preserving, so need to check:
-				// 2. If ingress rules on dest allows traffic in
-
-				result = checkInstanceIngressAllow(&sourceInstanceState, &destInstanceState, protocol, &destPortRange)
-
-				displayResult(result...)
-
-				for _, r := range result {
-					checkResults = append(checkResults, r)
+				destPortRange := trafficPortRange(dest)
+				sourceNetworks := addressNetworks(allAddresses(source))
+				checkResults = checkConnectivityToDestination(source, sourceNetworks, dest, protocol, &destPortRange, &ephermalPortRange)
+				if len(checkResults) == 0 {
+					return newNotFoundError("Couldn't find the addresses of %s", toDest)
 				}
+				displayResult(checkResults...)
 
-				if summarizeResults(checkResults...) {
-					color.Green("true\n")
-				} else {
-					color.Red("false\n")
+				// The trace shows the network interface the traffic leaves from
+				if networks := dest.resolveNetworks(); len(networks) != 0 {
+					source = source.forTrafficTo(networks[0])
 				}
+				return renderConnectivityReport(fromSource, source, toDest, describeTraffic(protocol, &destPortRange), checkResults...)
 			} else {
-				// TODO: ec2 instance to IP address
-				//       lambda function to RDS instance
-				//       ec2 instance to RDS instance
-				log.Fatal("Unrecognized source specification")
+				return newUsageError("Unrecognized source specification: %s", fromSource)
 			}
 		}
+		return nil
 	},
-	Args: cobra.ExactArgs(1),
+	Args: cobra.MaximumNArgs(1),
 }
 
 var toDest string
 var destPort int64
 var protocol string
+var icmpType, icmpCode int64
+var fromTags, toTags string
+var fromASG, toASG string
 var customEphermalPortRange string
 var usingPublicIP bool
 var destPrivateIPAddress string
 
 func init() {
 	inspectInstancesCmd.AddCommand(inspectConnectivityCmd)
-	inspectConnectivityCmd.Flags().StringVarP(&toDest, "to", "", "", "Connectivity Destination - EC2 instance Id/IP address/public")
+	addSnapshotFlag(inspectConnectivityCmd)
+	inspectConnectivityCmd.Flags().StringVarP(&toDest, "to", "", "", "Connectivity Destination - EC2 instance Id, network interface Id, IP address, CIDR block or database (db:<identifier> or ARN)")
+	inspectConnectivityCmd.MarkFlagCustom("to", "__yawsi_instance_ids")
+	inspectConnectivityCmd.Flags().StringVarP(&fromTags, "from-tag", "", "", "Check from each running instance with these tags (key:value,...)")
+	inspectConnectivityCmd.Flags().StringVarP(&fromASG, "from-asg", "", "", "Check from each running instance of this auto scaling group")
+	inspectConnectivityCmd.Flags().StringVarP(&toTags, "to-tag", "", "", "Check to each running instance with these tags (key:value,...)")
+	inspectConnectivityCmd.Flags().StringVarP(&toASG, "to-asg", "", "", "Check to each running instance of this auto scaling group")
 	inspectConnectivityCmd.Flags().Int64VarP(&destPort, "dport", "", -1, "Destination port")
-	inspectConnectivityCmd.Flags().StringVarP(&protocol, "protocol", "", "", "Network protocol (TCP/UDP)")
+	inspectConnectivityCmd.Flags().StringVarP(&protocol, "protocol", "", "", "Network protocol (TCP/UDP/ICMP/ICMPv6)")
+	inspectConnectivityCmd.Flags().Int64VarP(&icmpType, "icmp-type", "", -1, "ICMP type with --protocol icmp or icmpv6 (default echo request)")
+	inspectConnectivityCmd.Flags().Int64VarP(&icmpCode, "icmp-code", "", 0, "ICMP code with --protocol icmp or icmpv6")
 	inspectConnectivityCmd.Flags().StringVarP(&customEphermalPortRange, "override-ephermal-port-range", "", "", "Override ephermal port range")
 	inspectConnectivityCmd.Flags().BoolVarP(&verboseOutput, "verbose", "v", false, "Display more information about the result")
 	inspectConnectivityCmd.Flags().BoolVarP(&debugOutput, "debug", "", false, "Display more information about the result")
+	inspectConnectivityCmd.Flags().BoolVarP(&explainOutput, "explain", "", false, "Display the path of the traffic hop by hop with the rule or route each check matched")
+	inspectConnectivityCmd.Flags().StringVarP(&graphFormat, "graph", "", "", "Display the path of the traffic as a diagram (dot or mermaid)")
 	inspectConnectivityCmd.Flags().BoolVarP(&usingPublicIP, "using-public-ip", "", false, "Using public IP?")
-	inspectConnectivityCmd.Flags().StringVarP(&destPrivateIPAddress, "destination-private-ip", "", "", "Specify private IP address of destination")
+	inspectConnectivityCmd.Flags().StringVarP(&destPrivateIPAddress, "destination-ip", "", "", "Specify the private IPv4 or the IPv6 address of destination")
+	inspectConnectivityCmd.Flags().StringVarP(&destPrivateIPAddress, "destination-private-ip", "", "", "Specify private IP address of destination (same as --destination-ip)")
 
 	// Examples:
 	// yawsi ec2 inspect connectivity instance1 --to instance2 --dport 21000 --protocol TCP
@@ -0,0 +1,489 @@
+package cmd
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+// newConnectivityFixture returns two instances in separate subnets of the same
+// VPC: i-src (10.0.1.10) in subnet-a and i-dst (10.0.2.20) in subnet-b. The
+// destination only allows TCP 5432 from the source's security group.
+func newConnectivityFixture() *fakeClients {
+	c := newFakeClients()
+
+	instance := func(id, eni string) *ec2.Instance {
+		return &ec2.Instance{
+			InstanceId: aws.String(id),
+			State:      &ec2.InstanceState{Name: aws.String("running")},
+			NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+				{NetworkInterfaceId: aws.String(eni)},
+			},
+		}
+	}
+	eni := func(id, instanceID, subnetID, ip, sg string) *ec2.NetworkInterface {
+		return &ec2.NetworkInterface{
+			NetworkInterfaceId: aws.String(id),
+			SubnetId:           aws.String(subnetID),
+			VpcId:              aws.String("vpc-1"),
+			PrivateIpAddress:   aws.String(ip),
+			PrivateIpAddresses: []*ec2.NetworkInterfacePrivateIpAddress{
+				{PrivateIpAddress: aws.String(ip), Primary: aws.Bool(true)},
+			},
+			Groups:     []*ec2.GroupIdentifier{{GroupId: aws.String(sg)}},
+			Attachment: &ec2.NetworkInterfaceAttachment{InstanceId: aws.String(instanceID)},
+		}
+	}
+	naclEntry := func(number int64, egress bool, protocol, cidr, action string, from, to int64) *ec2.NetworkAclEntry {
+		entry := &ec2.NetworkAclEntry{
+			RuleNumber: aws.Int64(number),
+			Egress:     aws.Bool(egress),
+			Protocol:   aws.String(protocol),
+			CidrBlock:  aws.String(cidr),
+			RuleAction: aws.String(action),
+		}
+		if protocol != "-1" {
+			entry.PortRange = &ec2.PortRange{From: aws.Int64(from), To: aws.Int64(to)}
+		}
+		return entry
+	}
+	nacl := func(id, subnetID string, entries ...*ec2.NetworkAclEntry) *ec2.NetworkAcl {
+		return &ec2.NetworkAcl{
+			NetworkAclId: aws.String(id),
+			VpcId:        aws.String("vpc-1"),
+			Associations: []*ec2.NetworkAclAssociation{{SubnetId: aws.String(subnetID)}},
+			Entries: append(entries,
+				naclEntry(32767, true, "-1", "0.0.0.0/0", "deny", 0, 0),
+				naclEntry(32767, false, "-1", "0.0.0.0/0", "deny", 0, 0),
+			),
+		}
+	}
+
+	c.ec2.Instances = []*ec2.Instance{
+		instance("i-src", "eni-src"),
+		instance("i-dst", "eni-dst"),
+	}
+	c.ec2.NetworkInterfaces = []*ec2.NetworkInterface{
+		eni("eni-src", "i-src", "subnet-a", "10.0.1.10", "sg-src"),
+		eni("eni-dst", "i-dst", "subnet-b", "10.0.2.20", "sg-dst"),
+	}
+	c.ec2.Subnets = []*ec2.Subnet{
+		{SubnetId: aws.String("subnet-a"), VpcId: aws.String("vpc-1"), CidrBlock: aws.String("10.0.1.0/24")},
+		{SubnetId: aws.String("subnet-b"), VpcId: aws.String("vpc-1"), CidrBlock: aws.String("10.0.2.0/24")},
+	}
+	c.ec2.NetworkAcls = []*ec2.NetworkAcl{
+		nacl("acl-a", "subnet-a",
+			naclEntry(100, true, "-1", "0.0.0.0/0", "allow", 0, 0),
+			naclEntry(100, false, "6", "10.0.0.0/16", "allow", 1024, 65535),
+		),
+		nacl("acl-b", "subnet-b",
+			naclEntry(100, false, "6", "10.0.1.0/24", "allow", 5432, 5432),
+			naclEntry(100, true, "6", "10.0.1.0/24", "allow", 32768, 61000),
+		),
+	}
+	c.ec2.SecurityGroups = []*ec2.SecurityGroup{
+		{
+			GroupId: aws.String("sg-src"),
+			VpcId:   aws.String("vpc-1"),
+			IpPermissionsEgress: []*ec2.IpPermission{
+				{IpProtocol: aws.String("-1"), IpRanges: []*ec2.IpRange{{CidrIp: aws.String("0.0.0.0/0")}}},
+			},
+		},
+		{
+			GroupId: aws.String("sg-dst"),
+			VpcId:   aws.String("vpc-1"),
+			IpPermissions: []*ec2.IpPermission{
+				{
+					IpProtocol:       aws.String("tcp"),
+					FromPort:         aws.Int64(5432),
+					ToPort:           aws.Int64(5432),
+					UserIdGroupPairs: []*ec2.UserIdGroupPair{{GroupId: aws.String("sg-src")}},
+				},
+			},
+		},
+	}
+	c.ec2.RouteTables = []*ec2.RouteTable{
+		{
+			RouteTableId: aws.String("rtb-main"),
+			VpcId:        aws.String("vpc-1"),
+			Associations: []*ec2.RouteTableAssociation{{Main: aws.Bool(true), RouteTableId: aws.String("rtb-main")}},
+			Routes: []*ec2.Route{
+				{DestinationCidrBlock: aws.String("10.0.0.0/16"), GatewayId: aws.String("local"), State: aws.String("active")},
+			},
+		},
+	}
+	return c
+}
+
+// loadInstanceState gathers the same state as the connectivity command does
+func loadInstanceState(t *testing.T, c awsClientProvider, instanceID string) *instanceState {
+	svc := c.EC2()
+	states, err := getEC2InstanceData(svc, nil, aws.String(instanceID))
+	if !assert.NoError(t, err) || !assert.Len(t, states, 1) {
+		t.FailNow()
+	}
+	state := states[0]
+	if state.SubnetCIDRs, err = getSubnetCIDR(svc, state.SubnetIds...); err != nil {
+		t.Fatal(err)
+	}
+	if state.NetworkAcls, err = getNetworkAcls(svc, state.SubnetIds...); err != nil {
+		t.Fatal(err)
+	}
+	if state.SecurityGroupRules, err = getSecurityGroupRules(svc, state.SecurityGroups); err != nil {
+		t.Fatal(err)
+	}
+	if state.Routes, err = getRoutes(svc, state.SubnetIds...); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func allPassed(results []*checkResult) bool {
+	return len(results) != 0 && summarizeResults(results...)
+}
+
+func TestConnectivityChecksAllowed(t *testing.T) {
+	c := newConnectivityFixture()
+	src := loadInstanceState(t, c, "i-src")
+	dst := loadInstanceState(t, c, "i-dst")
+
+	assert.Equal(t, []string{"10.0.1.10"}, src.PrivateIPAddresses)
+	assert.Equal(t, "10.0.2.0/24", dst.SubnetCIDRs["subnet-b"])
+
+	port := ec2.PortRange{From: aws.Int64(5432), To: aws.Int64(5432)}
+	dest := &connectivityDestination{state: dst, networks: addressNetworks([]string{"10.0.2.20"})}
+	results := checkConnectivityToDestination(src, addressNetworks(src.PrivateIPAddresses), dest, "tcp", &port, &defaultEphermalPortRange)
+	assert.Empty(t, failedChecks(results))
+	stages := map[string]bool{}
+	for _, r := range results {
+		stages[r.Stage] = true
+	}
+	for _, stage := range []string{stageSecurityGroupEgress, stageNACLEgress, stageRoute, stageNACLIngress, stageSecurityGroupIngress} {
+		assert.True(t, stages[stage], stage)
+	}
+}
+
+func TestConnectivityChecksBlocked(t *testing.T) {
+	c := newConnectivityFixture()
+	src := loadInstanceState(t, c, "i-src")
+	dst := loadInstanceState(t, c, "i-dst")
+
+	port := ec2.PortRange{From: aws.Int64(22), To: aws.Int64(22)}
+	dest := &connectivityDestination{state: dst, networks: addressNetworks([]string{"10.0.2.20"})}
+	results := checkConnectivityToDestination(src, addressNetworks(src.PrivateIPAddresses), dest, "tcp", &port, &defaultEphermalPortRange)
+	failedStages := map[string]bool{}
+	for _, r := range results {
+		if !r.Result {
+			failedStages[r.Stage] = true
+		}
+	}
+	assert.True(t, failedStages[stageNACLIngress])
+	assert.True(t, failedStages[stageSecurityGroupIngress])
+
+	// No route to an address outside the VPC
+	assert.False(t, allPassed(checkRouteToNetwork(src, nil, mustParseNetwork(t, "192.168.1.1"))))
+}
+
+func TestConnectivityChecksSameSubnet(t *testing.T) {
+	c := newConnectivityFixture()
+	c.ec2.NetworkInterfaces[1].SubnetId = aws.String("subnet-a")
+	c.ec2.NetworkInterfaces[1].PrivateIpAddresses[0].PrivateIpAddress = aws.String("10.0.1.20")
+	c.ec2.NetworkAcls[0].Entries[1].PortRange = &ec2.PortRange{From: aws.Int64(8080), To: aws.Int64(8080)}
+	src := loadInstanceState(t, c, "i-src")
+	dst := loadInstanceState(t, c, "i-dst")
+
+	// acl-a doesn't allow 5432 in, but it doesn't apply within subnet-a
+	port := ec2.PortRange{From: aws.Int64(5432), To: aws.Int64(5432)}
+	destNetwork := mustParseNetwork(t, "10.0.1.20")
+	assert.False(t, allPassed(checkNACLFromNetwork(dst, destNetwork, mustParseNetwork(t, "10.0.1.10"), "tcp", &port, &defaultEphermalPortRange)))
+
+	dest := &connectivityDestination{state: dst, networks: []*net.IPNet{destNetwork}}
+	results := checkConnectivityToDestination(src, addressNetworks(src.PrivateIPAddresses), dest, "tcp", &port, &defaultEphermalPortRange)
+	assert.Empty(t, failedChecks(results))
+	var texts []string
+	for _, r := range results {
+		texts = append(texts, r.DisplayText)
+	}
+	assert.Contains(t, texts, "Network ACL of Subnet subnet-a doesn't apply to traffic within the subnet")
+	assert.NotContains(t, strings.Join(texts, "\n"), "ACL at Subnet")
+}
+
+func TestRouteToNetworkMostSpecificRoute(t *testing.T) {
+	c := newConnectivityFixture()
+	src := loadInstanceState(t, c, "i-src")
+	dst := loadInstanceState(t, c, "i-dst")
+	network := mustParseNetwork(t, "10.0.2.20")
+
+	results := checkRouteToNetwork(src, dst, network)
+	if assert.Len(t, results, 1) && assert.True(t, results[0].Result) {
+		assert.Equal(t, "Route exists from rtb-main to 10.0.2.20/32 via local", results[0].DisplayText)
+		assert.Equal(t, "local", results[0].Metadata["RouteTarget"])
+	}
+
+	// A more specific route to a deleted NAT instance drops the traffic,
+	// even though the local route contains the destination too
+	routes := src.Routes[0]
+	routes.Routes = append(routes.Routes, &ec2.Route{
+		DestinationCidrBlock: aws.String("10.0.2.0/24"),
+		InstanceId:           aws.String("i-nat"),
+		NetworkInterfaceId:   aws.String("eni-nat"),
+		State:                aws.String("blackhole"),
+	})
+	results = checkRouteToNetwork(src, dst, network)
+	if assert.NotEmpty(t, results) && assert.False(t, results[0].Result) {
+		assert.Equal(t, "Route exists from rtb-main to 10.0.2.20/32 via instance i-nat", results[0].DisplayText)
+		assert.Equal(t, "The route is a blackhole, its target instance i-nat is no longer available", results[0].Metadata["Reason"])
+	}
+
+	// A less specific blackhole route doesn't matter
+	routes.Routes[1].DestinationCidrBlock = aws.String("10.0.0.0/8")
+	assert.True(t, allPassed(checkRouteToNetwork(src, dst, network)))
+
+	// Gateway load balancer endpoints are targets of their own
+	routes.Routes[1] = &ec2.Route{DestinationCidrBlock: aws.String("10.0.2.0/24"), GatewayId: aws.String("vpce-1"), State: aws.String("active")}
+	results = checkRouteToNetwork(src, dst, network)
+	if assert.True(t, allPassed(results)) {
+		assert.Equal(t, "vpce", results[0].Metadata["RouteTarget"])
+	}
+
+	// No route at all
+	results = checkRouteToNetwork(src, nil, mustParseNetwork(t, "192.168.1.1"))
+	if assert.Len(t, results, 1) && assert.False(t, results[0].Result) {
+		assert.Equal(t, "rtb-main has no route to 192.168.1.1/32", results[0].Metadata["Reason"])
+	}
+}
+
+// addMultiInterfaceInstance adds i-multi to the connectivity fixture, with a
+// management interface in subnet-a (10.0.1.30) which allows SSH from
+// anywhere and a data interface in subnet-b (10.0.2.30) with the
+// destination's security group
+func addMultiInterfaceInstance(c *fakeClients) {
+	c.ec2.Instances = append(c.ec2.Instances, &ec2.Instance{
+		InstanceId: aws.String("i-multi"),
+		State:      &ec2.InstanceState{Name: aws.String("running")},
+		NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+			{NetworkInterfaceId: aws.String("eni-mgmt"), Attachment: &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)}},
+			{NetworkInterfaceId: aws.String("eni-data"), Attachment: &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(1)}},
+		},
+	})
+	for _, ni := range []struct{ id, subnetID, ip, sg string }{
+		{"eni-mgmt", "subnet-a", "10.0.1.30", "sg-mgmt"},
+		{"eni-data", "subnet-b", "10.0.2.30", "sg-dst"},
+	} {
+		c.ec2.NetworkInterfaces = append(c.ec2.NetworkInterfaces, &ec2.NetworkInterface{
+			NetworkInterfaceId: aws.String(ni.id),
+			SubnetId:           aws.String(ni.subnetID),
+			VpcId:              aws.String("vpc-1"),
+			PrivateIpAddress:   aws.String(ni.ip),
+			PrivateIpAddresses: []*ec2.NetworkInterfacePrivateIpAddress{
+				{PrivateIpAddress: aws.String(ni.ip), Primary: aws.Bool(true)},
+			},
+			Groups:     []*ec2.GroupIdentifier{{GroupId: aws.String(ni.sg)}},
+			Attachment: &ec2.NetworkInterfaceAttachment{InstanceId: aws.String("i-multi")},
+		})
+	}
+	c.ec2.SecurityGroups = append(c.ec2.SecurityGroups, &ec2.SecurityGroup{
+		GroupId: aws.String("sg-mgmt"),
+		VpcId:   aws.String("vpc-1"),
+		IpPermissions: []*ec2.IpPermission{
+			{IpProtocol: aws.String("tcp"), FromPort: aws.Int64(22), ToPort: aws.Int64(22), IpRanges: []*ec2.IpRange{{CidrIp: aws.String("0.0.0.0/0")}}},
+		},
+	})
+}
+
+func TestConnectivityPerNetworkInterface(t *testing.T) {
+	c := newConnectivityFixture()
+	addMultiInterfaceInstance(c)
+	// subnet-b allows SSH from subnet-a, only the security groups of the
+	// data interface must deny it
+	acl := c.ec2.NetworkAcls[1]
+	acl.Entries = append([]*ec2.NetworkAclEntry{{
+		RuleNumber: aws.Int64(90),
+		Egress:     aws.Bool(false),
+		Protocol:   aws.String("6"),
+		CidrBlock:  aws.String("10.0.1.0/24"),
+		RuleAction: aws.String("allow"),
+		PortRange:  &ec2.PortRange{From: aws.Int64(22), To: aws.Int64(22)},
+	}}, acl.Entries...)
+
+	svc := c.EC2()
+	states, err := getEndpointStates(svc, "i-src", "i-multi")
+	if !assert.NoError(t, err) || !assert.NoError(t, enrichInstanceStates(svc, states...)) {
+		t.FailNow()
+	}
+	src, multi := states[0], states[1]
+	assert.Len(t, multi.Interfaces, 2)
+	assert.Equal(t, int64(1), multi.Interfaces["eni-data"].DeviceIndex)
+
+	data := multi.forAddress(mustParseNetwork(t, "10.0.2.30"))
+	assert.Equal(t, []string{"subnet-b"}, data.SubnetIds)
+	assert.Equal(t, []string{"10.0.2.30"}, data.PrivateIPAddresses)
+	assert.Len(t, data.NetworkAcls, 1)
+	assert.Len(t, data.Routes, 1)
+	for _, rule := range data.SecurityGroupRules {
+		assert.Equal(t, "sg-dst", rule.groupID)
+	}
+	// The union of the interfaces is used for other addresses
+	assert.Equal(t, multi, multi.forAddress(mustParseNetwork(t, "10.0.2.0/24")))
+
+	// Traffic to subnet-b leaves from the data interface, to anywhere else
+	// from the primary interface
+	assert.Equal(t, []string{"subnet-b"}, multi.forTrafficTo(mustParseNetwork(t, "10.0.2.20")).SubnetIds)
+	assert.Equal(t, []string{"subnet-a"}, multi.forTrafficTo(mustParseNetwork(t, "8.8.8.8")).SubnetIds)
+
+	// SSH is only allowed to the management interface
+	ssh := ec2.PortRange{From: aws.Int64(22), To: aws.Int64(22)}
+	dest := &connectivityDestination{state: multi, networks: addressNetworks([]string{"10.0.2.30"})}
+	results := checkConnectivityToDestination(src, addressNetworks(src.PrivateIPAddresses), dest, "tcp", &ssh, &defaultEphermalPortRange)
+	assert.Contains(t, failedChecks(results), "Security Group at Destination allows Ingress traffic from 10.0.1.10/32")
+	dest = &connectivityDestination{state: multi, networks: addressNetworks([]string{"10.0.1.30"})}
+	results = checkConnectivityToDestination(src, addressNetworks(src.PrivateIPAddresses), dest, "tcp", &ssh, &defaultEphermalPortRange)
+	assert.NotContains(t, failedChecks(results), "Security Group at Destination allows Ingress traffic from 10.0.1.10/32")
+
+	// A network interface is an endpoint of its own
+	states, err = getEndpointStates(svc, "eni-data")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "eni-data", states[0].InstanceId)
+		assert.Equal(t, []string{"10.0.2.30"}, states[0].PrivateIPAddresses)
+	}
+	_, err = getEndpointStates(svc, "eni-missing")
+	assert.Equal(t, exitNotFound, exitCode(err))
+}
+
+func TestConnectivityPairs(t *testing.T) {
+	c := newConnectivityFixture()
+	role := &ec2.Tag{Key: aws.String("Role"), Value: aws.String("db")}
+	group := &ec2.Tag{Key: aws.String(autoScalingGroupTag), Value: aws.String("db-asg")}
+	c.ec2.Instances[0].Tags = []*ec2.Tag{role}
+	c.ec2.Instances[1].Tags = []*ec2.Tag{role, group}
+
+	svc := c.EC2()
+	sources, err := getInstanceGroup(svc, "Role:db", "")
+	if !assert.NoError(t, err) || !assert.Len(t, sources, 2) {
+		t.FailNow()
+	}
+	dests, err := getInstanceGroup(svc, "", "db-asg")
+	if !assert.NoError(t, err) || !assert.Len(t, dests, 1) {
+		t.FailNow()
+	}
+	assert.Equal(t, "i-dst", dests[0].InstanceId)
+	_, err = getInstanceGroup(svc, "Role:web", "db-asg")
+	assert.Equal(t, exitNotFound, exitCode(err))
+	_, err = getInstanceGroup(svc, "Role", "")
+	assert.Equal(t, exitUsage, exitCode(err))
+
+	if !assert.NoError(t, enrichInstanceStates(svc, sources...)) {
+		t.FailNow()
+	}
+	// Every instance is a source and a destination, other than of itself
+	var destinations []*connectivityDestination
+	var names []string
+	for _, state := range sources {
+		destinations = append(destinations, &connectivityDestination{state: state})
+		names = append(names, state.InstanceId)
+	}
+	port := ec2.PortRange{From: aws.Int64(5432), To: aws.Int64(5432)}
+	pairs := checkConnectivityPairs(sources, destinations, names, "tcp", &port, &defaultEphermalPortRange)
+	if !assert.Len(t, pairs, 2) {
+		t.FailNow()
+	}
+	allowed := map[string]bool{}
+	for _, pair := range pairs {
+		allowed[pair.Source+" -> "+pair.Destination] = pair.Allowed
+	}
+	assert.Equal(t, map[string]bool{"i-src -> i-dst": true, "i-dst -> i-src": false}, allowed)
+
+	var renderErr error
+	out := captureOutput("table", func() { renderErr = renderConnectivityPairs(pairs) })
+	assert.Equal(t, errCheckFailed, renderErr)
+	assert.Contains(t, out, "1/2 pairs allowed\n")
+	assert.Contains(t, out, "Blocked by Security group egress, no rule of sg-dst matched:\n  i-dst -> i-src\n")
+
+	// A pair has the same checks as the single check of the instances
+	single := checkConnectivityToDestination(sources[0], addressNetworks(allAddresses(sources[0])), &connectivityDestination{state: sources[1]}, "tcp", &port, &defaultEphermalPortRange)
+	for _, pair := range pairs {
+		if pair.Source == "i-src" {
+			assert.Equal(t, failedChecks(single), failedChecks(pair.Checks))
+			assert.Equal(t, len(single), len(pair.Checks))
+		}
+	}
+
+	// --using-public-ip checks the public IP address of each destination
+	_, _, err = groupDestinations(sources, true)
+	assert.Equal(t, exitNotFound, exitCode(err))
+	sources[1].PublicIP = "54.0.0.20"
+	destinations, names, err = groupDestinations(sources[1:], true)
+	if assert.NoError(t, err) && assert.Len(t, destinations, 1) {
+		assert.Equal(t, []string{"i-dst"}, names)
+		assert.Equal(t, addressNetworks([]string{"54.0.0.20"}), destinations[0].networks)
+	}
+	pairs = checkConnectivityPairs(sources[:1], destinations, names, "tcp", &port, &defaultEphermalPortRange)
+	if assert.Len(t, pairs, 1) {
+		assert.False(t, pairs[0].Allowed)
+		assert.Contains(t, failedChecks(pairs[0].Checks), "Route exists from rtb-main to 54.0.0.20/32")
+	}
+}
+
+func TestConnectivityDestinationAddress(t *testing.T) {
+	c := newConnectivityFixture()
+
+	// A network interface defaults to its primary private IP address
+	dest, err := getConnectivityDestination(c.EC2(), c.RDS(), "eni-dst", "", false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, addressNetworks([]string{"10.0.2.20"}), dest.resolveNetworks())
+	}
+	_, err = getConnectivityDestination(c.EC2(), c.RDS(), "i-dst", "10.0.9.9", false)
+	assert.Equal(t, exitUsage, exitCode(err))
+	_, err = getConnectivityDestination(c.EC2(), c.RDS(), "i-dst", "", true)
+	assert.Equal(t, exitNotFound, exitCode(err))
+
+	// Traffic to the public IP address of the destination arrives from the
+	// public IP address of the source, which the reference of sg-src
+	// doesn't cover
+	c.ec2.Instances[0].PublicIpAddress = aws.String("54.0.0.10")
+	c.ec2.Instances[1].PublicIpAddress = aws.String("54.0.0.20")
+	dest, err = getConnectivityDestination(c.EC2(), c.RDS(), "i-dst", "", true)
+	if !assert.NoError(t, err) {
+		return
+	}
+	src := loadInstanceState(t, c, "i-src")
+	assert.NoError(t, enrichInstanceStates(c.EC2(), dest.state))
+	port := &ec2.PortRange{From: aws.Int64(5432), To: aws.Int64(5432)}
+	results := checkConnectivityToDestination(src, addressNetworks(src.PrivateIPAddresses), dest, "tcp", port, &defaultEphermalPortRange)
+	failed := failedChecks(results)
+	assert.Contains(t, failed, "Route exists from rtb-main to 54.0.0.20/32")
+	assert.Contains(t, failed, "Security Group at Destination allows Ingress traffic from 54.0.0.10/32")
+	assert.NotContains(t, failed, "Security Group at Source allows Egress Traffic to 54.0.0.20/32")
+}
+
+func TestConnectivityCommandLeavesFlags(t *testing.T) {
+	defer func(f func(string) ([]string, error)) { lookupHost = f }(lookupHost)
+	oldClients := clients
+	defer func(dest string, port int64, proto string) {
+		clients, toDest, destPort, protocol = oldClients, dest, port, proto
+	}(toDest, destPort, protocol)
+	lookupHost = func(host string) ([]string, error) { return []string{"10.0.2.30"}, nil }
+	clients = newDatabaseFixture()
+
+	// The address of a network interface and the port of a database are
+	// chosen for the check only, the flags keep their values for the next
+	// check
+	for _, tc := range []struct {
+		dest string
+		port int64
+	}{
+		{"eni-dst", 5432},
+		{"db:orders", -1},
+	} {
+		toDest, destPort, protocol = tc.dest, tc.port, "tcp"
+		captureOutput(outputTable, func() {
+			assert.NoError(t, inspectConnectivityCmd.RunE(inspectConnectivityCmd, []string{"i-src"}), tc.dest)
+		})
+		assert.Empty(t, destPrivateIPAddress)
+		assert.Equal(t, tc.port, destPort)
+	}
+}
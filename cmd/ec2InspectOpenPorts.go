@@ -0,0 +1,458 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/spf13/cobra"
+)
+
+// portRange is an inclusive range of ports, or of ICMP types
+type portRange struct {
+	From int64 `json:"from" yaml:"from"`
+	To   int64 `json:"to" yaml:"to"`
+}
+
+// portSet is a sorted list of port ranges which neither overlap nor touch
+type portSet []portRange
+
+// newPortSet returns the port set with the ports of the ranges
+func newPortSet(ranges ...portRange) portSet {
+	sorted := append([]portRange{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].From < sorted[j].From
+	})
+	var set portSet
+	for _, r := range sorted {
+		if r.From > r.To {
+			continue
+		}
+		if last := len(set) - 1; last >= 0 && r.From <= set[last].To+1 {
+			if r.To > set[last].To {
+				set[last].To = r.To
+			}
+			continue
+		}
+		set = append(set, r)
+	}
+	return set
+}
+
+func (s portSet) union(other portSet) portSet {
+	return newPortSet(append(append([]portRange{}, s...), other...)...)
+}
+
+func (s portSet) intersect(other portSet) portSet {
+	var ranges []portRange
+	for _, a := range s {
+		for _, b := range other {
+			r := portRange{From: a.From, To: a.To}
+			if b.From > r.From {
+				r.From = b.From
+			}
+			if b.To < r.To {
+				r.To = b.To
+			}
+			ranges = append(ranges, r)
+		}
+	}
+	return newPortSet(ranges...)
+}
+
+func (s portSet) subtract(other portSet) portSet {
+	result := s
+	for _, b := range other {
+		var next portSet
+		for _, a := range result {
+			if b.To < a.From || b.From > a.To {
+				next = append(next, a)
+				continue
+			}
+			if a.From < b.From {
+				next = append(next, portRange{From: a.From, To: b.From - 1})
+			}
+			if b.To < a.To {
+				next = append(next, portRange{From: b.To + 1, To: a.To})
+			}
+		}
+		result = next
+	}
+	return result
+}
+
+// String returns the ranges as e.g. "22, 443, 8000-8100"
+func (s portSet) String() string {
+	var parts []string
+	for _, r := range s {
+		if r.From == r.To {
+			parts = append(parts, strconv.FormatInt(r.From, 10))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", r.From, r.To))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// allPorts returns all the ports of a protocol, the ICMP types for ICMP
+func allPorts(protocol string) portSet {
+	if isICMPProtocol(protocol) {
+		return portSet{{From: 0, To: 255}}
+	}
+	return portSet{{From: 0, To: 65535}}
+}
+
+// permissionPorts returns the ports (or ICMP types) a security group rule
+// allows. The FromPort of an ICMP rule is the type and the ToPort the code,
+// -1 for all of them. An ICMP type is only allowed when all of its codes
+// are.
+func permissionPorts(permission *ec2.IpPermission, protocol string) portSet {
+	from, to := permission.FromPort, permission.ToPort
+	if from == nil || to == nil || protocolMapping[aws.StringValue(permission.IpProtocol)] == "all" {
+		return allPorts(protocol)
+	}
+	if isICMPProtocol(protocol) {
+		if !icmpMatches(nil, to, allICMPCodes) {
+			return nil
+		}
+		if *from == -1 {
+			return allPorts(protocol)
+		}
+		return portSet{{From: *from, To: *from}}
+	}
+	return newPortSet(portRange{From: *from, To: *to})
+}
+
+// allICMPCodes are the ports of all the codes of an ICMP type, as matched
+// by icmpMatches
+var allICMPCodes = &ec2.PortRange{To: aws.Int64(-1)}
+
+// naclEntryPorts returns the ports (or ICMP types) a network ACL entry covers
+// part of and those it covers all of, which differ for an entry covering one
+// code of an ICMP type
+func naclEntryPorts(entry *ec2.NetworkAclEntry, protocol string) (portSet, portSet) {
+	if isICMPProtocol(protocol) {
+		types := allPorts(protocol)
+		if entry.IcmpTypeCode == nil {
+			return types, types
+		}
+		if icmpType := aws.Int64Value(entry.IcmpTypeCode.Type); icmpType != -1 {
+			types = portSet{{From: icmpType, To: icmpType}}
+		}
+		if !icmpMatches(nil, entry.IcmpTypeCode.Code, allICMPCodes) {
+			return types, nil
+		}
+		return types, types
+	}
+	if entry.PortRange == nil {
+		return allPorts(protocol), allPorts(protocol)
+	}
+	ports := newPortSet(portRange{From: aws.Int64Value(entry.PortRange.From), To: aws.Int64Value(entry.PortRange.To)})
+	return ports, ports
+}
+
+// securityGroupPorts returns the ports the security group rules allow to
+// (egress) or from (ingress) the network
+func securityGroupPorts(rules []*SecurityGroupRule, egress bool, protocol string, network *net.IPNet, groups []*ec2.GroupIdentifier, prefixLists map[string][]string) portSet {
+	var ports portSet
+	for _, rule := range rules {
+		if rule.egress != egress || !protocolMatches(rule.permission.IpProtocol, protocol) {
+			continue
+		}
+		if matchSecurityGroupRule(rule, network, groups, prefixLists, make(map[string]interface{})) {
+			ports = ports.union(permissionPorts(rule.permission, protocol))
+		}
+	}
+	return ports
+}
+
+// naclPorts returns the ports the network ACL allows to (egress) or from
+// (ingress) the network, evaluating the entries as evaluateNACL does: the
+// first entry covering a port decides, an allow entry must contain all of
+// the network (and all the codes of an ICMP type) and a deny entry only part
+// of it.
+func naclPorts(acl *ec2.NetworkAcl, egress bool, protocol string, network *net.IPNet) portSet {
+	var entries []*ec2.NetworkAclEntry
+	for _, entry := range acl.Entries {
+		if aws.BoolValue(entry.Egress) == egress {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return aws.Int64Value(entries[i].RuleNumber) < aws.Int64Value(entries[j].RuleNumber)
+	})
+
+	remaining := allPorts(protocol)
+	var allowed portSet
+	for _, entry := range entries {
+		if !protocolMatches(entry.Protocol, protocol) {
+			continue
+		}
+		_, entryNetwork, err := net.ParseCIDR(aws.StringValue(naclEntryCIDR(entry)))
+		if err != nil || !networksOverlap(entryNetwork, network) {
+			continue
+		}
+		overlapped, covered := naclEntryPorts(entry, protocol)
+		if aws.StringValue(entry.RuleAction) == "deny" {
+			remaining = remaining.subtract(overlapped)
+		} else if networkContains(entryNetwork, network) {
+			allowed = allowed.union(remaining.intersect(covered))
+			remaining = remaining.subtract(covered)
+		}
+	}
+	return allowed
+}
+
+// openPortsStage is what a stage of the path allows, e.g. the egress rules
+// of the source's security groups
+type openPortsStage struct {
+	Name string `json:"name" yaml:"name"`
+	// Map of protocol to the ports (ICMP types for ICMP) allowed
+	Ports map[string]portSet `json:"ports" yaml:"ports"`
+}
+
+// openPorts is what is open from an address of the source to an address of
+// the destination
+type openPorts struct {
+	Source      string `json:"source" yaml:"source"`
+	Destination string `json:"destination" yaml:"destination"`
+	// Map of protocol to the ports (ICMP types for ICMP) open end to end
+	Ports   map[string]portSet `json:"ports" yaml:"ports"`
+	Summary string             `json:"summary" yaml:"summary"`
+	Stages  []openPortsStage   `json:"stages" yaml:"stages"`
+
+	protocols []string
+}
+
+// openPortsProtocols returns the protocols we look at for a network
+func openPortsProtocols(network *net.IPNet) []string {
+	if isIPv6Network(network) {
+		return []string{"tcp", "udp", "icmpv6"}
+	}
+	return []string{"tcp", "udp", "icmp"}
+}
+
+// summarizeOpenPorts returns the open ports as e.g. "tcp 22, 443; udp 53"
+func summarizeOpenPorts(ports map[string]portSet, protocols []string) string {
+	var parts []string
+	for _, protocol := range protocols {
+		set := ports[protocol]
+		switch {
+		case len(set) == 0:
+			continue
+		case len(set) == 1 && set[0] == allPorts(protocol)[0]:
+			parts = append(parts, protocol+" all")
+		default:
+			parts = append(parts, protocol+" "+set.String())
+		}
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// computeOpenPorts intersects what each stage of the path from the source
+// network to the destination network allows: the source's security groups,
+// network ACLs (including the return traffic to the ephemeral ports) and
+// route, then the destination's network ACLs, route back and security
+// groups. dest is nil for an IP address or CIDR block, in which case only
+// the source side is looked at. An ICMP query, such as an echo request, is
+// only open when the network ACLs allow its reply back.
+func computeOpenPorts(source *instanceState, sourceNetwork *net.IPNet, dest *instanceState, destNetwork *net.IPNet, ephemeralPorts *ec2.PortRange) *openPorts {
+	protocols := openPortsProtocols(destNetwork)
+	result := &openPorts{Source: sourceNetwork.String(), Destination: destNetwork.String(), Ports: make(map[string]portSet), protocols: protocols}
+
+	addStage := func(name string, ports func(protocol string) portSet) {
+		stage := openPortsStage{Name: name, Ports: make(map[string]portSet)}
+		for _, protocol := range protocols {
+			stage.Ports[protocol] = ports(protocol)
+		}
+		result.Stages = append(result.Stages, stage)
+	}
+	// Stages such as a route either allow all the traffic or none of it
+	allOrNothing := func(allowed func(protocol string) bool) func(protocol string) portSet {
+		return func(protocol string) portSet {
+			if allowed(protocol) {
+				return allPorts(protocol)
+			}
+			return nil
+		}
+	}
+	returnTraffic := func(acl *ec2.NetworkAcl, egress bool, network *net.IPNet) func(protocol string) portSet {
+		return func(protocol string) portSet {
+			if !isICMPProtocol(protocol) {
+				_, allowed := evaluateNACL(acl, egress, protocol, network, ephemeralPorts)
+				if allowed {
+					return allPorts(protocol)
+				}
+				return nil
+			}
+			// The other ICMP types have no return traffic
+			open := allPorts(protocol)
+			for query := range icmpReplies[protocol] {
+				ports := &ec2.PortRange{From: aws.Int64(query), To: aws.Int64(-1)}
+				if _, allowed := evaluateNACL(acl, egress, protocol, network, returnTrafficPorts(protocol, ports, ephemeralPorts)); !allowed {
+					open = open.subtract(portSet{{From: query, To: query}})
+				}
+			}
+			return open
+		}
+	}
+
+	var destGroups []*ec2.GroupIdentifier
+	if dest != nil {
+		destGroups = securityGroupsFor(dest, destNetwork)
+	}
+	addStage("Security Group egress at source", func(protocol string) portSet {
+		return securityGroupPorts(source.SecurityGroupRules, true, protocol, destNetwork, destGroups, source.PrefixLists)
+	})
+	for _, subnetID := range sortedSubnetIDs(source.NetworkAcls) {
+		acl := source.NetworkAcls[subnetID]
+		addStage("Egress ACL from Subnet "+subnetID, func(protocol string) portSet {
+			return naclPorts(acl, true, protocol, destNetwork)
+		})
+		addStage("Ingress ACL at Subnet "+subnetID+" allows return traffic", returnTraffic(acl, false, destNetwork))
+	}
+	routes := checkRouteToNetwork(source, dest, destNetwork)
+	addStage("Route from source", allOrNothing(func(string) bool {
+		return summarizeResults(routes...)
+	}))
+
+	if dest != nil {
+		for _, subnetID := range subnetsContaining(dest, destNetwork) {
+			acl := dest.NetworkAcls[subnetID]
+			addStage("Ingress ACL at Subnet "+subnetID, func(protocol string) portSet {
+				return naclPorts(acl, false, protocol, sourceNetwork)
+			})
+			addStage("Egress ACL from Subnet "+subnetID+" allows return traffic", returnTraffic(acl, true, sourceNetwork))
+		}
+		routesBack := checkRouteToNetwork(dest, source, sourceNetwork)
+		addStage("Route back from destination", allOrNothing(func(string) bool {
+			return summarizeResults(routesBack...)
+		}))
+		sourceGroups := securityGroupsFor(source, sourceNetwork)
+		addStage("Security Group ingress at destination", func(protocol string) portSet {
+			return securityGroupPorts(dest.SecurityGroupRules, false, protocol, sourceNetwork, sourceGroups, dest.PrefixLists)
+		})
+	}
+
+	for _, protocol := range protocols {
+		open := allPorts(protocol)
+		for _, stage := range result.Stages {
+			open = open.intersect(stage.Ports[protocol])
+		}
+		result.Ports[protocol] = open
+	}
+	result.Summary = summarizeOpenPorts(result.Ports, protocols)
+	return result
+}
+
+var openPortsTo string
+
+var inspectOpenPortsCmd = &cobra.Command{
+	Use:   "open-ports",
+	Short: "List the ports open from an EC2 instance to an EC2 instance, IP address, CIDR block or database",
+	Long: `List the TCP and UDP ports and the ICMP types which are allowed end to end from an EC2 instance to
+another instance, IP address, CIDR block or database. The ports the security groups and network ACLs allow
+on both sides are intersected, and nothing is open when there isn't a route either way or the network ACLs
+don't allow the return traffic to the ephermal ports. An ICMP type is open when all of its codes are allowed,
+and a query such as an echo request only when the network ACLs allow its reply back:
+
+	yawsi ec2 inspect open-ports i-06d80024e0df241da --to i-03fb71646161e8626
+	SOURCE             DESTINATION        OPEN PORTS
+	172.31.41.185/32   172.31.13.182/32   tcp 22, 443, 8000-8100; udp 53; icmp all
+
+The --verbose flag shows what each security group, network ACL and route allows:
+
+	yawsi ec2 inspect open-ports i-06d80024e0df241da --to 10.50.0.0/16 --verbose
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(openPortsTo) == 0 {
+			return newUsageError("Specify the destination via --to")
+		}
+		if !isInstanceOrInterface(args[0]) {
+			return newUsageError("Unrecognized source specification: %s", args[0])
+		}
+		if isDatabaseDestination(openPortsTo) && len(snapshotFilePath) != 0 {
+			return newUsageError("Snapshots don't include RDS, databases can't be used with --from-snapshot")
+		}
+		ephemeralPorts, err := parseEphermalPortRange()
+		if err != nil {
+			return err
+		}
+
+		svc := getClients().EC2()
+		dest, err := getConnectivityDestination(svc, getClients().RDS(), openPortsTo, "", false)
+		if err != nil {
+			return err
+		}
+		sources, err := getEndpointStates(svc, args[0])
+		if err != nil {
+			return err
+		}
+		source := sources[0]
+		states := []*instanceState{source}
+		if dest.state != nil {
+			states = append(states, dest.state)
+		}
+		if err := enrichInstanceStates(svc, states...); err != nil {
+			return err
+		}
+
+		var items []*openPorts
+		for _, destNetwork := range dest.resolveNetworks() {
+			from := source.forTrafficTo(destNetwork)
+			for _, sourceNetwork := range sameVersionNetworks(addressNetworks(allAddresses(from)), destNetwork) {
+				items = append(items, computeOpenPorts(from, sourceNetwork, dest.state.forAddress(destNetwork), destNetwork, &ephemeralPorts))
+			}
+		}
+		if len(items) == 0 {
+			return newNotFoundError("Couldn't find the addresses of %s", openPortsTo)
+		}
+
+		table := newTableOutput("Source", "Destination", "Open Ports")
+		if verboseOutput {
+			table = newTableOutput("Source", "Destination", "Stage", "Open Ports")
+		}
+		for _, item := range items {
+			if !verboseOutput {
+				table.addRow(item.Source, item.Destination, item.Summary)
+				continue
+			}
+			for _, stage := range item.Stages {
+				table.addRow(item.Source, item.Destination, stage.Name, summarizeOpenPorts(stage.Ports, item.protocols))
+			}
+			table.addRow(item.Source, item.Destination, "End to end", item.Summary)
+		}
+		return renderItems("OpenPortsList", items, table)
+	},
+	Args: cobra.ExactArgs(1),
+}
+
+func init() {
+	inspectInstancesCmd.AddCommand(inspectOpenPortsCmd)
+	addSnapshotFlag(inspectOpenPortsCmd)
+	inspectOpenPortsCmd.Flags().StringVarP(&openPortsTo, "to", "", "", "Destination - EC2 instance Id, IP address, CIDR block or database (db:<identifier> or ARN)")
+	inspectOpenPortsCmd.MarkFlagCustom("to", "__yawsi_instance_ids")
+	inspectOpenPortsCmd.Flags().StringVarP(&customEphermalPortRange, "override-ephermal-port-range", "", "", "Override ephermal port range")
+	inspectOpenPortsCmd.Flags().BoolVarP(&verboseOutput, "verbose", "v", false, "Display what each stage of the path allows")
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPortSet(t *testing.T) {
+	s := newPortSet(portRange{From: 443, To: 443}, portRange{From: 22, To: 22}, portRange{From: 8000, To: 8100}, portRange{From: 8101, To: 8200})
+	assert.Equal(t, "22, 443, 8000-8200", s.String())
+	assert.Equal(t, "443, 8000-8010", s.intersect(newPortSet(portRange{From: 100, To: 8010})).String())
+	assert.Equal(t, "22, 443, 8000-8049, 8051-8200", s.subtract(newPortSet(portRange{From: 8050, To: 8050})).String())
+	assert.Equal(t, "22-443, 8000-8200", s.union(newPortSet(portRange{From: 23, To: 442})).String())
+	assert.Empty(t, s.intersect(nil))
+}
+
+func TestComputeOpenPorts(t *testing.T) {
+	c := newConnectivityFixture()
+	acl := c.ec2.NetworkAcls[1]
+	acl.Entries = append(acl.Entries,
+		&ec2.NetworkAclEntry{
+			RuleNumber: aws.Int64(110), Egress: aws.Bool(false), Protocol: aws.String("6"), CidrBlock: aws.String("10.0.0.0/16"), RuleAction: aws.String("allow"),
+			PortRange: &ec2.PortRange{From: aws.Int64(8000), To: aws.Int64(8100)},
+		},
+		&ec2.NetworkAclEntry{
+			RuleNumber: aws.Int64(105), Egress: aws.Bool(false), Protocol: aws.String("6"), CidrBlock: aws.String("10.0.1.0/24"), RuleAction: aws.String("deny"),
+			PortRange: &ec2.PortRange{From: aws.Int64(8050), To: aws.Int64(8050)},
+		},
+	)
+	group := c.ec2.SecurityGroups[1]
+	group.IpPermissions = append(group.IpPermissions, &ec2.IpPermission{
+		IpProtocol: aws.String("tcp"),
+		FromPort:   aws.Int64(7000),
+		ToPort:     aws.Int64(9000),
+		IpRanges:   []*ec2.IpRange{{CidrIp: aws.String("10.0.0.0/16")}},
+	})
+
+	src := loadInstanceState(t, c, "i-src")
+	dest, err := getConnectivityDestination(c.EC2(), c.RDS(), "i-dst", "", false)
+	if !assert.NoError(t, err) || !assert.NoError(t, enrichInstanceStates(c.EC2(), src, dest.state)) {
+		return
+	}
+	open := computeOpenPorts(src, mustParseNetwork(t, "10.0.1.10"), dest.state, mustParseNetwork(t, "10.0.2.20"), &defaultEphermalPortRange)
+	assert.Equal(t, "tcp 5432, 8000-8049, 8051-8100", open.Summary)
+	assert.Empty(t, open.Ports["udp"])
+	if assert.Len(t, open.Stages, 8) {
+		assert.Equal(t, "Security Group egress at source", open.Stages[0].Name)
+		assert.Equal(t, "tcp all; udp all; icmp all", summarizeOpenPorts(open.Stages[0].Ports, open.protocols))
+		assert.Equal(t, "Ingress ACL at Subnet subnet-b", open.Stages[4].Name)
+		assert.Equal(t, "tcp 5432, 8000-8049, 8051-8100", summarizeOpenPorts(open.Stages[4].Ports, open.protocols))
+	}
+
+	// Nothing is open when the return traffic to the source is blocked
+	acl.Entries = append(acl.Entries[:1], acl.Entries[2:]...)
+	open = computeOpenPorts(src, mustParseNetwork(t, "10.0.1.10"), dest.state, mustParseNetwork(t, "10.0.2.20"), &defaultEphermalPortRange)
+	assert.Equal(t, "none", open.Summary)
+
+	// Only the source side is looked at for a CIDR block
+	open = computeOpenPorts(src, mustParseNetwork(t, "10.0.1.10"), nil, mustParseNetwork(t, "10.0.0.0/16"), &defaultEphermalPortRange)
+	// The network ACL of subnet-a doesn't allow the replies of the ICMP
+	// queries back
+	assert.Equal(t, "tcp all; icmp 0-7, 9-12, 14, 16, 18-255", open.Summary)
+}
+
+func TestComputeOpenPortsICMP(t *testing.T) {
+	c := newConnectivityFixture()
+	icmpEntry := func(number int64, egress bool, action string, icmpType, icmpCode int64) *ec2.NetworkAclEntry {
+		return &ec2.NetworkAclEntry{
+			RuleNumber:   aws.Int64(number),
+			Egress:       aws.Bool(egress),
+			Protocol:     aws.String("1"),
+			CidrBlock:    aws.String("10.0.0.0/16"),
+			RuleAction:   aws.String(action),
+			IcmpTypeCode: &ec2.IcmpTypeCode{Type: aws.Int64(icmpType), Code: aws.Int64(icmpCode)},
+		}
+	}
+	aclA, aclB := c.ec2.NetworkAcls[0], c.ec2.NetworkAcls[1]
+	// subnet-a allows the echo replies back, subnet-b allows echo requests
+	// and destination unreachable messages with any code other than 4
+	aclA.Entries = append(aclA.Entries, icmpEntry(120, false, "allow", 0, -1))
+	aclB.Entries = append(aclB.Entries,
+		icmpEntry(120, true, "allow", 0, -1),
+		icmpEntry(120, false, "allow", 8, -1),
+		icmpEntry(125, false, "deny", 3, 4),
+		icmpEntry(130, false, "allow", 3, -1),
+		icmpEntry(140, false, "allow", 11, 0),
+	)
+	group := c.ec2.SecurityGroups[1]
+	group.IpPermissions = append(group.IpPermissions,
+		&ec2.IpPermission{IpProtocol: aws.String("icmp"), FromPort: aws.Int64(-1), ToPort: aws.Int64(-1), IpRanges: []*ec2.IpRange{{CidrIp: aws.String("10.0.0.0/16")}}},
+	)
+
+	src := loadInstanceState(t, c, "i-src")
+	dest, err := getConnectivityDestination(c.EC2(), c.RDS(), "i-dst", "", false)
+	if !assert.NoError(t, err) || !assert.NoError(t, enrichInstanceStates(c.EC2(), src, dest.state)) {
+		return
+	}
+	open := computeOpenPorts(src, mustParseNetwork(t, "10.0.1.10"), dest.state, mustParseNetwork(t, "10.0.2.20"), &defaultEphermalPortRange)
+	// Type 3 is only open for some of its codes and type 11 for code 0, the
+	// echo request is open since its reply is allowed back
+	assert.Equal(t, "8", open.Ports["icmp"].String())
+
+	// Without the reply, the echo request isn't open
+	aclA.Entries = aclA.Entries[:len(aclA.Entries)-1]
+	src = loadInstanceState(t, c, "i-src")
+	open = computeOpenPorts(src, mustParseNetwork(t, "10.0.1.10"), dest.state, mustParseNetwork(t, "10.0.2.20"), &defaultEphermalPortRange)
+	assert.Empty(t, open.Ports["icmp"])
+
+	// A security group rule for one code of a type doesn't open the type
+	group.IpPermissions[len(group.IpPermissions)-1].FromPort = aws.Int64(3)
+	group.IpPermissions[len(group.IpPermissions)-1].ToPort = aws.Int64(4)
+	assert.Empty(t, permissionPorts(group.IpPermissions[len(group.IpPermissions)-1], "icmp"))
+}
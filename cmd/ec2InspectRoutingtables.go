@@ -17,145 +17,156 @@ package cmd
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws" //"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/spf13/cobra"
-	"log"
-	"os"
 	"strings"
-	"text/tabwriter"
 )
 
-func displayRoutingTables(routes []*RouteContainer) {
+type routeOutput struct {
+	RouteTableId string `json:"routeTableId" yaml:"routeTableId"`
+	Main         bool   `json:"main" yaml:"main"`
+	Destination  string `json:"destination" yaml:"destination"`
+	Target       string `json:"target" yaml:"target"`
+	Description  string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+var prefixListServices = map[string]string{
+	"s3":                   "S3",
+	"dynamodb":             "DynamoDB",
+	"ec2":                  "EC2",
+	"ec2messages":          "EC2 Messages",
+	"elasticloadbalancing": "ELB API",
+	"kinesis":              "Kinesis",
+	"ssm":                  "SSM",
+}
+
+func describePrefixListService(svc ec2iface.EC2API, prefixListId *string) (string, error) {
+	input := &ec2.DescribePrefixListsInput{
+		PrefixListIds: []*string{prefixListId},
+	}
+	result, err := svc.DescribePrefixLists(input)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't describe prefix list %s: %w", *prefixListId, err)
+	}
+
+	if len(result.PrefixLists) != 1 {
+		return "", newNotFoundError("Prefix list %s not found", *prefixListId)
+	}
+
+	for k, v := range prefixListServices {
+		if strings.Contains(*result.PrefixLists[0].PrefixListName, k) {
+			return v, nil
+		}
+	}
+	return "", nil
+}
 
-	sess := createSession()
-	svc := ec2.New(sess)
+func describePeeredVpc(svc ec2iface.EC2API, peeringConnectionId *string) (string, error) {
+	input := &ec2.DescribeVpcPeeringConnectionsInput{
+		VpcPeeringConnectionIds: []*string{peeringConnectionId},
+	}
+
+	result, err := svc.DescribeVpcPeeringConnections(input)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't describe VPC peering connection %s: %w", *peeringConnectionId, err)
+	}
+
+	if len(result.VpcPeeringConnections) == 0 {
+		return "", newNotFoundError("VPC peering connection %s not found", *peeringConnectionId)
+	}
+	vpcId := *result.VpcPeeringConnections[0].AccepterVpcInfo.VpcId
+	input1 := &ec2.DescribeVpcsInput{
+		VpcIds: []*string{
+			aws.String(vpcId),
+		},
+	}
+
+	// The accepter VPC may be in another account, in which case we
+	// only know its ID
+	result1, err := svc.DescribeVpcs(input1)
+	if err != nil || len(result1.Vpcs) == 0 {
+		return vpcId, nil
+	}
+
+	vpcName := ""
+	for _, tag := range result1.Vpcs[0].Tags {
+		if *tag.Key == "Name" {
+			vpcName = *tag.Value
+		}
+	}
+	return fmt.Sprintf("%s - %s", vpcId, vpcName), nil
+}
 
-	w := new(tabwriter.Writer)
+func displayRoutingTables(svc ec2iface.EC2API, routes []*RouteContainer) error {
 
-	// Format in tab-separated columns with a tab stop of 8.
-	w.Init(os.Stdout, 0, 40, 0, '\t', tabwriter.AlignRight)
-	fmt.Fprintln(w, "RoutTableID\tMain\tDestination\tTarget\t")
-	fmt.Fprintln(w, "-----------\t----\t------------\t------\t")
+	items := []routeOutput{}
+	table := newTableOutput("RoutTableID", "Main", "Destination", "Target")
 
 	for _, routeTable := range routes {
 
 		for _, route := range routeTable.Routes {
-			fmt.Fprintf(w, "%v\t", routeTable.RouteTableId)
-			fmt.Fprintf(w, "%v\t", routeTable.Main)
+			item := routeOutput{
+				RouteTableId: routeTable.RouteTableId,
+				Main:         routeTable.Main,
+			}
+			destination := ""
 
 			if route.DestinationCidrBlock != nil {
-				fmt.Fprintf(w, "%s\t", *route.DestinationCidrBlock)
+				item.Destination = *route.DestinationCidrBlock
+				destination = item.Destination
 			}
 
 			if route.DestinationPrefixListId != nil {
-				fmt.Fprintf(w, "%s", *route.DestinationPrefixListId)
-				input := &ec2.DescribePrefixListsInput{
-					PrefixListIds: []*string{route.DestinationPrefixListId},
-				}
-				result, err := svc.DescribePrefixLists(input)
+				item.Destination = *route.DestinationPrefixListId
+				destination = item.Destination
+				service, err := describePrefixListService(svc, route.DestinationPrefixListId)
 				if err != nil {
-					log.Fatal(err)
-				}
-
-				if len(result.PrefixLists) != 1 {
-					panic("Expected the prefix list query to return a result")
-				}
-
-				prefixListServices := map[string]string{
-					"s3":                   "S3",
-					"dynamodb":             "DynamoDB",
-					"ec2":                  "EC2",
-					"ec2messages":          "EC2 Messages",
-					"elasticloadbalancing": "ELB API",
-					"kinesis":              "Kinesis",
-					"ssm":                  "SSM",
+					return err
 				}
-				for k, v := range prefixListServices {
-					if strings.Contains(*result.PrefixLists[0].PrefixListName, k) {
-						fmt.Fprintf(w, "(%s)\t", v)
-					}
+				if len(service) != 0 {
+					item.Description = service
+					destination = fmt.Sprintf("%s(%s)", item.Destination, service)
 				}
-
 			}
 
+			target := ""
 			if route.GatewayId != nil && len(*route.GatewayId) != 0 {
-				fmt.Fprintf(w, "%s\t\n", *route.GatewayId)
+				item.Target = *route.GatewayId
+				target = item.Target
 				// TODO: Add more details on the gateway as required
 			}
 			if route.NatGatewayId != nil && len(*route.NatGatewayId) != 0 {
-				fmt.Fprintf(w, "%s\t\n", *route.NatGatewayId)
+				item.Target = *route.NatGatewayId
+				target = item.Target
 			}
 			// NAT instance - this means we likely have both the instance ID
 			// and network interface ID set corresponding to the ENI interface
 			if route.InstanceId != nil && len(*route.InstanceId) != 0 {
-				fmt.Fprintf(w, "%s - ", *route.InstanceId)
+				item.Target = *route.InstanceId
 				if route.NetworkInterfaceId != nil && len(*route.NetworkInterfaceId) != 0 {
-					fmt.Fprintf(w, "%s\t\n", *route.NetworkInterfaceId)
+					item.Description = *route.NetworkInterfaceId
+					target = fmt.Sprintf("%s - %s", *route.InstanceId, *route.NetworkInterfaceId)
 				} else {
-					fmt.Fprintf(w, " (No ENI)\t\n")
+					target = fmt.Sprintf("%s - (No ENI)", *route.InstanceId)
 				}
 			}
 			if route.VpcPeeringConnectionId != nil && len(*route.VpcPeeringConnectionId) != 0 {
-
-				input := &ec2.DescribeVpcPeeringConnectionsInput{
-					VpcPeeringConnectionIds: []*string{route.VpcPeeringConnectionId},
-				}
-
-				result, err := svc.DescribeVpcPeeringConnections(input)
+				item.Target = *route.VpcPeeringConnectionId
+				description, err := describePeeredVpc(svc, route.VpcPeeringConnectionId)
 				if err != nil {
-					if aerr, ok := err.(awserr.Error); ok {
-						switch aerr.Code() {
-						default:
-							fmt.Println(aerr.Error())
-						}
-					} else {
-						// Print the error, cast err to awserr.Error to get the Code and
-						// Message from an error.
-						fmt.Println(err.Error())
-					}
+					return err
 				}
-
-				if result == nil {
-					fmt.Println("Could not retrieve VPC peering connection details")
-
-				}
-				vpcId := *result.VpcPeeringConnections[0].AccepterVpcInfo.VpcId
-				input1 := &ec2.DescribeVpcsInput{
-					VpcIds: []*string{
-						aws.String(vpcId),
-					},
-				}
-
-				result1, err := svc.DescribeVpcs(input1)
-				if err != nil {
-					if aerr, ok := err.(awserr.Error); ok {
-						switch aerr.Code() {
-						default:
-							fmt.Println(aerr.Error())
-						}
-					} else {
-						// Print the error, cast err to awserr.Error to get the Code and
-						// Message from an error.
-						fmt.Println(err.Error())
-					}
-					return
-				}
-
-				vpcName := ""
-				for _, tag := range result1.Vpcs[0].Tags {
-					if *tag.Key == "Name" {
-						vpcName = *tag.Value
-					}
-				}
-				fmt.Fprintf(w, "%s (%s - %s) \t\n", *route.VpcPeeringConnectionId, vpcId, vpcName)
+				item.Description = description
+				target = fmt.Sprintf("%s (%s)", item.Target, item.Description)
 			}
+
+			items = append(items, item)
+			table.addRow(item.RouteTableId, fmt.Sprintf("%v", item.Main), destination, target)
 		}
 	}
 
-	fmt.Fprintln(w)
-	w.Flush()
-
+	return renderItems("RouteList", items, table)
 }
 
 var inspectRoutingTablesInstancesCmd = &cobra.Command{
@@ -172,20 +183,27 @@ var inspectRoutingTablesInstancesCmd = &cobra.Command{
 	rtb-942315f1    true    172.31.0.0/16   pcx-cd9541a4 (vpc-20988a4 - VPCA)
 	rtb-63caa9f1    true    0.0.0.0/0       igw-121234
 	`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		//var ec2Filters []*ec2.Filter
 		var inputInstanceIds []*string
 
+		svc := getClients().EC2()
 		inputInstanceIds = append(inputInstanceIds, &args[0])
-		instanceData := getEC2InstanceData(nil, inputInstanceIds...)
+		instanceData, err := getEC2InstanceData(svc, nil, inputInstanceIds...)
+		if err != nil {
+			return err
+		}
 
 		if len(instanceData) != 1 {
-			panic("Expected ")
+			return newNotFoundError("Instance %s not found", args[0])
 		}
 		instanceState := instanceData[0]
 
-		routes := getRoutes(instanceState.SubnetIds...)
-		displayRoutingTables(routes)
+		routes, err := getRoutes(svc, instanceState.SubnetIds...)
+		if err != nil {
+			return err
+		}
+		return displayRoutingTables(svc, routes)
 	},
 	Args: cobra.ExactArgs(1),
 }
@@ -196,4 +214,5 @@ var inspectRoutingTablesInstancesCmd = &cobra.Command{
 
 func init() {
 	inspectInstancesCmd.AddCommand(inspectRoutingTablesInstancesCmd)
+	addSnapshotFlag(inspectRoutingTablesInstancesCmd)
 }
@@ -21,7 +21,6 @@ import (
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/spf13/cobra"
 )
@@ -31,10 +30,9 @@ var launchMoreLikeCmd = &cobra.Command{
 	Use:   "launch-more-like",
 	Short: "Launch more AWS EC2 instance like another instance",
 	Long:  `launch-more-like creates another AWS instance given another instance id`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		cloneInstanceID := args[0]
-		sess := createSession()
-		svc := ec2.New(sess)
+		svc := getClients().EC2()
 		var ec2Filters []*ec2.Filter
 		ec2Filters = append(ec2Filters, &ec2.Filter{
 			Name: aws.String("instance-id"),
@@ -49,10 +47,10 @@ var launchMoreLikeCmd = &cobra.Command{
 
 		instancesOutput, err := svc.DescribeInstances(params)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("Couldn't describe the instance: %w", err)
 		}
 		if len(instancesOutput.Reservations) == 0 {
-			log.Fatalf("No instance found with ID: %s", cloneInstanceID)
+			return newNotFoundError("No instance found with ID: %s", cloneInstanceID)
 		}
 		instance := instancesOutput.Reservations[0].Instances[0]
 
@@ -73,15 +71,7 @@ var launchMoreLikeCmd = &cobra.Command{
 
 		result, err := svc.DescribeInstanceAttribute(input)
 		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok {
-				switch aerr.Code() {
-				default:
-					fmt.Println(aerr.Error())
-				}
-			} else {
-				fmt.Println(err.Error())
-			}
-			return
+			return fmt.Errorf("Couldn't get the user data of the instance: %w", err)
 		}
 
 		var instanceTags []*ec2.Tag
@@ -102,6 +92,9 @@ var launchMoreLikeCmd = &cobra.Command{
 		if len(updateTags) != 0 {
 			for _, f := range strings.Split(updateTags, ",") {
 				kv := strings.Split(f, ":")
+				if len(kv) != 2 {
+					return newUsageError("Invalid tag %q, expected key:value", f)
+				}
 				key := strings.TrimSpace(kv[0])
 				value := strings.TrimSpace(kv[1])
 
@@ -116,12 +109,11 @@ var launchMoreLikeCmd = &cobra.Command{
 		if editUserData {
 			currentUserData, err := base64.StdEncoding.DecodeString(*result.UserData.Value)
 			if err != nil {
-				fmt.Println("error:", err)
-				return
+				return fmt.Errorf("Couldn't decode the user data: %w", err)
 			}
 			userData, err = modifyUserData(string(currentUserData))
 			if err != nil {
-				log.Fatalf("Error editing user data: %s", err)
+				return fmt.Errorf("Error editing user data: %w", err)
 			}
 			userDataEncoded := base64.StdEncoding.EncodeToString([]byte(*userData))
 			userData = &userDataEncoded
@@ -153,7 +145,7 @@ var launchMoreLikeCmd = &cobra.Command{
 		log.Printf("Launching instance with %#v\n", launchParams)
 		runResult, err := svc.RunInstances(launchParams)
 		if err != nil {
-			log.Fatal("Could not create instance", err)
+			return fmt.Errorf("Could not create instance: %w", err)
 		}
 
 		log.Println("Created instance", *runResult.Instances[0].InstanceId)
@@ -164,10 +156,11 @@ var launchMoreLikeCmd = &cobra.Command{
 			Tags:      instanceTags,
 		})
 		if err != nil {
-			log.Fatal("Could not create tags for instance", runResult.Instances[0].InstanceId, err)
+			return fmt.Errorf("Could not create tags for instance %s: %w", *runResult.Instances[0].InstanceId, err)
 		}
 
 		log.Println("Successfully tagged instance")
+		return nil
 	},
 	Args: cobra.ExactArgs(1),
 }
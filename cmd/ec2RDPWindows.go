@@ -15,9 +15,6 @@
 package cmd
 
 import (
-	"strings"
-
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/spf13/cobra"
 )
@@ -29,46 +26,37 @@ var rdpWindowsCmd = &cobra.Command{
 
 	yawsi ec2 rdp-windows i-0121212
 	`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		var instanceID string
 		var ec2Filters []*ec2.Filter
 
+		svc := getClients().EC2()
+
 		if len(args) == 1 {
 			instanceID = args[0]
 		} else {
 			if len(tags) != 0 {
-				for _, tag := range strings.Split(tags, ",") {
-					tag = strings.TrimSpace(tag)
-					key := tag[0:strings.LastIndex(tag, ":")]
-					value := tag[strings.LastIndex(tag, ":")+1 : len(tag)]
-
-					ec2Filters = append(ec2Filters, &ec2.Filter{
-						Name: aws.String("tag:" + key),
-						Values: []*string{
-							aws.String(value),
-						},
-					})
+				tagFilters, err := parseTagFilters(tags)
+				if err != nil {
+					return err
 				}
+				ec2Filters = append(ec2Filters, tagFilters...)
 			}
 
 			if len(tagKeys) != 0 {
-				for _, tag := range strings.Split(tagKeys, ",") {
-					tag = strings.TrimSpace(tag)
-					ec2Filters = append(ec2Filters, &ec2.Filter{
-						Name: aws.String("tag-key"),
-						Values: []*string{
-							aws.String(tag),
-						},
-					})
-				}
+				ec2Filters = append(ec2Filters, parseTagKeyFilters(tagKeys)...)
 			}
-			var instanceIDs []*string
+			var instances instanceListing
 
-			go getEC2InstanceIDs(ec2Filters, &instanceIDs)
-			selectedInstance := selectEC2InstanceInteractive(&instanceIDs)
+			cachedSvc := getClients().CachedEC2()
+			go listEC2Instances(cachedSvc, ec2Filters, &instances)
+			selectedInstance, err := selectEC2InstanceInteractive(cachedSvc, &instances)
+			if err != nil {
+				return err
+			}
 			instanceID = selectedInstance.InstanceId
 		}
-		rdpWindowsHelper(instanceID, PrivateIP, PublicIP, ShowCommand, KeyPath, rdpPassword)
+		return rdpWindowsHelper(svc, instanceID, PrivateIP, PublicIP, ShowCommand, KeyPath, rdpPassword)
 	},
 	//Args: cobra.ExactArgs(1),
 }
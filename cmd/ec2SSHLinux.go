@@ -15,9 +15,6 @@
 package cmd
 
 import (
-	"strings"
-
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/spf13/cobra"
 )
@@ -29,49 +26,47 @@ var ec2SSHLinuxCmd = &cobra.Command{
 
 	    yawsi ec2 ssh-linux i-0121212
 	`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		var instanceID string
 		var ec2Filters []*ec2.Filter
 		var instanceDetails []*instanceState
 
+		svc := getClients().EC2()
+
 		if len(args) == 1 {
 			instanceID = args[0]
-			instanceDetails = getEC2InstanceData(ec2Filters, &instanceID)
+			var err error
+			instanceDetails, err = getEC2InstanceData(svc, ec2Filters, &instanceID)
+			if err != nil {
+				return err
+			}
+			if len(instanceDetails) == 0 {
+				return newNotFoundError("Instance %s not found", instanceID)
+			}
 		} else {
 			if len(tags) != 0 {
-				for _, tag := range strings.Split(tags, ",") {
-					tag = strings.TrimSpace(tag)
-					key := tag[0:strings.LastIndex(tag, ":")]
-					value := tag[strings.LastIndex(tag, ":")+1 : len(tag)]
-
-					ec2Filters = append(ec2Filters, &ec2.Filter{
-						Name: aws.String("tag:" + key),
-						Values: []*string{
-							aws.String(value),
-						},
-					})
+				tagFilters, err := parseTagFilters(tags)
+				if err != nil {
+					return err
 				}
+				ec2Filters = append(ec2Filters, tagFilters...)
 			}
 
 			if len(tagKeys) != 0 {
-				for _, tag := range strings.Split(tagKeys, ",") {
-					tag = strings.TrimSpace(tag)
-					ec2Filters = append(ec2Filters, &ec2.Filter{
-						Name: aws.String("tag-key"),
-						Values: []*string{
-							aws.String(tag),
-						},
-					})
-				}
+				ec2Filters = append(ec2Filters, parseTagKeyFilters(tagKeys)...)
 			}
 
-			var instanceIDs []*string
-			go getEC2InstanceIDs(ec2Filters, &instanceIDs)
-			selectedInstanceDetails := selectEC2InstanceInteractive(&instanceIDs)
+			var instances instanceListing
+			cachedSvc := getClients().CachedEC2()
+			go listEC2Instances(cachedSvc, ec2Filters, &instances)
+			selectedInstanceDetails, err := selectEC2InstanceInteractive(cachedSvc, &instances)
+			if err != nil {
+				return err
+			}
 			instanceDetails = append(instanceDetails, selectedInstanceDetails)
 		}
 
-		startSSHSessionLinux(instanceDetails, PrivateIP, PublicIP, KeyPath, sshUsername)
+		return startSSHSessionLinux(instanceDetails, PrivateIP, PublicIP, KeyPath, sshUsername)
 	},
 }
 
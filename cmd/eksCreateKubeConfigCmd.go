@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"log"
 	"net/url"
 
 	"github.com/spf13/cobra"
@@ -22,29 +21,37 @@ var eksCreateKubeConfigCmd = &cobra.Command{
 	    yawsi eks create-kube-config --cluster-name k8s-cluster-non-production --project projectA --environment qa
 	
 	`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		if len(clusterName) == 0 {
-			log.Fatal("Please specify cluster name")
+			return newUsageError("Please specify cluster name")
 		}
-		clusterData := DescribeEKSCluster(&clusterName)
-		if clusterData == nil {
-			log.Fatal("Couldn't find cluster data")
+		clusterData, err := DescribeEKSCluster(getClients().EKS(), &clusterName)
+		if err != nil {
+			return err
 		}
 
-		err := WriteKubeConfigToFile(clusterData, projectName, projectEnvironment)
+		err = WriteKubeConfigToFile(getClients().IAM(), clusterData, projectName, projectEnvironment)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
 		if showHostsFileEntry {
 			fmt.Printf("\n\n--------------------------/etc/hosts/ file entry ---------------------\n\n")
 			u, err := url.Parse(*clusterData.Cluster.Endpoint)
 			if err != nil {
-				log.Fatal(err)
+				return err
+			}
+			masterIP, err := GetPrivateMasterIP(getClients().EC2(), &clusterName)
+			if err != nil {
+				return err
+			}
+			if masterIP == nil {
+				return newNotFoundError("Couldn't find the private IP address of the cluster endpoint")
 			}
-			fmt.Printf("%s %s\n\n", *GetPrivateMasterIP(&clusterName), u.Hostname())
+			fmt.Printf("%s %s\n\n", *masterIP, u.Hostname())
 		}
+		return nil
 	},
 	Args: cobra.NoArgs,
 }
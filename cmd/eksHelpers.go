@@ -4,93 +4,54 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
 
 	"gopkg.in/yaml.v2"
 )
 
-func describeEKSCluster(input *eks.DescribeClusterInput) *eks.DescribeClusterOutput {
-	sess := createSession()
-	svc := eks.New(sess)
+func describeEKSCluster(svc eksiface.EKSAPI, input *eks.DescribeClusterInput) (*eks.DescribeClusterOutput, error) {
 
 	result, err := svc.DescribeCluster(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case eks.ErrCodeResourceNotFoundException:
-				fmt.Println(eks.ErrCodeResourceNotFoundException, aerr.Error())
-			case eks.ErrCodeClientException:
-				fmt.Println(eks.ErrCodeClientException, aerr.Error())
-			case eks.ErrCodeServerException:
-				fmt.Println(eks.ErrCodeServerException, aerr.Error())
-			case eks.ErrCodeServiceUnavailableException:
-				fmt.Println(eks.ErrCodeServiceUnavailableException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
-		return nil
+		return nil, fmt.Errorf("Couldn't describe EKS cluster %s: %w", *input.Name, err)
 	}
-	return result
+	return result, nil
 }
 
-func DescribeEKSCluster(clusterName *string) *eks.DescribeClusterOutput {
+func DescribeEKSCluster(svc eksiface.EKSAPI, clusterName *string) (*eks.DescribeClusterOutput, error) {
 
 	input := &eks.DescribeClusterInput{
 		Name: clusterName,
 	}
-	return describeEKSCluster(input)
+	return describeEKSCluster(svc, input)
 }
 
-func listEKSCluster(input *eks.ListClustersInput) *eks.ListClustersOutput {
-	sess := createSession()
-	svc := eks.New(sess)
+func listEKSCluster(svc eksiface.EKSAPI, input *eks.ListClustersInput) (*eks.ListClustersOutput, error) {
 
 	result, err := svc.ListClusters(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case eks.ErrCodeResourceNotFoundException:
-				fmt.Println(eks.ErrCodeResourceNotFoundException, aerr.Error())
-			case eks.ErrCodeClientException:
-				fmt.Println(eks.ErrCodeClientException, aerr.Error())
-			case eks.ErrCodeServerException:
-				fmt.Println(eks.ErrCodeServerException, aerr.Error())
-			case eks.ErrCodeServiceUnavailableException:
-				fmt.Println(eks.ErrCodeServiceUnavailableException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
-		return nil
+		return nil, fmt.Errorf("Couldn't list EKS clusters: %w", err)
 	}
-	return result
+	return result, nil
 }
 
-func ListEKSClusters(details bool) *eks.ListClustersOutput {
+func ListEKSClusters(svc eksiface.EKSAPI, details bool) (*eks.ListClustersOutput, error) {
 
 	input := &eks.ListClustersInput{}
-	return listEKSCluster(input)
+	return listEKSCluster(svc, input)
 }
 
 // Idea from http://www.studytrails.com/devops/kubernetes/local-dns-resolution-for-eks-with-private-endpoint/
-func GetPrivateMasterIP(clusterName *string) *string {
+func GetPrivateMasterIP(svc ec2iface.EC2API, clusterName *string) (*string, error) {
 	description := ec2.Filter{
 		Name:   aws.String("description"),
 		Values: []*string{aws.String("Amazon EKS " + *clusterName)},
@@ -101,11 +62,14 @@ func GetPrivateMasterIP(clusterName *string) *string {
 			&description,
 		},
 	}
-	result := GetNetworkInterfaces(input)
-	if result != nil {
-		return result.NetworkInterfaces[0].PrivateIpAddress
+	result, err := GetNetworkInterfaces(svc, input)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.NetworkInterfaces) != 0 {
+		return result.NetworkInterfaces[0].PrivateIpAddress, nil
 	}
-	return nil
+	return nil, nil
 }
 
 type KubeConfigType struct {
@@ -123,8 +87,8 @@ type ClusterConfig struct {
 }
 
 type ClusterContext struct {
-	Context map[string]string `yaml:"context"`
-	Name    string            `yaml:"name"`
+	Context map[string]string `yaml:"context" json:"context"`
+	Name    string            `yaml:"name" json:"name"`
 }
 
 type ClusterUser struct {
@@ -147,7 +111,15 @@ type kubeConfigData struct {
 	UserName                        string
 }
 
+// kubeConfigFilePath overrides ~/.kube/config, tests point it at a
+// temporary directory
+var kubeConfigFilePath string
+
 func KubeConfigPath() (string, error) {
+	if len(kubeConfigFilePath) != 0 {
+		return kubeConfigFilePath, nil
+	}
+
 	homeDir := GetUserHomeDir()
 	if len(homeDir) == 0 {
 		return "", errors.New("Couldn't find user's home directory")
@@ -297,7 +269,7 @@ func WriteKubeConfig(kd kubeConfigData) error {
 }
 
 //Write kubeconfig to file
-func WriteKubeConfigToFile(clusterData *eks.DescribeClusterOutput, projectName string, environment string) error {
+func WriteKubeConfigToFile(svc iamiface.IAMAPI, clusterData *eks.DescribeClusterOutput, projectName string, environment string) error {
 
 	d := kubeConfigData{}
 	d.ClusterName = clusterData.Cluster.Name
@@ -310,7 +282,10 @@ func WriteKubeConfigToFile(clusterData *eks.DescribeClusterOutput, projectName s
 			return errors.New("Must specify environment")
 		}
 
-		iamRoleArn := GetIAMRoleArnToAssume(projectName, environment)
+		iamRoleArn, err := GetIAMRoleArnToAssume(svc, projectName, environment)
+		if err != nil {
+			return err
+		}
 		if iamRoleArn == nil {
 			return fmt.Errorf("Unable to get IAM role: %s-%s-humans", projectName, environment)
 		}
@@ -335,10 +310,10 @@ func WriteKubeConfigToFile(clusterData *eks.DescribeClusterOutput, projectName s
 	return nil
 }
 
-func GetKubeConfigContexts() []ClusterContext {
+func GetKubeConfigContexts() ([]ClusterContext, error) {
 	kd, err := GetKubeConfigData()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	if len(kd) != 0 {
@@ -346,14 +321,14 @@ func GetKubeConfigContexts() []ClusterContext {
 
 		err = yaml.Unmarshal(kd, &kConfig)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
-		return kConfig.Contexts
+		return kConfig.Contexts, nil
 
 	}
 
-	return nil
+	return nil, nil
 }
 
 func SetKubeConfigCurrentContext(contextName string) error {
@@ -388,9 +363,6 @@ func SetKubeConfigCurrentContext(contextName string) error {
 		err = ioutil.WriteFile(kubeConfig, yamlData, 0644)
 		return err
 
-	} else {
-		return errors.New("Empty kube config file")
 	}
-
-	return nil
+	return errors.New("Empty kube config file")
 }
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func newKubeConfigFixture() (*fakeClients, *eks.DescribeClusterOutput) {
+	c := newFakeClients()
+	c.iam.Roles = []*iam.Role{
+		{
+			RoleName: aws.String("web-staging-humans"),
+			Arn:      aws.String("arn:aws:iam::123456789012:role/web-staging-humans"),
+			Tags: []*iam.Tag{
+				{Key: aws.String("Project"), Value: aws.String("web")},
+				{Key: aws.String("Environment"), Value: aws.String("staging")},
+				{Key: aws.String("Role"), Value: aws.String("EKSUserAuth")},
+			},
+		},
+	}
+	clusterData := &eks.DescribeClusterOutput{
+		Cluster: &eks.Cluster{
+			Name:                 aws.String("main"),
+			Endpoint:             aws.String("https://main.eks.example.com"),
+			CertificateAuthority: &eks.Certificate{Data: aws.String("Y2VydA==")},
+		},
+	}
+	return c, clusterData
+}
+
+func withKubeConfig(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "yawsi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubeConfigFilePath = filepath.Join(dir, "config")
+	return kubeConfigFilePath, func() {
+		kubeConfigFilePath = ""
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGetIAMRoleArnToAssume(t *testing.T) {
+	c, _ := newKubeConfigFixture()
+
+	arn, err := GetIAMRoleArnToAssume(c.IAM(), "web", "staging")
+	if assert.NoError(t, err) && assert.NotNil(t, arn) {
+		assert.Equal(t, "arn:aws:iam::123456789012:role/web-staging-humans", *arn)
+	}
+	arn, _ = GetIAMRoleArnToAssume(c.IAM(), "web", "production")
+	assert.Nil(t, arn)
+
+	// The role must be tagged with the expected project
+	c.iam.Roles[0].Tags[0].Value = aws.String("api")
+	arn, _ = GetIAMRoleArnToAssume(c.IAM(), "web", "staging")
+	assert.Nil(t, arn)
+}
+
+func TestWriteKubeConfigToFile(t *testing.T) {
+	c, clusterData := newKubeConfigFixture()
+	kubeConfig, cleanup := withKubeConfig(t)
+	defer cleanup()
+
+	assert.NoError(t, WriteKubeConfigToFile(c.IAM(), clusterData, "web", "staging"))
+	assert.NoError(t, WriteKubeConfigToFile(c.IAM(), clusterData, "", ""))
+
+	data, err := ioutil.ReadFile(kubeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kConfig := KubeConfigType{}
+	if err := yaml.Unmarshal(data, &kConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	if assert.Len(t, kConfig.Clusters, 1) {
+		assert.Equal(t, "main", kConfig.Clusters[0].Name)
+		assert.Equal(t, "https://main.eks.example.com", kConfig.Clusters[0].Cluster["server"])
+	}
+	var contexts []string
+	for _, ctx := range kConfig.Contexts {
+		contexts = append(contexts, ctx.Name)
+	}
+	assert.ElementsMatch(t, []string{"web-staging", "main-admin"}, contexts)
+
+	assert.Error(t, WriteKubeConfigToFile(c.IAM(), clusterData, "web", ""))
+	assert.Error(t, WriteKubeConfigToFile(c.IAM(), clusterData, "web", "production"))
+}
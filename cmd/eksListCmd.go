@@ -1,20 +1,90 @@
 package cmd
 
 import (
-	"fmt"
-
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
 	"github.com/spf13/cobra"
 )
 
+type eksClusterOutput struct {
+	resourceLocation `yaml:",inline"`
+	Name             string `json:"name" yaml:"name"`
+	Status           string `json:"status,omitempty" yaml:"status,omitempty"`
+	Version          string `json:"version,omitempty" yaml:"version,omitempty"`
+	Endpoint         string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+}
+
+func buildEKSClusterList(svc eksiface.EKSAPI, loc resourceLocation, clusterNames []*string, details bool) ([]eksClusterOutput, *tableOutput, error) {
+	items := []eksClusterOutput{}
+	var table *tableOutput
+	if details {
+		table = newTableOutput("Name", "Status", "Version", "Endpoint").in(loc)
+	} else {
+		table = newTableOutput("Name").in(loc)
+	}
+
+	for _, name := range clusterNames {
+		item := eksClusterOutput{resourceLocation: loc, Name: *name}
+		if details {
+			clusterData, err := DescribeEKSCluster(svc, name)
+			if err != nil {
+				return nil, nil, err
+			}
+			item.Status = aws.StringValue(clusterData.Cluster.Status)
+			item.Version = aws.StringValue(clusterData.Cluster.Version)
+			item.Endpoint = aws.StringValue(clusterData.Cluster.Endpoint)
+			table.addRow(item.Name, item.Status, item.Version, item.Endpoint)
+		} else {
+			table.addRow(item.Name)
+		}
+		items = append(items, item)
+	}
+	return items, table, nil
+}
+
+func displayEKSClusters(svc eksiface.EKSAPI, clusterNames []*string, details bool) error {
+	items, table, err := buildEKSClusterList(svc, resourceLocation{}, clusterNames, details)
+	if err != nil {
+		return err
+	}
+	return renderItems("ClusterList", items, table)
+}
+
+func listAllEKSClusters(details bool) error {
+	return renderAll("ClusterList", func(loc resourceLocation, c awsClientProvider) (interface{}, *tableOutput, error) {
+		svc := c.EKS()
+		var clusterNames []*string
+		input := &eks.ListClustersInput{}
+		for {
+			result, err := svc.ListClusters(input)
+			if err != nil {
+				return nil, nil, err
+			}
+			clusterNames = append(clusterNames, result.Clusters...)
+			if result.NextToken == nil {
+				break
+			}
+			input.NextToken = result.NextToken
+		}
+		return buildEKSClusterList(svc, loc, clusterNames, details)
+	})
+}
+
 var eksListCmd = &cobra.Command{
 	Use:   "list-clusters",
 	Short: "List EKS clusters",
 	Long:  "List the current AWS EKS clusters",
-	Run: func(cmd *cobra.Command, args []string) {
-
-		result := ListEKSClusters(details)
-		fmt.Printf("%#v\n", result)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if isMultiTarget() {
+			return listAllEKSClusters(details)
+		}
 
+		result, err := ListEKSClusters(getClients().EKS(), details)
+		if err != nil {
+			return err
+		}
+		return displayEKSClusters(getClients().EKS(), result.Clusters, details)
 	},
 	Args: cobra.NoArgs,
 }
@@ -24,4 +94,5 @@ var details bool
 func init() {
 	eksCmd.AddCommand(eksListCmd)
 	eksListCmd.Flags().BoolVarP(&details, "details", "", false, "Show cluster details")
+	addListingFlags(eksListCmd)
 }
@@ -1,8 +1,6 @@
 package cmd
 
 import (
-	"fmt"
-
 	"github.com/spf13/cobra"
 )
 
@@ -10,13 +8,20 @@ var eksListContextsCmd = &cobra.Command{
 	Use:   "list-kube-contexts",
 	Short: "List Kubernetes contexts",
 	Long:  "Lists the currently available kubernetes contexts",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
-		contexts := GetKubeConfigContexts()
+		contexts, err := GetKubeConfigContexts()
+		if err != nil {
+			return err
+		}
+		if contexts == nil {
+			contexts = []ClusterContext{}
+		}
+		table := newTableOutput("Name", "Cluster", "User", "Namespace")
 		for _, c := range contexts {
-			fmt.Printf("%s\n", c.Name)
+			table.addRow(c.Name, c.Context["cluster"], c.Context["user"], c.Context["namespace"])
 		}
-
+		return renderItems("KubeContextList", contexts, table)
 	},
 	Args: cobra.NoArgs,
 }
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"log"
 
 	"github.com/spf13/cobra"
 
@@ -13,11 +12,14 @@ var eksWorkonCmd = &cobra.Command{
 	Use:   "workon",
 	Short: "Set current kubernetes context",
 	Long:  "Set current kubernetes context",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		var kubeContextName string
 		if len(args) != 1 {
-			contexts := GetKubeConfigContexts()
+			contexts, err := GetKubeConfigContexts()
+			if err != nil {
+				return err
+			}
 			idx, err := fuzzyfinder.Find(
 				contexts,
 				func(i int) string {
@@ -25,7 +27,7 @@ var eksWorkonCmd = &cobra.Command{
 				},
 			)
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 			kubeContextName = contexts[idx].Name
 		} else {
@@ -34,10 +36,10 @@ var eksWorkonCmd = &cobra.Command{
 
 		err := SetKubeConfigCurrentContext(kubeContextName)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		fmt.Printf("Context set to %s\n", kubeContextName)
-
+		return nil
 	},
 }
 
@@ -2,30 +2,37 @@ package cmd
 
 import (
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudtrail"
 	"github.com/spf13/cobra"
 )
 
+type cloudTrailEventOutput struct {
+	EventId   string     `json:"eventId" yaml:"eventId"`
+	EventName string     `json:"eventName" yaml:"eventName"`
+	EventTime *time.Time `json:"eventTime" yaml:"eventTime"`
+	Username  string     `json:"username" yaml:"username"`
+	Event     string     `json:"cloudTrailEvent,omitempty" yaml:"cloudTrailEvent,omitempty"`
+}
+
 var eksWhoisCmd = &cobra.Command{
 	Use:   "whois",
 	Short: "Find out the AWS username who performed an operation on EKS cluster",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		eksUserIdParts := strings.Split(eksUserId, ":")
 		if len(eksUserIdParts) != 3 {
-			log.Fatal("Invalid EKS audit username.")
+			return newUsageError("Invalid EKS audit user id. Expected heptio-authenticator-aws:<AWS-ACCOUNT-ID>:<string>")
 		}
 		eksUsernameParts := strings.Split(eksUsername, "-")
-		if len(eksUsernameParts) != 3 && eksUserIdParts[0] != "heptio-authenticator-aws" {
-			log.Fatal("Invalid EKS audit user id. Expected heptio-authenticator-aws:<AWS-ACCOUNT-ID>:<string>")
+		if len(eksUsernameParts) != 3 {
+			return newUsageError("Invalid EKS audit username.")
 		}
 		assumedRoleResourceName := eksUserIdParts[2] + ":" + eksUsernameParts[2]
 
-		mySession := createSession()
-		client := cloudtrail.New(mySession)
+		client := getClients().CloudTrail()
 
 		attributeKey := "EventName"
 		attributeValue := "AssumeRole"
@@ -46,21 +53,33 @@ var eksWhoisCmd = &cobra.Command{
 			EndTime:          &timeTo,
 		}
 
+		events := []cloudTrailEventOutput{}
 		err := client.LookupEventsPages(&eventsInput,
 			func(page *cloudtrail.LookupEventsOutput, lastPage bool) bool {
 				for _, e := range page.Events {
 					for _, r := range e.Resources {
 						if *r.ResourceType == "AWS::STS::AssumedRole" && *r.ResourceName == assumedRoleResourceName {
-							fmt.Println(e)
+							events = append(events, cloudTrailEventOutput{
+								EventId:   aws.StringValue(e.EventId),
+								EventName: aws.StringValue(e.EventName),
+								EventTime: e.EventTime,
+								Username:  aws.StringValue(e.Username),
+								Event:     aws.StringValue(e.CloudTrailEvent),
+							})
 						}
 					}
 				}
 				return !lastPage
 			})
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("Couldn't look up CloudTrail events: %w", err)
 		}
 
+		table := newTableOutput("EventTime", "EventName", "Username", "EventID")
+		for _, e := range events {
+			table.addRow(e.EventTime.String(), e.EventName, e.Username, e.EventId)
+		}
+		return renderItems("CloudTrailEventList", events, table)
 	},
 }
 
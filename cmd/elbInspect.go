@@ -0,0 +1,542 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/spf13/cobra"
+)
+
+// loadBalancerEphemeralPorts are the source ports of the connections from the
+// load balancer nodes, which the network ACLs must allow the return traffic to
+var loadBalancerEphemeralPorts = ec2.PortRange{From: aws.Int64(1024), To: aws.Int64(65535)}
+
+// targetReachability is the result of the checks from all the nodes of the
+// load balancer to a target, for the traffic or the health checks
+type targetReachability struct {
+	TargetGroup string         `json:"targetGroup" yaml:"targetGroup"`
+	Target      string         `json:"target" yaml:"target"`
+	Port        int64          `json:"port" yaml:"port"`
+	Protocol    string         `json:"protocol" yaml:"protocol"`
+	Purpose     string         `json:"purpose" yaml:"purpose"`
+	Health      string         `json:"health,omitempty" yaml:"health,omitempty"`
+	Reachable   bool           `json:"reachable" yaml:"reachable"`
+	BlockedBy   []string       `json:"blockedBy,omitempty" yaml:"blockedBy,omitempty"`
+	Checks      []*checkResult `json:"checks" yaml:"checks"`
+}
+
+func getLoadBalancer(svc elbv2iface.ELBV2API, name string) (*elbv2.LoadBalancer, error) {
+	output, err := svc.DescribeLoadBalancers(&elbv2.DescribeLoadBalancersInput{Names: []*string{aws.String(name)}})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe load balancer %s: %w", name, err)
+	}
+	if len(output.LoadBalancers) != 1 {
+		return nil, newNotFoundError("Load balancer %s not found", name)
+	}
+	return output.LoadBalancers[0], nil
+}
+
+// loadBalancerNodeStates returns a state for each node of the load balancer.
+// The network interfaces of the nodes are described as "ELB app/<name>/<id>"
+// (or net/ for a network load balancer). When there aren't any, e.g. the
+// load balancer is being provisioned, a state for each of its subnets is
+// returned instead.
+func loadBalancerNodeStates(svc ec2iface.EC2API, lb *elbv2.LoadBalancer) ([]*instanceState, error) {
+	var states []*instanceState
+	if parts := strings.SplitN(*lb.LoadBalancerArn, ":loadbalancer/", 2); len(parts) == 2 {
+		input := &ec2.DescribeNetworkInterfacesInput{
+			Filters: []*ec2.Filter{{Name: aws.String("description"), Values: []*string{aws.String("ELB " + parts[1])}}},
+		}
+		var output *ec2.DescribeNetworkInterfacesOutput
+		err := retryThrottled(func() (err error) {
+			output, err = svc.DescribeNetworkInterfaces(input)
+			return
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't describe the network interfaces of load balancer %s: %w", *lb.LoadBalancerName, err)
+		}
+		for _, ni := range output.NetworkInterfaces {
+			state := networkInterfaceState(ni)
+			state.Name = *lb.LoadBalancerName
+			states = append(states, state)
+		}
+	}
+	if len(states) != 0 {
+		sort.Slice(states, func(i, j int) bool {
+			return states[i].SubnetIds[0] < states[j].SubnetIds[0]
+		})
+		return states, nil
+	}
+
+	var securityGroups []*ec2.GroupIdentifier
+	for _, groupID := range lb.SecurityGroups {
+		securityGroups = append(securityGroups, &ec2.GroupIdentifier{GroupId: groupID})
+	}
+	for _, az := range lb.AvailabilityZones {
+		states = append(states, &instanceState{
+			InstanceId:     *lb.LoadBalancerArn,
+			Name:           *lb.LoadBalancerName,
+			VpcID:          aws.StringValue(lb.VpcId),
+			SubnetIds:      []string{*az.SubnetId},
+			SecurityGroups: securityGroups,
+		})
+	}
+	return states, nil
+}
+
+// nodeNetworks returns the addresses of a load balancer node, or the CIDR of
+// its subnet when we don't know them
+func nodeNetworks(node *instanceState) []*net.IPNet {
+	if len(node.PrivateIPAddresses) != 0 {
+		return addressNetworks(node.PrivateIPAddresses)
+	}
+	if network, err := parseNetwork(node.SubnetCIDRs[node.SubnetIds[0]]); err == nil {
+		return []*net.IPNet{network}
+	}
+	return nil
+}
+
+// allowAllEgressRules stand in for the security groups of a network load
+// balancer, which doesn't have any
+func allowAllEgressRules() []*SecurityGroupRule {
+	return []*SecurityGroupRule{{
+		egress: true,
+		permission: &ec2.IpPermission{
+			IpProtocol: aws.String("-1"),
+			IpRanges:   []*ec2.IpRange{{CidrIp: aws.String("0.0.0.0/0")}},
+		},
+	}}
+}
+
+// getTargetGroups returns the target groups of the load balancer
+func getTargetGroups(svc elbv2iface.ELBV2API, lb *elbv2.LoadBalancer) ([]*elbv2.TargetGroup, error) {
+	var targetGroups []*elbv2.TargetGroup
+	err := retryThrottled(func() error {
+		targetGroups = nil
+		return svc.DescribeTargetGroupsPages(&elbv2.DescribeTargetGroupsInput{LoadBalancerArn: lb.LoadBalancerArn},
+			func(output *elbv2.DescribeTargetGroupsOutput, lastPage bool) bool {
+				targetGroups = append(targetGroups, output.TargetGroups...)
+				return true
+			})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe the target groups of load balancer %s: %w", *lb.LoadBalancerName, err)
+	}
+	return targetGroups, nil
+}
+
+// getTargetHealth returns the registered targets of each target group, keyed
+// by the target group ARN
+func getTargetHealth(svc elbv2iface.ELBV2API, targetGroups []*elbv2.TargetGroup) (map[string][]*elbv2.TargetHealthDescription, error) {
+	var mu sync.Mutex
+	targets := make(map[string][]*elbv2.TargetHealthDescription)
+	errs := make([]error, len(targetGroups))
+	runWorkers(len(targetGroups), func(i int) {
+		tg := targetGroups[i]
+		var output *elbv2.DescribeTargetHealthOutput
+		errs[i] = retryThrottled(func() (err error) {
+			output, err = svc.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{TargetGroupArn: tg.TargetGroupArn})
+			return
+		})
+		if errs[i] != nil {
+			errs[i] = fmt.Errorf("Couldn't describe the health of target group %s: %w", *tg.TargetGroupName, errs[i])
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		targets[*tg.TargetGroupArn] = output.TargetHealthDescriptions
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+// getTargetStates returns the states of the instance targets keyed by the
+// instance ID and of the IP address targets in the VPC keyed by the IP
+// address. Targets which can't be found, e.g. an IP address outside of the
+// VPC, are left out.
+func getTargetStates(svc ec2iface.EC2API, lb *elbv2.LoadBalancer, targetGroups []*elbv2.TargetGroup, targets map[string][]*elbv2.TargetHealthDescription) (map[string]*instanceState, error) {
+	var instanceIDs, ips []string
+	for _, tg := range targetGroups {
+		for _, target := range targets[*tg.TargetGroupArn] {
+			switch aws.StringValue(tg.TargetType) {
+			case elbv2.TargetTypeEnumInstance:
+				instanceIDs = append(instanceIDs, *target.Target.Id)
+			case elbv2.TargetTypeEnumIp:
+				ips = append(ips, *target.Target.Id)
+			}
+		}
+	}
+
+	states := make(map[string]*instanceState)
+	if len(instanceIDs) != 0 {
+		// A filter rather than the IDs, so that a terminated instance which is
+		// still registered doesn't fail the whole lookup
+		instances, err := getEC2InstanceData(svc, []*ec2.Filter{{Name: aws.String("instance-id"), Values: aws.StringSlice(instanceIDs)}})
+		if err != nil {
+			return nil, err
+		}
+		for _, state := range instances {
+			states[state.InstanceId] = state
+		}
+	}
+
+	var mu sync.Mutex
+	err := describeInBatches(ips, func(batch []string) error {
+		output, err := svc.DescribeNetworkInterfaces(&ec2.DescribeNetworkInterfacesInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("vpc-id"), Values: []*string{lb.VpcId}},
+				{Name: aws.String("addresses.private-ip-address"), Values: aws.StringSlice(batch)},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, ni := range output.NetworkInterfaces {
+			state := networkInterfaceState(ni)
+			for _, ip := range state.PrivateIPAddresses {
+				states[ip] = state
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe the network interfaces of the targets: %w", err)
+	}
+	return states, nil
+}
+
+// targetPorts returns the traffic and health check ports of a target
+func targetPorts(tg *elbv2.TargetGroup, target *elbv2.TargetHealthDescription) (int64, int64) {
+	trafficPort := aws.Int64Value(tg.Port)
+	if target.Target.Port != nil {
+		trafficPort = *target.Target.Port
+	}
+	healthCheckPort := trafficPort
+	port := aws.StringValue(target.HealthCheckPort)
+	if len(port) == 0 {
+		port = aws.StringValue(tg.HealthCheckPort)
+	}
+	if p, err := strconv.ParseInt(port, 10, 64); err == nil {
+		healthCheckPort = p
+	}
+	return trafficPort, healthCheckPort
+}
+
+// targetProtocol is the IP protocol of the traffic to a target group, the
+// health checks always use TCP
+func targetProtocol(tg *elbv2.TargetGroup) string {
+	if aws.StringValue(tg.Protocol) == elbv2.ProtocolEnumUdp {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// blockingRule describes a failed check. The display text of a network ACL
+// check already has the entry which denies the traffic, for a security group
+// check none of the rules of its groups allow it.
+func blockingRule(r *checkResult) string {
+	if r.Stage != stageSecurityGroupEgress && r.Stage != stageSecurityGroupIngress {
+		return r.DisplayText
+	}
+	if ids, ok := r.Metadata["SecurityGroupIds"].([]string); ok && len(ids) != 0 {
+		return fmt.Sprintf("%s: no rule of %s allows it", r.DisplayText, strings.Join(ids, ", "))
+	}
+	return r.DisplayText + ": no rule allows it"
+}
+
+// hasInstanceTargets returns whether any of the target groups registers
+// instances
+func hasInstanceTargets(targetGroups []*elbv2.TargetGroup) bool {
+	for _, tg := range targetGroups {
+		if aws.StringValue(tg.TargetType) == elbv2.TargetTypeEnumInstance {
+			return true
+		}
+	}
+	return false
+}
+
+// loadBalancerClientNetwork returns the clients of a network load balancer:
+// clientCIDR when it is specified, otherwise anywhere for an internet-facing
+// load balancer and its VPC for an internal one
+func loadBalancerClientNetwork(svc ec2iface.EC2API, lb *elbv2.LoadBalancer, clientCIDR string) (*net.IPNet, error) {
+	if len(clientCIDR) != 0 {
+		network, err := parseNetwork(clientCIDR)
+		if err != nil {
+			return nil, newUsageError("Invalid client CIDR block: %s", clientCIDR)
+		}
+		return network, nil
+	}
+	if aws.StringValue(lb.Scheme) == elbv2.LoadBalancerSchemeEnumInternetFacing {
+		return parseNetwork("0.0.0.0/0")
+	}
+	var output *ec2.DescribeVpcsOutput
+	err := retryThrottled(func() (err error) {
+		output, err = svc.DescribeVpcs(&ec2.DescribeVpcsInput{VpcIds: []*string{lb.VpcId}})
+		return
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe VPC %s: %w", aws.StringValue(lb.VpcId), err)
+	}
+	if len(output.Vpcs) == 0 {
+		return nil, newNotFoundError("VPC %s not found", aws.StringValue(lb.VpcId))
+	}
+	return parseNetwork(aws.StringValue(output.Vpcs[0].CidrBlock))
+}
+
+// checkPreservedClientIP runs the checks for traffic from a node of the load
+// balancer to the target, which arrives from the client's IP address: the
+// node's security groups, network ACLs and routes, then the target's network
+// ACLs and security groups for the clients. The replies go back via the
+// load balancer, so the target's route to the clients isn't checked.
+func checkPreservedClientIP(node *instanceState, clientNetwork *net.IPNet, dest *connectivityDestination, protocol string, ports *ec2.PortRange) []*checkResult {
+	var result []*checkResult
+	for _, network := range dest.resolveNetworks() {
+		target := dest.state.forAddress(network)
+		result = append(result, checkSecurityGroupEgressToNetwork(node, network, securityGroupsFor(target, network), protocol, ports)...)
+		result = append(result, checkNACLToNetwork(node, network, protocol, ports, &loadBalancerEphemeralPorts)...)
+		result = append(result, checkRouteToNetwork(node, target, network)...)
+		result = append(result, checkNACLFromNetwork(target, network, clientNetwork, protocol, ports, &loadBalancerEphemeralPorts)...)
+		result = append(result, checkSecurityGroupIngressFromNetwork(target, clientNetwork, nil, protocol, ports)...)
+	}
+	return result
+}
+
+// checkTargetReachability runs the checks from each node of the load
+// balancer to the target. The results are labelled with the node's subnet.
+// clientNetwork is the clients when the target sees their IP addresses
+// rather than the node's, nil otherwise.
+func checkTargetReachability(nodes []*instanceState, dest *connectivityDestination, item *targetReachability, clientNetwork *net.IPNet) {
+	ports := ec2.PortRange{From: aws.Int64(item.Port), To: aws.Int64(item.Port)}
+	for _, node := range nodes {
+		subnetID := node.SubnetIds[0]
+		var results []*checkResult
+		if clientNetwork != nil && dest.state != nil {
+			results = checkPreservedClientIP(node, clientNetwork, dest, item.Protocol, &ports)
+		} else {
+			results = checkConnectivityToDestination(node, nodeNetworks(node), dest, item.Protocol, &ports, &loadBalancerEphemeralPorts)
+		}
+		for _, r := range results {
+			r.DisplayText = subnetID + ": " + r.DisplayText
+			r.Metadata["SourceSubnet"] = subnetID
+			item.Checks = append(item.Checks, r)
+			if !r.Result {
+				item.BlockedBy = append(item.BlockedBy, blockingRule(r))
+			}
+		}
+	}
+	item.Reachable = len(item.Checks) != 0 && len(item.BlockedBy) == 0
+}
+
+// inspectLoadBalancer checks whether the nodes of the load balancer can
+// reach each registered target on its traffic and health check ports.
+// clientCIDR is the clients of a network load balancer, see
+// loadBalancerClientNetwork.
+func inspectLoadBalancer(svc ec2iface.EC2API, elbSvc elbv2iface.ELBV2API, name string, clientCIDR string) ([]*targetReachability, error) {
+	lb, err := getLoadBalancer(elbSvc, name)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*instanceState
+	var targetGroups []*elbv2.TargetGroup
+	err = runConcurrently(
+		func() (err error) {
+			nodes, err = loadBalancerNodeStates(svc, lb)
+			return
+		},
+		func() (err error) {
+			targetGroups, err = getTargetGroups(elbSvc, lb)
+			return
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	// Network load balancers preserve the IP addresses of the clients for
+	// instance targets
+	var clientNetwork *net.IPNet
+	if aws.StringValue(lb.Type) == elbv2.LoadBalancerTypeEnumNetwork && hasInstanceTargets(targetGroups) {
+		clientNetwork, err = loadBalancerClientNetwork(svc, lb, clientCIDR)
+		if err != nil {
+			return nil, err
+		}
+	}
+	targets, err := getTargetHealth(elbSvc, targetGroups)
+	if err != nil {
+		return nil, err
+	}
+	targetStates, err := getTargetStates(svc, lb, targetGroups, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	states := append([]*instanceState{}, nodes...)
+	for _, state := range targetStates {
+		states = append(states, state)
+	}
+	if err := enrichInstanceStates(svc, states...); err != nil {
+		return nil, err
+	}
+	if len(lb.SecurityGroups) == 0 {
+		for _, node := range nodes {
+			node.SecurityGroupRules = allowAllEgressRules()
+		}
+	}
+
+	items := []*targetReachability{}
+	for _, tg := range targetGroups {
+		// Lambda targets are invoked by the load balancer, not via the VPC
+		if aws.StringValue(tg.TargetType) == elbv2.TargetTypeEnumLambda {
+			continue
+		}
+		for _, target := range targets[*tg.TargetGroupArn] {
+			// The traffic goes to the registered IP address or to the primary
+			// private IP address of an instance
+			dest := &connectivityDestination{state: targetStates[*target.Target.Id]}
+			if network, err := parseNetwork(*target.Target.Id); err == nil {
+				dest.networks = []*net.IPNet{network}
+			} else if dest.state != nil && len(dest.state.PrivateIPAddresses) != 0 {
+				dest.networks = addressNetworks(dest.state.PrivateIPAddresses[:1])
+			}
+
+			var healthState string
+			if target.TargetHealth != nil {
+				healthState = aws.StringValue(target.TargetHealth.State)
+				if target.TargetHealth.Reason != nil {
+					healthState += " (" + *target.TargetHealth.Reason + ")"
+				}
+			}
+
+			// The health checks always come from the nodes
+			var trafficClients *net.IPNet
+			if aws.StringValue(tg.TargetType) == elbv2.TargetTypeEnumInstance {
+				trafficClients = clientNetwork
+			}
+			trafficPort, healthCheckPort := targetPorts(tg, target)
+			health := &targetReachability{Port: healthCheckPort, Protocol: "tcp", Purpose: "health-check"}
+			traffic := &targetReachability{Port: trafficPort, Protocol: targetProtocol(tg), Purpose: "traffic"}
+			purposes := []*targetReachability{health, traffic}
+			if trafficPort == healthCheckPort && targetProtocol(tg) == "tcp" && trafficClients == nil {
+				health.Purpose = "traffic, health-check"
+				purposes = purposes[:1]
+			}
+			for _, item := range purposes {
+				item.TargetGroup = *tg.TargetGroupName
+				item.Target = *target.Target.Id
+				item.Health = healthState
+				clients := trafficClients
+				if item == health {
+					clients = nil
+				}
+				checkTargetReachability(nodes, dest, item, clients)
+				items = append(items, item)
+			}
+		}
+	}
+	return items, nil
+}
+
+var inspectLoadBalancerCmd = &cobra.Command{
+	Use:   "inspect <load-balancer-name>",
+	Short: "Check whether an application or network load balancer can reach its targets",
+	Long: `Check whether the nodes of an application or network load balancer can reach each registered target
+on its traffic port and its health check port, and whether the target's network ACLs allow the return
+traffic:
+
+	yawsi elb inspect my-alb
+
+For each target group and target, the security groups, network ACLs and routes of each node of the
+load balancer are checked, followed by the network ACLs, route back and security groups of the target.
+The Blocked By column shows the checks which failed and, for a network ACL, the entry which denies the
+traffic. The --verbose flag shows all the checks:
+
+	yawsi elb inspect my-alb --verbose
+
+A network load balancer without security groups allows all the traffic from its nodes, so only their
+network ACLs and routes are checked on the load balancer side. Network load balancers preserve the IP
+addresses of the clients for instance targets, so the traffic to an instance target is checked against
+its network ACLs and security groups from the clients rather than from the nodes: anywhere for an
+internet-facing load balancer and the load balancer's VPC for an internal one, or the CIDR block
+specified via --client-cidr. The health checks come from the nodes:
+
+	yawsi elb inspect my-nlb --client-cidr 203.0.113.0/24
+
+Targets with an IP address outside of the load balancer's VPC
+are only checked from the load balancer side and Lambda targets aren't checked. Classic load balancers
+aren't supported.
+
+The exit code is 1 when a target isn't reachable.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		items, err := inspectLoadBalancer(getClients().EC2(), getClients().ELBV2(), args[0], loadBalancerClientCIDR)
+		if err != nil {
+			return err
+		}
+
+		reachable := true
+		table := newTableOutput("Target Group", "Target", "Port", "Purpose", "Health", "Reachable", "Blocked By")
+		for _, item := range items {
+			reachable = reachable && item.Reachable
+			table.addRow(item.TargetGroup, item.Target, fmt.Sprintf("%d/%s", item.Port, item.Protocol), item.Purpose, item.Health, strconv.FormatBool(item.Reachable), strings.Join(item.BlockedBy, "; "))
+			if isTableOutput() && (verboseOutput || debugOutput) {
+				fmt.Fprintf(outputWriter, "%s %s %d/%s (%s):\n", item.TargetGroup, item.Target, item.Port, item.Protocol, item.Purpose)
+				displayResult(item.Checks...)
+			}
+		}
+
+		if isTableOutput() {
+			err = writeTable(table)
+		} else {
+			err = writeDocument(outputDocument{
+				APIVersion: outputAPIVersion,
+				Kind:       "TargetReachabilityList",
+				Result:     &reachable,
+				Items:      items,
+			})
+		}
+		if err != nil {
+			return err
+		}
+		if !reachable {
+			return errCheckFailed
+		}
+		return nil
+	},
+	Args: cobra.ExactArgs(1),
+}
+
+var loadBalancerClientCIDR string
+
+func init() {
+	elbCmd.AddCommand(inspectLoadBalancerCmd)
+	inspectLoadBalancerCmd.Flags().StringVarP(&loadBalancerClientCIDR, "client-cidr", "", "", "CIDR block of the clients of a network load balancer")
+	inspectLoadBalancerCmd.Flags().BoolVarP(&verboseOutput, "verbose", "v", false, "Display all the checks for each target")
+}
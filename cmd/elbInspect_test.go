@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/stretchr/testify/assert"
+)
+
+// newLoadBalancerFixture adds a load balancer with a node in subnet-a, which
+// forwards to i-dst on 5432 and health checks it on 8080
+func newLoadBalancerFixture() *fakeClients {
+	c := newConnectivityFixture()
+	c.ec2.NetworkInterfaces = append(c.ec2.NetworkInterfaces, &ec2.NetworkInterface{
+		NetworkInterfaceId: aws.String("eni-lb"),
+		Description:        aws.String("ELB app/web/50dc6c495c0c9188"),
+		SubnetId:           aws.String("subnet-a"),
+		VpcId:              aws.String("vpc-1"),
+		PrivateIpAddresses: []*ec2.NetworkInterfacePrivateIpAddress{{PrivateIpAddress: aws.String("10.0.1.50")}},
+		Groups:             []*ec2.GroupIdentifier{{GroupId: aws.String("sg-src")}},
+	})
+
+	lbArn := "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/web/50dc6c495c0c9188"
+	tgArn := "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/web-tg/73e2d6bc24d8a067"
+	c.elbv2.LoadBalancers = []*elbv2.LoadBalancer{
+		{
+			LoadBalancerArn:   aws.String(lbArn),
+			LoadBalancerName:  aws.String("web"),
+			Type:              aws.String(elbv2.LoadBalancerTypeEnumApplication),
+			VpcId:             aws.String("vpc-1"),
+			SecurityGroups:    aws.StringSlice([]string{"sg-src"}),
+			AvailabilityZones: []*elbv2.AvailabilityZone{{SubnetId: aws.String("subnet-a")}},
+		},
+	}
+	c.elbv2.TargetGroups = []*elbv2.TargetGroup{
+		{
+			TargetGroupArn:   aws.String(tgArn),
+			TargetGroupName:  aws.String("web-tg"),
+			LoadBalancerArns: aws.StringSlice([]string{lbArn}),
+			TargetType:       aws.String(elbv2.TargetTypeEnumInstance),
+			Protocol:         aws.String(elbv2.ProtocolEnumHttp),
+			Port:             aws.Int64(5432),
+			HealthCheckPort:  aws.String("8080"),
+		},
+	}
+	c.elbv2.TargetHealth[tgArn] = []*elbv2.TargetHealthDescription{
+		{
+			Target:       &elbv2.TargetDescription{Id: aws.String("i-dst"), Port: aws.Int64(5432)},
+			TargetHealth: &elbv2.TargetHealth{State: aws.String("unhealthy"), Reason: aws.String("Target.Timeout")},
+		},
+	}
+	return c
+}
+
+func TestInspectLoadBalancer(t *testing.T) {
+	c := newLoadBalancerFixture()
+
+	items, err := inspectLoadBalancer(c.EC2(), c.ELBV2(), "web", "")
+	if !assert.NoError(t, err) || !assert.Len(t, items, 2) {
+		t.FailNow()
+	}
+	healthCheck, traffic := items[0], items[1]
+
+	assert.Equal(t, "health-check", healthCheck.Purpose)
+	assert.Equal(t, int64(8080), healthCheck.Port)
+	assert.Equal(t, "unhealthy (Target.Timeout)", healthCheck.Health)
+	assert.False(t, healthCheck.Reachable)
+	assert.Contains(t, healthCheck.BlockedBy, "subnet-a: Ingress ACL at Subnet subnet-b allows traffic from 10.0.1.50/32 (rule * deny 0.0.0.0/0)")
+	assert.Contains(t, healthCheck.BlockedBy, "subnet-a: Security Group at Destination allows Ingress traffic from 10.0.1.50/32: no rule of sg-dst allows it")
+
+	// acl-b only allows the return traffic to 32768-61000
+	assert.Equal(t, "traffic", traffic.Purpose)
+	assert.False(t, traffic.Reachable)
+	assert.Equal(t, []string{"subnet-a: Egress ACL from Subnet subnet-b allows return traffic to 10.0.1.50/32 (rule * deny 0.0.0.0/0)"}, traffic.BlockedBy)
+
+	c.ec2.NetworkAcls[1].Entries[1].PortRange = &ec2.PortRange{From: aws.Int64(1024), To: aws.Int64(65535)}
+	items, err = inspectLoadBalancer(c.EC2(), c.ELBV2(), "web", "")
+	assert.NoError(t, err)
+	assert.True(t, items[1].Reachable)
+
+	_, err = inspectLoadBalancer(c.EC2(), c.ELBV2(), "missing", "")
+	assert.Equal(t, exitNotFound, exitCode(err))
+}
+
+func TestInspectNetworkLoadBalancerIPTarget(t *testing.T) {
+	c := newLoadBalancerFixture()
+	lb := c.elbv2.LoadBalancers[0]
+	lb.Type = aws.String(elbv2.LoadBalancerTypeEnumNetwork)
+	lb.SecurityGroups = nil
+	c.ec2.NetworkInterfaces[2].Groups = nil
+
+	tg := c.elbv2.TargetGroups[0]
+	tg.TargetType = aws.String(elbv2.TargetTypeEnumIp)
+	tg.Protocol = aws.String(elbv2.ProtocolEnumTcp)
+	tg.HealthCheckPort = aws.String("traffic-port")
+	c.elbv2.TargetHealth[*tg.TargetGroupArn] = []*elbv2.TargetHealthDescription{
+		{Target: &elbv2.TargetDescription{Id: aws.String("10.0.2.20"), Port: aws.Int64(5432)}},
+	}
+	// The node's own address rather than sg-src has to be allowed
+	c.ec2.SecurityGroups[1].IpPermissions[0].IpRanges = []*ec2.IpRange{{CidrIp: aws.String("10.0.1.0/24")}}
+	c.ec2.NetworkAcls[1].Entries[1].PortRange = &ec2.PortRange{From: aws.Int64(1024), To: aws.Int64(65535)}
+
+	items, err := inspectLoadBalancer(c.EC2(), c.ELBV2(), "web", "")
+	if !assert.NoError(t, err) || !assert.Len(t, items, 1) {
+		t.FailNow()
+	}
+	assert.Equal(t, "traffic, health-check", items[0].Purpose)
+	assert.Equal(t, "10.0.2.20", items[0].Target)
+	assert.True(t, items[0].Reachable, items[0].BlockedBy)
+}
+
+func TestInspectNetworkLoadBalancerInstanceTarget(t *testing.T) {
+	c := newLoadBalancerFixture()
+	c.ec2.Vpcs = []*ec2.Vpc{{VpcId: aws.String("vpc-1"), CidrBlock: aws.String("10.0.0.0/16")}}
+	lb := c.elbv2.LoadBalancers[0]
+	lb.Type = aws.String(elbv2.LoadBalancerTypeEnumNetwork)
+	lb.Scheme = aws.String(elbv2.LoadBalancerSchemeEnumInternal)
+	lb.SecurityGroups = nil
+	c.ec2.NetworkInterfaces[2].Groups = nil
+
+	tg := c.elbv2.TargetGroups[0]
+	tg.Protocol = aws.String(elbv2.ProtocolEnumTcp)
+	tg.HealthCheckPort = aws.String("traffic-port")
+	c.ec2.SecurityGroups[1].IpPermissions[0].IpRanges = []*ec2.IpRange{{CidrIp: aws.String("10.0.1.0/24")}}
+	c.ec2.NetworkAcls[1].Entries[1].PortRange = &ec2.PortRange{From: aws.Int64(1024), To: aws.Int64(65535)}
+
+	// The health checks come from the node, the traffic from the clients in
+	// the VPC, which the node's subnet doesn't cover
+	items, err := inspectLoadBalancer(c.EC2(), c.ELBV2(), "web", "")
+	if !assert.NoError(t, err) || !assert.Len(t, items, 2) {
+		t.FailNow()
+	}
+	healthCheck, traffic := items[0], items[1]
+	assert.Equal(t, "health-check", healthCheck.Purpose)
+	assert.True(t, healthCheck.Reachable, healthCheck.BlockedBy)
+	assert.Equal(t, "traffic", traffic.Purpose)
+	assert.False(t, traffic.Reachable)
+	assert.Contains(t, traffic.BlockedBy, "subnet-a: Security Group at Destination allows Ingress traffic from 10.0.0.0/16: no rule of sg-dst allows it")
+
+	// Clients in the node's subnet are allowed
+	items, err = inspectLoadBalancer(c.EC2(), c.ELBV2(), "web", "10.0.1.0/24")
+	assert.NoError(t, err)
+	assert.True(t, items[1].Reachable, items[1].BlockedBy)
+
+	_, err = inspectLoadBalancer(c.EC2(), c.ELBV2(), "web", "10.0.1.0/33")
+	assert.Equal(t, exitUsage, exitCode(err))
+}
@@ -0,0 +1,521 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// describeBatchSize is the number of IDs (or filter values) we ask for in a
+// single describe call
+const describeBatchSize = 200
+
+// When the API throttles us we retry, on top of the retries done by the
+// SDK, waiting throttleBackoff and then twice as long before each retry
+var (
+	maxThrottleRetries = 5
+	throttleBackoff    = 500 * time.Millisecond
+)
+
+func isThrottleError(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && request.IsErrorThrottle(aerr)
+}
+
+// retryThrottled calls f until it returns an error other than throttling
+// or we run out of retries
+func retryThrottled(f func() error) error {
+	delay := throttleBackoff
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || attempt == maxThrottleRetries || !isThrottleError(err) {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// uniqueIDs returns the unique, non-empty IDs in their original order
+func uniqueIDs(ids []string) []string {
+	var unique []string
+	for _, batch := range uniqueBatches(ids) {
+		unique = append(unique, batch...)
+	}
+	return unique
+}
+
+// uniqueBatches splits the unique, non-empty IDs into batches of
+// describeBatchSize
+func uniqueBatches(ids []string) [][]string {
+	var batches [][]string
+	var batch []string
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if len(id) == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		batch = append(batch, id)
+		if len(batch) == describeBatchSize {
+			batches = append(batches, batch)
+			batch = nil
+		}
+	}
+	if len(batch) != 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// describeInBatches calls describe for each batch of the IDs concurrently,
+// retrying the batches which are throttled. describe is called from several
+// goroutines.
+func describeInBatches(ids []string, describe func(batch []string) error) error {
+	batches := uniqueBatches(ids)
+	errs := make([]error, len(batches))
+	runWorkers(len(batches), func(i int) {
+		errs[i] = retryThrottled(func() error {
+			return describe(batches[i])
+		})
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runConcurrently runs the tasks concurrently and returns the first error
+func runConcurrently(tasks ...func() error) error {
+	errs := make([]error, len(tasks))
+	runWorkers(len(tasks), func(i int) {
+		errs[i] = tasks[i]()
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// describeNetworkInterfaces returns the network interfaces keyed by ID
+func describeNetworkInterfaces(svc ec2iface.EC2API, ids []string) (map[string]*ec2.NetworkInterface, error) {
+	var mu sync.Mutex
+	networkInterfaces := make(map[string]*ec2.NetworkInterface)
+	err := describeInBatches(ids, func(batch []string) error {
+		result, err := svc.DescribeNetworkInterfaces(&ec2.DescribeNetworkInterfacesInput{
+			NetworkInterfaceIds: aws.StringSlice(batch),
+		})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, ni := range result.NetworkInterfaces {
+			networkInterfaces[*ni.NetworkInterfaceId] = ni
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return networkInterfaces, nil
+}
+
+// describeSecurityGroups returns the security groups keyed by ID
+func describeSecurityGroups(svc ec2iface.EC2API, ids []string) (map[string]*ec2.SecurityGroup, error) {
+	var mu sync.Mutex
+	securityGroups := make(map[string]*ec2.SecurityGroup)
+	err := describeInBatches(ids, func(batch []string) error {
+		result, err := svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+			GroupIds: aws.StringSlice(batch),
+		})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, group := range result.SecurityGroups {
+			securityGroups[*group.GroupId] = group
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return securityGroups, nil
+}
+
+// enrichInstanceStates adds the subnet CIDRs, network ACLs, security group
+// rules and routes to the instances. The subnets, network ACLs and security
+// groups of all the instances are described together.
+func enrichInstanceStates(svc ec2iface.EC2API, states ...*instanceState) error {
+	var subnetIDs, groupIDs []string
+	for _, state := range states {
+		subnetIDs = append(subnetIDs, state.SubnetIds...)
+		for _, group := range state.SecurityGroups {
+			groupIDs = append(groupIDs, aws.StringValue(group.GroupId))
+		}
+	}
+
+	var subnetCIDRs, subnetIPv6CIDRs map[string]string
+	var networkACLs map[string]*ec2.NetworkAcl
+	var securityGroups map[string]*ec2.SecurityGroup
+	tasks := []func() error{
+		func() (err error) {
+			subnetCIDRs, subnetIPv6CIDRs, err = getSubnetCIDRs(svc, subnetIDs...)
+			return
+		},
+		func() (err error) {
+			networkACLs, err = getNetworkAcls(svc, subnetIDs...)
+			return
+		},
+		func() (err error) {
+			securityGroups, err = describeSecurityGroups(svc, groupIDs)
+			return
+		},
+	}
+	// The route tables of the subnets of all the instances are looked up
+	// together too, getRoutes returns a copy of a route table for each subnet
+	var routes []*RouteContainer
+	routeSubnetIDs := uniqueIDs(subnetIDs)
+	if len(routeSubnetIDs) != 0 {
+		tasks = append(tasks, func() error {
+			return retryThrottled(func() (err error) {
+				routes, err = getRoutes(svc, routeSubnetIDs...)
+				return
+			})
+		})
+	}
+	if err := runConcurrently(tasks...); err != nil {
+		return err
+	}
+
+	subnetRoutes := make(map[string][]*RouteContainer)
+	for _, route := range routes {
+		subnetRoutes[route.SubnetID] = append(subnetRoutes[route.SubnetID], route)
+	}
+	for _, state := range states {
+		if len(state.SubnetIds) != 0 {
+			state.SubnetCIDRs = make(map[string]string)
+			state.NetworkAcls = make(map[string]*ec2.NetworkAcl)
+			state.Routes = nil
+		}
+		for _, subnetID := range state.SubnetIds {
+			if cidr, ok := subnetCIDRs[subnetID]; ok {
+				state.SubnetCIDRs[subnetID] = cidr
+			}
+			if cidr, ok := subnetIPv6CIDRs[subnetID]; ok {
+				if state.SubnetIPv6CIDRs == nil {
+					state.SubnetIPv6CIDRs = make(map[string]string)
+				}
+				state.SubnetIPv6CIDRs[subnetID] = cidr
+			}
+			state.NetworkAcls[subnetID] = networkACLs[subnetID]
+			state.Routes = append(state.Routes, subnetRoutes[subnetID]...)
+		}
+		state.SecurityGroupRules = securityGroupRules(state.SecurityGroups, securityGroups)
+	}
+	if err := enrichPrefixLists(svc, states...); err != nil {
+		return err
+	}
+	return enrichRouteHops(svc, states...)
+}
+
+// referencedPrefixLists returns the prefix lists referenced by the security
+// group rules and the routes of an instance
+func referencedPrefixLists(state *instanceState) []string {
+	var ids []string
+	for _, rule := range state.SecurityGroupRules {
+		for _, prefixList := range rule.permission.PrefixListIds {
+			ids = append(ids, aws.StringValue(prefixList.PrefixListId))
+		}
+	}
+	for _, routeTable := range state.Routes {
+		for _, route := range routeTable.Routes {
+			if route.DestinationPrefixListId != nil {
+				ids = append(ids, *route.DestinationPrefixListId)
+			}
+		}
+	}
+	return ids
+}
+
+// getManagedPrefixListEntries returns the CIDR blocks of a customer managed
+// prefix list
+func getManagedPrefixListEntries(svc ec2iface.EC2API, id string) ([]string, error) {
+	var cidrs []string
+	err := svc.GetManagedPrefixListEntriesPages(&ec2.GetManagedPrefixListEntriesInput{
+		PrefixListId: aws.String(id),
+	}, func(page *ec2.GetManagedPrefixListEntriesOutput, lastPage bool) bool {
+		for _, entry := range page.Entries {
+			cidrs = append(cidrs, aws.StringValue(entry.Cidr))
+		}
+		return true
+	})
+	return cidrs, err
+}
+
+// isPrefixListNotFound reports whether the error is about a prefix list which
+// doesn't exist or isn't shared with the account
+func isPrefixListNotFound(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == "InvalidPrefixListID.NotFound"
+}
+
+// enrichPrefixLists adds the CIDR blocks of the prefix lists referenced by
+// the security group rules and the routes. DescribePrefixLists returns the
+// AWS managed prefix lists (e.g. for S3) with their CIDR blocks, the entries
+// of the customer managed ones are fetched one prefix list at a time. A prefix
+// list we can't see, such as one in another account which isn't shared with
+// this one, is left out.
+func enrichPrefixLists(svc ec2iface.EC2API, states ...*instanceState) error {
+	var ids []string
+	for _, state := range states {
+		ids = append(ids, referencedPrefixLists(state)...)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	prefixLists := make(map[string][]string)
+	err := retryThrottled(func() error {
+		return svc.DescribePrefixListsPages(&ec2.DescribePrefixListsInput{}, func(page *ec2.DescribePrefixListsOutput, lastPage bool) bool {
+			for _, prefixList := range page.PrefixLists {
+				prefixLists[*prefixList.PrefixListId] = aws.StringValueSlice(prefixList.Cidrs)
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("Couldn't describe prefix lists: %w", err)
+	}
+
+	var customerManaged []string
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if _, ok := prefixLists[id]; !ok && !seen[id] {
+			seen[id] = true
+			customerManaged = append(customerManaged, id)
+		}
+	}
+	entries := make([][]string, len(customerManaged))
+	errs := make([]error, len(customerManaged))
+	runWorkers(len(customerManaged), func(i int) {
+		errs[i] = retryThrottled(func() (err error) {
+			entries[i], err = getManagedPrefixListEntries(svc, customerManaged[i])
+			return
+		})
+	})
+	for i, id := range customerManaged {
+		switch {
+		case isPrefixListNotFound(errs[i]):
+			continue
+		case errs[i] != nil:
+			return fmt.Errorf("Couldn't get the entries of prefix list %s: %w", id, errs[i])
+		}
+		prefixLists[id] = entries[i]
+	}
+
+	for _, state := range states {
+		for _, id := range referencedPrefixLists(state) {
+			if cidrs, ok := prefixLists[id]; ok {
+				if state.PrefixLists == nil {
+					state.PrefixLists = make(map[string][]string)
+				}
+				state.PrefixLists[id] = cidrs
+			}
+		}
+	}
+	return nil
+}
+
+// enrichRouteHops adds the VPC peering connections and the transit gateway
+// attachments and routes which the routes of the instances use, so the checks
+// can follow the traffic to the VPC on the other side
+func enrichRouteHops(svc ec2iface.EC2API, states ...*instanceState) error {
+	var peeringIDs, transitGatewayIDs, vpcIDs []string
+	for _, state := range states {
+		vpcIDs = append(vpcIDs, state.VpcID)
+		for _, routeTable := range state.Routes {
+			for _, route := range routeTable.Routes {
+				// The target of a blackhole route may have been deleted
+				if aws.StringValue(route.State) == ec2.RouteStateBlackhole {
+					continue
+				}
+				switch targetType, targetID := routeTarget(route); targetType {
+				case routeTargetPeering:
+					peeringIDs = append(peeringIDs, targetID)
+				case routeTargetTGW:
+					transitGatewayIDs = append(transitGatewayIDs, targetID)
+				}
+			}
+		}
+	}
+
+	var mu sync.Mutex
+	peeringConnections := make(map[string]*ec2.VpcPeeringConnection)
+	err := describeInBatches(peeringIDs, func(batch []string) error {
+		result, err := svc.DescribeVpcPeeringConnections(&ec2.DescribeVpcPeeringConnectionsInput{
+			VpcPeeringConnectionIds: aws.StringSlice(batch),
+		})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, pcx := range result.VpcPeeringConnections {
+			peeringConnections[*pcx.VpcPeeringConnectionId] = pcx
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Couldn't describe VPC peering connections: %w", err)
+	}
+
+	attachments, err := describeTransitGatewayAttachments(svc, transitGatewayIDs, vpcIDs)
+	if err != nil {
+		return err
+	}
+
+	transitGatewayRoutes := make(map[string][]*ec2.TransitGatewayRoute)
+	for _, attachment := range attachments {
+		if attachment.Association == nil {
+			continue
+		}
+		routeTableID := aws.StringValue(attachment.Association.TransitGatewayRouteTableId)
+		if _, ok := transitGatewayRoutes[routeTableID]; ok {
+			continue
+		}
+		var routes []*ec2.TransitGatewayRoute
+		err := retryThrottled(func() (err error) {
+			routes, err = searchTransitGatewayRoutes(svc, routeTableID)
+			return
+		})
+		if err != nil {
+			return err
+		}
+		transitGatewayRoutes[routeTableID] = routes
+	}
+
+	for _, state := range states {
+		for _, routeTable := range state.Routes {
+			for _, route := range routeTable.Routes {
+				targetType, targetID := routeTarget(route)
+				if pcx, ok := peeringConnections[targetID]; ok && targetType == routeTargetPeering {
+					if state.PeeringConnections == nil {
+						state.PeeringConnections = make(map[string]*ec2.VpcPeeringConnection)
+					}
+					state.PeeringConnections[targetID] = pcx
+				}
+				if targetType != routeTargetTGW {
+					continue
+				}
+				for _, attachment := range attachments {
+					if aws.StringValue(attachment.TransitGatewayId) != targetID || aws.StringValue(attachment.ResourceId) != state.VpcID {
+						continue
+					}
+					if aws.StringValue(attachment.State) == ec2.TransitGatewayAttachmentStateDeleted {
+						continue
+					}
+					if state.TransitGatewayAttachments == nil {
+						state.TransitGatewayAttachments = make(map[string]*ec2.TransitGatewayAttachment)
+						state.TransitGatewayRoutes = make(map[string][]*ec2.TransitGatewayRoute)
+					}
+					state.TransitGatewayAttachments[targetID] = attachment
+					if attachment.Association != nil {
+						routeTableID := aws.StringValue(attachment.Association.TransitGatewayRouteTableId)
+						state.TransitGatewayRoutes[routeTableID] = transitGatewayRoutes[routeTableID]
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// describeTransitGatewayAttachments returns the attachments of the transit
+// gateways to the VPCs
+func describeTransitGatewayAttachments(svc ec2iface.EC2API, transitGatewayIDs []string, vpcIDs []string) ([]*ec2.TransitGatewayAttachment, error) {
+	var mu sync.Mutex
+	var attachments []*ec2.TransitGatewayAttachment
+	err := describeInBatches(transitGatewayIDs, func(batch []string) error {
+		input := &ec2.DescribeTransitGatewayAttachmentsInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("transit-gateway-id"), Values: aws.StringSlice(batch)},
+				{Name: aws.String("resource-id"), Values: aws.StringSlice(vpcIDs)},
+			},
+		}
+		// The pages are only kept once they have all been described, a
+		// throttled attempt is retried from the first page
+		var batchAttachments []*ec2.TransitGatewayAttachment
+		err := svc.DescribeTransitGatewayAttachmentsPages(input, func(page *ec2.DescribeTransitGatewayAttachmentsOutput, lastPage bool) bool {
+			batchAttachments = append(batchAttachments, page.TransitGatewayAttachments...)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		attachments = append(attachments, batchAttachments...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe transit gateway attachments: %w", err)
+	}
+	return attachments, nil
+}
+
+// maxTransitGatewayRoutes is the most routes a search of a transit gateway
+// route table returns, the search has no pagination
+const maxTransitGatewayRoutes = 1000
+
+// searchTransitGatewayRoutes returns the active and blackhole routes of a
+// transit gateway route table. The active and the blackhole routes are
+// searched separately to fit more routes, a route table with more than
+// maxTransitGatewayRoutes of either is an error rather than a partial table.
+func searchTransitGatewayRoutes(svc ec2iface.EC2API, routeTableID string) ([]*ec2.TransitGatewayRoute, error) {
+	var routes []*ec2.TransitGatewayRoute
+	for _, state := range []string{ec2.TransitGatewayRouteStateActive, ec2.TransitGatewayRouteStateBlackhole} {
+		result, err := svc.SearchTransitGatewayRoutes(&ec2.SearchTransitGatewayRoutesInput{
+			TransitGatewayRouteTableId: aws.String(routeTableID),
+			Filters: []*ec2.Filter{
+				{Name: aws.String("state"), Values: aws.StringSlice([]string{state})},
+			},
+			MaxResults: aws.Int64(maxTransitGatewayRoutes),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't search the routes of transit gateway route table %s: %w", routeTableID, err)
+		}
+		if aws.BoolValue(result.AdditionalRoutesAvailable) {
+			return nil, fmt.Errorf("Couldn't search the routes of transit gateway route table %s, it has more than %d %s routes", routeTableID, maxTransitGatewayRoutes, state)
+		}
+		routes = append(routes, result.Routes...)
+	}
+	return routes, nil
+}
@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// throttlingEC2 throttles the first DescribeSecurityGroups calls and records
+// the batches of subnet IDs passed to DescribeSubnets
+type throttlingEC2 struct {
+	ec2iface.EC2API
+	mu        sync.Mutex
+	throttles int
+	batches   [][]string
+}
+
+func (c *throttlingEC2) DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	c.mu.Lock()
+	throttle := c.throttles > 0
+	c.throttles--
+	c.mu.Unlock()
+	if throttle {
+		return nil, awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil)
+	}
+	return c.EC2API.DescribeSecurityGroups(input)
+}
+
+func (c *throttlingEC2) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	if len(input.SubnetIds) != 0 {
+		var batch []string
+		for _, id := range input.SubnetIds {
+			batch = append(batch, *id)
+		}
+		c.mu.Lock()
+		c.batches = append(c.batches, batch)
+		c.mu.Unlock()
+	}
+	return c.EC2API.DescribeSubnets(input)
+}
+
+func TestUniqueBatches(t *testing.T) {
+	var ids []string
+	for i := 0; i < describeBatchSize+10; i++ {
+		ids = append(ids, fmt.Sprintf("subnet-%d", i), "", "subnet-0")
+	}
+	batches := uniqueBatches(ids)
+	if assert.Len(t, batches, 2) {
+		assert.Len(t, batches[0], describeBatchSize)
+		assert.Len(t, batches[1], 10)
+		assert.Equal(t, "subnet-0", batches[0][0])
+	}
+	assert.Empty(t, uniqueBatches(nil))
+}
+
+func TestRetryThrottled(t *testing.T) {
+	defer func(backoff time.Duration) { throttleBackoff = backoff }(throttleBackoff)
+	throttleBackoff = 0
+
+	svc := &throttlingEC2{EC2API: newConnectivityFixture().EC2(), throttles: 2}
+	groups, err := describeSecurityGroups(svc, []string{"sg-src", "sg-dst", "sg-src"})
+	if assert.NoError(t, err) {
+		assert.Len(t, groups, 2)
+	}
+
+	svc.throttles = maxThrottleRetries + 1
+	_, err = describeSecurityGroups(svc, []string{"sg-src"})
+	assert.True(t, isThrottleError(err))
+	assert.Equal(t, exitThrottled, exitCode(err))
+}
+
+func TestEnrichInstanceStates(t *testing.T) {
+	svc := &throttlingEC2{EC2API: newConnectivityFixture().EC2()}
+	states, err := getEC2InstanceData(svc, nil)
+	if !assert.NoError(t, err) || !assert.Len(t, states, 2) {
+		return
+	}
+	assert.Equal(t, map[string]string{"eni-src": "subnet-a"}, states[0].NetworkInterfaces)
+
+	if !assert.NoError(t, enrichInstanceStates(svc, states...)) {
+		return
+	}
+	// The subnets of both instances are described in a single call
+	assert.Equal(t, [][]string{{"subnet-a", "subnet-b"}}, svc.batches)
+	assert.Equal(t, map[string]string{"subnet-a": "10.0.1.0/24"}, states[0].SubnetCIDRs)
+	assert.Equal(t, "acl-b", *states[1].NetworkAcls["subnet-b"].NetworkAclId)
+	assert.Len(t, states[1].NetworkAcls, 1)
+	assert.NotEmpty(t, states[0].SecurityGroupRules)
+	assert.NotEmpty(t, states[1].Routes)
+}
+
+// pageThrottlingEC2 throttles the first DescribeTransitGatewayAttachmentsPages
+// call after its first page
+type pageThrottlingEC2 struct {
+	ec2iface.EC2API
+	throttled bool
+}
+
+func (c *pageThrottlingEC2) DescribeTransitGatewayAttachmentsPages(input *ec2.DescribeTransitGatewayAttachmentsInput, fn func(*ec2.DescribeTransitGatewayAttachmentsOutput, bool) bool) error {
+	if c.throttled {
+		return c.EC2API.DescribeTransitGatewayAttachmentsPages(input, fn)
+	}
+	c.throttled = true
+	page, err := c.EC2API.DescribeTransitGatewayAttachments(input)
+	if err != nil {
+		return err
+	}
+	fn(page, false)
+	return awserr.New("Throttling", "Rate exceeded", nil)
+}
+
+func TestDescribeTransitGatewayAttachmentsRetry(t *testing.T) {
+	defer func(backoff time.Duration) { throttleBackoff = backoff }(throttleBackoff)
+	throttleBackoff = 0
+
+	c := newConnectivityFixture()
+	c.ec2.TransitGatewayAttachments = []*ec2.TransitGatewayAttachment{{
+		TransitGatewayAttachmentId: aws.String("tgw-attach-1"),
+		TransitGatewayId:           aws.String("tgw-1"),
+		ResourceId:                 aws.String("vpc-1"),
+		ResourceType:               aws.String("vpc"),
+		State:                      aws.String("available"),
+	}}
+
+	// The page described before the throttled call isn't kept twice
+	svc := &pageThrottlingEC2{EC2API: c.EC2()}
+	attachments, err := describeTransitGatewayAttachments(svc, []string{"tgw-1"}, []string{"vpc-1"})
+	if assert.NoError(t, err) && assert.Len(t, attachments, 1) {
+		assert.Equal(t, "tgw-attach-1", *attachments[0].TransitGatewayAttachmentId)
+	}
+	assert.True(t, svc.throttled)
+}
+
+// truncatingEC2 reports more routes than the search returned for the states
+// in truncated
+type truncatingEC2 struct {
+	ec2iface.EC2API
+	truncated map[string]bool
+}
+
+func (c *truncatingEC2) SearchTransitGatewayRoutes(input *ec2.SearchTransitGatewayRoutesInput) (*ec2.SearchTransitGatewayRoutesOutput, error) {
+	output, err := c.EC2API.SearchTransitGatewayRoutes(input)
+	if err == nil && c.truncated[*input.Filters[0].Values[0]] {
+		output.AdditionalRoutesAvailable = aws.Bool(true)
+	}
+	return output, err
+}
+
+func TestSearchTransitGatewayRoutes(t *testing.T) {
+	c := newConnectivityFixture()
+	c.ec2.TransitGatewayRoutes = map[string][]*ec2.TransitGatewayRoute{
+		"tgw-rtb-1": {
+			{DestinationCidrBlock: aws.String("10.1.0.0/16"), State: aws.String("active")},
+			{DestinationCidrBlock: aws.String("10.2.0.0/16"), State: aws.String("blackhole")},
+			{DestinationCidrBlock: aws.String("10.3.0.0/16"), State: aws.String("deleted")},
+		},
+	}
+	svc := &truncatingEC2{EC2API: c.EC2()}
+	routes, err := searchTransitGatewayRoutes(svc, "tgw-rtb-1")
+	if assert.NoError(t, err) && assert.Len(t, routes, 2) {
+		assert.Equal(t, "10.1.0.0/16", *routes[0].DestinationCidrBlock)
+		assert.Equal(t, "10.2.0.0/16", *routes[1].DestinationCidrBlock)
+	}
+
+	// A truncated search is an error, not a partial route table
+	svc.truncated = map[string]bool{"blackhole": true}
+	routes, err = searchTransitGatewayRoutes(svc, "tgw-rtb-1")
+	assert.Nil(t, routes)
+	if assert.Error(t, err) {
+		assert.Equal(t, "Couldn't search the routes of transit gateway route table tgw-rtb-1, it has more than 1000 blackhole routes", err.Error())
+	}
+}
+
+// routeTableCountingEC2 counts the DescribeRouteTables calls
+type routeTableCountingEC2 struct {
+	ec2iface.EC2API
+	calls int
+}
+
+func (c *routeTableCountingEC2) DescribeRouteTables(input *ec2.DescribeRouteTablesInput) (*ec2.DescribeRouteTablesOutput, error) {
+	c.calls++
+	return c.EC2API.DescribeRouteTables(input)
+}
+
+func TestEnrichInstanceStatesRoutes(t *testing.T) {
+	c := newConnectivityFixture()
+	svc := &routeTableCountingEC2{EC2API: c.EC2()}
+	states, err := getEC2InstanceData(svc, nil)
+	if !assert.NoError(t, err) || !assert.Len(t, states, 2) {
+		return
+	}
+
+	// One describe for the route tables associated with the subnets of both
+	// instances, one for the main route table of the VPC
+	if !assert.NoError(t, enrichInstanceStates(svc, states...)) {
+		return
+	}
+	assert.Equal(t, 2, svc.calls)
+	for i, subnetID := range []string{"subnet-a", "subnet-b"} {
+		if assert.Len(t, states[i].Routes, 1) {
+			assert.Equal(t, subnetID, states[i].Routes[0].SubnetID)
+			assert.Equal(t, "rtb-main", states[i].Routes[0].RouteTableId)
+		}
+	}
+}
+
+func TestGetRoutes(t *testing.T) {
+	c := newConnectivityFixture()
+	c.ec2.Subnets = append(c.ec2.Subnets, &ec2.Subnet{SubnetId: aws.String("subnet-c"), VpcId: aws.String("vpc-1"), CidrBlock: aws.String("10.0.3.0/24")})
+	c.ec2.RouteTables = append(c.ec2.RouteTables, &ec2.RouteTable{
+		RouteTableId: aws.String("rtb-a"),
+		VpcId:        aws.String("vpc-1"),
+		Associations: []*ec2.RouteTableAssociation{{Main: aws.Bool(false), SubnetId: aws.String("subnet-a"), RouteTableId: aws.String("rtb-a")}},
+	})
+
+	// One describe for the subnets' route tables, one for the main route
+	// table of the VPC, which subnet-b and subnet-c share
+	svc := &routeTableCountingEC2{EC2API: c.EC2()}
+	routes, err := getRoutes(svc, "subnet-a", "subnet-b", "subnet-c")
+	if !assert.NoError(t, err) || !assert.Len(t, routes, 3) {
+		t.FailNow()
+	}
+	assert.Equal(t, 2, svc.calls)
+	for i, expected := range []RouteContainer{
+		{SubnetID: "subnet-a", RouteTableId: "rtb-a"},
+		{SubnetID: "subnet-b", RouteTableId: "rtb-main", Main: true},
+		{SubnetID: "subnet-c", RouteTableId: "rtb-main", Main: true},
+	} {
+		assert.Equal(t, expected.SubnetID, routes[i].SubnetID)
+		assert.Equal(t, expected.RouteTableId, routes[i].RouteTableId)
+		assert.Equal(t, expected.Main, routes[i].Main)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Exit codes, documented in the README
+const (
+	exitOK          = 0
+	exitCheckFailed = 1
+	exitUsage       = 2
+	exitAuth        = 3
+	exitNotFound    = 4
+	exitThrottled   = 5
+	exitError       = 6
+)
+
+// errCheckFailed is returned when the command ran successfully but one of
+// the checks it performed (e.g. connectivity) failed. The results have
+// already been displayed.
+var errCheckFailed = errors.New("One or more checks failed")
+
+// usageError is an invalid combination of arguments/flags
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+func newUsageError(format string, a ...interface{}) error {
+	return &usageError{fmt.Sprintf(format, a...)}
+}
+
+// notFoundError is a resource we looked for which doesn't exist
+type notFoundError struct {
+	msg string
+}
+
+func (e *notFoundError) Error() string { return e.msg }
+
+func newNotFoundError(format string, a ...interface{}) error {
+	return &notFoundError{fmt.Sprintf(format, a...)}
+}
+
+// AWS error codes which mean the credentials are missing, expired or don't
+// allow the operation
+var authErrorCodes = map[string]bool{
+	"AccessDenied":                true,
+	"AccessDeniedException":       true,
+	"AuthFailure":                 true,
+	"ExpiredToken":                true,
+	"ExpiredTokenException":       true,
+	"InvalidClientTokenId":        true,
+	"NoCredentialProviders":       true,
+	"RequestExpired":              true,
+	"SignatureDoesNotMatch":       true,
+	"UnauthorizedOperation":       true,
+	"UnrecognizedClientException": true,
+}
+
+// AWS error codes which mean the resource doesn't exist, other than the
+// ones ending in NotFound (e.g. InvalidInstanceID.NotFound)
+var notFoundErrorCodes = map[string]bool{
+	"DBClusterNotFoundFault":     true,
+	"DBInstanceNotFound":         true,
+	"DBSubnetGroupNotFoundFault": true,
+	"NoSuchEntity":               true,
+	"NoSuchHostedZone":           true,
+	"ResourceNotFoundException":  true,
+	"ResourceNotFoundFault":      true,
+}
+
+// exitCode returns the exit code for an error returned by a command
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if err == errCheckFailed {
+		return exitCheckFailed
+	}
+
+	var usageErr *usageError
+	if errors.As(err, &usageErr) {
+		return exitUsage
+	}
+	var notFoundErr *notFoundError
+	if errors.As(err, &notFoundErr) {
+		return exitNotFound
+	}
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		code := aerr.Code()
+		switch {
+		case authErrorCodes[code]:
+			return exitAuth
+		case notFoundErrorCodes[code] || strings.HasSuffix(code, "NotFound"):
+			return exitNotFound
+		case request.IsErrorThrottle(aerr):
+			return exitThrottled
+		}
+	}
+	return exitError
+}
+
+// exitWithError is for the few places which can't return an error to the
+// command, such as creating the AWS session when a client is first used
+func exitWithError(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, exitOK, exitCode(nil))
+	assert.Equal(t, exitCheckFailed, exitCode(errCheckFailed))
+	assert.Equal(t, exitUsage, exitCode(newUsageError("Specify --to")))
+	assert.Equal(t, exitNotFound, exitCode(newNotFoundError("No instance found")))
+	assert.Equal(t, exitError, exitCode(errors.New("Something went wrong")))
+
+	assert.Equal(t, exitAuth, exitCode(awserr.New("UnauthorizedOperation", "", nil)))
+	assert.Equal(t, exitAuth, exitCode(awserr.New("ExpiredToken", "", nil)))
+	assert.Equal(t, exitNotFound, exitCode(awserr.New("InvalidInstanceID.NotFound", "", nil)))
+	assert.Equal(t, exitNotFound, exitCode(awserr.New("NoSuchHostedZone", "", nil)))
+	assert.Equal(t, exitThrottled, exitCode(awserr.New("Throttling", "", nil)))
+	assert.Equal(t, exitThrottled, exitCode(awserr.New("RequestLimitExceeded", "", nil)))
+	assert.Equal(t, exitError, exitCode(awserr.New("InvalidParameterValue", "", nil)))
+
+	// The AWS error is found when it has been wrapped by a helper
+	err := fmt.Errorf("Couldn't describe instances: %w", awserr.New("AuthFailure", "", nil))
+	assert.Equal(t, exitAuth, exitCode(err))
+}
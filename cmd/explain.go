@@ -0,0 +1,394 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The stages of the path the traffic of a connectivity check takes, in the
+// order --explain and --graph show them in
+const (
+	stageSecurityGroupEgress  = "securityGroupEgress"
+	stageNACLEgress           = "networkACLEgress"
+	stageRoute                = "route"
+	stageRouteTarget          = "routeTarget"
+	stageNACLIngress          = "networkACLIngress"
+	stageSecurityGroupIngress = "securityGroupIngress"
+	stageReturn               = "returnPath"
+)
+
+var stageOrder = []string{
+	stageSecurityGroupEgress,
+	stageNACLEgress,
+	stageRoute,
+	stageRouteTarget,
+	stageNACLIngress,
+	stageSecurityGroupIngress,
+	stageReturn,
+}
+
+var stageNames = map[string]string{
+	stageSecurityGroupEgress:  "Security group egress",
+	stageNACLEgress:           "Network ACL egress",
+	stageRoute:                "Route table",
+	stageRouteTarget:          "Route target",
+	stageNACLIngress:          "Network ACL ingress",
+	stageSecurityGroupIngress: "Security group ingress",
+	stageReturn:               "Return path",
+}
+
+const (
+	graphDot     = "dot"
+	graphMermaid = "mermaid"
+)
+
+var explainOutput bool
+var graphFormat string
+
+// withStage sets the stage of the results, for the checks which are used at
+// more than one point of the path (such as a route back to the source)
+func withStage(stage string, results []*checkResult) []*checkResult {
+	for _, r := range results {
+		r.Stage = stage
+	}
+	return results
+}
+
+// stageIndex returns the position of the stage on the path, checks without
+// a stage (such as the Lambda service network) come first
+func stageIndex(stage string) int {
+	for i, s := range stageOrder {
+		if s == stage {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// explainCheckResults returns the results in the order of the stages of the
+// path, keeping the order of the checks within a stage
+func explainCheckResults(results []*checkResult) []*checkResult {
+	ordered := make([]*checkResult, len(results))
+	copy(ordered, results)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return stageIndex(ordered[i].Stage) < stageIndex(ordered[j].Stage)
+	})
+	return ordered
+}
+
+// describePorts describes the protocol and ports of a security group rule,
+// for ICMP the ports are the type and code
+func describePorts(protocol *string, fromPort *int64, toPort *int64) string {
+	name := protocolMapping[aws.StringValue(protocol)]
+	if len(name) == 0 {
+		name = aws.StringValue(protocol)
+	}
+	if name == "all" {
+		return "all traffic"
+	}
+	from, to := int64(-1), int64(-1)
+	if fromPort != nil {
+		from = *fromPort
+	}
+	if toPort != nil {
+		to = *toPort
+	}
+	if isICMPProtocol(name) {
+		switch {
+		case from == -1:
+			return name + " all"
+		case to == -1:
+			return fmt.Sprintf("%s type %d", name, from)
+		}
+		return fmt.Sprintf("%s type %d code %d", name, from, to)
+	}
+	switch {
+	case from == -1 || (from == 0 && to == 65535):
+		return name + " all"
+	case from == to:
+		return fmt.Sprintf("%s %d", name, from)
+	}
+	return fmt.Sprintf("%s %d-%d", name, from, to)
+}
+
+// describeSecurityGroupRule describes a rule as its group, direction,
+// protocol, ports and the addresses, groups and prefix lists it allows
+func describeSecurityGroupRule(rule *SecurityGroupRule) string {
+	direction, preposition := "ingress", "from"
+	if rule.egress {
+		direction, preposition = "egress", "to"
+	}
+	var peers []string
+	for _, ipRange := range rule.permission.IpRanges {
+		peers = append(peers, aws.StringValue(ipRange.CidrIp))
+	}
+	for _, ipRange := range rule.permission.Ipv6Ranges {
+		peers = append(peers, aws.StringValue(ipRange.CidrIpv6))
+	}
+	for _, pair := range rule.permission.UserIdGroupPairs {
+		peers = append(peers, aws.StringValue(pair.GroupId))
+	}
+	for _, prefixList := range rule.permission.PrefixListIds {
+		peers = append(peers, aws.StringValue(prefixList.PrefixListId))
+	}
+	text := fmt.Sprintf("%s %s %s", direction, describePorts(rule.permission.IpProtocol, rule.permission.FromPort, rule.permission.ToPort), preposition)
+	if len(rule.groupID) != 0 {
+		text = rule.groupID + " " + text
+	}
+	return text + " " + strings.Join(peers, ", ")
+}
+
+// describeRoute describes a route as its destination and target
+func describeRoute(route *ec2.Route) string {
+	targetType, targetID := routeTarget(route)
+	text := fmt.Sprintf("%s via %s", aws.StringValue(routeDestination(route)), targetType)
+	if targetID != targetType {
+		text += " " + targetID
+	}
+	if aws.StringValue(route.State) == ec2.RouteStateBlackhole {
+		text += " (blackhole)"
+	}
+	return text
+}
+
+// explainMatch returns the details of a check the trace shows beneath it:
+// the rule or route which matched, or why the check failed
+func explainMatch(r *checkResult) []string {
+	var details []string
+	if rule, ok := r.Metadata["MatchedSecurityGroupRule"].(*SecurityGroupRule); ok {
+		details = append(details, "matched "+describeSecurityGroupRule(rule))
+	}
+	if id, ok := r.Metadata["ReferencedPrefixList"].(string); ok {
+		details = append(details, "via prefix list "+id)
+	}
+	if decision, ok := r.Metadata["DecidingRule"].(naclDecision); ok {
+		details = append(details, "decided by "+decision.String())
+	}
+	switch routes := r.Metadata["MatchedRoutes"].(type) {
+	case []*ec2.Route:
+		for _, route := range routes {
+			details = append(details, "matched route "+describeRoute(route))
+		}
+	case []*ec2.TransitGatewayRoute:
+		for _, route := range routes {
+			details = append(details, fmt.Sprintf("matched route %s (%s)", aws.StringValue(route.DestinationCidrBlock), aws.StringValue(route.State)))
+		}
+	}
+	if ids, ok := r.Metadata["SecurityGroupIds"].([]string); ok && len(ids) != 0 {
+		details = append(details, "no rule of "+strings.Join(ids, ", ")+" matched")
+	}
+	if ids, ok := r.Metadata["UnresolvedPrefixLists"].([]string); ok {
+		details = append(details, "unresolved prefix lists "+strings.Join(ids, ", "))
+	}
+	if reason, ok := r.Metadata["Reason"].(string); ok {
+		details = append(details, reason)
+	}
+	return details
+}
+
+// describeTraffic describes the protocol and ports of the traffic, for ICMP
+// the type and code
+func describeTraffic(protocol string, ports *ec2.PortRange) string {
+	if isICMPProtocol(protocol) {
+		return fmt.Sprintf("%s type %d code %d", protocol, aws.Int64Value(ports.From), aws.Int64Value(ports.To))
+	}
+	return fmt.Sprintf("%s %d", protocol, aws.Int64Value(ports.From))
+}
+
+// sourceInterfaces describes the network interfaces the traffic can leave
+// the source from
+func sourceInterfaces(state *instanceState) []string {
+	if state == nil {
+		return nil
+	}
+	var interfaces []string
+	for eni, subnetID := range state.NetworkInterfaces {
+		interfaces = append(interfaces, fmt.Sprintf("%s in %s", eni, subnetID))
+	}
+	sort.Strings(interfaces)
+	return interfaces
+}
+
+// writeExplanation writes the results as a trace of the path from the
+// source to the destination and back, stage by stage. The results must be
+// in the order of the path (see explainCheckResults).
+func writeExplanation(w io.Writer, source string, sourceState *instanceState, destination string, traffic string, results []*checkResult) {
+	fmt.Fprintf(w, "Path from %s to %s over %s\n", source, destination, traffic)
+	interfaces := sourceInterfaces(sourceState)
+	if len(interfaces) == 0 {
+		fmt.Fprintf(w, "Source %s\n", source)
+	}
+	for _, eni := range interfaces {
+		fmt.Fprintf(w, "Source %s ENI %s\n", source, eni)
+	}
+
+	stage, arrived := "", false
+	for i, r := range results {
+		if r.Stage == stageReturn && !arrived {
+			fmt.Fprintf(w, "Destination %s\n", destination)
+			arrived = true
+		}
+		if i == 0 || r.Stage != stage {
+			stage = r.Stage
+			if name, ok := stageNames[stage]; ok {
+				fmt.Fprintf(w, "  %s\n", name)
+			}
+		}
+		mark := "✔"
+		if !r.Result {
+			mark = "✖"
+		}
+		fmt.Fprintf(w, "    %s %s\n", mark, r.DisplayText)
+		for _, detail := range explainMatch(r) {
+			fmt.Fprintf(w, "        %s\n", detail)
+		}
+	}
+	if !arrived {
+		fmt.Fprintf(w, "Destination %s\n", destination)
+	}
+}
+
+// graphLabel returns the lines of the label of a check's node
+func graphLabel(r *checkResult) []string {
+	var lines []string
+	if name, ok := stageNames[r.Stage]; ok {
+		lines = append(lines, name)
+	}
+	lines = append(lines, r.DisplayText)
+	return append(lines, explainMatch(r)...)
+}
+
+// writeConnectivityGraph writes the path of the results as a Graphviz (dot)
+// or Mermaid diagram: the source, the checks towards the destination, the
+// destination and the checks of the return path back to the source. Failed
+// checks are drawn in red. The results must be in the order of the path.
+func writeConnectivityGraph(w io.Writer, format string, source string, destination string, results []*checkResult) error {
+	type node struct {
+		id     string
+		label  []string
+		result *bool
+	}
+	nodes := []node{{id: "source", label: []string{source}}}
+	arrived := false
+	for i, r := range results {
+		if r.Stage == stageReturn && !arrived {
+			nodes = append(nodes, node{id: "destination", label: []string{destination}})
+			arrived = true
+		}
+		result := r.Result
+		nodes = append(nodes, node{id: "n" + strconv.Itoa(i+1), label: graphLabel(r), result: &result})
+	}
+	if !arrived {
+		nodes = append(nodes, node{id: "destination", label: []string{destination}})
+	}
+
+	switch format {
+	case graphDot:
+		fmt.Fprintln(w, "digraph connectivity {")
+		fmt.Fprintln(w, "\trankdir=LR;")
+		fmt.Fprintln(w, "\tnode [shape=box];")
+		for _, n := range nodes {
+			var escaped []string
+			for _, line := range n.label {
+				escaped = append(escaped, strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(line))
+			}
+			attributes := fmt.Sprintf("label=\"%s\"", strings.Join(escaped, `\n`))
+			switch {
+			case n.result == nil:
+				attributes += ", shape=ellipse"
+			case *n.result:
+				attributes += ", color=green"
+			default:
+				attributes += ", color=red"
+			}
+			fmt.Fprintf(w, "\t%s [%s];\n", n.id, attributes)
+		}
+		for i := 1; i < len(nodes); i++ {
+			fmt.Fprintf(w, "\t%s -> %s;\n", nodes[i-1].id, nodes[i].id)
+		}
+		if arrived {
+			fmt.Fprintf(w, "\t%s -> source [style=dashed];\n", nodes[len(nodes)-1].id)
+		}
+		fmt.Fprintln(w, "}")
+	case graphMermaid:
+		fmt.Fprintln(w, "flowchart LR")
+		var passed, failed []string
+		for _, n := range nodes {
+			var escaped []string
+			for _, line := range n.label {
+				escaped = append(escaped, strings.Replace(line, `"`, "#quot;", -1))
+			}
+			label := strings.Join(escaped, "<br/>")
+			switch {
+			case n.result == nil:
+				fmt.Fprintf(w, "\t%s([\"%s\"])\n", n.id, label)
+				continue
+			case *n.result:
+				passed = append(passed, n.id)
+			default:
+				failed = append(failed, n.id)
+			}
+			fmt.Fprintf(w, "\t%s[\"%s\"]\n", n.id, label)
+		}
+		for i := 1; i < len(nodes); i++ {
+			fmt.Fprintf(w, "\t%s --> %s\n", nodes[i-1].id, nodes[i].id)
+		}
+		if arrived {
+			fmt.Fprintf(w, "\t%s -.-> source\n", nodes[len(nodes)-1].id)
+		}
+		fmt.Fprintln(w, "\tclassDef passed stroke:#2a2")
+		fmt.Fprintln(w, "\tclassDef failed stroke:#d22")
+		if len(passed) != 0 {
+			fmt.Fprintf(w, "\tclass %s passed\n", strings.Join(passed, ","))
+		}
+		if len(failed) != 0 {
+			fmt.Fprintf(w, "\tclass %s failed\n", strings.Join(failed, ","))
+		}
+	default:
+		return newUsageError("Unsupported graph format %s, must be %s or %s", format, graphDot, graphMermaid)
+	}
+	return nil
+}
+
+// renderConnectivityReport renders the results of the connectivity checks
+// from source to destination: as a diagram of the path with --graph, with
+// a trace of the path before the summary with --explain (and the results in
+// the order of the path in a JSON/YAML document), else as usual
+func renderConnectivityReport(source string, sourceState *instanceState, destination string, traffic string, results ...*checkResult) error {
+	if len(graphFormat) != 0 {
+		if err := writeConnectivityGraph(outputWriter, graphFormat, source, destination, explainCheckResults(results)); err != nil {
+			return err
+		}
+		if !summarizeResults(results...) {
+			return errCheckFailed
+		}
+		return nil
+	}
+	if explainOutput {
+		results = explainCheckResults(results)
+		if isTableOutput() {
+			writeExplanation(outputWriter, source, sourceState, destination, traffic, results)
+		}
+	}
+	return renderCheckResults("ConnectivityReport", results...)
+}
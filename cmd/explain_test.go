@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainConnectivity(t *testing.T) {
+	c := newConnectivityFixture()
+	src := loadInstanceState(t, c, "i-src")
+	dst := loadInstanceState(t, c, "i-dst")
+	network := mustParseNetwork(t, "10.0.2.20")
+
+	port := ec2.PortRange{From: aws.Int64(5432), To: aws.Int64(5432)}
+	results := explainCheckResults(checkConnectivityToState(src, addressNetworks(src.PrivateIPAddresses), dst, network, "tcp", &port, &defaultEphermalPortRange))
+	var stages []string
+	for _, r := range results {
+		if len(stages) == 0 || stages[len(stages)-1] != r.Stage {
+			stages = append(stages, r.Stage)
+		}
+	}
+	assert.Equal(t, []string{stageSecurityGroupEgress, stageNACLEgress, stageRoute, stageNACLIngress, stageSecurityGroupIngress, stageReturn}, stages)
+
+	var buf bytes.Buffer
+	writeExplanation(&buf, "i-src", src, "i-dst", describeTraffic("tcp", &port), results)
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "Path from i-src to i-dst over tcp 5432\nSource i-src ENI eni-src in subnet-a\n  Security group egress\n"), out)
+	assert.Contains(t, out, "        matched sg-src egress all traffic to 0.0.0.0/0\n")
+	assert.Contains(t, out, "        decided by rule #100 allow 10.0.1.0/24\n")
+	assert.Contains(t, out, "        matched route 10.0.0.0/16 via local\n")
+	assert.Contains(t, out, "        matched sg-dst ingress tcp 5432 from sg-src\n")
+	assert.Less(t, strings.Index(out, "Security group ingress"), strings.Index(out, "Destination i-dst"))
+	assert.Less(t, strings.Index(out, "Destination i-dst"), strings.Index(out, "Return path"))
+
+	// The failing hop is drawn in red, the path leads back to the source
+	port = ec2.PortRange{From: aws.Int64(22), To: aws.Int64(22)}
+	results = explainCheckResults(checkConnectivityToState(src, addressNetworks(src.PrivateIPAddresses), dst, network, "tcp", &port, &defaultEphermalPortRange))
+
+	buf.Reset()
+	assert.NoError(t, writeConnectivityGraph(&buf, graphDot, "i-src", "i-dst", results))
+	out = buf.String()
+	assert.True(t, strings.HasPrefix(out, "digraph connectivity {\n"), out)
+	assert.Contains(t, out, "\tsource [label=\"i-src\", shape=ellipse];\n")
+	assert.Contains(t, out, "\tsource -> n1;\n")
+	assert.Contains(t, out, `Network ACL ingress\nIngress ACL at Subnet subnet-b allows traffic from 10.0.1.10/32 (rule * deny 0.0.0.0/0)\ndecided by rule * deny 0.0.0.0/0", color=red];`)
+	assert.Contains(t, out, "-> source [style=dashed];\n")
+
+	buf.Reset()
+	assert.NoError(t, writeConnectivityGraph(&buf, graphMermaid, "i-src", "i-dst", results))
+	out = buf.String()
+	assert.True(t, strings.HasPrefix(out, "flowchart LR\n\tsource([\"i-src\"])\n\tn1[\"Security group egress<br/>"), out)
+	assert.Contains(t, out, "\tsource --> n1\n")
+	assert.Regexp(t, "\tclass n[0-9,n]+ failed\n", out)
+
+	assert.Error(t, writeConnectivityGraph(&buf, "svg", "i-src", "i-dst", results))
+}
+
+func TestDescribePorts(t *testing.T) {
+	assert.Equal(t, "all traffic", describePorts(aws.String("-1"), nil, nil))
+	assert.Equal(t, "tcp 22", describePorts(aws.String("tcp"), aws.Int64(22), aws.Int64(22)))
+	assert.Equal(t, "udp 8000-8100", describePorts(aws.String("17"), aws.Int64(8000), aws.Int64(8100)))
+	assert.Equal(t, "icmp type 8", describePorts(aws.String("icmp"), aws.Int64(8), aws.Int64(-1)))
+	assert.Equal(t, "icmp all", describePorts(aws.String("icmp"), aws.Int64(-1), aws.Int64(-1)))
+}
@@ -0,0 +1,361 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/cloudtrail/cloudtrailiface"
+	"github.com/aws/aws-sdk-go/service/databasemigrationservice"
+	"github.com/aws/aws-sdk-go/service/databasemigrationservice/databasemigrationserviceiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// fakeClients is an in-memory awsClientProvider. Populate the exported
+// fields of the fake services and the helpers will see them as if they
+// were returned by AWS. Calls not implemented by a fake panic, since
+// the embedded interface is nil.
+type fakeClients struct {
+	ec2         *fakeEC2
+	autoscaling *fakeAutoScaling
+	eks         *fakeEKS
+	iam         *fakeIAM
+	cloudtrail  *fakeCloudTrail
+	dms         *fakeDMS
+	route53     *fakeRoute53
+	rds         *fakeRDS
+	lambda      *fakeLambda
+	elbv2       *fakeELBV2
+	sts         *fakeSTS
+}
+
+func newFakeClients() *fakeClients {
+	return &fakeClients{
+		ec2:         &fakeEC2{snapshotEC2: &snapshotEC2{networkSnapshot: &networkSnapshot{}}},
+		autoscaling: &fakeAutoScaling{},
+		eks:         &fakeEKS{},
+		iam:         &fakeIAM{},
+		cloudtrail:  &fakeCloudTrail{},
+		dms:         &fakeDMS{TableStatistics: make(map[string][]*databasemigrationservice.TableStatistics)},
+		route53:     &fakeRoute53{RecordSets: make(map[string][]*route53.ResourceRecordSet)},
+		rds:         &fakeRDS{},
+		lambda:      &fakeLambda{},
+		elbv2:       &fakeELBV2{TargetHealth: make(map[string][]*elbv2.TargetHealthDescription)},
+		sts:         &fakeSTS{Account: "123456789012"},
+	}
+}
+
+func (c *fakeClients) EC2() ec2iface.EC2API                         { return c.ec2 }
+func (c *fakeClients) CachedEC2() ec2iface.EC2API                   { return c.ec2 }
+func (c *fakeClients) AutoScaling() autoscalingiface.AutoScalingAPI { return c.autoscaling }
+func (c *fakeClients) EKS() eksiface.EKSAPI                         { return c.eks }
+func (c *fakeClients) IAM() iamiface.IAMAPI                         { return c.iam }
+func (c *fakeClients) CloudTrail() cloudtrailiface.CloudTrailAPI    { return c.cloudtrail }
+func (c *fakeClients) DMS() databasemigrationserviceiface.DatabaseMigrationServiceAPI {
+	return c.dms
+}
+func (c *fakeClients) Route53() route53iface.Route53API { return c.route53 }
+func (c *fakeClients) RDS() rdsiface.RDSAPI             { return c.rds }
+func (c *fakeClients) Lambda() lambdaiface.LambdaAPI    { return c.lambda }
+func (c *fakeClients) ELBV2() elbv2iface.ELBV2API       { return c.elbv2 }
+func (c *fakeClients) STS() stsiface.STSAPI             { return c.sts }
+
+// fakeEC2 serves the EC2 calls from in-memory resources, the same way as a
+// snapshot does. Populate the fields of the snapshot, and Regions for
+// DescribeRegions.
+type fakeEC2 struct {
+	*snapshotEC2
+
+	Regions []*ec2.Region
+}
+
+func (f *fakeEC2) DescribeRegions(input *ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
+	output := &ec2.DescribeRegionsOutput{}
+	for _, r := range f.Regions {
+		if selectedByID(input.RegionNames, r.RegionName) {
+			output.Regions = append(output.Regions, r)
+		}
+	}
+	return output, nil
+}
+
+type fakeAutoScaling struct {
+	autoscalingiface.AutoScalingAPI
+
+	Groups []*autoscaling.Group
+}
+
+func (f *fakeAutoScaling) DescribeAutoScalingGroupsPages(input *autoscaling.DescribeAutoScalingGroupsInput, fn func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool) error {
+	output := &autoscaling.DescribeAutoScalingGroupsOutput{}
+	for _, g := range f.Groups {
+		if selectedByID(input.AutoScalingGroupNames, g.AutoScalingGroupName) {
+			output.AutoScalingGroups = append(output.AutoScalingGroups, g)
+		}
+	}
+	fn(output, true)
+	return nil
+}
+
+func (f *fakeAutoScaling) DescribeAutoScalingInstances(input *autoscaling.DescribeAutoScalingInstancesInput) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
+	output := &autoscaling.DescribeAutoScalingInstancesOutput{}
+	for _, g := range f.Groups {
+		for _, i := range g.Instances {
+			if selectedByID(input.InstanceIds, i.InstanceId) {
+				output.AutoScalingInstances = append(output.AutoScalingInstances, &autoscaling.InstanceDetails{
+					InstanceId:           i.InstanceId,
+					AutoScalingGroupName: g.AutoScalingGroupName,
+					AvailabilityZone:     i.AvailabilityZone,
+					ProtectedFromScaleIn: i.ProtectedFromScaleIn,
+				})
+			}
+		}
+	}
+	return output, nil
+}
+
+type fakeEKS struct {
+	eksiface.EKSAPI
+
+	Clusters []*eks.Cluster
+}
+
+func (f *fakeEKS) ListClusters(input *eks.ListClustersInput) (*eks.ListClustersOutput, error) {
+	output := &eks.ListClustersOutput{}
+	for _, c := range f.Clusters {
+		output.Clusters = append(output.Clusters, c.Name)
+	}
+	return output, nil
+}
+
+func (f *fakeEKS) DescribeCluster(input *eks.DescribeClusterInput) (*eks.DescribeClusterOutput, error) {
+	for _, c := range f.Clusters {
+		if *c.Name == *input.Name {
+			return &eks.DescribeClusterOutput{Cluster: c}, nil
+		}
+	}
+	return nil, awserr.New(eks.ErrCodeResourceNotFoundException, "No cluster found for name: "+*input.Name, nil)
+}
+
+type fakeIAM struct {
+	iamiface.IAMAPI
+
+	Roles []*iam.Role
+}
+
+func (f *fakeIAM) GetRole(input *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	for _, r := range f.Roles {
+		if *r.RoleName == *input.RoleName {
+			return &iam.GetRoleOutput{Role: r}, nil
+		}
+	}
+	return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "The role with name "+*input.RoleName+" cannot be found.", nil)
+}
+
+type fakeCloudTrail struct {
+	cloudtrailiface.CloudTrailAPI
+
+	Events []*cloudtrail.Event
+}
+
+func (f *fakeCloudTrail) LookupEventsPages(input *cloudtrail.LookupEventsInput, fn func(*cloudtrail.LookupEventsOutput, bool) bool) error {
+	fn(&cloudtrail.LookupEventsOutput{Events: f.Events}, true)
+	return nil
+}
+
+type fakeDMS struct {
+	databasemigrationserviceiface.DatabaseMigrationServiceAPI
+
+	ReplicationTasks []*databasemigrationservice.ReplicationTask
+	// Map of replication task ARN to table statistics
+	TableStatistics map[string][]*databasemigrationservice.TableStatistics
+}
+
+func (f *fakeDMS) DescribeReplicationTasks(input *databasemigrationservice.DescribeReplicationTasksInput) (*databasemigrationservice.DescribeReplicationTasksOutput, error) {
+	return &databasemigrationservice.DescribeReplicationTasksOutput{ReplicationTasks: f.ReplicationTasks}, nil
+}
+
+func (f *fakeDMS) DescribeReplicationTasksPages(input *databasemigrationservice.DescribeReplicationTasksInput, fn func(*databasemigrationservice.DescribeReplicationTasksOutput, bool) bool) error {
+	output, _ := f.DescribeReplicationTasks(input)
+	fn(output, true)
+	return nil
+}
+
+func (f *fakeDMS) DescribeTableStatistics(input *databasemigrationservice.DescribeTableStatisticsInput) (*databasemigrationservice.DescribeTableStatisticsOutput, error) {
+	stats, ok := f.TableStatistics[*input.ReplicationTaskArn]
+	if !ok {
+		return nil, awserr.New(databasemigrationservice.ErrCodeResourceNotFoundFault, "Replication task not found", nil)
+	}
+	return &databasemigrationservice.DescribeTableStatisticsOutput{TableStatistics: stats}, nil
+}
+
+type fakeRoute53 struct {
+	route53iface.Route53API
+
+	HostedZones []*route53.HostedZone
+	// Map of hosted zone ID to the record sets in the zone
+	RecordSets map[string][]*route53.ResourceRecordSet
+}
+
+func (f *fakeRoute53) ListHostedZones(input *route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error) {
+	return &route53.ListHostedZonesOutput{HostedZones: f.HostedZones}, nil
+}
+
+func (f *fakeRoute53) GetHostedZone(input *route53.GetHostedZoneInput) (*route53.GetHostedZoneOutput, error) {
+	for _, z := range f.HostedZones {
+		if *z.Id == *input.Id {
+			return &route53.GetHostedZoneOutput{HostedZone: z}, nil
+		}
+	}
+	return nil, awserr.New(route53.ErrCodeNoSuchHostedZone, "No hosted zone found with ID: "+*input.Id, nil)
+}
+
+func (f *fakeRoute53) ListResourceRecordSets(input *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+	recordSets, ok := f.RecordSets[*input.HostedZoneId]
+	if !ok {
+		return nil, awserr.New(route53.ErrCodeNoSuchHostedZone, "No hosted zone found with ID: "+*input.HostedZoneId, nil)
+	}
+	return &route53.ListResourceRecordSetsOutput{ResourceRecordSets: recordSets}, nil
+}
+
+type fakeRDS struct {
+	rdsiface.RDSAPI
+
+	DBInstances  []*rds.DBInstance
+	DBClusters   []*rds.DBCluster
+	SubnetGroups []*rds.DBSubnetGroup
+}
+
+func (f *fakeRDS) DescribeDBInstances(input *rds.DescribeDBInstancesInput) (*rds.DescribeDBInstancesOutput, error) {
+	output := &rds.DescribeDBInstancesOutput{}
+	for _, db := range f.DBInstances {
+		if input.DBInstanceIdentifier == nil || *input.DBInstanceIdentifier == *db.DBInstanceIdentifier {
+			output.DBInstances = append(output.DBInstances, db)
+		}
+	}
+	if input.DBInstanceIdentifier != nil && len(output.DBInstances) == 0 {
+		return nil, awserr.New(rds.ErrCodeDBInstanceNotFoundFault, "DBInstance "+*input.DBInstanceIdentifier+" not found.", nil)
+	}
+	return output, nil
+}
+
+func (f *fakeRDS) DescribeDBClusters(input *rds.DescribeDBClustersInput) (*rds.DescribeDBClustersOutput, error) {
+	output := &rds.DescribeDBClustersOutput{}
+	for _, c := range f.DBClusters {
+		if input.DBClusterIdentifier == nil || *input.DBClusterIdentifier == *c.DBClusterIdentifier {
+			output.DBClusters = append(output.DBClusters, c)
+		}
+	}
+	if input.DBClusterIdentifier != nil && len(output.DBClusters) == 0 {
+		return nil, awserr.New(rds.ErrCodeDBClusterNotFoundFault, "DBCluster "+*input.DBClusterIdentifier+" not found.", nil)
+	}
+	return output, nil
+}
+
+func (f *fakeRDS) DescribeDBSubnetGroups(input *rds.DescribeDBSubnetGroupsInput) (*rds.DescribeDBSubnetGroupsOutput, error) {
+	output := &rds.DescribeDBSubnetGroupsOutput{}
+	for _, g := range f.SubnetGroups {
+		if input.DBSubnetGroupName == nil || *input.DBSubnetGroupName == *g.DBSubnetGroupName {
+			output.DBSubnetGroups = append(output.DBSubnetGroups, g)
+		}
+	}
+	if input.DBSubnetGroupName != nil && len(output.DBSubnetGroups) == 0 {
+		return nil, awserr.New(rds.ErrCodeDBSubnetGroupNotFoundFault, "DB subnet group "+*input.DBSubnetGroupName+" not found.", nil)
+	}
+	return output, nil
+}
+
+type fakeLambda struct {
+	lambdaiface.LambdaAPI
+
+	Functions []*lambda.FunctionConfiguration
+}
+
+func (f *fakeLambda) GetFunctionConfiguration(input *lambda.GetFunctionConfigurationInput) (*lambda.FunctionConfiguration, error) {
+	for _, fn := range f.Functions {
+		if *fn.FunctionName == *input.FunctionName || aws.StringValue(fn.FunctionArn) == *input.FunctionName {
+			return fn, nil
+		}
+	}
+	return nil, awserr.New(lambda.ErrCodeResourceNotFoundException, "Function not found: "+*input.FunctionName, nil)
+}
+
+// fakeELBV2 holds the target health keyed by the target group ARN
+type fakeELBV2 struct {
+	elbv2iface.ELBV2API
+
+	LoadBalancers []*elbv2.LoadBalancer
+	TargetGroups  []*elbv2.TargetGroup
+	TargetHealth  map[string][]*elbv2.TargetHealthDescription
+}
+
+func (f *fakeELBV2) DescribeLoadBalancers(input *elbv2.DescribeLoadBalancersInput) (*elbv2.DescribeLoadBalancersOutput, error) {
+	output := &elbv2.DescribeLoadBalancersOutput{}
+	for _, lb := range f.LoadBalancers {
+		if len(input.Names) == 0 || matchesFilterValue(input.Names, []string{*lb.LoadBalancerName}) {
+			output.LoadBalancers = append(output.LoadBalancers, lb)
+		}
+	}
+	if len(input.Names) != 0 && len(output.LoadBalancers) != len(input.Names) {
+		return nil, awserr.New(elbv2.ErrCodeLoadBalancerNotFoundException, "One or more load balancers not found", nil)
+	}
+	return output, nil
+}
+
+func (f *fakeELBV2) DescribeTargetGroupsPages(input *elbv2.DescribeTargetGroupsInput, fn func(*elbv2.DescribeTargetGroupsOutput, bool) bool) error {
+	output := &elbv2.DescribeTargetGroupsOutput{}
+	for _, tg := range f.TargetGroups {
+		if input.LoadBalancerArn == nil || matchesFilterValue([]*string{input.LoadBalancerArn}, aws.StringValueSlice(tg.LoadBalancerArns)) {
+			output.TargetGroups = append(output.TargetGroups, tg)
+		}
+	}
+	fn(output, true)
+	return nil
+}
+
+func (f *fakeELBV2) DescribeTargetHealth(input *elbv2.DescribeTargetHealthInput) (*elbv2.DescribeTargetHealthOutput, error) {
+	descriptions, ok := f.TargetHealth[*input.TargetGroupArn]
+	if !ok {
+		return nil, awserr.New(elbv2.ErrCodeTargetGroupNotFoundException, "Target group not found: "+*input.TargetGroupArn, nil)
+	}
+	return &elbv2.DescribeTargetHealthOutput{TargetHealthDescriptions: descriptions}, nil
+}
+
+type fakeSTS struct {
+	stsiface.STSAPI
+
+	Account string
+}
+
+func (f *fakeSTS) GetCallerIdentity(input *sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{Account: aws.String(f.Account)}, nil
+}
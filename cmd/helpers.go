@@ -13,35 +13,40 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
-	"text/tabwriter"
+	"sync"
 
 	"runtime"
 	"time"
 
 	"github.com/aws/aws-sdk-go/service/databasemigrationservice"
+	"github.com/aws/aws-sdk-go/service/databasemigrationservice/databasemigrationserviceiface"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/aws/aws-sdk-go/service/route53/route53iface"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/aws/aws-sdk-go/aws"
 
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/fatih/color"
 
 	fuzzyfinder "github.com/ktr0731/go-fuzzyfinder"
 )
 
 var protocolMapping = map[string]string{
-	"6":    "tcp",
-	"17":   "udp",
-	"-1":   "all",
-	"tcp":  "tcp",
-	"udp":  "udp",
-	"icmp": "icmp",
+	"1":      "icmp",
+	"6":      "tcp",
+	"17":     "udp",
+	"58":     "icmpv6",
+	"-1":     "all",
+	"tcp":    "tcp",
+	"udp":    "udp",
+	"icmp":   "icmp",
+	"icmpv6": "icmpv6",
 }
 
 // https://en.wikipedia.org/wiki/Ephemeral_port
@@ -55,35 +60,6 @@ var defaultEphermalPortRange = ec2.PortRange{
 	To:   &endingEphermalPort,
 }
 
-func createSession(region ...string) *session.Session {
-	var sess *session.Session
-	var err error
-
-	profile := os.Getenv("AWS_PROFILE")
-	if len(profile) != 0 {
-		sess = session.Must(session.NewSessionWithOptions(session.Options{
-			SharedConfigState: session.SharedConfigEnable,
-			Profile:           profile,
-		}))
-	} else {
-		var awsRegion string
-		if len(region) == 1 {
-			awsRegion = region[0]
-		} else if os.Getenv("AWS_REGION") != "" {
-			awsRegion = os.Getenv("AWS_REGION")
-		} else {
-			awsRegion = "us-east-1"
-		}
-		sess, err = session.NewSession(&aws.Config{
-			Region: aws.String(awsRegion),
-		})
-		if err != nil {
-			log.Fatal("Couldn't create a session to talk to AWS", err.Error())
-		}
-	}
-	return sess
-}
-
 func modifyUserData(userData string) (*string, error) {
 	// TODO: support this better:
 	// https://bbengfort.github.io/snippets/2018/01/06/cli-editor-app.html
@@ -127,49 +103,44 @@ func modifyUserData(userData string) (*string, error) {
 	return &editedFileContentsStr, err
 }
 
-func describeSubnetAttachedRouteTables(svc *ec2.EC2, subnetID *string) []*RouteContainer {
+// describeSubnetAttachedRouteTables returns the route tables associated with
+// each of the subnets
+func describeSubnetAttachedRouteTables(svc ec2iface.EC2API, subnetIDs []string) (map[string][]*RouteContainer, error) {
 	input := &ec2.DescribeRouteTablesInput{
 
 		Filters: []*ec2.Filter{
 			{
-				Name: aws.String("association.subnet-id"),
-				Values: []*string{
-					aws.String(*subnetID),
-				},
+				Name:   aws.String("association.subnet-id"),
+				Values: aws.StringSlice(subnetIDs),
 			},
 		},
 	}
 
-	var routes []*RouteContainer
+	routes := make(map[string][]*RouteContainer)
 
 	result, err := svc.DescribeRouteTables(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
-		return nil
+		return nil, fmt.Errorf("Couldn't describe the route tables of %v: %w", subnetIDs, err)
 	}
 
 	for _, routeTable := range result.RouteTables {
-		route := RouteContainer{
-			RouteTableId: *routeTable.RouteTableId,
-			Main:         false,
-			Routes:       routeTable.Routes,
+		for _, association := range routeTable.Associations {
+			if association.SubnetId == nil {
+				continue
+			}
+			route := RouteContainer{
+				RouteTableId: *routeTable.RouteTableId,
+				Main:         false,
+				Routes:       routeTable.Routes,
+			}
+			routes[*association.SubnetId] = append(routes[*association.SubnetId], &route)
 		}
-		routes = append(routes, &route)
 	}
-	return routes
+	return routes, nil
 
 }
 
-func describeVpcMainRouteTables(svc *ec2.EC2, vpcID *string) []*RouteContainer {
+func describeVpcMainRouteTables(svc ec2iface.EC2API, vpcID *string) ([]*RouteContainer, error) {
 	input := &ec2.DescribeRouteTablesInput{
 
 		Filters: []*ec2.Filter{
@@ -186,17 +157,7 @@ func describeVpcMainRouteTables(svc *ec2.EC2, vpcID *string) []*RouteContainer {
 
 	result, err := svc.DescribeRouteTables(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
-		return nil
+		return nil, fmt.Errorf("Couldn't describe the route tables of %s: %w", *vpcID, err)
 	}
 
 	for _, routeTable := range result.RouteTables {
@@ -204,7 +165,7 @@ func describeVpcMainRouteTables(svc *ec2.EC2, vpcID *string) []*RouteContainer {
 		// which are not associated with any subnet and hence are "main" tables
 		for _, association := range routeTable.Associations {
 			if *association.Main && len(routeTable.Associations) > 1 {
-				log.Fatal("Unexpected associations")
+				return nil, fmt.Errorf("Unexpected associations for main route table %s", *routeTable.RouteTableId)
 			}
 			if *association.Main {
 				route := RouteContainer{
@@ -217,67 +178,99 @@ func describeVpcMainRouteTables(svc *ec2.EC2, vpcID *string) []*RouteContainer {
 		}
 
 	}
-	return routes
+	return routes, nil
 }
 
-func getRoutes(subnetIDs ...string) []*RouteContainer {
-
-	sess := createSession()
-	svc := ec2.New(sess)
+// getRoutes returns the route tables of the subnets, the main route table of
+// its VPC for a subnet without a route table of its own
+func getRoutes(svc ec2iface.EC2API, subnetIDs ...string) ([]*RouteContainer, error) {
 
 	var routes []*RouteContainer
 
-	var subnetIDsInput []*string
-
-	for _, subnetID := range subnetIDs {
-		subnetIDsInput = append(subnetIDsInput, &subnetID)
-	}
-
 	input := &ec2.DescribeSubnetsInput{
 		Filters: []*ec2.Filter{
 			{
 				Name:   aws.String("subnet-id"),
-				Values: subnetIDsInput,
+				Values: aws.StringSlice(subnetIDs),
 			},
 		},
 	}
 
 	result, err := svc.DescribeSubnets(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
-		return nil
+		return nil, fmt.Errorf("Couldn't describe subnets %v: %w", subnetIDs, err)
 	}
 
-	if result == nil {
-		fmt.Println("Could not retrieve subnet details")
+	if result == nil || len(result.Subnets) == 0 {
+		return nil, newNotFoundError("Could not retrieve subnet details for %v", subnetIDs)
+	}
+
+	vpcIDs := make(map[string]*string)
+	for _, subnet := range result.Subnets {
+		vpcIDs[aws.StringValue(subnet.SubnetId)] = subnet.VpcId
+	}
+
+	// The route tables associated with the subnets are described in batches
+	var mu sync.Mutex
+	associated := make(map[string][]*RouteContainer)
+	err = describeInBatches(subnetIDs, func(batch []string) error {
+		r, err := describeSubnetAttachedRouteTables(svc, batch)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for subnetID, tables := range r {
+			associated[subnetID] = tables
+		}
 		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	var r []*RouteContainer
+	// The subnets without a route table of their own use the main route
+	// table of their VPC
+	mainTables := make(map[string][]*RouteContainer)
 	for _, subnetID := range subnetIDs {
-		r = describeSubnetAttachedRouteTables(svc, &subnetID)
-		if r == nil {
-			r = describeVpcMainRouteTables(svc, result.Subnets[0].VpcId)
+		r := associated[subnetID]
+		if len(r) == 0 {
+			vpcID := vpcIDs[subnetID]
+			if vpcID == nil {
+				vpcID = result.Subnets[0].VpcId
+			}
+			main, ok := mainTables[*vpcID]
+			if !ok {
+				err = retryThrottled(func() (err error) {
+					main, err = describeVpcMainRouteTables(svc, vpcID)
+					return
+				})
+				if err != nil {
+					return nil, err
+				}
+				mainTables[*vpcID] = main
+			}
+			r = main
 		}
 		for _, route := range r {
-			routes = append(routes, route)
+			// A copy for each subnet, which can share the main route table
+			route := *route
+			route.SubnetID = subnetID
+			routes = append(routes, &route)
 		}
 	}
 
-	return routes
+	return routes, nil
 }
 
 func displayResult(result ...*checkResult) {
 
+	// Results are part of the document for json/yaml output, and of the
+	// trace or the diagram with --explain or --graph
+	if !isTableOutput() || explainOutput || len(graphFormat) != 0 {
+		return
+	}
+
 	for _, r := range result {
 		if verboseOutput || debugOutput {
 			if r.Result {
@@ -301,24 +294,41 @@ func summarizeResults(results ...*checkResult) bool {
 	return true
 }
 
-func getEC2InstanceIDs(ec2Filters []*ec2.Filter, instanceIDs *[]*string) {
+// instanceListing is the listing of the instances for the picker. IDs is
+// appended to as the pages arrive, while the picker hot reloads it, and
+// the rows are made from the same describe calls.
+type instanceListing struct {
+	IDs  []*string
+	rows sync.Map
+}
+
+// row returns the picker row of the instance
+func (l *instanceListing) row(instanceID *string) string {
+	if v, ok := l.rows.Load(*instanceID); ok {
+		state := v.(*instanceState)
+		return fmt.Sprintf("[%s] - %s - %s", *instanceID, state.Name, state.State)
+	}
+	return fmt.Sprintf("[%s]", *instanceID)
+}
+
+func listEC2Instances(svc ec2iface.EC2API, ec2Filters []*ec2.Filter, listing *instanceListing) error {
 	var maxResults int64 = 10
 	params := &ec2.DescribeInstancesInput{
 		DryRun:     aws.Bool(false),
 		Filters:    ec2Filters,
 		MaxResults: &maxResults,
 	}
-	sess := createSession()
-	svc := ec2.New(sess)
 
 	for {
 		result, err := svc.DescribeInstances(params)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("Couldn't list instances: %w", err)
 		}
 		for _, r := range result.Reservations {
 			for _, instance := range r.Instances {
-				*instanceIDs = append(*instanceIDs, instance.InstanceId)
+				// The row is stored before the picker can show the instance
+				listing.rows.Store(*instance.InstanceId, newBasicInstanceState(instance))
+				listing.IDs = append(listing.IDs, instance.InstanceId)
 			}
 		}
 
@@ -328,17 +338,39 @@ func getEC2InstanceIDs(ec2Filters []*ec2.Filter, instanceIDs *[]*string) {
 			break
 		}
 	}
+	return nil
+}
 
+func getEC2InstanceIDs(svc ec2iface.EC2API, ec2Filters []*ec2.Filter, instanceIDs *[]*string) error {
+	var listing instanceListing
+	err := listEC2Instances(svc, ec2Filters, &listing)
+	*instanceIDs = append(*instanceIDs, listing.IDs...)
+	return err
 }
 
-func getBasicEC2InstanceData(ec2Filters []*ec2.Filter, instanceIds ...*string) []*instanceState {
+// newBasicInstanceState returns the state of an instance without the
+// details of its network interfaces
+func newBasicInstanceState(instance *ec2.Instance) *instanceState {
+	instanceState := instanceState{
+		InstanceId: *instance.InstanceId,
+		State:      *instance.State.Name,
+		LaunchTime: instance.LaunchTime,
+		Tags:       instance.Tags,
+	}
+	for _, tag := range instance.Tags {
+		if *tag.Key == "Name" {
+			instanceState.Name = *tag.Value
+		}
+	}
+	return &instanceState
+}
+
+func getBasicEC2InstanceData(svc ec2iface.EC2API, ec2Filters []*ec2.Filter, instanceIds ...*string) ([]*instanceState, error) {
 	params := &ec2.DescribeInstancesInput{
 		DryRun:      aws.Bool(false),
 		InstanceIds: instanceIds,
 		Filters:     ec2Filters,
 	}
-	sess := createSession()
-	svc := ec2.New(sess)
 
 	var instanceStates []*instanceState
 
@@ -346,162 +378,271 @@ func getBasicEC2InstanceData(ec2Filters []*ec2.Filter, instanceIds ...*string) [
 		func(result *ec2.DescribeInstancesOutput, lastPage bool) bool {
 			for _, r := range result.Reservations {
 				for _, instance := range r.Instances {
-					instanceState := instanceState{
-						InstanceId: *instance.InstanceId,
-						State:      *instance.State.Name,
-						LaunchTime: instance.LaunchTime,
-						Tags:       instance.Tags,
-					}
-					for _, tag := range instance.Tags {
-						if *tag.Key == "Name" {
-							instanceState.Name = *tag.Value
-						}
-					}
-					instanceStates = append(instanceStates, &instanceState)
+					instanceStates = append(instanceStates, newBasicInstanceState(instance))
 				}
 			}
 			return lastPage
 		})
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("Couldn't describe instances: %w", err)
 	}
 
-	return instanceStates
+	return instanceStates, nil
 }
 
-func getEC2InstanceData(ec2Filters []*ec2.Filter, instanceIds ...*string) []*instanceState {
+// newInstanceState returns the state of an instance, with the subnets, IP
+// addresses and security groups of its network interfaces looked up in
+// networkInterfaces (keyed by ID)
+func newInstanceState(instance *ec2.Instance, networkInterfaces map[string]*ec2.NetworkInterface) *instanceState {
+	instanceState := instanceState{
+		InstanceId: *instance.InstanceId,
+		State:      *instance.State.Name,
+		LaunchTime: instance.LaunchTime,
+		Tags:       instance.Tags,
+	}
+
+	if instance.IamInstanceProfile != nil {
+		instanceState.IAMProfile = *instance.IamInstanceProfile.Arn
+	}
+	if instance.PublicIpAddress != nil {
+		instanceState.PublicIP = *instance.PublicIpAddress
+	}
+
+	if instance.KeyName != nil {
+		instanceState.KeyName = *instance.KeyName
+	}
+
+	for _, attached := range instance.NetworkInterfaces {
+		ni, ok := networkInterfaces[aws.StringValue(attached.NetworkInterfaceId)]
+		if !ok || ni.SubnetId == nil || len(*ni.SubnetId) == 0 {
+			continue
+		}
+		instanceState.SubnetIds = append(instanceState.SubnetIds, *ni.SubnetId)
+		if instanceState.NetworkInterfaces == nil {
+			instanceState.NetworkInterfaces = make(map[string]string)
+		}
+		instanceState.NetworkInterfaces[*ni.NetworkInterfaceId] = *ni.SubnetId
+		// ni.PrivateIpAddresses is a superset of ni.PrivateIpAddress
+		for _, ip := range ni.PrivateIpAddresses {
+			instanceState.PrivateIPAddresses = append(instanceState.PrivateIPAddresses, *ip.PrivateIpAddress)
+		}
+		for _, ip := range ni.Ipv6Addresses {
+			instanceState.IPv6Addresses = append(instanceState.IPv6Addresses, *ip.Ipv6Address)
+		}
+		for _, sg := range ni.Groups {
+			instanceState.SecurityGroups = append(instanceState.SecurityGroups, sg)
+		}
+		instanceState.addAddressSecurityGroups(ni)
+		var deviceIndex int64
+		if attached.Attachment != nil {
+			deviceIndex = aws.Int64Value(attached.Attachment.DeviceIndex)
+		}
+		instanceState.addInterface(ni, deviceIndex)
+	}
+
+	// The primary private IP address goes first, it is the address used by
+	// e.g. load balancer targets
+	for i, ip := range instanceState.PrivateIPAddresses {
+		if ip == aws.StringValue(instance.PrivateIpAddress) {
+			copy(instanceState.PrivateIPAddresses[1:i+1], instanceState.PrivateIPAddresses[:i])
+			instanceState.PrivateIPAddresses[0] = ip
+			break
+		}
+	}
+
+	if instance.VpcId != nil {
+		instanceState.VpcID = *instance.VpcId
+	}
+
+	for _, tag := range instance.Tags {
+		if *tag.Key == "Name" {
+			instanceState.Name = *tag.Value
+		}
+	}
+	return &instanceState
+}
+
+// networkInterfaceState returns the state of a network interface which
+// isn't necessarily attached to an instance, such as a load balancer node
+func networkInterfaceState(ni *ec2.NetworkInterface) *instanceState {
+	state := &instanceState{
+		InstanceId:        *ni.NetworkInterfaceId,
+		State:             aws.StringValue(ni.Status),
+		VpcID:             aws.StringValue(ni.VpcId),
+		SecurityGroups:    ni.Groups,
+		NetworkInterfaces: map[string]string{*ni.NetworkInterfaceId: aws.StringValue(ni.SubnetId)},
+	}
+	if ni.SubnetId != nil {
+		state.SubnetIds = []string{*ni.SubnetId}
+	}
+	if ni.Association != nil && ni.Association.PublicIp != nil {
+		state.PublicIP = *ni.Association.PublicIp
+	}
+	for _, ip := range ni.PrivateIpAddresses {
+		state.PrivateIPAddresses = append(state.PrivateIPAddresses, *ip.PrivateIpAddress)
+	}
+	for _, ip := range ni.Ipv6Addresses {
+		state.IPv6Addresses = append(state.IPv6Addresses, *ip.Ipv6Address)
+	}
+	state.addAddressSecurityGroups(ni)
+	var deviceIndex int64
+	if ni.Attachment != nil {
+		deviceIndex = aws.Int64Value(ni.Attachment.DeviceIndex)
+	}
+	state.addInterface(ni, deviceIndex)
+	return state
+}
+
+// addAddressSecurityGroups records the security groups of the network
+// interface for each of its addresses
+func (s *instanceState) addAddressSecurityGroups(ni *ec2.NetworkInterface) {
+	if s.AddressSecurityGroups == nil {
+		s.AddressSecurityGroups = make(map[string][]*ec2.GroupIdentifier)
+	}
+	for _, ip := range ni.PrivateIpAddresses {
+		s.AddressSecurityGroups[*ip.PrivateIpAddress] = ni.Groups
+	}
+	for _, ip := range ni.Ipv6Addresses {
+		s.AddressSecurityGroups[*ip.Ipv6Address] = ni.Groups
+	}
+}
+
+// addInterface records the subnet, addresses and security groups of the
+// network interface
+func (s *instanceState) addInterface(ni *ec2.NetworkInterface, deviceIndex int64) {
+	iface := &interfaceState{
+		SubnetID:       aws.StringValue(ni.SubnetId),
+		DeviceIndex:    deviceIndex,
+		SecurityGroups: ni.Groups,
+	}
+	if ni.Association != nil {
+		iface.PublicIP = aws.StringValue(ni.Association.PublicIp)
+	}
+	for _, ip := range ni.PrivateIpAddresses {
+		iface.PrivateIPAddresses = append(iface.PrivateIPAddresses, *ip.PrivateIpAddress)
+	}
+	for _, ip := range ni.Ipv6Addresses {
+		iface.IPv6Addresses = append(iface.IPv6Addresses, *ip.Ipv6Address)
+	}
+	if s.Interfaces == nil {
+		s.Interfaces = make(map[string]*interfaceState)
+	}
+	s.Interfaces[*ni.NetworkInterfaceId] = iface
+}
+
+// parseTagFilters returns the filters for a comma separated list of
+// key:value tags, the value follows the last colon so that keys such as
+// aws:autoscaling:groupName can be used
+func parseTagFilters(tags string) ([]*ec2.Filter, error) {
+	var filters []*ec2.Filter
+	for _, tag := range strings.Split(tags, ",") {
+		tag = strings.TrimSpace(tag)
+		if !strings.Contains(tag, ":") {
+			return nil, newUsageError("Invalid tag %q, expected key:value", tag)
+		}
+		key := tag[0:strings.LastIndex(tag, ":")]
+		value := tag[strings.LastIndex(tag, ":")+1:]
+
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String("tag:" + key),
+			Values: []*string{aws.String(value)},
+		})
+	}
+	return filters, nil
+}
+
+// parseTagKeyFilters returns the filters for a comma separated list of tag
+// keys
+func parseTagKeyFilters(tagKeys string) []*ec2.Filter {
+	var filters []*ec2.Filter
+	for _, key := range strings.Split(tagKeys, ",") {
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String("tag-key"),
+			Values: []*string{aws.String(strings.TrimSpace(key))},
+		})
+	}
+	return filters
+}
+
+// getEC2InstanceData describes the instances and then the network
+// interfaces of all of them, in batches
+func getEC2InstanceData(svc ec2iface.EC2API, ec2Filters []*ec2.Filter, instanceIds ...*string) ([]*instanceState, error) {
 	params := &ec2.DescribeInstancesInput{
 		DryRun:      aws.Bool(false),
 		InstanceIds: instanceIds,
 		Filters:     ec2Filters,
 	}
-	sess := createSession()
-	svc := ec2.New(sess)
-
-	var instanceStates []*instanceState
 
-	err := svc.DescribeInstancesPages(params,
-		func(result *ec2.DescribeInstancesOutput, lastPage bool) bool {
-			for _, r := range result.Reservations {
-				for _, instance := range r.Instances {
-					instanceState := instanceState{
-						InstanceId: *instance.InstanceId,
-						State:      *instance.State.Name,
-						LaunchTime: instance.LaunchTime,
-						Tags:       instance.Tags,
-					}
-
-					if instance.IamInstanceProfile != nil {
-						instanceState.IAMProfile = *instance.IamInstanceProfile.Arn
-					}
-					if instance.PublicIpAddress != nil {
-						instanceState.PublicIP = *instance.PublicIpAddress
-					}
-
-					if instance.KeyName != nil {
-						instanceState.KeyName = *instance.KeyName
-					}
-
-					if len(instance.NetworkInterfaces) != 0 {
-
-						var networkInterfaces []*string
-						for _, ni := range instance.NetworkInterfaces {
-							networkInterfaces = append(networkInterfaces, ni.NetworkInterfaceId)
-
-						}
-						input := &ec2.DescribeNetworkInterfacesInput{
-							NetworkInterfaceIds: networkInterfaces,
-						}
-
-						result, err := svc.DescribeNetworkInterfaces(input)
-						if err != nil {
-							if aerr, ok := err.(awserr.Error); ok {
-								switch aerr.Code() {
-								default:
-									fmt.Println(aerr.Error())
-								}
-							} else {
-								// Print the error, cast err to awserr.Error to get the Code and
-								// Message from an error.
-								fmt.Println(err.Error())
-							}
-							log.Fatal(err)
-						}
-
-						for _, ni := range result.NetworkInterfaces {
-							if ni.SubnetId != nil && len(*ni.SubnetId) != 0 {
-								instanceState.SubnetIds = append(instanceState.SubnetIds, *ni.SubnetId)
-								// ni.PrivateIpAddresses is a superset of ni.PrivateIpAddress
-								/*if ni.PrivateIpAddress != nil {
-									instanceState.PrivateIPAddresses = append(instanceState.PrivateIPAddresses, *ni.PrivateIpAddress)
-									log.Printf("%s %s\n", *ni.NetworkInterfaceId, *ni.PrivateIpAddress)
-								}*/
-								if len(ni.PrivateIpAddresses) != 0 {
-									for _, ip := range ni.PrivateIpAddresses {
-										instanceState.PrivateIPAddresses = append(instanceState.PrivateIPAddresses, *ip.PrivateIpAddress)
-									}
-								}
-
-								if len(ni.Groups) != 0 {
-									for _, sg := range ni.Groups {
-										instanceState.SecurityGroups = append(instanceState.SecurityGroups, sg)
-
-									}
-
-								}
-							}
-						}
-					}
-
-					if instance.VpcId != nil {
-						instanceState.VpcID = *instance.VpcId
-					}
-
-					for _, tag := range instance.Tags {
-						if *tag.Key == "Name" {
-							instanceState.Name = *tag.Value
-						}
-					}
-					instanceStates = append(instanceStates, &instanceState)
+	var instances []*ec2.Instance
+	err := retryThrottled(func() error {
+		instances = nil
+		return svc.DescribeInstancesPages(params,
+			func(result *ec2.DescribeInstancesOutput, lastPage bool) bool {
+				for _, r := range result.Reservations {
+					instances = append(instances, r.Instances...)
 				}
-			}
-			return lastPage
-		})
+				return true
+			})
+	})
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("Couldn't describe instances: %w", err)
 	}
 
-	return instanceStates
+	var networkInterfaceIds []string
+	for _, instance := range instances {
+		for _, ni := range instance.NetworkInterfaces {
+			networkInterfaceIds = append(networkInterfaceIds, aws.StringValue(ni.NetworkInterfaceId))
+		}
+	}
+	networkInterfaces, err := describeNetworkInterfaces(svc, networkInterfaceIds)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe network interfaces: %w", err)
+	}
+
+	var instanceStates []*instanceState
+	for _, instance := range instances {
+		instanceStates = append(instanceStates, newInstanceState(instance, networkInterfaces))
+	}
+	return instanceStates, nil
 }
 
-func getInstanceKeyPairName(instanceID string) string {
+func getInstanceKeyPairName(svc ec2iface.EC2API, instanceID string) (string, error) {
 
 	instanceIds := []*string{&instanceID}
-	instanceData := getEC2InstanceData(nil, instanceIds...)
+	instanceData, err := getEC2InstanceData(svc, nil, instanceIds...)
+	if err != nil {
+		return "", err
+	}
 
 	for _, instance := range instanceData {
 		if len(instance.KeyName) != 0 {
-			return instance.KeyName
+			return instance.KeyName, nil
 		}
 	}
-	return ""
+	return "", nil
 }
 
-func rdpWindowsHelper(instanceID string, PrivateIP bool, PublicIP bool, ShowCommand bool, KeyPath string, password string) {
+func rdpWindowsHelper(svc ec2iface.EC2API, instanceID string, PrivateIP bool, PublicIP bool, ShowCommand bool, KeyPath string, password string) error {
 
 	var instanceIds []*string
 	if len(instanceID) != 0 {
 		instanceIds = append(instanceIds, &instanceID)
 	}
 
-	instanceData := getEC2InstanceData(nil, instanceIds...)
+	instanceData, err := getEC2InstanceData(svc, nil, instanceIds...)
+	if err != nil {
+		return err
+	}
 
 	if len(instanceData) == 0 {
-		log.Fatal("Instance data couldn't be retrieved")
+		return newNotFoundError("Instance data couldn't be retrieved")
 	} else if len(instanceData) > 1 {
-		idx, _ := fuzzyfinder.Find(instanceData, func(i int) string {
+		idx, err := fuzzyfinder.Find(instanceData, func(i int) string {
 			return fmt.Sprintf("[%s] %s %s", instanceData[i].InstanceId, instanceData[i].Name, instanceData[i].State)
 		})
+		if err != nil {
+			return err
+		}
 		instanceID = instanceData[idx].InstanceId
 	}
 
@@ -510,7 +651,10 @@ func rdpWindowsHelper(instanceID string, PrivateIP bool, PublicIP bool, ShowComm
 
 	if runtime.GOOS == "windows" {
 		if len(password) == 0 {
-			password = getWindowsPasswordHelper(instanceID, KeyPath)
+			password, err = getWindowsPasswordHelper(svc, instanceID, KeyPath)
+			if err != nil {
+				return err
+			}
 		}
 
 		cmdToExecute = "cmdkey.exe"
@@ -519,7 +663,7 @@ func rdpWindowsHelper(instanceID string, PrivateIP bool, PublicIP bool, ShowComm
 			cmdArgs = append(cmdArgs, fmt.Sprintf("/add:%s", instanceData[0].PublicIP))
 		} else if PrivateIP {
 			if len(instanceData[0].PrivateIPAddresses) != 1 {
-				panic("Instance has multiple private IP addresses. Not supported yet.")
+				return errors.New("Instance has multiple private IP addresses. Not supported yet.")
 			}
 			cmdArgs = append(cmdArgs, fmt.Sprintf("/add:%s", instanceData[0].PrivateIPAddresses[0]))
 		}
@@ -545,7 +689,7 @@ func rdpWindowsHelper(instanceID string, PrivateIP bool, PublicIP bool, ShowComm
 			cmdArgs = append(cmdArgs, fmt.Sprintf("/v:%s", instanceData[0].PublicIP))
 		} else if PrivateIP {
 			if len(instanceData[0].PrivateIPAddresses) != 1 {
-				panic("Instance has multiple private IP addresses. Not supported yet.")
+				return errors.New("Instance has multiple private IP addresses. Not supported yet.")
 			}
 			cmdArgs = append(cmdArgs, fmt.Sprintf("/add:%s", instanceData[0].PrivateIPAddresses[0]))
 		}
@@ -562,48 +706,43 @@ func rdpWindowsHelper(instanceID string, PrivateIP bool, PublicIP bool, ShowComm
 			}
 		}
 	}
+	return nil
 }
 
-func getWindowsPasswordHelper(instanceID string, privateKeyPath string) string {
+func getWindowsPasswordHelper(svc ec2iface.EC2API, instanceID string, privateKeyPath string) (string, error) {
 
 	if len(privateKeyPath) == 0 {
 		usr, _ := user.Current()
 		homeDir := usr.HomeDir
 
-		keyPairName := getInstanceKeyPairName(instanceID)
+		keyPairName, err := getInstanceKeyPairName(svc, instanceID)
+		if err != nil {
+			return "", err
+		}
 		if len(keyPairName) == 0 {
-			log.Fatal("Instance not launched using a key pair")
+			return "", newUsageError("Instance not launched using a key pair, specify the private key via --key-path")
 		}
 		log.Printf("No key specified. The instance was launched using keypair: %s\n", keyPairName)
 		privateKeyPath = filepath.Join(fmt.Sprintf("%s/.ssh/", homeDir), fmt.Sprintf("%s.pem", keyPairName))
 		log.Printf("Attempting to find private key in %s\n", privateKeyPath)
 	}
 
-	sess := createSession()
-	svc := ec2.New(sess)
-
 	passwordInput := ec2.GetPasswordDataInput{
 		InstanceId: &instanceID,
 	}
 
 	result, err := svc.GetPasswordData(&passwordInput)
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("Couldn't retrieve the password data of %s: %w", instanceID, err)
 	}
 
-	password, err := decryptWindowsPassword(privateKeyPath, *result.PasswordData)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return password
+	return decryptWindowsPassword(privateKeyPath, *result.PasswordData)
 }
 
-func getAutoScalingGroups(params *autoscaling.DescribeAutoScalingGroupsInput) []*autoscaling.Group {
+func getAutoScalingGroups(svc autoscalingiface.AutoScalingAPI, params *autoscaling.DescribeAutoScalingGroupsInput) ([]*autoscaling.Group, error) {
 
 	var autoScalingGroups []*autoscaling.Group
 
-	sess := createSession()
-	svc := autoscaling.New(sess)
 	err := svc.DescribeAutoScalingGroupsPages(params,
 		func(result *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
 			// When we support multiple ASG names, this will be a way
@@ -614,22 +753,9 @@ func getAutoScalingGroups(params *autoscaling.DescribeAutoScalingGroupsInput) []
 			return lastPage
 		})
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case autoscaling.ErrCodeInvalidNextToken:
-				fmt.Println(autoscaling.ErrCodeInvalidNextToken, aerr.Error())
-			case autoscaling.ErrCodeResourceContentionFault:
-				fmt.Println(autoscaling.ErrCodeResourceContentionFault, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
+		return nil, fmt.Errorf("Couldn't describe auto scaling groups: %w", err)
 	}
-	return autoScalingGroups
+	return autoScalingGroups, nil
 }
 
 func getTagsAsString(tags []*ec2.Tag, delim string) string {
@@ -652,9 +778,13 @@ func getTagsAsString(tags []*ec2.Tag, delim string) string {
 	return strTags
 }
 
-func displayEC2Interactive(instanceIDs *[]*string) {
-	selectedData := selectEC2InstanceInteractive(instanceIDs)
+func displayEC2Interactive(svc ec2iface.EC2API, listing *instanceListing) error {
+	selectedData, err := selectEC2InstanceInteractive(svc, listing)
+	if err != nil {
+		return err
+	}
 	displayFixedInstanceDetails(selectedData)
+	return nil
 }
 
 func getSecurityGroupNames(sg []*ec2.GroupIdentifier) []string {
@@ -667,16 +797,23 @@ func getSecurityGroupNames(sg []*ec2.GroupIdentifier) []string {
 
 }
 
-func selectEC2InstanceInteractive(instanceIDs *[]*string) *instanceState {
+func selectEC2InstanceInteractive(svc ec2iface.EC2API, listing *instanceListing) (*instanceState, error) {
 
 	var ec2Filters []*ec2.Filter
+	instanceIDs := &listing.IDs
 	var instanceData []*instanceState
 
 	previewFuncWindow := fuzzyfinder.WithPreviewWindow(func(i, w, h int) string {
 		if i == -1 {
 			return ""
 		}
-		instanceData = getEC2InstanceData(ec2Filters, (*instanceIDs)[i])
+		instanceData, err := getEC2InstanceData(svc, ec2Filters, (*instanceIDs)[i])
+		if err != nil {
+			return err.Error()
+		}
+		if len(instanceData) == 0 {
+			return ""
+		}
 
 		now := time.Now()
 		uptime := now.Sub(*instanceData[0].LaunchTime)
@@ -696,58 +833,54 @@ func selectEC2InstanceInteractive(instanceIDs *[]*string) *instanceState {
 		)
 	})
 
-	idx, _ := fuzzyfinder.Find(
+	idx, err := fuzzyfinder.Find(
 		instanceIDs,
 		func(i int) string {
-			instanceData = getBasicEC2InstanceData(ec2Filters, (*instanceIDs)[i])
-			return fmt.Sprintf("[%s] - %s - %s", *(*instanceIDs)[i], instanceData[0].Name, instanceData[0].State)
+			return listing.row((*instanceIDs)[i])
 		},
 		previewFuncWindow,
 		fuzzyfinder.WithHotReload(),
 	)
-	instanceData = getEC2InstanceData(ec2Filters, (*instanceIDs)[idx])
+	if err != nil {
+		return nil, err
+	}
+	// The instance may have changed since its details were cached
+	instanceData, err = getEC2InstanceData(uncachedEC2(svc), ec2Filters, (*instanceIDs)[idx])
+	if err != nil {
+		return nil, err
+	}
+	if len(instanceData) == 0 {
+		return nil, newNotFoundError("Instance %s not found", *(*instanceIDs)[idx])
+	}
 
-	return instanceData[0]
+	return instanceData[0], nil
 }
 
-func getVpcs() *ec2.DescribeVpcsOutput {
-	svc := ec2.New(session.New())
+func getVpcs(svc ec2iface.EC2API) (*ec2.DescribeVpcsOutput, error) {
 	input := &ec2.DescribeVpcsInput{}
 
 	result, err := svc.DescribeVpcs(input)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("Couldn't describe VPCs: %w", err)
 	}
-	return result
+	return result, nil
 }
 
-func getSubnets(input *ec2.DescribeSubnetsInput) []*ec2.Subnet {
-	var subnets []*ec2.Subnet
-	sess := createSession()
-	svc := ec2.New(sess)
+func getSubnets(svc ec2iface.EC2API, input *ec2.DescribeSubnetsInput) ([]*ec2.Subnet, error) {
 	result, err := svc.DescribeSubnets(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
-	} else {
-		subnets = result.Subnets
+		return nil, fmt.Errorf("Couldn't describe subnets: %w", err)
 	}
-
-	return subnets
+	return result.Subnets, nil
 }
 
-func selectVPCInteractive() string {
+func selectVPCInteractive(svc ec2iface.EC2API) (string, error) {
 
-	result := getVpcs()
-	idx, _ := fuzzyfinder.Find(result.Vpcs,
+	result, err := getVpcs(svc)
+	if err != nil {
+		return "", err
+	}
+	idx, err := fuzzyfinder.Find(result.Vpcs,
 		func(i int) string {
 			return fmt.Sprintf("%s", *result.Vpcs[i].VpcId)
 		},
@@ -769,13 +902,19 @@ func selectVPCInteractive() string {
 				*result.Vpcs[i].IsDefault,
 			)
 		}))
-	return *result.Vpcs[idx].VpcId
+	if err != nil {
+		return "", err
+	}
+	return *result.Vpcs[idx].VpcId, nil
 }
 
-func displayVPCDetails() {
+func displayVPCDetails(svc ec2iface.EC2API) error {
 	var subnetDetails string
-	result := getVpcs()
-	_, _ = fuzzyfinder.Find(result.Vpcs,
+	result, err := getVpcs(svc)
+	if err != nil {
+		return err
+	}
+	_, err = fuzzyfinder.Find(result.Vpcs,
 		func(i int) string {
 			return fmt.Sprintf("%s", *result.Vpcs[i].VpcId)
 		},
@@ -801,10 +940,17 @@ func displayVPCDetails() {
 				},
 			}
 
-			subnets := getSubnets(input)
+			subnets, err := getSubnets(svc, input)
+			if err != nil {
+				return err.Error()
+			}
 			subnetDetails = ""
 			for _, subnet := range subnets {
-				subnetDetails += fmt.Sprintf("%s (%s) - %s - %s\n", getSubnetName(subnet.Tags), getSubnetType(subnet.SubnetId), *subnet.SubnetId, *subnet.CidrBlock)
+				subnetType, err := getSubnetType(svc, subnet.SubnetId)
+				if err != nil {
+					return err.Error()
+				}
+				subnetDetails += fmt.Sprintf("%s (%s) - %s - %s\n", getSubnetName(subnet.Tags), subnetType, *subnet.SubnetId, *subnet.CidrBlock)
 			}
 
 			return fmt.Sprintf("Vpc: %s (%s) \nCIDR block: %s\nDefault: %v\n\nSubnets:\n\n%s\n",
@@ -815,34 +961,22 @@ func displayVPCDetails() {
 				subnetDetails,
 			)
 		}))
+	return err
 }
 
-func getDMSReplicationTasks() []*databasemigrationservice.ReplicationTask {
-	svc := databasemigrationservice.New(session.New())
+func getDMSReplicationTasks(svc databasemigrationserviceiface.DatabaseMigrationServiceAPI) ([]*databasemigrationservice.ReplicationTask, error) {
 	input := &databasemigrationservice.DescribeReplicationTasksInput{}
 
 	result, err := svc.DescribeReplicationTasks(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case databasemigrationservice.ErrCodeResourceNotFoundFault:
-				fmt.Println(databasemigrationservice.ErrCodeResourceNotFoundFault, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
-		return nil
+		return nil, fmt.Errorf("Couldn't describe replication tasks: %w", err)
 	}
-	return result.ReplicationTasks
+	return result.ReplicationTasks, nil
 }
 
-func displayDMSTaskStatusInteractive(taskData []*databasemigrationservice.ReplicationTask) {
+func displayDMSTaskStatusInteractive(svc databasemigrationserviceiface.DatabaseMigrationServiceAPI, taskData []*databasemigrationservice.ReplicationTask) error {
 
-	_, _ = fuzzyfinder.Find(taskData,
+	_, err := fuzzyfinder.Find(taskData,
 		func(i int) string {
 			return fmt.Sprintf("[%s] - %s", *taskData[i].ReplicationTaskIdentifier, *taskData[i].Status)
 		},
@@ -851,7 +985,10 @@ func displayDMSTaskStatusInteractive(taskData []*databasemigrationservice.Replic
 				return ""
 			}
 
-			tableStatistics := getTableStatistics(*taskData[i].ReplicationTaskArn)
+			tableStatistics, err := getTableStatistics(svc, *taskData[i].ReplicationTaskArn)
+			if err != nil {
+				return err.Error()
+			}
 			pendingValidation := 0
 			validated := 0
 			mismatched := 0
@@ -894,38 +1031,23 @@ func displayDMSTaskStatusInteractive(taskData []*databasemigrationservice.Replic
 				pendingValidation,
 			)
 		}))
-	//return instanceData[idx]
+	return err
 }
 
-func getTableStatistics(taskArn string) []*databasemigrationservice.TableStatistics {
-	svc := databasemigrationservice.New(session.New())
+func getTableStatistics(svc databasemigrationserviceiface.DatabaseMigrationServiceAPI, taskArn string) ([]*databasemigrationservice.TableStatistics, error) {
 	input := &databasemigrationservice.DescribeTableStatisticsInput{
 		ReplicationTaskArn: aws.String(taskArn),
 	}
 
 	result, err := svc.DescribeTableStatistics(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case databasemigrationservice.ErrCodeResourceNotFoundFault:
-				fmt.Println(databasemigrationservice.ErrCodeResourceNotFoundFault, aerr.Error())
-			case databasemigrationservice.ErrCodeInvalidResourceStateFault:
-				fmt.Println(databasemigrationservice.ErrCodeInvalidResourceStateFault, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
-		return nil
+		return nil, fmt.Errorf("Couldn't describe the table statistics of %s: %w", taskArn, err)
 	}
 
-	return result.TableStatistics
+	return result.TableStatistics, nil
 }
 
-func startSSHSessionLinux(instanceDetails []*instanceState, PrivateIP bool, PublicIP bool, KeyPath string, username string) {
+func startSSHSessionLinux(instanceDetails []*instanceState, PrivateIP bool, PublicIP bool, KeyPath string, username string) error {
 
 	var remoteIP string
 	if PublicIP {
@@ -936,13 +1058,13 @@ func startSSHSessionLinux(instanceDetails []*instanceState, PrivateIP bool, Publ
 
 	key, err := ioutil.ReadFile(KeyPath)
 	if err != nil {
-		log.Fatalf("unable to read private key: %v", err)
+		return fmt.Errorf("unable to read private key: %w", err)
 	}
 
 	// Create the Signer for this private key.
 	signer, err := ssh.ParsePrivateKey(key)
 	if err != nil {
-		log.Fatalf("unable to parse private key: %v", err)
+		return fmt.Errorf("unable to parse private key: %w", err)
 	}
 
 	config := &ssh.ClientConfig{
@@ -956,7 +1078,7 @@ func startSSHSessionLinux(instanceDetails []*instanceState, PrivateIP bool, Publ
 	// Connect to ssh server
 	conn, err := ssh.Dial("tcp", remoteIP+":22", config)
 	if err != nil {
-		log.Fatal("unable to connect: ", err)
+		return fmt.Errorf("unable to connect: %w", err)
 	}
 	defer conn.Close()
 
@@ -968,7 +1090,7 @@ func startSSHSessionLinux(instanceDetails []*instanceState, PrivateIP bool, Publ
 	// Create a session
 	session, err := conn.NewSession()
 	if err != nil {
-		log.Fatal("Unable to create session: ", err)
+		return fmt.Errorf("Unable to create session: %w", err)
 	}
 	defer session.Close()
 
@@ -985,12 +1107,12 @@ func startSSHSessionLinux(instanceDetails []*instanceState, PrivateIP bool, Publ
 	}
 	// Request pseudo terminal
 	if err := session.RequestPty("xterm", 40, 80, modes); err != nil {
-		log.Fatal("Request for pseudo terminal failed: ", err)
+		return fmt.Errorf("Request for pseudo terminal failed: %w", err)
 	}
 
 	// Start remote shell
 	if err := session.Shell(); err != nil {
-		log.Fatalf("Failed to start shell: %s", err)
+		return fmt.Errorf("Failed to start shell: %w", err)
 	}
 
 	// Accepting commands
@@ -1017,58 +1139,31 @@ func startSSHSessionLinux(instanceDetails []*instanceState, PrivateIP bool, Publ
 			} else {
 				fmt.Printf("Bye\n")
 			}
-			os.Exit(0)
+			return nil
 		}
 	}
 }
 
-func GetNetworkInterfaces(input *ec2.DescribeNetworkInterfacesInput) *ec2.DescribeNetworkInterfacesOutput {
-
-	sess := createSession()
-	svc := ec2.New(sess)
+func GetNetworkInterfaces(svc ec2iface.EC2API, input *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
 
 	result, err := svc.DescribeNetworkInterfaces(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
-		return nil
+		return nil, fmt.Errorf("Couldn't describe network interfaces: %w", err)
 	}
 
-	return result
+	return result, nil
 }
 
-func GetIAMRoleArnToAssume(projectName string, environmentName string) *string {
-	sess := createSession()
-	svc := iam.New(sess)
+// GetIAMRoleArnToAssume returns nil if the role's tags don't match the
+// project and environment
+func GetIAMRoleArnToAssume(svc iamiface.IAMAPI, projectName string, environmentName string) (*string, error) {
 	input := &iam.GetRoleInput{
 		RoleName: aws.String(fmt.Sprintf("%s-%s-humans", projectName, environmentName)),
 	}
 
 	result, err := svc.GetRole(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case iam.ErrCodeNoSuchEntityException:
-				fmt.Println(iam.ErrCodeNoSuchEntityException, aerr.Error())
-			case iam.ErrCodeServiceFailureException:
-				fmt.Println(iam.ErrCodeServiceFailureException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
-		return nil
+		return nil, fmt.Errorf("Couldn't get role %s: %w", *input.RoleName, err)
 	}
 
 	var expectedTagValueMap = map[string]string{
@@ -1081,11 +1176,11 @@ func GetIAMRoleArnToAssume(projectName string, environmentName string) *string {
 			if expectedTagValueMap[*t.Key] == *t.Value {
 				continue
 			} else {
-				return nil
+				return nil, nil
 			}
 		}
 	}
-	return result.Role.Arn
+	return result.Role.Arn, nil
 }
 
 func GetUserHomeDir() string {
@@ -1094,77 +1189,53 @@ func GetUserHomeDir() string {
 	return homeDir
 }
 
-func GetR53Zone(zoneId string) {
-	svc := route53.New(session.New())
+func GetR53Zone(svc route53iface.Route53API, zoneId string) error {
 	input := &route53.GetHostedZoneInput{
 		Id: aws.String(zoneId),
 	}
 
 	result, err := svc.GetHostedZone(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case route53.ErrCodeNoSuchHostedZone:
-				fmt.Println(route53.ErrCodeNoSuchHostedZone, aerr.Error())
-			case route53.ErrCodeInvalidInput:
-				fmt.Println(route53.ErrCodeInvalidInput, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
-		return
+		return fmt.Errorf("Couldn't get hosted zone %s: %w", zoneId, err)
 	}
 
 	fmt.Println(result)
+	return nil
+}
+
+type r53ZoneOutput struct {
+	Name    string `json:"name" yaml:"name"`
+	Id      string `json:"id" yaml:"id"`
+	Private bool   `json:"private" yaml:"private"`
+	Comment string `json:"comment,omitempty" yaml:"comment,omitempty"`
 }
 
-func ListR53Zones() {
-	svc := route53.New(session.New())
+func ListR53Zones(svc route53iface.Route53API) error {
 	input := &route53.ListHostedZonesInput{}
 
 	result, err := svc.ListHostedZones(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case route53.ErrCodeNoSuchHostedZone:
-				fmt.Println(route53.ErrCodeNoSuchHostedZone, aerr.Error())
-			case route53.ErrCodeInvalidInput:
-				fmt.Println(route53.ErrCodeInvalidInput, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
-		return
+		return fmt.Errorf("Couldn't list hosted zones: %w", err)
 	}
-	w := new(tabwriter.Writer)
-	w.Init(os.Stdout, 35, 0, 1, ' ', 0)
-	fmt.Fprintln(w, "Name \t ID \tPrivate\tComment\t")
-	fmt.Fprintln(w, "-----\t----------\t--------\t--------\t")
+	items := []r53ZoneOutput{}
+	table := newTableOutput("Name", "ID", "Private", "Comment")
 
 	for _, z := range result.HostedZones {
-
-		fmt.Fprintf(w, "%s\t", *z.Name)
-		fmt.Fprintf(w, "%s\t", *z.Id)
-		fmt.Fprintf(w, "%v\t", *z.Config.PrivateZone)
+		item := r53ZoneOutput{
+			Name:    *z.Name,
+			Id:      *z.Id,
+			Private: *z.Config.PrivateZone,
+		}
 		if z.Config.Comment != nil {
-			fmt.Fprintf(w, "%v\t", *z.Config.Comment)
+			item.Comment = *z.Config.Comment
 		}
-		fmt.Fprintf(w, "\n")
+		items = append(items, item)
+		table.addRow(item.Name, item.Id, fmt.Sprintf("%v", item.Private), item.Comment)
 	}
-	fmt.Fprintln(w)
-	w.Flush()
-
+	return renderItems("HostedZoneList", items, table)
 }
 
-func ListR53RecordSets(svc route53iface.Route53API, zoneId string) *route53.ListResourceRecordSetsOutput {
+func ListR53RecordSets(svc route53iface.Route53API, zoneId string) (*route53.ListResourceRecordSetsOutput, error) {
 
 	input := &route53.ListResourceRecordSetsInput{
 		HostedZoneId: &zoneId,
@@ -1172,48 +1243,18 @@ func ListR53RecordSets(svc route53iface.Route53API, zoneId string) *route53.List
 
 	result, err := svc.ListResourceRecordSets(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case route53.ErrCodeNoSuchHostedZone:
-				fmt.Println(route53.ErrCodeNoSuchHostedZone, aerr.Error())
-			case route53.ErrCodeInvalidInput:
-				fmt.Println(route53.ErrCodeInvalidInput, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
-		return nil
+		return nil, fmt.Errorf("Couldn't list the record sets of %s: %w", zoneId, err)
 	}
-	return result
+	return result, nil
 
 }
 
-func GetRoute53ZoneID(zoneName string) (string, error) {
-
-	svc := route53.New(session.New())
+func GetRoute53ZoneID(svc route53iface.Route53API, zoneName string) (string, error) {
 	input := &route53.ListHostedZonesInput{}
 
 	result, err := svc.ListHostedZones(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case route53.ErrCodeNoSuchHostedZone:
-				fmt.Println(route53.ErrCodeNoSuchHostedZone, aerr.Error())
-			case route53.ErrCodeInvalidInput:
-				fmt.Println(route53.ErrCodeInvalidInput, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
-		}
-		return "", err
+		return "", fmt.Errorf("Couldn't list hosted zones: %w", err)
 	}
 
 	for _, z := range result.HostedZones {
@@ -1222,6 +1263,6 @@ func GetRoute53ZoneID(zoneName string) (string, error) {
 			return *z.Id, nil
 		}
 	}
-	return "", errors.New("No Route53 hosted zone found")
+	return "", newNotFoundError("No Route53 hosted zone found for %s", zoneName)
 
 }
@@ -54,7 +54,10 @@ func (m *mockRoute53Client) ListResourceRecordSets(input *route53.ListResourceRe
 func TestListR53Records(t *testing.T) {
 
 	mockSvc := &mockRoute53Client{}
-	rs := ListR53RecordSets(mockSvc, "example.org")
+	rs, err := ListR53RecordSets(mockSvc, "example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(rs.ResourceRecordSets) != 1 {
 		t.Errorf("Expected 1 resource record set, got %v \n", len(rs.ResourceRecordSets))
 	}
@@ -158,7 +161,14 @@ func TestCopyRoute53RecordsToCloudflare(t *testing.T) {
 	mux.HandleFunc("/zones/023e105f4ecef8ad9ca31a8372d0c353/dns_records", zoneDNSRecordsHandler)
 
 	mockSvc := &mockRoute53Client{}
-	cfRecords := AddRecordsToCloudflare(cfClient, "example.org", ListR53RecordSets(mockSvc, "example.org"))
+	rs, err := ListR53RecordSets(mockSvc, "example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfRecords, err := AddRecordsToCloudflare(cfClient, "example.org", rs)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(cfRecords) != 5 {
 		t.Errorf("Expected 5 cloudflare records to be created, got %v \n", len(cfRecords))
 	}
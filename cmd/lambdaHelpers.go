@@ -0,0 +1,110 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+// isLambdaSource reports whether a source is a Lambda function, either
+// lambda:<name> or the ARN of a function
+func isLambdaSource(source string) bool {
+	if strings.HasPrefix(source, "lambda:") {
+		return true
+	}
+	parsed, err := arn.Parse(source)
+	return err == nil && parsed.Service == "lambda" && strings.HasPrefix(parsed.Resource, "function:")
+}
+
+func getLambdaFunction(svc lambdaiface.LambdaAPI, source string) (*lambda.FunctionConfiguration, error) {
+	name := strings.TrimPrefix(source, "lambda:")
+	fn, err := svc.GetFunctionConfiguration(&lambda.GetFunctionConfigurationInput{FunctionName: aws.String(name)})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't get the configuration of Lambda function %s: %w", name, err)
+	}
+	return fn, nil
+}
+
+// lambdaSubnetStates returns a state for each subnet of the function's VPC
+// configuration, since an invocation can run in any of them. Functions which
+// aren't attached to a VPC don't have any.
+func lambdaSubnetStates(fn *lambda.FunctionConfiguration) []*instanceState {
+	if fn.VpcConfig == nil {
+		return nil
+	}
+	var securityGroups []*ec2.GroupIdentifier
+	for _, groupID := range fn.VpcConfig.SecurityGroupIds {
+		securityGroups = append(securityGroups, &ec2.GroupIdentifier{GroupId: groupID})
+	}
+
+	var states []*instanceState
+	for _, subnetID := range fn.VpcConfig.SubnetIds {
+		states = append(states, &instanceState{
+			InstanceId:     aws.StringValue(fn.FunctionArn),
+			Name:           *fn.FunctionName,
+			VpcID:          aws.StringValue(fn.VpcConfig.VpcId),
+			SubnetIds:      []string{*subnetID},
+			SecurityGroups: securityGroups,
+		})
+	}
+	return states
+}
+
+// checkLambdaServiceNetwork checks the destination of a function which isn't
+// attached to a VPC. Its traffic leaves from the Lambda service network,
+// which only reaches public addresses, from any public IPv4 address, so the
+// destination side is checked for traffic from anywhere.
+func checkLambdaServiceNetwork(fn *lambda.FunctionConfiguration, dest *connectivityDestination, protocol string, ports *ec2.PortRange, ephemeralPorts *ec2.PortRange) []*checkResult {
+	var result []*checkResult
+	for _, network := range dest.resolveNetworks() {
+		r := newCheckResult()
+		r.Result = isPublicNetwork(network)
+		r.DisplayText = fmt.Sprintf("Lambda function %s isn't attached to a VPC, the Lambda service network can reach %s", *fn.FunctionName, network)
+		r.Stage = stageRoute
+		result = append(result, &r)
+	}
+	if dest.state != nil {
+		_, anywhere, _ := net.ParseCIDR("0.0.0.0/0")
+		result = append(result, checkConnectivityFromAddress(anywhere, dest, protocol, ports, ephemeralPorts)...)
+	}
+	return result
+}
+
+// checkLambdaConnectivity runs the checks from each subnet of the function,
+// the results are labelled with the subnet
+func checkLambdaConnectivity(sources []*instanceState, dest *connectivityDestination, protocol string, ports *ec2.PortRange, ephemeralPorts *ec2.PortRange) []*checkResult {
+	var result []*checkResult
+	for _, source := range sources {
+		subnetID := source.SubnetIds[0]
+		sourceNetwork, err := parseNetwork(source.SubnetCIDRs[subnetID])
+		if err != nil {
+			continue
+		}
+		for _, r := range checkConnectivityToDestination(source, []*net.IPNet{sourceNetwork}, dest, protocol, ports, ephemeralPorts) {
+			r.DisplayText = subnetID + ": " + r.DisplayText
+			r.Metadata["SourceSubnet"] = subnetID
+			result = append(result, r)
+		}
+	}
+	return result
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLambdaConnectivity(t *testing.T) {
+	c := newConnectivityFixture()
+	// subnet-c only allows traffic to 10.0.4.0/24
+	c.ec2.Subnets = append(c.ec2.Subnets, &ec2.Subnet{SubnetId: aws.String("subnet-c"), VpcId: aws.String("vpc-1"), CidrBlock: aws.String("10.0.3.0/24")})
+	c.ec2.NetworkAcls = append(c.ec2.NetworkAcls, &ec2.NetworkAcl{
+		NetworkAclId: aws.String("acl-c"),
+		VpcId:        aws.String("vpc-1"),
+		Associations: []*ec2.NetworkAclAssociation{{SubnetId: aws.String("subnet-c")}},
+		Entries: []*ec2.NetworkAclEntry{
+			{RuleNumber: aws.Int64(100), Egress: aws.Bool(true), Protocol: aws.String("-1"), CidrBlock: aws.String("10.0.4.0/24"), RuleAction: aws.String("allow")},
+			{RuleNumber: aws.Int64(100), Egress: aws.Bool(false), Protocol: aws.String("-1"), CidrBlock: aws.String("0.0.0.0/0"), RuleAction: aws.String("allow")},
+		},
+	})
+	c.lambda.Functions = []*lambda.FunctionConfiguration{
+		{
+			FunctionName: aws.String("my-fn"),
+			FunctionArn:  aws.String("arn:aws:lambda:us-east-1:123456789012:function:my-fn"),
+			VpcConfig: &lambda.VpcConfigResponse{
+				VpcId:            aws.String("vpc-1"),
+				SubnetIds:        aws.StringSlice([]string{"subnet-a", "subnet-c"}),
+				SecurityGroupIds: aws.StringSlice([]string{"sg-src"}),
+			},
+		},
+		{FunctionName: aws.String("no-vpc")},
+	}
+
+	assert.True(t, isLambdaSource("lambda:my-fn"))
+	assert.True(t, isLambdaSource("arn:aws:lambda:us-east-1:123456789012:function:my-fn"))
+	assert.False(t, isLambdaSource("i-src"))
+
+	fn, err := getLambdaFunction(c.Lambda(), "arn:aws:lambda:us-east-1:123456789012:function:my-fn")
+	if !assert.NoError(t, err) {
+		return
+	}
+	sources := lambdaSubnetStates(fn)
+	dest, err := getConnectivityDestination(c.EC2(), c.RDS(), "i-dst", "", false)
+	if !assert.NoError(t, err) || !assert.NoError(t, enrichInstanceStates(c.EC2(), append(sources, dest.state)...)) {
+		return
+	}
+
+	port := &ec2.PortRange{From: aws.Int64(5432), To: aws.Int64(5432)}
+	results := checkLambdaConnectivity(sources, dest, "tcp", port, &defaultEphermalPortRange)
+	bySubnet := make(map[string][]*checkResult)
+	for _, r := range results {
+		subnetID := r.Metadata["SourceSubnet"].(string)
+		bySubnet[subnetID] = append(bySubnet[subnetID], r)
+	}
+	assert.True(t, allPassed(bySubnet["subnet-a"]))
+	assert.False(t, allPassed(bySubnet["subnet-c"]))
+
+	// Functions which aren't attached to a VPC only reach public addresses
+	fn, _ = getLambdaFunction(c.Lambda(), "lambda:no-vpc")
+	assert.Empty(t, lambdaSubnetStates(fn))
+	assert.False(t, allPassed(checkLambdaServiceNetwork(fn, dest, "tcp", port, &defaultEphermalPortRange)))
+	dest, _ = getConnectivityDestination(c.EC2(), c.RDS(), "8.8.8.8", "", false)
+	assert.True(t, allPassed(checkLambdaServiceNetwork(fn, dest, "tcp", port, &defaultEphermalPortRange)))
+
+	_, err = getLambdaFunction(c.Lambda(), "lambda:missing")
+	assert.Equal(t, exitNotFound, exitCode(err))
+}
+
+func TestLambdaServiceNetworkToPublicIP(t *testing.T) {
+	c := newConnectivityFixture()
+	c.ec2.Instances[1].PublicIpAddress = aws.String("54.0.0.20")
+	c.lambda.Functions = []*lambda.FunctionConfiguration{{FunctionName: aws.String("no-vpc")}}
+	fn, err := getLambdaFunction(c.Lambda(), "lambda:no-vpc")
+	if !assert.NoError(t, err) {
+		return
+	}
+	port := &ec2.PortRange{From: aws.Int64(5432), To: aws.Int64(5432)}
+	check := func() []*checkResult {
+		dest, err := getConnectivityDestination(c.EC2(), c.RDS(), "i-dst", "", true)
+		if !assert.NoError(t, err) || !assert.NoError(t, enrichInstanceStates(c.EC2(), dest.state)) {
+			t.FailNow()
+		}
+		return checkLambdaServiceNetwork(fn, dest, "tcp", port, &defaultEphermalPortRange)
+	}
+
+	// The public address is reachable, but i-dst only allows 10.0.1.0/24 and
+	// has no route back to the internet
+	results := check()
+	assert.True(t, results[0].Result)
+	assert.False(t, allPassed(results))
+
+	c.ec2.RouteTables[0].Routes = append(c.ec2.RouteTables[0].Routes, &ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-1"), State: aws.String("active")})
+	for _, entry := range c.ec2.NetworkAcls[1].Entries[:2] {
+		entry.CidrBlock = aws.String("0.0.0.0/0")
+	}
+	c.ec2.NetworkAcls[1].Entries[1].PortRange = &ec2.PortRange{From: aws.Int64(1024), To: aws.Int64(65535)}
+	c.ec2.SecurityGroups[1].IpPermissions[0].IpRanges = []*ec2.IpRange{{CidrIp: aws.String("0.0.0.0/0")}}
+	assert.True(t, allPassed(check()))
+
+	_, err = getLambdaFunction(c.Lambda(), "lambda:missing")
+	assert.Equal(t, exitNotFound, exitCode(err))
+}
@@ -0,0 +1,315 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/spf13/cobra"
+)
+
+// Maximum number of accounts/regions we query at the same time
+const maxListWorkers = 8
+
+var (
+	allRegions  bool
+	regionsList []string
+)
+
+// resourceLocation records the account and region an item was found in when
+// listing across accounts or regions. It is embedded in the listed items.
+type resourceLocation struct {
+	Account string `json:"account,omitempty" yaml:"account,omitempty"`
+	Region  string `json:"region,omitempty" yaml:"region,omitempty"`
+}
+
+func (l resourceLocation) String() string {
+	if len(l.Account) != 0 {
+		return l.Account + "/" + l.Region
+	}
+	return l.Region
+}
+
+// listTarget is an account and region to list resources in. account is nil
+// when using the credentials yawsi was run with.
+type listTarget struct {
+	resourceLocation
+	account *accountConfig
+}
+
+// targetClients returns the clients to use for a target. Tests replace it
+// to return fakes.
+var targetClients = func(t listTarget) awsClientProvider {
+	if t.account != nil {
+		return newSessionClients(createAccountSession(t.account, t.Region))
+	}
+	return newSessionClients(createSession(t.Region))
+}
+
+// listError records why we couldn't list the resources in an account/region
+type listError struct {
+	resourceLocation `yaml:",inline"`
+	Error            string `json:"error" yaml:"error"`
+}
+
+// addListingFlags adds the flags to list resources across regions and
+// accounts to a command
+func addListingFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&allRegions, "all-regions", "", false, "Query all the regions enabled for the account")
+	cmd.Flags().StringSliceVarP(&regionsList, "regions", "", nil, "Comma separated list of regions to query (Example: us-east-1,eu-west-1)")
+	cmd.Flags().BoolVarP(&allAccounts, "all-accounts", "", false, "Query all the accounts in the accounts file")
+	cmd.Flags().StringSliceVarP(&accountsList, "accounts", "", nil, "Comma separated list of account aliases or IDs from the accounts file to query")
+	cmd.Flags().StringVarP(&accountsFilePath, "accounts-file", "", "", "Accounts file (default is ~/.config/yawsi/accounts.toml)")
+}
+
+func isMultiRegion() bool {
+	return allRegions || len(regionsList) != 0
+}
+
+func isMultiAccount() bool {
+	return allAccounts || len(accountsList) != 0
+}
+
+// isMultiTarget is true when a command has to list resources in more than
+// the current account and region
+func isMultiTarget() bool {
+	return isMultiRegion() || isMultiAccount()
+}
+
+func specifiedRegions() []string {
+	var regions []string
+	for _, region := range regionsList {
+		if region = strings.TrimSpace(region); len(region) != 0 {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+func describeRegions(c awsClientProvider) ([]string, error) {
+	result, err := c.EC2().DescribeRegions(&ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't list regions: %w", err)
+	}
+	var regions []string
+	for _, r := range result.Regions {
+		regions = append(regions, aws.StringValue(r.RegionName))
+	}
+	sort.Strings(regions)
+	return regions, nil
+}
+
+// runWorkers calls f for 0..n-1 using at most maxListWorkers goroutines
+func runWorkers(n int, f func(i int)) {
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < maxListWorkers && i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+}
+
+// listTargets returns the accounts and regions specified via the flags
+// added by addListingFlags. The regions for an account are --regions, all
+// the regions enabled in the account for --all-regions, the regions in the
+// accounts file or the current region, in that order.
+func listTargets() ([]listTarget, []listError, error) {
+	if !isMultiAccount() {
+		regions := specifiedRegions()
+		if len(regions) == 0 {
+			var err error
+			if regions, err = describeRegions(getClients()); err != nil {
+				return nil, nil, err
+			}
+		}
+		var targets []listTarget
+		for _, region := range regions {
+			targets = append(targets, listTarget{resourceLocation: resourceLocation{Region: region}})
+		}
+		return targets, nil, nil
+	}
+
+	accounts, err := selectedAccounts()
+	if err != nil {
+		return nil, nil, err
+	}
+	currentRegion := aws.StringValue(createSession().Config.Region)
+
+	accountRegions := make([][]string, len(accounts))
+	accountErrors := make([]error, len(accounts))
+	runWorkers(len(accounts), func(i int) {
+		switch {
+		case len(regionsList) != 0:
+			accountRegions[i] = specifiedRegions()
+		case allRegions:
+			t := listTarget{resourceLocation{Account: accounts[i].name(), Region: currentRegion}, accounts[i]}
+			accountRegions[i], accountErrors[i] = describeRegions(targetClients(t))
+		case len(accounts[i].Regions) != 0:
+			accountRegions[i] = accounts[i].Regions
+		default:
+			accountRegions[i] = []string{currentRegion}
+		}
+	})
+
+	var targets []listTarget
+	var errors []listError
+	for i, account := range accounts {
+		if accountErrors[i] != nil {
+			errors = append(errors, listError{resourceLocation{Account: account.name()}, accountErrors[i].Error()})
+			continue
+		}
+		for _, region := range accountRegions[i] {
+			targets = append(targets, listTarget{resourceLocation{Account: account.name(), Region: region}, account})
+		}
+	}
+	return targets, errors, nil
+}
+
+type listResult struct {
+	items interface{}
+	table *tableOutput
+}
+
+// listing holds the items listed in each account/region so that they can be
+// rendered as a single list
+type listing struct {
+	mu      sync.Mutex
+	targets []listTarget
+	results map[resourceLocation]listResult
+	errors  []listError
+}
+
+// listFunc returns a slice of items and the table to display for an
+// account/region, both including the location
+type listFunc func(loc resourceLocation, c awsClientProvider) (interface{}, *tableOutput, error)
+
+// listInTargets calls list for each target concurrently. An error in one
+// account or region doesn't stop the others.
+func listInTargets(targets []listTarget, list listFunc) *listing {
+	l := &listing{targets: targets, results: make(map[resourceLocation]listResult)}
+
+	runWorkers(len(targets), func(i int) {
+		t := targets[i]
+		items, table, err := list(t.resourceLocation, targetClients(t))
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if err != nil {
+			l.errors = append(l.errors, listError{t.resourceLocation, err.Error()})
+		} else {
+			l.results[t.resourceLocation] = listResult{items: items, table: table}
+		}
+	})
+	return l
+}
+
+// merge returns the items and the table rows from all the targets in the
+// order of the targets
+func (l *listing) merge() ([]interface{}, *tableOutput) {
+	items := []interface{}{}
+	var table *tableOutput
+	for _, t := range l.targets {
+		result, ok := l.results[t.resourceLocation]
+		if !ok {
+			continue
+		}
+		if table == nil {
+			table = newTableOutput(result.table.Headers...)
+		}
+		table.Rows = append(table.Rows, result.table.Rows...)
+
+		v := reflect.ValueOf(result.items)
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, v.Index(i).Interface())
+		}
+	}
+	if table == nil {
+		table = newTableOutput()
+	}
+	return items, table
+}
+
+func (l *listing) sortErrors() {
+	sort.Slice(l.errors, func(i, j int) bool { return l.errors[i].String() < l.errors[j].String() })
+}
+
+// render writes the merged listing. Errors are part of the document for
+// JSON/YAML output and are written to stderr for table output.
+func (l *listing) render(kind string) error {
+	items, table := l.merge()
+	if isTableOutput() {
+		if err := writeTable(table); err != nil {
+			return err
+		}
+		l.reportErrors()
+		return nil
+	}
+	return l.writeDocument(kind, items)
+}
+
+func (l *listing) reportErrors() {
+	l.sortErrors()
+	for _, e := range l.errors {
+		fmt.Fprintf(os.Stderr, "Error listing resources in %s: %s\n", e.String(), e.Error)
+	}
+}
+
+func (l *listing) writeDocument(kind string, items []interface{}) error {
+	l.sortErrors()
+	return writeDocument(outputDocument{
+		APIVersion: outputAPIVersion,
+		Kind:       kind,
+		Items:      items,
+		Errors:     l.errors,
+	})
+}
+
+// listAll lists the resources in the accounts and regions specified via the
+// flags added by addListingFlags
+func listAll(list listFunc) (*listing, error) {
+	targets, errors, err := listTargets()
+	if err != nil {
+		return nil, err
+	}
+	l := listInTargets(targets, list)
+	l.errors = append(l.errors, errors...)
+	return l, nil
+}
+
+// renderAll lists the resources using listAll and renders them as a single
+// list
+func renderAll(kind string, list listFunc) error {
+	l, err := listAll(list)
+	if err != nil {
+		return err
+	}
+	return l.render(kind)
+}
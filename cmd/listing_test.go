@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// deniedEC2 fails like a region disabled by an SCP
+type deniedEC2 struct {
+	*fakeEC2
+}
+
+func (f *deniedEC2) DescribeVpcs(input *ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
+	return nil, awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil)
+}
+
+type deniedClients struct {
+	*fakeClients
+}
+
+func (c *deniedClients) EC2() ec2iface.EC2API { return &deniedEC2{c.ec2} }
+
+// withListingFakes uses the fakes for each "account/region" or "region"
+func withListingFakes(t *testing.T, fakes map[string]awsClientProvider) func() {
+	oldClients, oldTargetClients := clients, targetClients
+
+	c := newFakeClients()
+	for name := range fakes {
+		if !strings.Contains(name, "/") {
+			c.ec2.Regions = append(c.ec2.Regions, &ec2.Region{RegionName: aws.String(name)})
+		}
+	}
+	clients = c
+	targetClients = func(t listTarget) awsClientProvider {
+		return fakes[t.String()]
+	}
+	return func() {
+		clients, targetClients = oldClients, oldTargetClients
+		allRegions, regionsList = false, nil
+		allAccounts, accountsList, accountsFilePath = false, nil, ""
+	}
+}
+
+func newVpcFakes(vpcID string, cidr string) *fakeClients {
+	c := newFakeClients()
+	c.ec2.Vpcs = []*ec2.Vpc{
+		{VpcId: aws.String(vpcID), CidrBlock: aws.String(cidr), IsDefault: aws.Bool(false)},
+	}
+	return c
+}
+
+type listDocument struct {
+	Items  []json.RawMessage
+	Errors []listError
+}
+
+func decodeListDocument(t *testing.T, out string, items interface{}) listDocument {
+	var doc listDocument
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(doc.Items)
+	if err := json.Unmarshal(data, items); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestListAllVpcsInRegions(t *testing.T) {
+	defer withListingFakes(t, map[string]awsClientProvider{
+		"us-east-1":  newVpcFakes("vpc-1", "10.0.0.0/16"),
+		"eu-west-1":  newVpcFakes("vpc-2", "10.1.0.0/16"),
+		"ap-south-1": &deniedClients{newFakeClients()},
+	})()
+	allRegions = true
+
+	out := captureOutput(outputJSON, func() {
+		assert.NoError(t, listAllVpcs())
+	})
+
+	var vpcs []vpcOutput
+	doc := decodeListDocument(t, out, &vpcs)
+	if assert.Len(t, vpcs, 2) {
+		// Sorted by region
+		assert.Equal(t, "eu-west-1", vpcs[0].Region)
+		assert.Equal(t, "vpc-2", vpcs[0].VpcId)
+		assert.Equal(t, "us-east-1", vpcs[1].Region)
+	}
+	if assert.Len(t, doc.Errors, 1) {
+		assert.Equal(t, "ap-south-1", doc.Errors[0].Region)
+		assert.Contains(t, doc.Errors[0].Error, "UnauthorizedOperation")
+	}
+}
+
+func TestListAllVpcsTable(t *testing.T) {
+	defer withListingFakes(t, map[string]awsClientProvider{
+		"us-east-1": newVpcFakes("vpc-1", "10.0.0.0/16"),
+		"eu-west-1": newVpcFakes("vpc-2", "10.1.0.0/16"),
+	})()
+	regionsList = []string{"us-east-1", " eu-west-1"}
+
+	out := captureOutput(outputTable, func() {
+		assert.NoError(t, listAllVpcs())
+	})
+	lines := strings.Split(out, "\n")
+	assert.True(t, strings.HasPrefix(lines[0], "Region"))
+	// In the order the regions were specified
+	assert.Contains(t, lines[2], "us-east-1")
+	assert.Contains(t, lines[3], "eu-west-1")
+}
+
+const testAccountsFile = `
+[[account]]
+id = "111111111111"
+alias = "production"
+role_name = "ReadOnly"
+regions = ["us-east-1", "eu-west-1"]
+
+[[account]]
+id = "222222222222"
+role_name = "ReadOnly"
+`
+
+func writeAccountsFile(t *testing.T, contents string) func() {
+	f, err := ioutil.TempFile("", "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(contents)
+	f.Close()
+	accountsFilePath = f.Name()
+	return func() { os.Remove(f.Name()) }
+}
+
+func TestLoadAccounts(t *testing.T) {
+	defer writeAccountsFile(t, testAccountsFile)()
+	defer func() { accountsFilePath = "" }()
+
+	accounts, err := loadAccounts(accountsFilePath)
+	if assert.NoError(t, err) && assert.Len(t, accounts, 2) {
+		assert.Equal(t, "arn:aws:iam::111111111111:role/ReadOnly", accounts[0].roleArn())
+		assert.Equal(t, "production", accounts[0].name())
+		assert.Equal(t, "222222222222", accounts[1].name())
+	}
+
+	accountsList = []string{"staging"}
+	defer func() { accountsList = nil }()
+	_, err = selectedAccounts()
+	assert.Equal(t, exitUsage, exitCode(err))
+
+	defer writeAccountsFile(t, "[[account]]\nid = \"1234\"\nrole_name = \"ReadOnly\"\n")()
+	_, err = loadAccounts(accountsFilePath)
+	assert.Equal(t, exitUsage, exitCode(err))
+}
+
+func TestLocateAcrossAccounts(t *testing.T) {
+	defer withListingFakes(t, map[string]awsClientProvider{
+		"production/us-east-1":   newVpcFakes("vpc-1", "10.20.0.0/16"),
+		"production/eu-west-1":   newVpcFakes("vpc-2", "10.30.0.0/16"),
+		"222222222222/us-west-2": newVpcFakes("vpc-3", "10.20.128.0/17"),
+	})()
+	defer writeAccountsFile(t, testAccountsFile)()
+	accountsList = []string{"production", "222222222222"}
+	awsRegion = "us-west-2"
+	defer func() { awsRegion = "" }()
+
+	locate, err := newLocator("10.20.0.0/16")
+	if !assert.NoError(t, err) {
+		return
+	}
+	out := captureOutput(outputJSON, func() {
+		assert.NoError(t, renderAll("LocationList", locate))
+	})
+
+	var matches []locateOutput
+	doc := decodeListDocument(t, out, &matches)
+	assert.Empty(t, doc.Errors)
+	if assert.Len(t, matches, 2) {
+		assert.Equal(t, resourceLocation{Account: "production", Region: "us-east-1"}, matches[0].resourceLocation)
+		assert.Equal(t, "vpc-1", matches[0].ResourceId)
+		// The account without regions in the accounts file uses the current region
+		assert.Equal(t, resourceLocation{Account: "222222222222", Region: "us-west-2"}, matches[1].resourceLocation)
+		assert.Equal(t, "vpc-3", matches[1].ResourceId)
+	}
+
+	accountsList = []string{"staging"}
+	assert.Error(t, renderAll("LocationList", locate))
+}
+
+func TestLocateIPAddress(t *testing.T) {
+	c := newConnectivityFixture()
+	locate, err := newLocator("10.0.2.20")
+	if !assert.NoError(t, err) {
+		return
+	}
+	items, _, err := locate(resourceLocation{}, c)
+	if assert.NoError(t, err) {
+		matches := items.([]locateOutput)
+		if assert.Len(t, matches, 2) {
+			assert.Equal(t, "eni-dst", matches[0].ResourceId)
+			assert.Equal(t, "attached to i-dst", matches[0].Detail)
+			assert.Equal(t, "subnet-b", matches[1].ResourceId)
+		}
+	}
+
+	_, err = newLocator("not-an-ip")
+	assert.Error(t, err)
+}
@@ -0,0 +1,243 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/spf13/cobra"
+)
+
+type locateOutput struct {
+	resourceLocation `yaml:",inline"`
+	ResourceType     string `json:"resourceType" yaml:"resourceType"`
+	ResourceId       string `json:"resourceId" yaml:"resourceId"`
+	VpcId            string `json:"vpcId,omitempty" yaml:"vpcId,omitempty"`
+	SubnetId         string `json:"subnetId,omitempty" yaml:"subnetId,omitempty"`
+	Detail           string `json:"detail,omitempty" yaml:"detail,omitempty"`
+}
+
+func cidrsOverlap(a *net.IPNet, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+func locateInstance(svc ec2iface.EC2API, instanceID string) ([]locateOutput, error) {
+	// Using a filter rather than InstanceIds, since the instance won't
+	// exist in most of the accounts/regions
+	input := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("instance-id"),
+				Values: []*string{aws.String(instanceID)},
+			},
+		},
+	}
+	var matches []locateOutput
+	err := svc.DescribeInstancesPages(input, func(result *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, r := range result.Reservations {
+			for _, instance := range r.Instances {
+				var name string
+				for _, tag := range instance.Tags {
+					if *tag.Key == "Name" {
+						name = *tag.Value
+					}
+				}
+				matches = append(matches, locateOutput{
+					ResourceType: "instance",
+					ResourceId:   aws.StringValue(instance.InstanceId),
+					VpcId:        aws.StringValue(instance.VpcId),
+					SubnetId:     aws.StringValue(instance.SubnetId),
+					Detail:       strings.TrimSpace(name + " " + aws.StringValue(instance.PrivateIpAddress)),
+				})
+			}
+		}
+		return !lastPage
+	})
+	return matches, err
+}
+
+func locateIPAddress(svc ec2iface.EC2API, ip net.IP) ([]locateOutput, error) {
+	var matches []locateOutput
+
+	// Network interfaces which have the IP as a private or public IP address
+	for _, filterName := range []string{"addresses.private-ip-address", "association.public-ip"} {
+		input := &ec2.DescribeNetworkInterfacesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String(filterName),
+					Values: []*string{aws.String(ip.String())},
+				},
+			},
+		}
+		result, err := svc.DescribeNetworkInterfaces(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, ni := range result.NetworkInterfaces {
+			detail := aws.StringValue(ni.Description)
+			if ni.Attachment != nil && ni.Attachment.InstanceId != nil {
+				detail = "attached to " + *ni.Attachment.InstanceId
+			}
+			matches = append(matches, locateOutput{
+				ResourceType: "network-interface",
+				ResourceId:   aws.StringValue(ni.NetworkInterfaceId),
+				VpcId:        aws.StringValue(ni.VpcId),
+				SubnetId:     aws.StringValue(ni.SubnetId),
+				Detail:       detail,
+			})
+		}
+	}
+
+	// Subnets the IP address belongs to, even if it isn't in use
+	result, err := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{})
+	if err != nil {
+		return nil, err
+	}
+	for _, subnet := range result.Subnets {
+		_, subnetCIDR, err := net.ParseCIDR(aws.StringValue(subnet.CidrBlock))
+		if err != nil || !subnetCIDR.Contains(ip) {
+			continue
+		}
+		matches = append(matches, locateOutput{
+			ResourceType: "subnet",
+			ResourceId:   aws.StringValue(subnet.SubnetId),
+			VpcId:        aws.StringValue(subnet.VpcId),
+			SubnetId:     aws.StringValue(subnet.SubnetId),
+			Detail:       aws.StringValue(subnet.CidrBlock),
+		})
+	}
+	return matches, nil
+}
+
+func locateCIDR(svc ec2iface.EC2API, cidr *net.IPNet) ([]locateOutput, error) {
+	result, err := svc.DescribeVpcs(&ec2.DescribeVpcsInput{})
+	if err != nil {
+		return nil, err
+	}
+	var matches []locateOutput
+	for _, vpc := range result.Vpcs {
+		// A VPC may have secondary CIDR blocks
+		vpcCIDRs := []string{aws.StringValue(vpc.CidrBlock)}
+		for _, association := range vpc.CidrBlockAssociationSet {
+			if association.CidrBlock != nil && *association.CidrBlock != *vpc.CidrBlock {
+				vpcCIDRs = append(vpcCIDRs, *association.CidrBlock)
+			}
+		}
+		for _, vpcCIDR := range vpcCIDRs {
+			_, vpcNet, err := net.ParseCIDR(vpcCIDR)
+			if err != nil || !cidrsOverlap(cidr, vpcNet) {
+				continue
+			}
+			matches = append(matches, locateOutput{
+				ResourceType: "vpc",
+				ResourceId:   aws.StringValue(vpc.VpcId),
+				VpcId:        aws.StringValue(vpc.VpcId),
+				Detail:       vpcCIDR,
+			})
+		}
+	}
+	return matches, nil
+}
+
+// newLocator returns a listFunc which looks for the instance ID, IP address
+// or CIDR block
+func newLocator(query string) (listFunc, error) {
+	var locate func(svc ec2iface.EC2API) ([]locateOutput, error)
+
+	if strings.HasPrefix(query, "i-") {
+		locate = func(svc ec2iface.EC2API) ([]locateOutput, error) {
+			return locateInstance(svc, query)
+		}
+	} else if ip := net.ParseIP(query); ip != nil {
+		locate = func(svc ec2iface.EC2API) ([]locateOutput, error) {
+			return locateIPAddress(svc, ip)
+		}
+	} else if _, cidr, err := net.ParseCIDR(query); err == nil {
+		locate = func(svc ec2iface.EC2API) ([]locateOutput, error) {
+			return locateCIDR(svc, cidr)
+		}
+	} else {
+		return nil, fmt.Errorf("%q is not an instance ID, IP address or CIDR block", query)
+	}
+
+	return func(loc resourceLocation, c awsClientProvider) (interface{}, *tableOutput, error) {
+		matches, err := locate(c.EC2())
+		if err != nil {
+			return nil, nil, err
+		}
+		items := []locateOutput{}
+		table := newTableOutput("Type", "ID", "VPC", "Subnet", "Detail").in(loc)
+		for _, m := range matches {
+			m.resourceLocation = loc
+			items = append(items, m)
+			table.addRow(m.ResourceType, m.ResourceId, m.VpcId, m.SubnetId, m.Detail)
+		}
+		return items, table, nil
+	}, nil
+}
+
+var locateCmd = &cobra.Command{
+	Use:   "locate <instance-id|ip-address|cidr>",
+	Short: "Find where an instance, IP address or CIDR block is",
+	Long: `Find the account, region, VPC and subnet of an EC2 instance, the network interfaces and
+subnets an IP address belongs to or the VPCs overlapping a CIDR block.
+
+Look for an IP address in all the accounts in the accounts file:
+
+	$ yawsi locate 10.1.2.3 --all-accounts
+
+Look for VPCs overlapping a CIDR block in two accounts in all their regions:
+
+	$ yawsi locate 10.20.0.0/16 --accounts production,staging --all-regions
+
+The accounts file (~/.config/yawsi/accounts.toml by default) lists the accounts and the role to
+assume in each of them:
+
+	[[account]]
+	id = "123456789012"
+	alias = "production"
+	role_name = "OrganizationAccountAccessRole"
+	regions = ["us-east-1", "eu-west-1"]
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		locate, err := newLocator(strings.TrimSpace(args[0]))
+		if err != nil {
+			return &usageError{err.Error()}
+		}
+
+		if isMultiTarget() {
+			err = renderAll("LocationList", locate)
+		} else {
+			var items interface{}
+			var table *tableOutput
+			items, table, err = locate(resourceLocation{}, getClients())
+			if err == nil {
+				err = renderItems("LocationList", items, table)
+			}
+		}
+		return err
+	},
+	Args: cobra.ExactArgs(1),
+}
+
+func init() {
+	RootCmd.AddCommand(locateCmd)
+	addListingFlags(locateCmd)
+}
@@ -0,0 +1,1031 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The targets a route can send traffic to, as displayed in the check
+// results
+const (
+	routeTargetLocal    = "local"
+	routeTargetPeering  = "pcx"
+	routeTargetNAT      = "nat"
+	routeTargetIGW      = "igw"
+	routeTargetTGW      = "tgw"
+	routeTargetVGW      = "vgw"
+	routeTargetEIGW     = "eigw"
+	routeTargetInstance = "instance"
+	routeTargetENI      = "eni"
+	routeTargetVPCE     = "vpce"
+)
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which isn't
+// reachable over the internet either
+var _, sharedAddressSpace, _ = net.ParseCIDR("100.64.0.0/10")
+
+// parseNetwork parses an IP address (as a single address network) or a CIDR
+// block
+func parseNetwork(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither an IP address nor a CIDR block", s)
+	}
+	return network, nil
+}
+
+// networkContains reports whether all the addresses of inner are in outer
+func networkContains(outer *net.IPNet, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// networksOverlap reports whether a and b have addresses in common
+func networksOverlap(a *net.IPNet, b *net.IPNet) bool {
+	return networkContains(a, b) || networkContains(b, a)
+}
+
+// cidrContains is networkContains for a CIDR block from the API, an invalid
+// (or missing) block contains nothing
+func cidrContains(cidr *string, network *net.IPNet) bool {
+	_, outer, err := net.ParseCIDR(aws.StringValue(cidr))
+	return err == nil && networkContains(outer, network)
+}
+
+// isIPv6Network reports whether the network is an IPv6 address or block
+func isIPv6Network(network *net.IPNet) bool {
+	return network.IP.To4() == nil
+}
+
+// isIPv6Address reports whether s is an IPv6 address
+func isIPv6Address(s string) bool {
+	ip := net.ParseIP(s)
+	return ip != nil && ip.To4() == nil
+}
+
+// stateAddresses returns the IPv4 or the IPv6 addresses of the state
+func stateAddresses(state *instanceState, ipv6 bool) []string {
+	if ipv6 {
+		return state.IPv6Addresses
+	}
+	return state.PrivateIPAddresses
+}
+
+// allAddresses returns the IPv4 and IPv6 addresses of the state
+func allAddresses(state *instanceState) []string {
+	var addresses []string
+	addresses = append(addresses, state.PrivateIPAddresses...)
+	return append(addresses, state.IPv6Addresses...)
+}
+
+// naclEntryCIDR returns the IPv4 or IPv6 CIDR block of an entry
+func naclEntryCIDR(entry *ec2.NetworkAclEntry) *string {
+	if entry.CidrBlock != nil {
+		return entry.CidrBlock
+	}
+	return entry.Ipv6CidrBlock
+}
+
+// routeDestination returns the IPv4 or IPv6 CIDR block of a route, or the
+// prefix list of a route to one, such as a gateway VPC endpoint's route
+func routeDestination(route *ec2.Route) *string {
+	if route.DestinationCidrBlock != nil {
+		return route.DestinationCidrBlock
+	}
+	if route.DestinationIpv6CidrBlock != nil {
+		return route.DestinationIpv6CidrBlock
+	}
+	return route.DestinationPrefixListId
+}
+
+// routeDestinationCIDRs returns the CIDR blocks a route sends traffic to: its
+// own or those of its prefix list, looked up in prefixLists (the CIDR blocks
+// keyed by ID)
+func routeDestinationCIDRs(route *ec2.Route, prefixLists map[string][]string) []string {
+	if route.DestinationPrefixListId != nil && route.DestinationCidrBlock == nil && route.DestinationIpv6CidrBlock == nil {
+		return prefixLists[*route.DestinationPrefixListId]
+	}
+	return []string{aws.StringValue(routeDestination(route))}
+}
+
+// permissionContains reports whether one of the IPv4 or IPv6 ranges of a
+// security group rule contains all of the network
+func permissionContains(permission *ec2.IpPermission, network *net.IPNet) bool {
+	for _, ipRange := range permission.IpRanges {
+		if cidrContains(ipRange.CidrIp, network) {
+			return true
+		}
+	}
+	for _, ipRange := range permission.Ipv6Ranges {
+		if cidrContains(ipRange.CidrIpv6, network) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublicNetwork reports whether the addresses are routed on the internet.
+// For IPv6, private covers the unique local addresses (fc00::/7).
+func isPublicNetwork(network *net.IPNet) bool {
+	ip := network.IP
+	return !(ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || sharedAddressSpace.Contains(ip))
+}
+
+// protocolMatches reports whether a rule for ruleProtocol (as returned by the
+// API) applies to protocol
+func protocolMatches(ruleProtocol *string, protocol string) bool {
+	mapped := protocolMapping[aws.StringValue(ruleProtocol)]
+	return mapped == protocol || mapped == "all"
+}
+
+// portsContain reports whether a rule's port range covers all of ports. Rules
+// without ports (all protocols) cover everything.
+func portsContain(from *int64, to *int64, ports *ec2.PortRange) bool {
+	if from == nil || to == nil {
+		return true
+	}
+	return *from <= *ports.From && *ports.To <= *to
+}
+
+// portsOverlap reports whether a rule's port range covers some of ports
+func portsOverlap(from *int64, to *int64, ports *ec2.PortRange) bool {
+	if from == nil || to == nil {
+		return true
+	}
+	return *from <= *ports.To && *ports.From <= *to
+}
+
+func isICMPProtocol(protocol string) bool {
+	return protocol == "icmp" || protocol == "icmpv6"
+}
+
+// icmpReplies maps the ICMP types of queries, such as an echo request, to
+// the type of their reply
+var icmpReplies = map[string]map[int64]int64{
+	"icmp":   {8: 0, 13: 14, 15: 16, 17: 18},
+	"icmpv6": {128: 129},
+}
+
+// defaultICMPType is the ICMP type of an echo request
+func defaultICMPType(protocol string) int64 {
+	if protocol == "icmpv6" {
+		return 128
+	}
+	return 8
+}
+
+// returnTrafficPorts returns what the return traffic of traffic to ports is
+// for: the ephemeral ports for TCP and UDP and the type and code of the reply
+// to an ICMP query. The ports of ICMP traffic are its type (From) and code
+// (To), as in a security group rule. It is nil when there's no return
+// traffic, e.g. for an ICMP destination unreachable message.
+func returnTrafficPorts(protocol string, ports *ec2.PortRange, ephemeralPorts *ec2.PortRange) *ec2.PortRange {
+	if !isICMPProtocol(protocol) {
+		return ephemeralPorts
+	}
+	reply, ok := icmpReplies[protocol][aws.Int64Value(ports.From)]
+	if !ok {
+		return nil
+	}
+	return &ec2.PortRange{From: aws.Int64(reply), To: aws.Int64(0)}
+}
+
+// icmpMatches reports whether an ICMP type and code of a rule, nil or -1 for
+// all of them, cover the type and code of ports
+func icmpMatches(icmpType *int64, icmpCode *int64, ports *ec2.PortRange) bool {
+	typeMatches := icmpType == nil || *icmpType == -1 || *icmpType == aws.Int64Value(ports.From)
+	codeMatches := icmpCode == nil || *icmpCode == -1 || *icmpCode == aws.Int64Value(ports.To)
+	return typeMatches && codeMatches
+}
+
+// permissionAllowsPorts reports whether a security group rule allows traffic
+// to all of ports, the type and code for ICMP
+func permissionAllowsPorts(permission *ec2.IpPermission, protocol string, ports *ec2.PortRange) bool {
+	if isICMPProtocol(protocol) {
+		return icmpMatches(permission.FromPort, permission.ToPort, ports)
+	}
+	return portsContain(permission.FromPort, permission.ToPort, ports)
+}
+
+// naclEntryCoversPorts reports whether a network ACL entry covers some and
+// all of ports. An ICMP entry covers a type and code or all of them.
+func naclEntryCoversPorts(entry *ec2.NetworkAclEntry, protocol string, ports *ec2.PortRange) (bool, bool) {
+	if isICMPProtocol(protocol) {
+		if entry.IcmpTypeCode == nil {
+			return true, true
+		}
+		matches := icmpMatches(entry.IcmpTypeCode.Type, entry.IcmpTypeCode.Code, ports)
+		return matches, matches
+	}
+	var from, to *int64
+	if entry.PortRange != nil {
+		from, to = entry.PortRange.From, entry.PortRange.To
+	}
+	return portsOverlap(from, to, ports), portsContain(from, to, ports)
+}
+
+// evaluateNACL returns the entry which decides whether traffic to (egress)
+// or from (ingress) the network over the protocol and ports is allowed. The
+// entries are evaluated in rule number order and the traffic is only
+// allowed if it is for all the addresses and ports: an allow entry which
+// covers part of them is skipped, a deny entry which covers part of them
+// denies the traffic.
+func evaluateNACL(acl *ec2.NetworkAcl, egress bool, protocol string, network *net.IPNet, ports *ec2.PortRange) (*ec2.NetworkAclEntry, bool) {
+	var entries []*ec2.NetworkAclEntry
+	for _, entry := range acl.Entries {
+		if aws.BoolValue(entry.Egress) == egress {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return aws.Int64Value(entries[i].RuleNumber) < aws.Int64Value(entries[j].RuleNumber)
+	})
+
+	for _, entry := range entries {
+		if !protocolMatches(entry.Protocol, protocol) {
+			continue
+		}
+		_, entryNetwork, err := net.ParseCIDR(aws.StringValue(naclEntryCIDR(entry)))
+		if err != nil || !networksOverlap(entryNetwork, network) {
+			continue
+		}
+		overlaps, contains := naclEntryCoversPorts(entry, protocol, ports)
+		if !overlaps {
+			continue
+		}
+		if aws.StringValue(entry.RuleAction) == "deny" {
+			return entry, false
+		}
+		if networkContains(entryNetwork, network) && contains {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// naclDefaultRuleNumber is the number of the "*" rule, which denies the
+// traffic no other rule matches
+const naclDefaultRuleNumber = 32767
+
+// naclDecision is the network ACL rule which decided whether the traffic of
+// a check is allowed
+type naclDecision struct {
+	RuleNumber string `json:"ruleNumber" yaml:"ruleNumber"`
+	Action     string `json:"action" yaml:"action"`
+	CidrBlock  string `json:"cidrBlock" yaml:"cidrBlock"`
+}
+
+func (d naclDecision) String() string {
+	if d.RuleNumber == "*" {
+		return fmt.Sprintf("rule * %s %s", d.Action, d.CidrBlock)
+	}
+	return fmt.Sprintf("rule #%s %s %s", d.RuleNumber, d.Action, d.CidrBlock)
+}
+
+// setNACLResult sets the result of a check from the network ACL and records
+// the rule which decided it in the metadata and the display text. Traffic no
+// entry matches is denied by the "*" rule, even when the API didn't return it.
+func setNACLResult(r *checkResult, acl *ec2.NetworkAcl, egress bool, protocol string, network *net.IPNet, ports *ec2.PortRange) {
+	entry, allowed := evaluateNACL(acl, egress, protocol, network, ports)
+	decision := naclDecision{RuleNumber: "*", Action: "deny", CidrBlock: "0.0.0.0/0"}
+	if isIPv6Network(network) {
+		decision.CidrBlock = "::/0"
+	}
+	if entry != nil {
+		r.Metadata["MatchedACL"] = *entry
+		decision.Action = aws.StringValue(entry.RuleAction)
+		decision.CidrBlock = aws.StringValue(naclEntryCIDR(entry))
+		if number := aws.Int64Value(entry.RuleNumber); number != naclDefaultRuleNumber {
+			decision.RuleNumber = strconv.FormatInt(number, 10)
+		}
+	}
+	r.Metadata["DecidingRule"] = decision
+	r.Metadata["NetworkAclId"] = aws.StringValue(acl.NetworkAclId)
+	r.DisplayText += " (" + decision.String() + ")"
+	r.Result = allowed
+}
+
+// longestPrefixRoute returns the route of the table which traffic to the
+// network takes, the most specific route containing all of it. A route to a
+// prefix list is as specific as the CIDR block of the prefix list containing
+// the network. IPv4 and IPv6 routes never contain each other's networks.
+func longestPrefixRoute(routeTable *RouteContainer, network *net.IPNet, prefixLists map[string][]string) *ec2.Route {
+	var selected *ec2.Route
+	selectedOnes := -1
+	for _, route := range routeTable.Routes {
+		for _, cidr := range routeDestinationCIDRs(route, prefixLists) {
+			_, routeNetwork, err := net.ParseCIDR(cidr)
+			if err != nil || !networkContains(routeNetwork, network) {
+				continue
+			}
+			if ones, _ := routeNetwork.Mask.Size(); ones > selectedOnes {
+				selected, selectedOnes = route, ones
+			}
+		}
+	}
+	return selected
+}
+
+// routeTarget returns the type and ID of the target of a route
+func routeTarget(route *ec2.Route) (string, string) {
+	gatewayID := aws.StringValue(route.GatewayId)
+	switch {
+	case gatewayID == "local":
+		return routeTargetLocal, gatewayID
+	case route.VpcPeeringConnectionId != nil:
+		return routeTargetPeering, *route.VpcPeeringConnectionId
+	case route.NatGatewayId != nil:
+		return routeTargetNAT, *route.NatGatewayId
+	case route.TransitGatewayId != nil:
+		return routeTargetTGW, *route.TransitGatewayId
+	case route.EgressOnlyInternetGatewayId != nil:
+		return routeTargetEIGW, *route.EgressOnlyInternetGatewayId
+	case strings.HasPrefix(gatewayID, "igw-"):
+		return routeTargetIGW, gatewayID
+	case strings.HasPrefix(gatewayID, "vgw-"):
+		return routeTargetVGW, gatewayID
+	case strings.HasPrefix(gatewayID, "vpce-"):
+		return routeTargetVPCE, gatewayID
+	case route.InstanceId != nil:
+		return routeTargetInstance, *route.InstanceId
+	case route.NetworkInterfaceId != nil:
+		return routeTargetENI, *route.NetworkInterfaceId
+	}
+	return "", gatewayID
+}
+
+// undeliverableRouteReason returns why traffic taking the route can't be
+// delivered, empty when it can. A blackhole route's target, such as a NAT
+// gateway or an instance, has been deleted or stopped.
+func undeliverableRouteReason(route *ec2.Route) string {
+	targetType, targetID := routeTarget(route)
+	switch {
+	case aws.StringValue(route.State) == ec2.RouteStateBlackhole && len(targetType) != 0:
+		return fmt.Sprintf("The route is a blackhole, its target %s %s is no longer available", targetType, targetID)
+	case aws.StringValue(route.State) == ec2.RouteStateBlackhole:
+		return "The route is a blackhole, its target is no longer available"
+	case len(targetType) == 0:
+		return "The route has no target we know how to follow"
+	}
+	return ""
+}
+
+// referencedGroupPair returns the security group pair of a rule which refers
+// to one of the groups. Group IDs are unique across accounts, so this covers
+// the references to groups in other accounts and peered VPCs too.
+func referencedGroupPair(pairs []*ec2.UserIdGroupPair, groups []*ec2.GroupIdentifier) *ec2.UserIdGroupPair {
+	for _, pair := range pairs {
+		for _, group := range groups {
+			if pair.GroupId != nil && *pair.GroupId == aws.StringValue(group.GroupId) {
+				return pair
+			}
+		}
+	}
+	return nil
+}
+
+// prefixListContaining returns the prefix list of a rule which contains all
+// of the network, looked up in prefixLists (the CIDR blocks keyed by ID)
+func prefixListContaining(permission *ec2.IpPermission, prefixLists map[string][]string, network *net.IPNet) string {
+	for _, prefixList := range permission.PrefixListIds {
+		id := aws.StringValue(prefixList.PrefixListId)
+		for _, cidr := range prefixLists[id] {
+			if cidrContains(aws.String(cidr), network) {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// unresolvedPrefixLists returns the prefix lists referenced by the egress or
+// ingress rules which we couldn't expand, such as customer managed ones
+func unresolvedPrefixLists(rules []*SecurityGroupRule, egress bool, prefixLists map[string][]string) []string {
+	var ids []string
+	for _, rule := range rules {
+		if rule.egress != egress {
+			continue
+		}
+		for _, prefixList := range rule.permission.PrefixListIds {
+			if _, ok := prefixLists[aws.StringValue(prefixList.PrefixListId)]; !ok {
+				ids = append(ids, aws.StringValue(prefixList.PrefixListId))
+			}
+		}
+	}
+	return ids
+}
+
+// matchSecurityGroupRule reports whether a rule allows traffic to or from the
+// network, via an IP range, a reference to one of the groups or a prefix
+// list. The referenced group or prefix list is added to the metadata.
+func matchSecurityGroupRule(rule *SecurityGroupRule, network *net.IPNet, groups []*ec2.GroupIdentifier, prefixLists map[string][]string, metadata map[string]interface{}) bool {
+	if permissionContains(rule.permission, network) {
+		return true
+	}
+	if pair := referencedGroupPair(rule.permission.UserIdGroupPairs, groups); pair != nil {
+		metadata["ReferencedSecurityGroup"] = pair
+		return true
+	}
+	if id := prefixListContaining(rule.permission, prefixLists, network); len(id) != 0 {
+		metadata["ReferencedPrefixList"] = id
+		return true
+	}
+	return false
+}
+
+// securityGroupIDs returns the IDs of the security groups, without
+// duplicates
+func securityGroupIDs(groups []*ec2.GroupIdentifier) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, group := range groups {
+		id := aws.StringValue(group.GroupId)
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// securityGroupsFor returns the security groups of the network interfaces
+// with an address in the network, or all the groups of the state when we
+// don't know which interface has it. Security group references don't apply
+// to traffic to or from the public IP address, so it has none.
+func securityGroupsFor(state *instanceState, network *net.IPNet) []*ec2.GroupIdentifier {
+	if isAddressOf(network, state.PublicIP) {
+		return nil
+	}
+	var groups []*ec2.GroupIdentifier
+	seen := make(map[string]bool)
+	for address, addressGroups := range state.AddressSecurityGroups {
+		ip := net.ParseIP(address)
+		if ip == nil || !network.Contains(ip) {
+			continue
+		}
+		for _, group := range addressGroups {
+			if !seen[aws.StringValue(group.GroupId)] {
+				seen[aws.StringValue(group.GroupId)] = true
+				groups = append(groups, group)
+			}
+		}
+	}
+	if len(groups) == 0 {
+		return state.SecurityGroups
+	}
+	return groups
+}
+
+// checkSecurityGroupEgressToNetwork checks whether the security groups of
+// the source allow traffic to the network, either via an IP range, a prefix
+// list or a reference to one of the destination's security groups
+// (destGroups)
+func checkSecurityGroupEgressToNetwork(source *instanceState, network *net.IPNet, destGroups []*ec2.GroupIdentifier, protocol string, ports *ec2.PortRange) []*checkResult {
+	r := newCheckResult()
+	r.DisplayText = "Security Group at Source allows Egress Traffic to " + network.String()
+	r.Stage = stageSecurityGroupEgress
+
+	for _, rule := range source.SecurityGroupRules {
+		if !rule.egress || !protocolMatches(rule.permission.IpProtocol, protocol) {
+			continue
+		}
+		if !permissionAllowsPorts(rule.permission, protocol, ports) {
+			continue
+		}
+		if matchSecurityGroupRule(rule, network, destGroups, source.PrefixLists, r.Metadata) {
+			r.Metadata["MatchedSecurityGroupRule"] = rule
+			r.Result = true
+			break
+		}
+	}
+	if ids := unresolvedPrefixLists(source.SecurityGroupRules, true, source.PrefixLists); !r.Result && len(ids) != 0 {
+		r.Metadata["UnresolvedPrefixLists"] = ids
+	}
+	if !r.Result {
+		r.Metadata["SecurityGroupIds"] = securityGroupIDs(source.SecurityGroups)
+	}
+	return []*checkResult{&r}
+}
+
+// checkSecurityGroupIngressFromNetwork checks whether the security groups of
+// the destination allow traffic from the network, either via an IP range, a
+// prefix list or a reference to one of the source's security groups
+// (sourceGroups)
+func checkSecurityGroupIngressFromNetwork(dest *instanceState, network *net.IPNet, sourceGroups []*ec2.GroupIdentifier, protocol string, ports *ec2.PortRange) []*checkResult {
+	r := newCheckResult()
+	r.DisplayText = "Security Group at Destination allows Ingress traffic from " + network.String()
+	r.Stage = stageSecurityGroupIngress
+
+	for _, rule := range dest.SecurityGroupRules {
+		if rule.egress || !protocolMatches(rule.permission.IpProtocol, protocol) {
+			continue
+		}
+		if !permissionAllowsPorts(rule.permission, protocol, ports) {
+			continue
+		}
+		if matchSecurityGroupRule(rule, network, sourceGroups, dest.PrefixLists, r.Metadata) {
+			r.Metadata["MatchedSecurityGroupRule"] = rule
+			r.Result = true
+			break
+		}
+	}
+	if ids := unresolvedPrefixLists(dest.SecurityGroupRules, false, dest.PrefixLists); !r.Result && len(ids) != 0 {
+		r.Metadata["UnresolvedPrefixLists"] = ids
+	}
+	if !r.Result {
+		r.Metadata["SecurityGroupIds"] = securityGroupIDs(dest.SecurityGroups)
+	}
+	return []*checkResult{&r}
+}
+
+// checkNACLToNetwork checks whether the network ACLs of the source subnets
+// allow traffic to the network and the return traffic to the ephemeral ports
+// (or the ICMP reply, see returnTrafficPorts)
+func checkNACLToNetwork(source *instanceState, network *net.IPNet, protocol string, ports *ec2.PortRange, ephemeralPorts *ec2.PortRange) []*checkResult {
+	var result []*checkResult
+
+	returnPorts := returnTrafficPorts(protocol, ports, ephemeralPorts)
+	for _, subnetID := range sortedSubnetIDs(source.NetworkAcls) {
+		acl := source.NetworkAcls[subnetID]
+
+		egress := newCheckResult()
+		egress.DisplayText = fmt.Sprintf("Egress ACL from Subnet %s to %s", subnetID, network)
+		egress.Stage = stageNACLEgress
+		setNACLResult(&egress, acl, true, protocol, network, ports)
+		result = append(result, &egress)
+
+		if returnPorts == nil {
+			continue
+		}
+		ingress := newCheckResult()
+		ingress.DisplayText = fmt.Sprintf("Ingress ACL at Subnet %s allows return traffic from %s", subnetID, network)
+		ingress.Stage = stageReturn
+		setNACLResult(&ingress, acl, false, protocol, network, returnPorts)
+		result = append(result, &ingress)
+	}
+	return result
+}
+
+// subnetsContaining returns the subnets of the state containing the network,
+// all of them when we don't know which one it is in
+func subnetsContaining(state *instanceState, network *net.IPNet) []string {
+	subnetCIDRs := state.SubnetCIDRs
+	if isIPv6Network(network) {
+		subnetCIDRs = state.SubnetIPv6CIDRs
+	}
+	var subnetIDs, allSubnetIDs []string
+	for subnetID := range state.NetworkAcls {
+		allSubnetIDs = append(allSubnetIDs, subnetID)
+		if cidr, ok := subnetCIDRs[subnetID]; ok && cidrContains(&cidr, network) {
+			subnetIDs = append(subnetIDs, subnetID)
+		}
+	}
+	if len(subnetIDs) == 0 {
+		subnetIDs = allSubnetIDs
+	}
+	sort.Strings(subnetIDs)
+	return subnetIDs
+}
+
+// checkNACLFromNetwork checks whether the network ACLs of the destination
+// subnets containing destNetwork allow traffic from the source network and
+// the return traffic to its ephemeral ports (or the ICMP reply). All the
+// subnets are checked when we don't know which one destNetwork is in.
+func checkNACLFromNetwork(dest *instanceState, destNetwork *net.IPNet, sourceNetwork *net.IPNet, protocol string, ports *ec2.PortRange, ephemeralPorts *ec2.PortRange) []*checkResult {
+	var result []*checkResult
+
+	returnPorts := returnTrafficPorts(protocol, ports, ephemeralPorts)
+	for _, subnetID := range subnetsContaining(dest, destNetwork) {
+		acl := dest.NetworkAcls[subnetID]
+
+		ingress := newCheckResult()
+		ingress.DisplayText = fmt.Sprintf("Ingress ACL at Subnet %s allows traffic from %s", subnetID, sourceNetwork)
+		ingress.Stage = stageNACLIngress
+		setNACLResult(&ingress, acl, false, protocol, sourceNetwork, ports)
+		result = append(result, &ingress)
+
+		if returnPorts == nil {
+			continue
+		}
+		egress := newCheckResult()
+		egress.DisplayText = fmt.Sprintf("Egress ACL from Subnet %s allows return traffic to %s", subnetID, sourceNetwork)
+		egress.Stage = stageReturn
+		setNACLResult(&egress, acl, true, protocol, sourceNetwork, returnPorts)
+		result = append(result, &egress)
+	}
+	return result
+}
+
+// checkRouteToNetwork checks whether each route table of the source has a
+// route to the network and, when the traffic leaves the VPC via an internet
+// gateway, whether it can get there: the destination must be public and the
+// source must have a public IP address. Traffic via a NAT gateway uses the
+// NAT gateway's address, so it doesn't need one. IPv6 addresses are public,
+// so IPv6 traffic via an internet gateway or an egress-only internet gateway
+// only needs the source to have one. Routes via a VPC peering connection or a
+// transit gateway are followed to the VPC of dest, see checkRouteHop.
+func checkRouteToNetwork(source *instanceState, dest *instanceState, network *net.IPNet) []*checkResult {
+	var result []*checkResult
+
+	for _, routeTable := range source.Routes {
+		r := newCheckResult()
+		r.Stage = stageRoute
+		r.Metadata["RouteTableId"] = routeTable.RouteTableId
+		route := longestPrefixRoute(routeTable, network, source.PrefixLists)
+		if route == nil {
+			r.DisplayText = fmt.Sprintf("Route exists from %s to %s", routeTable.RouteTableId, network)
+			r.Metadata["Reason"] = fmt.Sprintf("%s has no route to %s", routeTable.RouteTableId, network)
+			result = append(result, &r)
+			continue
+		}
+
+		targetType, targetID := routeTarget(route)
+		r.DisplayText = fmt.Sprintf("Route exists from %s to %s via %s", routeTable.RouteTableId, network, targetType)
+		if targetID != targetType {
+			r.DisplayText += " " + targetID
+		}
+		r.Metadata["MatchedRoutes"] = []*ec2.Route{route}
+		r.Metadata["RouteTarget"] = targetType
+		if reason := undeliverableRouteReason(route); len(reason) != 0 {
+			r.Metadata["Reason"] = reason
+		} else {
+			r.Result = true
+		}
+		result = append(result, &r)
+		result = append(result, checkRouteHop(source, dest, route, network)...)
+
+		if targetType == routeTargetIGW || targetType == routeTargetEIGW {
+			access := newCheckResult()
+			access.Stage = stageRouteTarget
+			gateway := "internet gateway"
+			if targetType == routeTargetEIGW {
+				gateway = "egress-only internet gateway"
+			}
+			if !isPublicNetwork(network) {
+				access.DisplayText = fmt.Sprintf("%s is reachable via %s %s", network, gateway, targetID)
+			} else if isIPv6Network(network) {
+				access.DisplayText = fmt.Sprintf("Source has an IPv6 address for %s %s", gateway, targetID)
+				if len(source.IPv6Addresses) != 0 {
+					access.Metadata["IPv6Addresses"] = source.IPv6Addresses
+					access.Result = true
+				}
+			} else {
+				access.DisplayText = fmt.Sprintf("Source has a public IP address for internet gateway %s", targetID)
+				if len(source.PublicIP) != 0 {
+					access.Metadata["PublicIP"] = source.PublicIP
+					access.Result = true
+				}
+			}
+			result = append(result, &access)
+		}
+	}
+
+	if len(result) == 0 {
+		r := newCheckResult()
+		r.DisplayText = "Route exists from Source to " + network.String()
+		r.Stage = stageRoute
+		result = append(result, &r)
+	}
+	return result
+}
+
+// peerVpcInfo returns the other side of a peering connection with the VPC,
+// nil when the VPC isn't one of its sides
+func peerVpcInfo(pcx *ec2.VpcPeeringConnection, vpcID string) *ec2.VpcPeeringConnectionVpcInfo {
+	requester, accepter := pcx.RequesterVpcInfo, pcx.AccepterVpcInfo
+	switch {
+	case requester != nil && aws.StringValue(requester.VpcId) == vpcID:
+		return accepter
+	case accepter != nil && aws.StringValue(accepter.VpcId) == vpcID:
+		return requester
+	}
+	return nil
+}
+
+// vpcInfoContains reports whether one of the CIDR blocks of a peered VPC
+// contains the network
+func vpcInfoContains(info *ec2.VpcPeeringConnectionVpcInfo, network *net.IPNet) bool {
+	if cidrContains(info.CidrBlock, network) {
+		return true
+	}
+	for _, block := range info.CidrBlockSet {
+		if cidrContains(block.CidrBlock, network) {
+			return true
+		}
+	}
+	for _, block := range info.Ipv6CidrBlockSet {
+		if cidrContains(block.Ipv6CidrBlock, network) {
+			return true
+		}
+	}
+	return false
+}
+
+// peeringStatus returns the status code of a peering connection
+func peeringStatus(pcx *ec2.VpcPeeringConnection) string {
+	if pcx.Status == nil {
+		return ""
+	}
+	return aws.StringValue(pcx.Status.Code)
+}
+
+// checkPeeringHop checks that the peering connection is active and that the
+// VPC on the other side has the network. When we know the destination, its
+// VPC must be the one on the other side.
+func checkPeeringHop(source *instanceState, dest *instanceState, pcxID string, network *net.IPNet) []*checkResult {
+	active := newCheckResult()
+	active.DisplayText = fmt.Sprintf("Peering connection %s is active", pcxID)
+	pcx := source.PeeringConnections[pcxID]
+	if pcx == nil {
+		active.Metadata["Reason"] = "The peering connection wasn't found"
+		return []*checkResult{&active}
+	}
+	active.Metadata["Status"] = peeringStatus(pcx)
+	active.Result = peeringStatus(pcx) == ec2.VpcPeeringConnectionStateReasonCodeActive
+
+	peer := newCheckResult()
+	info := peerVpcInfo(pcx, source.VpcID)
+	if info == nil {
+		peer.DisplayText = fmt.Sprintf("Peering connection %s is with VPC %s", pcxID, source.VpcID)
+		return []*checkResult{&active, &peer}
+	}
+	peerVpcID := aws.StringValue(info.VpcId)
+	peer.DisplayText = fmt.Sprintf("Peer VPC %s of %s has %s", peerVpcID, pcxID, network)
+	peer.Metadata["PeerVpc"] = info
+	peer.Result = vpcInfoContains(info, network)
+	if dest != nil && len(dest.VpcID) != 0 && dest.VpcID != peerVpcID {
+		peer.DisplayText = fmt.Sprintf("Peer VPC %s of %s is the destination's VPC %s", peerVpcID, pcxID, dest.VpcID)
+		peer.Result = false
+	}
+	return []*checkResult{&active, &peer}
+}
+
+// longestPrefixTransitGatewayRoute returns the most specific route of a
+// transit gateway route table containing all of the network
+func longestPrefixTransitGatewayRoute(routes []*ec2.TransitGatewayRoute, network *net.IPNet) *ec2.TransitGatewayRoute {
+	var selected *ec2.TransitGatewayRoute
+	selectedOnes := -1
+	for _, route := range routes {
+		_, routeNetwork, err := net.ParseCIDR(aws.StringValue(route.DestinationCidrBlock))
+		if err != nil || !networkContains(routeNetwork, network) {
+			continue
+		}
+		if ones, _ := routeNetwork.Mask.Size(); ones > selectedOnes {
+			selected, selectedOnes = route, ones
+		}
+	}
+	return selected
+}
+
+// checkTransitGatewayHop checks that the VPC of the source is attached to
+// the transit gateway and that the route table associated with the
+// attachment has an active route to the network. When we know the
+// destination, the route must be via the attachment of its VPC.
+func checkTransitGatewayHop(source *instanceState, dest *instanceState, transitGatewayID string, network *net.IPNet) []*checkResult {
+	attached := newCheckResult()
+	attached.DisplayText = fmt.Sprintf("VPC %s is attached to transit gateway %s", source.VpcID, transitGatewayID)
+	attachment := source.TransitGatewayAttachments[transitGatewayID]
+	if attachment == nil {
+		return []*checkResult{&attached}
+	}
+	attachmentID := aws.StringValue(attachment.TransitGatewayAttachmentId)
+	attached.Metadata["TransitGatewayAttachmentId"] = attachmentID
+	attached.Metadata["State"] = aws.StringValue(attachment.State)
+	attached.Result = aws.StringValue(attachment.State) == ec2.TransitGatewayAttachmentStateAvailable
+
+	r := newCheckResult()
+	if attachment.Association == nil || attachment.Association.TransitGatewayRouteTableId == nil {
+		r.DisplayText = fmt.Sprintf("Transit gateway attachment %s is associated with a route table", attachmentID)
+		return []*checkResult{&attached, &r}
+	}
+	routeTableID := *attachment.Association.TransitGatewayRouteTableId
+	r.DisplayText = fmt.Sprintf("Route exists from %s to %s", routeTableID, network)
+	route := longestPrefixTransitGatewayRoute(source.TransitGatewayRoutes[routeTableID], network)
+	if route == nil {
+		return []*checkResult{&attached, &r}
+	}
+	r.Metadata["MatchedRoutes"] = []*ec2.TransitGatewayRoute{route}
+	r.Result = aws.StringValue(route.State) == ec2.TransitGatewayRouteStateActive
+	var resourceIDs []string
+	toDestination := false
+	for _, routeAttachment := range route.TransitGatewayAttachments {
+		resourceIDs = append(resourceIDs, aws.StringValue(routeAttachment.ResourceId))
+		toDestination = toDestination || (dest != nil && aws.StringValue(routeAttachment.ResourceId) == dest.VpcID)
+	}
+	if len(resourceIDs) != 0 {
+		r.DisplayText += " via " + strings.Join(resourceIDs, ", ")
+	} else {
+		r.DisplayText += " via " + aws.StringValue(route.State)
+	}
+	if r.Result && dest != nil && len(dest.VpcID) != 0 && !toDestination {
+		r.DisplayText = fmt.Sprintf("Route exists from %s to %s via the destination's VPC %s", routeTableID, network, dest.VpcID)
+		r.Result = false
+	}
+	return []*checkResult{&attached, &r}
+}
+
+// checkRouteHop follows a route via a VPC peering connection or a transit
+// gateway to the VPC on the other side. dest is nil when the destination is
+// an IP address or CIDR block.
+func checkRouteHop(source *instanceState, dest *instanceState, route *ec2.Route, network *net.IPNet) []*checkResult {
+	switch targetType, targetID := routeTarget(route); targetType {
+	case routeTargetPeering:
+		return withStage(stageRouteTarget, checkPeeringHop(source, dest, targetID, network))
+	case routeTargetTGW:
+		return withStage(stageRouteTarget, checkTransitGatewayHop(source, dest, targetID, network))
+	}
+	return nil
+}
+
+// peeringConnectionBetween returns a peering connection between the VPCs of
+// the instances the routes of either use
+func peeringConnectionBetween(a *instanceState, b *instanceState) *ec2.VpcPeeringConnection {
+	for _, state := range []*instanceState{a, b} {
+		other := a
+		if state == a {
+			other = b
+		}
+		for _, pcx := range state.PeeringConnections {
+			if info := peerVpcInfo(pcx, state.VpcID); info != nil && aws.StringValue(info.VpcId) == other.VpcID {
+				return pcx
+			}
+		}
+	}
+	return nil
+}
+
+// checkGroupReference checks that a security group rule which allowed the
+// traffic of r by referring to a security group of the source can do so:
+// across VPCs the rule only works over an active peering connection in the
+// same region, not via a transit gateway
+func checkGroupReference(source *instanceState, dest *instanceState, r *checkResult) []*checkResult {
+	pair, ok := r.Metadata["ReferencedSecurityGroup"].(*ec2.UserIdGroupPair)
+	if !ok || len(source.VpcID) == 0 || len(dest.VpcID) == 0 || source.VpcID == dest.VpcID {
+		return nil
+	}
+	reference := newCheckResult()
+	reference.DisplayText = fmt.Sprintf("Security group %s in %s can be referenced from %s", aws.StringValue(pair.GroupId), source.VpcID, dest.VpcID)
+	reference.Stage = stageSecurityGroupIngress
+	pcx := peeringConnectionBetween(source, dest)
+	switch {
+	case pcx == nil:
+		reference.Metadata["Reason"] = "Security groups can only be referenced across a VPC peering connection"
+	case peeringStatus(pcx) != ec2.VpcPeeringConnectionStateReasonCodeActive:
+		reference.Metadata["VpcPeeringConnectionId"] = aws.StringValue(pcx.VpcPeeringConnectionId)
+		reference.Metadata["Reason"] = "The peering connection is " + peeringStatus(pcx)
+	case pcx.RequesterVpcInfo != nil && pcx.AccepterVpcInfo != nil && aws.StringValue(pcx.RequesterVpcInfo.Region) != aws.StringValue(pcx.AccepterVpcInfo.Region):
+		reference.Metadata["VpcPeeringConnectionId"] = aws.StringValue(pcx.VpcPeeringConnectionId)
+		reference.Metadata["Reason"] = "Security groups can't be referenced across an inter-region peering connection"
+	default:
+		reference.Metadata["VpcPeeringConnectionId"] = aws.StringValue(pcx.VpcPeeringConnectionId)
+		reference.Result = true
+	}
+	return []*checkResult{&reference}
+}
+
+// sameVersionNetworks returns the networks of the same IP version as network
+func sameVersionNetworks(networks []*net.IPNet, network *net.IPNet) []*net.IPNet {
+	var result []*net.IPNet
+	for _, n := range networks {
+		if isIPv6Network(n) == isIPv6Network(network) {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// isAddressOf reports whether the network is the single address ip
+func isAddressOf(network *net.IPNet, ip string) bool {
+	ones, bits := network.Mask.Size()
+	return len(ip) != 0 && ones == bits && network.IP.Equal(net.ParseIP(ip))
+}
+
+// addressNetworks returns the single address networks of the IP addresses
+func addressNetworks(ips []string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, ip := range ips {
+		if network, err := parseNetwork(ip); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+// checkConnectivityToState runs the checks for traffic from sourceNetworks,
+// the addresses of the source, to destNetwork, the address (or addresses)
+// of dest: the source's security groups, network ACLs and routes, then the
+// destination's network ACLs, route back to the source and security groups
+// for each source network of the same IP version as destNetwork. A security
+// group reference allowing the traffic is checked to work across the VPCs.
+func checkConnectivityToState(source *instanceState, sourceNetworks []*net.IPNet, dest *instanceState, destNetwork *net.IPNet, protocol string, ports *ec2.PortRange, ephemeralPorts *ec2.PortRange) []*checkResult {
+	var result []*checkResult
+
+	result = append(result, checkSecurityGroupEgressToNetwork(source, destNetwork, securityGroupsFor(dest, destNetwork), protocol, ports)...)
+	if subnetID, ok := sameSubnet(source, dest, destNetwork); ok {
+		result = append(result, naclNotApplicable(subnetID, stageNACLEgress))
+	} else {
+		result = append(result, checkNACLToNetwork(source, destNetwork, protocol, ports, ephemeralPorts)...)
+	}
+	result = append(result, checkRouteToNetwork(source, dest, destNetwork)...)
+
+	// Traffic to the public IP address of the destination goes via the
+	// internet gateway, so it arrives from the public IP address of the
+	// source
+	if isAddressOf(destNetwork, dest.PublicIP) && len(source.PublicIP) != 0 {
+		sourceNetworks = addressNetworks([]string{source.PublicIP})
+	}
+	sourceNetworks = sameVersionNetworks(sourceNetworks, destNetwork)
+	if len(sourceNetworks) == 0 {
+		r := newCheckResult()
+		r.DisplayText = "Source has an address of the same IP version as " + destNetwork.String()
+		r.Stage = stageReturn
+		result = append(result, &r)
+	}
+	for _, sourceNetwork := range sourceNetworks {
+		result = append(result, checkConnectivityFromNetwork(source, sourceNetwork, dest, destNetwork, protocol, ports, ephemeralPorts)...)
+	}
+	return result
+}
+
+// sameSubnet returns the subnet of the source when destNetwork is in it too.
+// Network ACLs only apply at the boundary of a subnet, so they don't apply to
+// the traffic within it.
+func sameSubnet(source *instanceState, dest *instanceState, destNetwork *net.IPNet) (string, bool) {
+	if source == nil || dest == nil || len(source.NetworkAcls) != 1 {
+		return "", false
+	}
+	subnetCIDRs := dest.SubnetCIDRs
+	if isIPv6Network(destNetwork) {
+		subnetCIDRs = dest.SubnetIPv6CIDRs
+	}
+	for subnetID := range source.NetworkAcls {
+		cidr, ok := subnetCIDRs[subnetID]
+		return subnetID, ok && cidrContains(&cidr, destNetwork)
+	}
+	return "", false
+}
+
+// naclNotApplicable is the result of the network ACL checks at the stage for
+// traffic within the subnet
+func naclNotApplicable(subnetID string, stage string) *checkResult {
+	r := newCheckResult()
+	r.Result = true
+	r.DisplayText = fmt.Sprintf("Network ACL of Subnet %s doesn't apply to traffic within the subnet", subnetID)
+	r.Stage = stage
+	return &r
+}
+
+// checkConnectivityFromNetwork runs the checks on the destination side for
+// traffic from the source network to destNetwork: the network ACLs of the
+// destination, its route back and its security groups. source is nil when
+// the traffic is from an IP address or CIDR block.
+func checkConnectivityFromNetwork(source *instanceState, sourceNetwork *net.IPNet, dest *instanceState, destNetwork *net.IPNet, protocol string, ports *ec2.PortRange, ephemeralPorts *ec2.PortRange) []*checkResult {
+	var result []*checkResult
+	var sourceGroups []*ec2.GroupIdentifier
+	if source != nil {
+		sourceGroups = securityGroupsFor(source, sourceNetwork)
+	}
+
+	if subnetID, ok := sameSubnet(source, dest, destNetwork); ok {
+		result = append(result, naclNotApplicable(subnetID, stageNACLIngress))
+	} else {
+		result = append(result, checkNACLFromNetwork(dest, destNetwork, sourceNetwork, protocol, ports, ephemeralPorts)...)
+	}
+	result = append(result, withStage(stageReturn, checkRouteToNetwork(dest, source, sourceNetwork))...)
+	ingress := checkSecurityGroupIngressFromNetwork(dest, sourceNetwork, sourceGroups, protocol, ports)
+	result = append(result, ingress...)
+	if source != nil {
+		for _, r := range ingress {
+			result = append(result, checkGroupReference(source, dest, r)...)
+		}
+	}
+	return result
+}
@@ -0,0 +1,563 @@
+package cmd
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustParseNetwork(t *testing.T, s string) *net.IPNet {
+	network, err := parseNetwork(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return network
+}
+
+func TestParseNetwork(t *testing.T) {
+	assert.Equal(t, "10.50.3.7/32", mustParseNetwork(t, "10.50.3.7").String())
+	assert.Equal(t, "10.50.0.0/16", mustParseNetwork(t, "10.50.3.7/16").String())
+	_, err := parseNetwork("i-src")
+	assert.Error(t, err)
+
+	assert.True(t, networkContains(mustParseNetwork(t, "10.0.0.0/8"), mustParseNetwork(t, "10.50.0.0/16")))
+	assert.False(t, networkContains(mustParseNetwork(t, "10.50.0.0/16"), mustParseNetwork(t, "10.0.0.0/8")))
+	assert.True(t, networksOverlap(mustParseNetwork(t, "10.50.0.0/16"), mustParseNetwork(t, "10.0.0.0/8")))
+	assert.True(t, isPublicNetwork(mustParseNetwork(t, "8.8.8.8")))
+	assert.False(t, isPublicNetwork(mustParseNetwork(t, "100.64.1.1")))
+}
+
+func TestEvaluateNACL(t *testing.T) {
+	entry := func(number int64, cidr, action string, from, to int64) *ec2.NetworkAclEntry {
+		return &ec2.NetworkAclEntry{
+			RuleNumber: aws.Int64(number),
+			Egress:     aws.Bool(true),
+			Protocol:   aws.String("6"),
+			CidrBlock:  aws.String(cidr),
+			RuleAction: aws.String(action),
+			PortRange:  &ec2.PortRange{From: aws.Int64(from), To: aws.Int64(to)},
+		}
+	}
+	acl := &ec2.NetworkAcl{Entries: []*ec2.NetworkAclEntry{
+		entry(200, "0.0.0.0/0", "allow", 0, 65535),
+		entry(100, "10.0.5.0/24", "deny", 0, 65535),
+		entry(150, "10.0.6.0/24", "allow", 443, 443),
+	}}
+	port := &ec2.PortRange{From: aws.Int64(443), To: aws.Int64(443)}
+
+	matched, allowed := evaluateNACL(acl, true, "tcp", mustParseNetwork(t, "10.0.5.1"), port)
+	assert.False(t, allowed)
+	assert.Equal(t, int64(100), *matched.RuleNumber)
+
+	matched, allowed = evaluateNACL(acl, true, "tcp", mustParseNetwork(t, "10.0.6.1"), port)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(150), *matched.RuleNumber)
+
+	// Part of the block is denied by rule 100
+	_, allowed = evaluateNACL(acl, true, "tcp", mustParseNetwork(t, "10.0.0.0/16"), port)
+	assert.False(t, allowed)
+
+	matched, _ = evaluateNACL(acl, false, "tcp", mustParseNetwork(t, "10.0.6.1"), port)
+	assert.Nil(t, matched)
+}
+
+func TestRouteTarget(t *testing.T) {
+	routeTable := &RouteContainer{RouteTableId: "rtb-1", Routes: []*ec2.Route{
+		{DestinationCidrBlock: aws.String("10.0.0.0/16"), GatewayId: aws.String("local")},
+		{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-1")},
+		{DestinationCidrBlock: aws.String("10.50.0.0/16"), TransitGatewayId: aws.String("tgw-1")},
+		{DestinationCidrBlock: aws.String("10.50.3.0/24"), VpcPeeringConnectionId: aws.String("pcx-1")},
+	}}
+	for destination, expected := range map[string]string{
+		"10.0.1.1":     routeTargetLocal,
+		"10.50.3.7":    routeTargetPeering,
+		"10.50.4.0/24": routeTargetTGW,
+		"10.50.0.0/15": routeTargetNAT,
+		"8.8.8.8":      routeTargetNAT,
+	} {
+		targetType, _ := routeTarget(longestPrefixRoute(routeTable, mustParseNetwork(t, destination), nil))
+		assert.Equal(t, expected, targetType, destination)
+	}
+	targetType, targetID := routeTarget(&ec2.Route{GatewayId: aws.String("vgw-1")})
+	assert.Equal(t, routeTargetVGW, targetType)
+	assert.Equal(t, "vgw-1", targetID)
+}
+
+func TestPrefixListRoutes(t *testing.T) {
+	c := newConnectivityFixture()
+	c.ec2.PrefixLists = []*ec2.PrefixList{
+		{PrefixListId: aws.String("pl-s3"), Cidrs: aws.StringSlice([]string{"52.216.0.0/15", "3.5.0.0/19"})},
+	}
+	c.ec2.RouteTables[0].Routes = append(c.ec2.RouteTables[0].Routes,
+		&ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-1"), State: aws.String("active")},
+		&ec2.Route{DestinationPrefixListId: aws.String("pl-s3"), GatewayId: aws.String("vpce-1"), State: aws.String("active")},
+	)
+	states, err := getEC2InstanceData(c.EC2(), nil, aws.String("i-src"))
+	if !assert.NoError(t, err) || !assert.NoError(t, enrichInstanceStates(c.EC2(), states...)) {
+		t.FailNow()
+	}
+	src := states[0]
+	assert.Equal(t, []string{"52.216.0.0/15", "3.5.0.0/19"}, src.PrefixLists["pl-s3"])
+
+	// Traffic to S3 takes the gateway endpoint's route rather than the
+	// default route
+	results := checkRouteToNetwork(src, nil, mustParseNetwork(t, "52.217.1.1"))
+	if assert.Len(t, results, 1) && assert.True(t, allPassed(results)) {
+		assert.Equal(t, "Route exists from rtb-main to 52.217.1.1/32 via vpce vpce-1", results[0].DisplayText)
+		assert.Equal(t, routeTargetVPCE, results[0].Metadata["RouteTarget"])
+	}
+	results = checkRouteToNetwork(src, nil, mustParseNetwork(t, "8.8.8.8"))
+	assert.Equal(t, "Route exists from rtb-main to 8.8.8.8/32 via nat nat-1", results[0].DisplayText)
+
+	route := longestPrefixRoute(src.Routes[0], mustParseNetwork(t, "3.5.1.0/24"), src.PrefixLists)
+	assert.Equal(t, "pl-s3 via vpce vpce-1", describeRoute(route))
+	// Without the prefix list's CIDR blocks, the route can't be selected
+	route = longestPrefixRoute(src.Routes[0], mustParseNetwork(t, "3.5.1.0/24"), nil)
+	assert.Equal(t, "0.0.0.0/0 via nat nat-1", describeRoute(route))
+}
+
+func TestConnectivityChecksToNetwork(t *testing.T) {
+	c := newConnectivityFixture()
+	c.ec2.RouteTables[0].Routes = append(c.ec2.RouteTables[0].Routes,
+		&ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-1"), State: aws.String("active")},
+		&ec2.Route{DestinationCidrBlock: aws.String("10.50.0.0/16"), NatGatewayId: aws.String("nat-1"), State: aws.String("active")},
+	)
+	src := loadInstanceState(t, c, "i-src")
+	port := &ec2.PortRange{From: aws.Int64(5432), To: aws.Int64(5432)}
+
+	// Return traffic is allowed from within 10.0.0.0/16 only
+	assert.True(t, allPassed(checkSecurityGroupEgressToNetwork(src, mustParseNetwork(t, "10.0.2.20"), nil, "tcp", port)))
+	assert.True(t, allPassed(checkNACLToNetwork(src, mustParseNetwork(t, "10.0.2.0/24"), "tcp", port, &defaultEphermalPortRange)))
+	assert.False(t, allPassed(checkNACLToNetwork(src, mustParseNetwork(t, "10.50.3.7"), "tcp", port, &defaultEphermalPortRange)))
+
+	results := checkRouteToNetwork(src, nil, mustParseNetwork(t, "10.50.3.7"))
+	if assert.True(t, allPassed(results)) {
+		assert.Equal(t, "Route exists from rtb-main to 10.50.3.7/32 via nat nat-1", results[0].DisplayText)
+	}
+
+	// The instance doesn't have a public IP address
+	results = checkRouteToNetwork(src, nil, mustParseNetwork(t, "8.8.8.8"))
+	assert.Len(t, results, 2)
+	assert.False(t, allPassed(results))
+	src.PublicIP = "54.1.2.3"
+	assert.True(t, allPassed(checkRouteToNetwork(src, nil, mustParseNetwork(t, "8.8.8.8"))))
+
+	// Private addresses aren't reachable via an internet gateway
+	assert.False(t, allPassed(checkRouteToNetwork(src, nil, mustParseNetwork(t, "192.168.1.1"))))
+}
+
+func TestConnectivityChecksIPv6(t *testing.T) {
+	c := newConnectivityFixture()
+	ipv6CIDR := func(cidr string) []*ec2.SubnetIpv6CidrBlockAssociation {
+		return []*ec2.SubnetIpv6CidrBlockAssociation{{
+			Ipv6CidrBlock:      aws.String(cidr),
+			Ipv6CidrBlockState: &ec2.SubnetCidrBlockState{State: aws.String("associated")},
+		}}
+	}
+	c.ec2.Subnets[0].Ipv6CidrBlockAssociationSet = ipv6CIDR("2600:1f18:0:1::/64")
+	c.ec2.Subnets[1].Ipv6CidrBlockAssociationSet = ipv6CIDR("2600:1f18:0:2::/64")
+	c.ec2.NetworkInterfaces[0].Ipv6Addresses = []*ec2.NetworkInterfaceIpv6Address{{Ipv6Address: aws.String("2600:1f18:0:1::10")}}
+	c.ec2.NetworkInterfaces[1].Ipv6Addresses = []*ec2.NetworkInterfaceIpv6Address{{Ipv6Address: aws.String("2600:1f18:0:2::20")}}
+
+	ipv6Entry := func(number int64, egress bool, cidr string, from, to int64) *ec2.NetworkAclEntry {
+		return &ec2.NetworkAclEntry{
+			RuleNumber:    aws.Int64(number),
+			Egress:        aws.Bool(egress),
+			Protocol:      aws.String("6"),
+			Ipv6CidrBlock: aws.String(cidr),
+			RuleAction:    aws.String("allow"),
+			PortRange:     &ec2.PortRange{From: aws.Int64(from), To: aws.Int64(to)},
+		}
+	}
+	c.ec2.NetworkAcls[0].Entries = append(c.ec2.NetworkAcls[0].Entries,
+		ipv6Entry(110, true, "::/0", 0, 65535),
+		ipv6Entry(110, false, "::/0", 1024, 65535),
+	)
+	c.ec2.NetworkAcls[1].Entries = append(c.ec2.NetworkAcls[1].Entries,
+		ipv6Entry(110, false, "2600:1f18:0:1::/64", 5432, 5432),
+		ipv6Entry(110, true, "2600:1f18:0:1::/64", 32768, 61000),
+	)
+	c.ec2.SecurityGroups[0].IpPermissionsEgress[0].Ipv6Ranges = []*ec2.Ipv6Range{{CidrIpv6: aws.String("::/0")}}
+	c.ec2.RouteTables[0].Routes = append(c.ec2.RouteTables[0].Routes,
+		&ec2.Route{DestinationIpv6CidrBlock: aws.String("2600:1f18::/56"), GatewayId: aws.String("local"), State: aws.String("active")},
+		&ec2.Route{DestinationIpv6CidrBlock: aws.String("::/0"), EgressOnlyInternetGatewayId: aws.String("eigw-1"), State: aws.String("active")},
+	)
+
+	states, err := getEC2InstanceData(c.EC2(), nil, aws.String("i-src"), aws.String("i-dst"))
+	if !assert.NoError(t, err) || !assert.Len(t, states, 2) {
+		t.FailNow()
+	}
+	assert.NoError(t, enrichInstanceStates(c.EC2(), states...))
+	src, dst := states[0], states[1]
+	assert.Equal(t, []string{"2600:1f18:0:2::20"}, dst.IPv6Addresses)
+	assert.Equal(t, "2600:1f18:0:2::/64", dst.SubnetIPv6CIDRs["subnet-b"])
+
+	// Both the IPv4 and the IPv6 address of the destination are checked
+	port := &ec2.PortRange{From: aws.Int64(5432), To: aws.Int64(5432)}
+	results := checkConnectivityToDestination(src, addressNetworks(allAddresses(src)), &connectivityDestination{state: dst}, "tcp", port, &defaultEphermalPortRange)
+	assert.True(t, allPassed(results))
+	var checkedIPv6 bool
+	for _, r := range results {
+		checkedIPv6 = checkedIPv6 || strings.Contains(r.DisplayText, "2600:1f18:0:1::10/128")
+	}
+	assert.True(t, checkedIPv6)
+
+	// An IPv4 only source can't reach an IPv6 address
+	ipv4Only := addressNetworks(src.PrivateIPAddresses)
+	results = checkConnectivityToState(src, ipv4Only, dst, mustParseNetwork(t, "2600:1f18:0:2::20"), "tcp", port, &defaultEphermalPortRange)
+	assert.False(t, allPassed(results))
+
+	// An IPv6 only source reaches it
+	ipv6Only := addressNetworks(src.IPv6Addresses)
+	results = checkConnectivityToState(src, ipv6Only, dst, mustParseNetwork(t, "2600:1f18:0:2::20"), "tcp", port, &defaultEphermalPortRange)
+	assert.True(t, allPassed(results))
+
+	// Egress-only internet gateways need the source to have an IPv6 address
+	results = checkRouteToNetwork(src, nil, mustParseNetwork(t, "2606:4700:4700::1111"))
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, "Route exists from rtb-main to 2606:4700:4700::1111/128 via eigw eigw-1", results[0].DisplayText)
+		assert.True(t, allPassed(results))
+	}
+	src.IPv6Addresses = nil
+	assert.False(t, allPassed(checkRouteToNetwork(src, nil, mustParseNetwork(t, "2606:4700:4700::1111"))))
+}
+
+func TestSecurityGroupReferencesAndPrefixLists(t *testing.T) {
+	c := newConnectivityFixture()
+	c.ec2.PrefixLists = []*ec2.PrefixList{
+		{PrefixListId: aws.String("pl-s3"), Cidrs: aws.StringSlice([]string{"52.216.0.0/15"})},
+	}
+	c.ec2.SecurityGroups[0].IpPermissionsEgress = []*ec2.IpPermission{
+		{
+			IpProtocol:    aws.String("tcp"),
+			FromPort:      aws.Int64(443),
+			ToPort:        aws.Int64(443),
+			PrefixListIds: []*ec2.PrefixListId{{PrefixListId: aws.String("pl-s3")}, {PrefixListId: aws.String("pl-custom")}},
+		},
+		{
+			IpProtocol: aws.String("tcp"),
+			FromPort:   aws.Int64(5432),
+			ToPort:     aws.Int64(5432),
+			UserIdGroupPairs: []*ec2.UserIdGroupPair{
+				{GroupId: aws.String("sg-dst"), UserId: aws.String("210987654321"), VpcPeeringConnectionId: aws.String("pcx-1")},
+			},
+		},
+	}
+	// i-dst has a second network interface without sg-dst
+	c.ec2.Instances[1].NetworkInterfaces = append(c.ec2.Instances[1].NetworkInterfaces, &ec2.InstanceNetworkInterface{NetworkInterfaceId: aws.String("eni-dst2")})
+	c.ec2.NetworkInterfaces = append(c.ec2.NetworkInterfaces, &ec2.NetworkInterface{
+		NetworkInterfaceId: aws.String("eni-dst2"),
+		SubnetId:           aws.String("subnet-b"),
+		VpcId:              aws.String("vpc-1"),
+		PrivateIpAddresses: []*ec2.NetworkInterfacePrivateIpAddress{{PrivateIpAddress: aws.String("10.0.2.30")}},
+		Groups:             []*ec2.GroupIdentifier{{GroupId: aws.String("sg-other")}},
+	})
+	c.ec2.SecurityGroups = append(c.ec2.SecurityGroups, &ec2.SecurityGroup{GroupId: aws.String("sg-other"), VpcId: aws.String("vpc-1")})
+
+	states, err := getEC2InstanceData(c.EC2(), nil, aws.String("i-src"), aws.String("i-dst"))
+	if !assert.NoError(t, err) || !assert.Len(t, states, 2) {
+		t.FailNow()
+	}
+	assert.NoError(t, enrichInstanceStates(c.EC2(), states...))
+	src, dst := states[0], states[1]
+	assert.Equal(t, map[string][]string{"pl-s3": {"52.216.0.0/15"}}, src.PrefixLists)
+
+	https := &ec2.PortRange{From: aws.Int64(443), To: aws.Int64(443)}
+	results := checkSecurityGroupEgressToNetwork(src, mustParseNetwork(t, "52.217.1.1"), nil, "tcp", https)
+	if assert.True(t, allPassed(results)) {
+		assert.Equal(t, "pl-s3", results[0].Metadata["ReferencedPrefixList"])
+	}
+	results = checkSecurityGroupEgressToNetwork(src, mustParseNetwork(t, "8.8.8.8"), nil, "tcp", https)
+	assert.False(t, allPassed(results))
+	assert.Equal(t, []string{"pl-custom"}, results[0].Metadata["UnresolvedPrefixLists"])
+
+	// The reference only covers the address of the interface with sg-dst
+	port := &ec2.PortRange{From: aws.Int64(5432), To: aws.Int64(5432)}
+	withGroup := mustParseNetwork(t, "10.0.2.20")
+	results = checkSecurityGroupEgressToNetwork(src, withGroup, securityGroupsFor(dst, withGroup), "tcp", port)
+	if assert.True(t, allPassed(results)) {
+		pair := results[0].Metadata["ReferencedSecurityGroup"].(*ec2.UserIdGroupPair)
+		assert.Equal(t, "pcx-1", *pair.VpcPeeringConnectionId)
+	}
+	withoutGroup := mustParseNetwork(t, "10.0.2.30")
+	assert.False(t, allPassed(checkSecurityGroupEgressToNetwork(src, withoutGroup, securityGroupsFor(dst, withoutGroup), "tcp", port)))
+}
+
+func TestNACLDecidingRule(t *testing.T) {
+	c := newConnectivityFixture()
+	// A lower numbered deny of the source comes before the allow of subnet-a
+	acl := c.ec2.NetworkAcls[1]
+	acl.Entries = append([]*ec2.NetworkAclEntry{{
+		RuleNumber: aws.Int64(120),
+		Egress:     aws.Bool(false),
+		Protocol:   aws.String("-1"),
+		CidrBlock:  aws.String("10.0.0.0/8"),
+		RuleAction: aws.String("allow"),
+	}}, acl.Entries...)
+	acl.Entries = append(acl.Entries, &ec2.NetworkAclEntry{
+		RuleNumber: aws.Int64(90),
+		Egress:     aws.Bool(false),
+		Protocol:   aws.String("6"),
+		CidrBlock:  aws.String("10.0.1.10/32"),
+		RuleAction: aws.String("deny"),
+		PortRange:  &ec2.PortRange{From: aws.Int64(5432), To: aws.Int64(5432)},
+	})
+	dst := loadInstanceState(t, c, "i-dst")
+
+	port := &ec2.PortRange{From: aws.Int64(5432), To: aws.Int64(5432)}
+	srcNetwork, dstNetwork := mustParseNetwork(t, "10.0.1.10"), mustParseNetwork(t, "10.0.2.20")
+	results := checkNACLFromNetwork(dst, dstNetwork, srcNetwork, "tcp", port, &defaultEphermalPortRange)
+	if assert.NotEmpty(t, results) {
+		assert.False(t, results[0].Result)
+		assert.Equal(t, "Ingress ACL at Subnet subnet-b allows traffic from 10.0.1.10/32 (rule #90 deny 10.0.1.10/32)", results[0].DisplayText)
+		assert.Equal(t, naclDecision{RuleNumber: "90", Action: "deny", CidrBlock: "10.0.1.10/32"}, results[0].Metadata["DecidingRule"])
+	}
+
+	// Other ports are allowed by the next rule in order
+	results = checkNACLFromNetwork(dst, dstNetwork, srcNetwork, "tcp", &ec2.PortRange{From: aws.Int64(22), To: aws.Int64(22)}, &defaultEphermalPortRange)
+	if assert.NotEmpty(t, results) {
+		assert.True(t, results[0].Result)
+		assert.Contains(t, results[0].DisplayText, "(rule #120 allow 10.0.0.0/8)")
+	}
+
+	// Nothing matches the egress of subnet-b to 10.0.1.10 on a port outside
+	// of the ephemeral port range, so the default rule denies it
+	results = checkNACLToNetwork(dst, srcNetwork, "tcp", &ec2.PortRange{From: aws.Int64(80), To: aws.Int64(80)}, &defaultEphermalPortRange)
+	if assert.NotEmpty(t, results) {
+		assert.False(t, results[0].Result)
+		assert.Equal(t, "Egress ACL from Subnet subnet-b to 10.0.1.10/32 (rule * deny 0.0.0.0/0)", results[0].DisplayText)
+	}
+}
+
+// newPeeredFixture moves i-dst into vpc-2 (10.1.0.0/16) and routes the
+// traffic between the VPCs via target
+func newPeeredFixture(target func(route *ec2.Route)) *fakeClients {
+	c := newConnectivityFixture()
+	c.ec2.Instances[0].VpcId = aws.String("vpc-1")
+	c.ec2.Instances[1].VpcId = aws.String("vpc-2")
+	dst := c.ec2.NetworkInterfaces[1]
+	dst.VpcId = aws.String("vpc-2")
+	dst.PrivateIpAddress = aws.String("10.1.2.20")
+	dst.PrivateIpAddresses[0].PrivateIpAddress = aws.String("10.1.2.20")
+	c.ec2.Subnets[1] = &ec2.Subnet{SubnetId: aws.String("subnet-b"), VpcId: aws.String("vpc-2"), CidrBlock: aws.String("10.1.2.0/24")}
+	c.ec2.NetworkAcls[0].Entries = append(c.ec2.NetworkAcls[0].Entries, &ec2.NetworkAclEntry{
+		RuleNumber: aws.Int64(110), Egress: aws.Bool(false), Protocol: aws.String("-1"), CidrBlock: aws.String("10.1.0.0/16"), RuleAction: aws.String("allow"),
+	})
+
+	toVpc2 := &ec2.Route{DestinationCidrBlock: aws.String("10.1.0.0/16"), State: aws.String("active")}
+	toVpc1 := &ec2.Route{DestinationCidrBlock: aws.String("10.0.0.0/16"), State: aws.String("active")}
+	target(toVpc2)
+	target(toVpc1)
+	c.ec2.RouteTables[0].Routes = append(c.ec2.RouteTables[0].Routes, toVpc2)
+	c.ec2.RouteTables = append(c.ec2.RouteTables, &ec2.RouteTable{
+		RouteTableId: aws.String("rtb-2"),
+		VpcId:        aws.String("vpc-2"),
+		Associations: []*ec2.RouteTableAssociation{{Main: aws.Bool(true), RouteTableId: aws.String("rtb-2")}},
+		Routes: []*ec2.Route{
+			{DestinationCidrBlock: aws.String("10.1.0.0/16"), GatewayId: aws.String("local"), State: aws.String("active")},
+			toVpc1,
+		},
+	})
+	return c
+}
+
+// failedChecks returns the display text of the failed checks
+func failedChecks(results []*checkResult) []string {
+	var failed []string
+	for _, r := range results {
+		if !r.Result {
+			failed = append(failed, r.DisplayText)
+		}
+	}
+	return failed
+}
+
+func TestPathAcrossPeeringAndTransitGateway(t *testing.T) {
+	port := &ec2.PortRange{From: aws.Int64(5432), To: aws.Int64(5432)}
+	check := func(c *fakeClients) []*checkResult {
+		src := loadInstanceState(t, c, "i-src")
+		dest, err := getConnectivityDestination(c.EC2(), c.RDS(), "i-dst", "", false)
+		if !assert.NoError(t, err) || !assert.NoError(t, enrichInstanceStates(c.EC2(), src, dest.state)) {
+			t.FailNow()
+		}
+		return checkConnectivityToDestination(src, addressNetworks(allAddresses(src)), dest, "tcp", port, &defaultEphermalPortRange)
+	}
+
+	peering := &ec2.VpcPeeringConnection{
+		VpcPeeringConnectionId: aws.String("pcx-1"),
+		Status:                 &ec2.VpcPeeringConnectionStateReason{Code: aws.String("active")},
+		RequesterVpcInfo:       &ec2.VpcPeeringConnectionVpcInfo{VpcId: aws.String("vpc-1"), CidrBlock: aws.String("10.0.0.0/16"), Region: aws.String("us-east-1")},
+		AccepterVpcInfo:        &ec2.VpcPeeringConnectionVpcInfo{VpcId: aws.String("vpc-2"), CidrBlock: aws.String("10.1.0.0/16"), Region: aws.String("us-east-1")},
+	}
+	c := newPeeredFixture(func(route *ec2.Route) { route.VpcPeeringConnectionId = aws.String("pcx-1") })
+	c.ec2.VpcPeeringConnections = []*ec2.VpcPeeringConnection{peering}
+	results := check(c)
+	assert.Empty(t, failedChecks(results))
+	var texts []string
+	for _, r := range results {
+		texts = append(texts, r.DisplayText)
+	}
+	assert.Contains(t, texts, "Peering connection pcx-1 is active")
+	assert.Contains(t, texts, "Peer VPC vpc-2 of pcx-1 has 10.1.2.20/32")
+	assert.Contains(t, texts, "Peer VPC vpc-1 of pcx-1 has 10.0.1.10/32")
+	assert.Contains(t, texts, "Security group sg-src in vpc-1 can be referenced from vpc-2")
+
+	// The route check follows the peering connection to the peer VPC
+	src, dst := loadInstanceState(t, c, "i-src"), loadInstanceState(t, c, "i-dst")
+	assert.NoError(t, enrichInstanceStates(c.EC2(), src, dst))
+	results = checkRouteToNetwork(src, dst, mustParseNetwork(t, "10.1.2.20"))
+	assert.Len(t, results, 3)
+	assert.True(t, allPassed(results))
+
+	peering.Status.Code = aws.String("pending-acceptance")
+	assert.ElementsMatch(t, []string{
+		"Peering connection pcx-1 is active",
+		"Peering connection pcx-1 is active",
+		"Security group sg-src in vpc-1 can be referenced from vpc-2",
+	}, failedChecks(check(c)))
+
+	// Inter-region peering connections don't allow security group references
+	peering.Status.Code = aws.String("active")
+	peering.AccepterVpcInfo.Region = aws.String("eu-west-1")
+	assert.Equal(t, []string{"Security group sg-src in vpc-1 can be referenced from vpc-2"}, failedChecks(check(c)))
+
+	// Transit gateways route between the VPCs, but the security group
+	// reference doesn't work
+	c = newPeeredFixture(func(route *ec2.Route) { route.TransitGatewayId = aws.String("tgw-1") })
+	attachment := func(id, vpcID string) *ec2.TransitGatewayAttachment {
+		return &ec2.TransitGatewayAttachment{
+			TransitGatewayAttachmentId: aws.String(id),
+			TransitGatewayId:           aws.String("tgw-1"),
+			ResourceId:                 aws.String(vpcID),
+			ResourceType:               aws.String("vpc"),
+			State:                      aws.String("available"),
+			Association:                &ec2.TransitGatewayAttachmentAssociation{TransitGatewayRouteTableId: aws.String("tgw-rtb-1")},
+		}
+	}
+	tgwRoute := func(cidr, attachmentID, vpcID string) *ec2.TransitGatewayRoute {
+		return &ec2.TransitGatewayRoute{
+			DestinationCidrBlock: aws.String(cidr),
+			State:                aws.String("active"),
+			TransitGatewayAttachments: []*ec2.TransitGatewayRouteAttachment{
+				{TransitGatewayAttachmentId: aws.String(attachmentID), ResourceId: aws.String(vpcID)},
+			},
+		}
+	}
+	c.ec2.TransitGatewayAttachments = []*ec2.TransitGatewayAttachment{
+		attachment("tgw-attach-1", "vpc-1"),
+		attachment("tgw-attach-2", "vpc-2"),
+	}
+	c.ec2.TransitGatewayRoutes = map[string][]*ec2.TransitGatewayRoute{
+		"tgw-rtb-1": {
+			tgwRoute("10.0.0.0/16", "tgw-attach-1", "vpc-1"),
+			tgwRoute("10.1.0.0/16", "tgw-attach-2", "vpc-2"),
+		},
+	}
+	results = check(c)
+	assert.Equal(t, []string{"Security group sg-src in vpc-1 can be referenced from vpc-2"}, failedChecks(results))
+	texts = nil
+	for _, r := range results {
+		texts = append(texts, r.DisplayText)
+	}
+	assert.Contains(t, texts, "VPC vpc-1 is attached to transit gateway tgw-1")
+	assert.Contains(t, texts, "Route exists from tgw-rtb-1 to 10.1.2.20/32 via vpc-2")
+	assert.Contains(t, texts, "Route exists from tgw-rtb-1 to 10.0.1.10/32 via vpc-1")
+
+	// A more specific blackhole route drops the traffic
+	c.ec2.TransitGatewayRoutes["tgw-rtb-1"] = append(c.ec2.TransitGatewayRoutes["tgw-rtb-1"], &ec2.TransitGatewayRoute{
+		DestinationCidrBlock: aws.String("10.1.2.0/24"),
+		State:                aws.String("blackhole"),
+	})
+	assert.Contains(t, failedChecks(check(c)), "Route exists from tgw-rtb-1 to 10.1.2.20/32 via blackhole")
+}
+
+func TestICMPConnectivity(t *testing.T) {
+	c := newConnectivityFixture()
+	icmpEntry := func(number int64, egress bool, icmpType int64, cidr string) *ec2.NetworkAclEntry {
+		return &ec2.NetworkAclEntry{
+			RuleNumber:   aws.Int64(number),
+			Egress:       aws.Bool(egress),
+			Protocol:     aws.String("1"),
+			CidrBlock:    aws.String(cidr),
+			RuleAction:   aws.String("allow"),
+			IcmpTypeCode: &ec2.IcmpTypeCode{Type: aws.Int64(icmpType), Code: aws.Int64(-1)},
+		}
+	}
+	aclA, aclB := c.ec2.NetworkAcls[0], c.ec2.NetworkAcls[1]
+	aclB.Entries = append(aclB.Entries, icmpEntry(120, false, 8, "10.0.1.0/24"), icmpEntry(120, true, 0, "10.0.1.0/24"))
+	group := c.ec2.SecurityGroups[1]
+	group.IpPermissions = append(group.IpPermissions, &ec2.IpPermission{
+		IpProtocol: aws.String("icmp"),
+		FromPort:   aws.Int64(8),
+		ToPort:     aws.Int64(-1),
+		IpRanges:   []*ec2.IpRange{{CidrIp: aws.String("10.0.0.0/16")}},
+	})
+
+	check := func(icmpType int64) []*checkResult {
+		src := loadInstanceState(t, c, "i-src")
+		dest, err := getConnectivityDestination(c.EC2(), c.RDS(), "i-dst", "", false)
+		if !assert.NoError(t, err) || !assert.NoError(t, enrichInstanceStates(c.EC2(), src, dest.state)) {
+			t.FailNow()
+		}
+		ports := &ec2.PortRange{From: aws.Int64(icmpType), To: aws.Int64(0)}
+		return checkConnectivityToDestination(src, addressNetworks(allAddresses(src)), dest, "icmp", ports, &defaultEphermalPortRange)
+	}
+
+	// The echo reply isn't allowed back into subnet-a
+	assert.Equal(t, []string{"Ingress ACL at Subnet subnet-a allows return traffic from 10.0.2.20/32 (rule * deny 0.0.0.0/0)"}, failedChecks(check(8)))
+
+	aclA.Entries = append(aclA.Entries, icmpEntry(120, false, 0, "10.0.2.0/24"))
+	assert.Empty(t, failedChecks(check(8)))
+
+	// Destination unreachable messages have no reply, but aren't allowed in
+	assert.ElementsMatch(t, []string{
+		"Ingress ACL at Subnet subnet-b allows traffic from 10.0.1.10/32 (rule * deny 0.0.0.0/0)",
+		"Security Group at Destination allows Ingress traffic from 10.0.1.10/32",
+	}, failedChecks(check(3)))
+
+	assert.Nil(t, returnTrafficPorts("icmp", &ec2.PortRange{From: aws.Int64(3), To: aws.Int64(1)}, &defaultEphermalPortRange))
+	assert.Equal(t, int64(129), *returnTrafficPorts("icmpv6", &ec2.PortRange{From: aws.Int64(128), To: aws.Int64(0)}, &defaultEphermalPortRange).From)
+}
+
+func TestCustomerManagedPrefixLists(t *testing.T) {
+	c := newConnectivityFixture()
+	c.ec2.PrefixListEntries = map[string][]*ec2.PrefixListEntry{
+		"pl-office": {{Cidr: aws.String("203.0.113.0/24")}, {Cidr: aws.String("198.51.100.0/24")}},
+	}
+	c.ec2.SecurityGroups[0].IpPermissionsEgress = []*ec2.IpPermission{
+		{
+			IpProtocol:    aws.String("tcp"),
+			FromPort:      aws.Int64(443),
+			ToPort:        aws.Int64(443),
+			PrefixListIds: []*ec2.PrefixListId{{PrefixListId: aws.String("pl-office")}},
+		},
+	}
+
+	states, err := getEC2InstanceData(c.EC2(), nil, aws.String("i-src"))
+	if !assert.NoError(t, err) || !assert.NoError(t, enrichInstanceStates(c.EC2(), states...)) {
+		t.FailNow()
+	}
+	src := states[0]
+	assert.Equal(t, map[string][]string{"pl-office": {"203.0.113.0/24", "198.51.100.0/24"}}, src.PrefixLists)
+
+	https := &ec2.PortRange{From: aws.Int64(443), To: aws.Int64(443)}
+	results := checkSecurityGroupEgressToNetwork(src, mustParseNetwork(t, "198.51.100.7"), nil, "tcp", https)
+	if assert.True(t, allPassed(results)) {
+		assert.Equal(t, "pl-office", results[0].Metadata["ReferencedPrefixList"])
+	}
+	assert.False(t, allPassed(checkSecurityGroupEgressToNetwork(src, mustParseNetwork(t, "8.8.8.8"), nil, "tcp", https)))
+
+	// The snapshot has the entries of the customer managed prefix lists
+	c.ec2.ManagedPrefixLists = []*ec2.ManagedPrefixList{
+		{PrefixListId: aws.String("pl-office"), OwnerId: aws.String("123456789012")},
+		{PrefixListId: aws.String("pl-s3"), OwnerId: aws.String("AWS")},
+	}
+	s, err := captureSnapshot(c.EC2(), c.STS())
+	if assert.NoError(t, err) {
+		assert.Equal(t, c.ec2.PrefixListEntries, s.PrefixListEntries)
+	}
+}
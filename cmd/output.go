@@ -0,0 +1,198 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/fatih/color"
+	"gopkg.in/yaml.v2"
+)
+
+// outputAPIVersion is the schema version of the JSON/YAML documents
+// we emit. Bump it whenever a field is renamed or removed so that scripts
+// consuming the output can detect the change.
+const outputAPIVersion = "yawsi/v1"
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+var outputType string
+
+// All command output is written here, so that tests can capture it
+var outputWriter io.Writer = os.Stdout
+
+// outputDocument is the envelope of every JSON/YAML document
+type outputDocument struct {
+	APIVersion string      `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string      `json:"kind" yaml:"kind"`
+	Result     *bool       `json:"result,omitempty" yaml:"result,omitempty"`
+	Items      interface{} `json:"items" yaml:"items"`
+
+	// Accounts/regions which couldn't be listed when listing across accounts
+	// or regions
+	Errors []listError `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+// tableOutput holds the rows to display when the output is a table
+type tableOutput struct {
+	Headers []string
+	Rows    [][]string
+
+	location resourceLocation
+}
+
+func newTableOutput(headers ...string) *tableOutput {
+	return &tableOutput{Headers: headers}
+}
+
+// in adds Account and Region columns to the table, used when listing
+// resources in more than one account or region. It must be called before
+// adding any rows.
+func (t *tableOutput) in(loc resourceLocation) *tableOutput {
+	t.location = loc
+	var headers []string
+	if len(loc.Account) != 0 {
+		headers = append(headers, "Account")
+	}
+	if len(loc.Region) != 0 {
+		headers = append(headers, "Region")
+	}
+	t.Headers = append(headers, t.Headers...)
+	return t
+}
+
+func (t *tableOutput) addRow(columns ...string) {
+	var location []string
+	if len(t.location.Account) != 0 {
+		location = append(location, t.location.Account)
+	}
+	if len(t.location.Region) != 0 {
+		location = append(location, t.location.Region)
+	}
+	t.Rows = append(t.Rows, append(location, columns...))
+}
+
+func validateOutputType() error {
+	switch outputType {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	}
+	return fmt.Errorf("Invalid output type %q, must be one of: table, json, yaml", outputType)
+}
+
+func isTableOutput() bool {
+	return outputType != outputJSON && outputType != outputYAML
+}
+
+func writeDocument(doc outputDocument) error {
+	switch outputType {
+	case outputJSON:
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(outputWriter, string(data))
+		return err
+	case outputYAML:
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		_, err = outputWriter.Write(data)
+		return err
+	}
+	return fmt.Errorf("Cannot write document as %q", outputType)
+}
+
+func writeTable(t *tableOutput) error {
+	w := tabwriter.NewWriter(outputWriter, 0, 8, 2, ' ', 0)
+
+	var separators []string
+	for _, h := range t.Headers {
+		separators = append(separators, strings.Repeat("-", len(h)))
+	}
+	fmt.Fprintln(w, strings.Join(t.Headers, "\t")+"\t")
+	fmt.Fprintln(w, strings.Join(separators, "\t")+"\t")
+	for _, row := range t.Rows {
+		fmt.Fprintln(w, strings.Join(row, "\t")+"\t")
+	}
+	fmt.Fprintln(w)
+	return w.Flush()
+}
+
+// renderItems writes items as a document of the given kind or, when
+// the output is a table, writes the table instead
+func renderItems(kind string, items interface{}, table *tableOutput) error {
+	if isTableOutput() {
+		return writeTable(table)
+	}
+	return writeDocument(outputDocument{
+		APIVersion: outputAPIVersion,
+		Kind:       kind,
+		Items:      items,
+	})
+}
+
+// renderCheckResults writes the overall result of a set of checks. For
+// table output, the individual checks are shown via displayResult() as they
+// are run, so we only print the summary here. errCheckFailed is returned
+// when any of the checks failed.
+func renderCheckResults(kind string, results ...*checkResult) error {
+	summary := summarizeResults(results...)
+	if isTableOutput() {
+		if summary {
+			color.New(color.FgGreen).Fprintln(outputWriter, "true")
+		} else {
+			color.New(color.FgRed).Fprintln(outputWriter, "false")
+		}
+	} else {
+		if results == nil {
+			results = []*checkResult{}
+		}
+		err := writeDocument(outputDocument{
+			APIVersion: outputAPIVersion,
+			Kind:       kind,
+			Result:     &summary,
+			Items:      results,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !summary {
+		return errCheckFailed
+	}
+	return nil
+}
+
+func tagsToMap(tags []*ec2.Tag) map[string]string {
+	m := make(map[string]string)
+	for _, tag := range tags {
+		if tag.Key != nil && tag.Value != nil {
+			m[*tag.Key] = *tag.Value
+		}
+	}
+	return m
+}
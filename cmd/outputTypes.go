@@ -0,0 +1,485 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The SDK types have no json/yaml struct tags, so encoding/json uses the Go
+// field names (GroupId) while yaml.v2 lowercases them (groupid). The types
+// below are what instanceState and RouteContainer are marshaled as, so that
+// the JSON and YAML documents have the same schema.
+
+type tagOutput struct {
+	Key   string `json:"key" yaml:"key"`
+	Value string `json:"value" yaml:"value"`
+}
+
+func newTagOutputs(tags []*ec2.Tag) []tagOutput {
+	var out []tagOutput
+	for _, t := range tags {
+		out = append(out, tagOutput{Key: aws.StringValue(t.Key), Value: aws.StringValue(t.Value)})
+	}
+	return out
+}
+
+type groupIdentifierOutput struct {
+	GroupID   string `json:"groupId" yaml:"groupId"`
+	GroupName string `json:"groupName,omitempty" yaml:"groupName,omitempty"`
+}
+
+func newGroupIdentifierOutputs(groups []*ec2.GroupIdentifier) []groupIdentifierOutput {
+	var out []groupIdentifierOutput
+	for _, g := range groups {
+		out = append(out, groupIdentifierOutput{GroupID: aws.StringValue(g.GroupId), GroupName: aws.StringValue(g.GroupName)})
+	}
+	return out
+}
+
+type routeEntryOutput struct {
+	DestinationCidrBlock        string `json:"destinationCidrBlock,omitempty" yaml:"destinationCidrBlock,omitempty"`
+	DestinationIpv6CidrBlock    string `json:"destinationIpv6CidrBlock,omitempty" yaml:"destinationIpv6CidrBlock,omitempty"`
+	DestinationPrefixListID     string `json:"destinationPrefixListId,omitempty" yaml:"destinationPrefixListId,omitempty"`
+	GatewayID                   string `json:"gatewayId,omitempty" yaml:"gatewayId,omitempty"`
+	EgressOnlyInternetGatewayID string `json:"egressOnlyInternetGatewayId,omitempty" yaml:"egressOnlyInternetGatewayId,omitempty"`
+	NatGatewayID                string `json:"natGatewayId,omitempty" yaml:"natGatewayId,omitempty"`
+	InstanceID                  string `json:"instanceId,omitempty" yaml:"instanceId,omitempty"`
+	NetworkInterfaceID          string `json:"networkInterfaceId,omitempty" yaml:"networkInterfaceId,omitempty"`
+	TransitGatewayID            string `json:"transitGatewayId,omitempty" yaml:"transitGatewayId,omitempty"`
+	VpcPeeringConnectionID      string `json:"vpcPeeringConnectionId,omitempty" yaml:"vpcPeeringConnectionId,omitempty"`
+	LocalGatewayID              string `json:"localGatewayId,omitempty" yaml:"localGatewayId,omitempty"`
+	CarrierGatewayID            string `json:"carrierGatewayId,omitempty" yaml:"carrierGatewayId,omitempty"`
+	Origin                      string `json:"origin,omitempty" yaml:"origin,omitempty"`
+	State                       string `json:"state,omitempty" yaml:"state,omitempty"`
+}
+
+func newRouteEntryOutput(r *ec2.Route) routeEntryOutput {
+	return routeEntryOutput{
+		DestinationCidrBlock:        aws.StringValue(r.DestinationCidrBlock),
+		DestinationIpv6CidrBlock:    aws.StringValue(r.DestinationIpv6CidrBlock),
+		DestinationPrefixListID:     aws.StringValue(r.DestinationPrefixListId),
+		GatewayID:                   aws.StringValue(r.GatewayId),
+		EgressOnlyInternetGatewayID: aws.StringValue(r.EgressOnlyInternetGatewayId),
+		NatGatewayID:                aws.StringValue(r.NatGatewayId),
+		InstanceID:                  aws.StringValue(r.InstanceId),
+		NetworkInterfaceID:          aws.StringValue(r.NetworkInterfaceId),
+		TransitGatewayID:            aws.StringValue(r.TransitGatewayId),
+		VpcPeeringConnectionID:      aws.StringValue(r.VpcPeeringConnectionId),
+		LocalGatewayID:              aws.StringValue(r.LocalGatewayId),
+		CarrierGatewayID:            aws.StringValue(r.CarrierGatewayId),
+		Origin:                      aws.StringValue(r.Origin),
+		State:                       aws.StringValue(r.State),
+	}
+}
+
+type portRangeOutput struct {
+	From int64 `json:"from" yaml:"from"`
+	To   int64 `json:"to" yaml:"to"`
+}
+
+type icmpTypeCodeOutput struct {
+	Type int64 `json:"type" yaml:"type"`
+	Code int64 `json:"code" yaml:"code"`
+}
+
+type networkACLEntryOutput struct {
+	RuleNumber    int64               `json:"ruleNumber" yaml:"ruleNumber"`
+	Egress        bool                `json:"egress" yaml:"egress"`
+	Protocol      string              `json:"protocol" yaml:"protocol"`
+	RuleAction    string              `json:"ruleAction" yaml:"ruleAction"`
+	CidrBlock     string              `json:"cidrBlock,omitempty" yaml:"cidrBlock,omitempty"`
+	Ipv6CidrBlock string              `json:"ipv6CidrBlock,omitempty" yaml:"ipv6CidrBlock,omitempty"`
+	PortRange     *portRangeOutput    `json:"portRange,omitempty" yaml:"portRange,omitempty"`
+	IcmpTypeCode  *icmpTypeCodeOutput `json:"icmpTypeCode,omitempty" yaml:"icmpTypeCode,omitempty"`
+}
+
+type networkACLOutput struct {
+	NetworkACLID string                  `json:"networkAclId" yaml:"networkAclId"`
+	VpcID        string                  `json:"vpcId" yaml:"vpcId"`
+	IsDefault    bool                    `json:"isDefault" yaml:"isDefault"`
+	SubnetIds    []string                `json:"subnetIds,omitempty" yaml:"subnetIds,omitempty"`
+	Entries      []networkACLEntryOutput `json:"entries,omitempty" yaml:"entries,omitempty"`
+}
+
+func newNetworkACLOutput(acl *ec2.NetworkAcl) *networkACLOutput {
+	out := &networkACLOutput{
+		NetworkACLID: aws.StringValue(acl.NetworkAclId),
+		VpcID:        aws.StringValue(acl.VpcId),
+		IsDefault:    aws.BoolValue(acl.IsDefault),
+	}
+	for _, a := range acl.Associations {
+		out.SubnetIds = append(out.SubnetIds, aws.StringValue(a.SubnetId))
+	}
+	for _, e := range acl.Entries {
+		entry := networkACLEntryOutput{
+			RuleNumber:    aws.Int64Value(e.RuleNumber),
+			Egress:        aws.BoolValue(e.Egress),
+			Protocol:      aws.StringValue(e.Protocol),
+			RuleAction:    aws.StringValue(e.RuleAction),
+			CidrBlock:     aws.StringValue(e.CidrBlock),
+			Ipv6CidrBlock: aws.StringValue(e.Ipv6CidrBlock),
+		}
+		if e.PortRange != nil {
+			entry.PortRange = &portRangeOutput{From: aws.Int64Value(e.PortRange.From), To: aws.Int64Value(e.PortRange.To)}
+		}
+		if e.IcmpTypeCode != nil {
+			entry.IcmpTypeCode = &icmpTypeCodeOutput{Type: aws.Int64Value(e.IcmpTypeCode.Type), Code: aws.Int64Value(e.IcmpTypeCode.Code)}
+		}
+		out.Entries = append(out.Entries, entry)
+	}
+	return out
+}
+
+type ipRangeOutput struct {
+	CidrIP      string `json:"cidrIp" yaml:"cidrIp"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+type ipv6RangeOutput struct {
+	CidrIpv6    string `json:"cidrIpv6" yaml:"cidrIpv6"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+type prefixListIDOutput struct {
+	PrefixListID string `json:"prefixListId" yaml:"prefixListId"`
+	Description  string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+type userIDGroupPairOutput struct {
+	GroupID                string `json:"groupId,omitempty" yaml:"groupId,omitempty"`
+	GroupName              string `json:"groupName,omitempty" yaml:"groupName,omitempty"`
+	UserID                 string `json:"userId,omitempty" yaml:"userId,omitempty"`
+	VpcID                  string `json:"vpcId,omitempty" yaml:"vpcId,omitempty"`
+	VpcPeeringConnectionID string `json:"vpcPeeringConnectionId,omitempty" yaml:"vpcPeeringConnectionId,omitempty"`
+	PeeringStatus          string `json:"peeringStatus,omitempty" yaml:"peeringStatus,omitempty"`
+	Description            string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+type ipPermissionOutput struct {
+	IPProtocol       string                  `json:"ipProtocol" yaml:"ipProtocol"`
+	FromPort         *int64                  `json:"fromPort,omitempty" yaml:"fromPort,omitempty"`
+	ToPort           *int64                  `json:"toPort,omitempty" yaml:"toPort,omitempty"`
+	IPRanges         []ipRangeOutput         `json:"ipRanges,omitempty" yaml:"ipRanges,omitempty"`
+	Ipv6Ranges       []ipv6RangeOutput       `json:"ipv6Ranges,omitempty" yaml:"ipv6Ranges,omitempty"`
+	PrefixListIds    []prefixListIDOutput    `json:"prefixListIds,omitempty" yaml:"prefixListIds,omitempty"`
+	UserIDGroupPairs []userIDGroupPairOutput `json:"userIdGroupPairs,omitempty" yaml:"userIdGroupPairs,omitempty"`
+}
+
+func newIPPermissionOutput(p *ec2.IpPermission) *ipPermissionOutput {
+	if p == nil {
+		return nil
+	}
+	out := &ipPermissionOutput{
+		IPProtocol: aws.StringValue(p.IpProtocol),
+		FromPort:   p.FromPort,
+		ToPort:     p.ToPort,
+	}
+	for _, r := range p.IpRanges {
+		out.IPRanges = append(out.IPRanges, ipRangeOutput{CidrIP: aws.StringValue(r.CidrIp), Description: aws.StringValue(r.Description)})
+	}
+	for _, r := range p.Ipv6Ranges {
+		out.Ipv6Ranges = append(out.Ipv6Ranges, ipv6RangeOutput{CidrIpv6: aws.StringValue(r.CidrIpv6), Description: aws.StringValue(r.Description)})
+	}
+	for _, pl := range p.PrefixListIds {
+		out.PrefixListIds = append(out.PrefixListIds, prefixListIDOutput{PrefixListID: aws.StringValue(pl.PrefixListId), Description: aws.StringValue(pl.Description)})
+	}
+	for _, g := range p.UserIdGroupPairs {
+		out.UserIDGroupPairs = append(out.UserIDGroupPairs, userIDGroupPairOutput{
+			GroupID:                aws.StringValue(g.GroupId),
+			GroupName:              aws.StringValue(g.GroupName),
+			UserID:                 aws.StringValue(g.UserId),
+			VpcID:                  aws.StringValue(g.VpcId),
+			VpcPeeringConnectionID: aws.StringValue(g.VpcPeeringConnectionId),
+			PeeringStatus:          aws.StringValue(g.PeeringStatus),
+			Description:            aws.StringValue(g.Description),
+		})
+	}
+	return out
+}
+
+type peeringVpcOutput struct {
+	OwnerID        string   `json:"ownerId,omitempty" yaml:"ownerId,omitempty"`
+	VpcID          string   `json:"vpcId,omitempty" yaml:"vpcId,omitempty"`
+	Region         string   `json:"region,omitempty" yaml:"region,omitempty"`
+	CidrBlocks     []string `json:"cidrBlocks,omitempty" yaml:"cidrBlocks,omitempty"`
+	Ipv6CidrBlocks []string `json:"ipv6CidrBlocks,omitempty" yaml:"ipv6CidrBlocks,omitempty"`
+}
+
+func newPeeringVpcOutput(info *ec2.VpcPeeringConnectionVpcInfo) *peeringVpcOutput {
+	if info == nil {
+		return nil
+	}
+	out := &peeringVpcOutput{
+		OwnerID: aws.StringValue(info.OwnerId),
+		VpcID:   aws.StringValue(info.VpcId),
+		Region:  aws.StringValue(info.Region),
+	}
+	for _, c := range info.CidrBlockSet {
+		out.CidrBlocks = append(out.CidrBlocks, aws.StringValue(c.CidrBlock))
+	}
+	if len(out.CidrBlocks) == 0 && info.CidrBlock != nil {
+		out.CidrBlocks = []string{*info.CidrBlock}
+	}
+	for _, c := range info.Ipv6CidrBlockSet {
+		out.Ipv6CidrBlocks = append(out.Ipv6CidrBlocks, aws.StringValue(c.Ipv6CidrBlock))
+	}
+	return out
+}
+
+type peeringConnectionOutput struct {
+	VpcPeeringConnectionID string            `json:"vpcPeeringConnectionId" yaml:"vpcPeeringConnectionId"`
+	Status                 string            `json:"status,omitempty" yaml:"status,omitempty"`
+	RequesterVpc           *peeringVpcOutput `json:"requesterVpc,omitempty" yaml:"requesterVpc,omitempty"`
+	AccepterVpc            *peeringVpcOutput `json:"accepterVpc,omitempty" yaml:"accepterVpc,omitempty"`
+}
+
+func newPeeringConnectionOutput(p *ec2.VpcPeeringConnection) *peeringConnectionOutput {
+	out := &peeringConnectionOutput{
+		VpcPeeringConnectionID: aws.StringValue(p.VpcPeeringConnectionId),
+		RequesterVpc:           newPeeringVpcOutput(p.RequesterVpcInfo),
+		AccepterVpc:            newPeeringVpcOutput(p.AccepterVpcInfo),
+	}
+	if p.Status != nil {
+		out.Status = aws.StringValue(p.Status.Code)
+	}
+	return out
+}
+
+type transitGatewayAttachmentOutput struct {
+	TransitGatewayAttachmentID string `json:"transitGatewayAttachmentId" yaml:"transitGatewayAttachmentId"`
+	TransitGatewayID           string `json:"transitGatewayId" yaml:"transitGatewayId"`
+	ResourceType               string `json:"resourceType,omitempty" yaml:"resourceType,omitempty"`
+	ResourceID                 string `json:"resourceId,omitempty" yaml:"resourceId,omitempty"`
+	ResourceOwnerID            string `json:"resourceOwnerId,omitempty" yaml:"resourceOwnerId,omitempty"`
+	State                      string `json:"state,omitempty" yaml:"state,omitempty"`
+	RouteTableID               string `json:"transitGatewayRouteTableId,omitempty" yaml:"transitGatewayRouteTableId,omitempty"`
+}
+
+func newTransitGatewayAttachmentOutput(a *ec2.TransitGatewayAttachment) *transitGatewayAttachmentOutput {
+	out := &transitGatewayAttachmentOutput{
+		TransitGatewayAttachmentID: aws.StringValue(a.TransitGatewayAttachmentId),
+		TransitGatewayID:           aws.StringValue(a.TransitGatewayId),
+		ResourceType:               aws.StringValue(a.ResourceType),
+		ResourceID:                 aws.StringValue(a.ResourceId),
+		ResourceOwnerID:            aws.StringValue(a.ResourceOwnerId),
+		State:                      aws.StringValue(a.State),
+	}
+	if a.Association != nil {
+		out.RouteTableID = aws.StringValue(a.Association.TransitGatewayRouteTableId)
+	}
+	return out
+}
+
+type transitGatewayRouteAttachmentOutput struct {
+	TransitGatewayAttachmentID string `json:"transitGatewayAttachmentId" yaml:"transitGatewayAttachmentId"`
+	ResourceType               string `json:"resourceType,omitempty" yaml:"resourceType,omitempty"`
+	ResourceID                 string `json:"resourceId,omitempty" yaml:"resourceId,omitempty"`
+}
+
+type transitGatewayRouteOutput struct {
+	DestinationCidrBlock string                                `json:"destinationCidrBlock,omitempty" yaml:"destinationCidrBlock,omitempty"`
+	PrefixListID         string                                `json:"prefixListId,omitempty" yaml:"prefixListId,omitempty"`
+	State                string                                `json:"state,omitempty" yaml:"state,omitempty"`
+	Type                 string                                `json:"type,omitempty" yaml:"type,omitempty"`
+	Attachments          []transitGatewayRouteAttachmentOutput `json:"attachments,omitempty" yaml:"attachments,omitempty"`
+}
+
+func newTransitGatewayRouteOutput(r *ec2.TransitGatewayRoute) transitGatewayRouteOutput {
+	out := transitGatewayRouteOutput{
+		DestinationCidrBlock: aws.StringValue(r.DestinationCidrBlock),
+		PrefixListID:         aws.StringValue(r.PrefixListId),
+		State:                aws.StringValue(r.State),
+		Type:                 aws.StringValue(r.Type),
+	}
+	for _, a := range r.TransitGatewayAttachments {
+		out.Attachments = append(out.Attachments, transitGatewayRouteAttachmentOutput{
+			TransitGatewayAttachmentID: aws.StringValue(a.TransitGatewayAttachmentId),
+			ResourceType:               aws.StringValue(a.ResourceType),
+			ResourceID:                 aws.StringValue(a.ResourceId),
+		})
+	}
+	return out
+}
+
+type routeContainerOutput struct {
+	Main         bool               `json:"main" yaml:"main"`
+	RouteTableId string             `json:"routeTableId" yaml:"routeTableId"`
+	Routes       []routeEntryOutput `json:"routes" yaml:"routes"`
+	SubnetID     string             `json:"subnetId,omitempty" yaml:"subnetId,omitempty"`
+}
+
+func (r *RouteContainer) output() routeContainerOutput {
+	out := routeContainerOutput{
+		Main:         r.Main,
+		RouteTableId: r.RouteTableId,
+		Routes:       []routeEntryOutput{},
+		SubnetID:     r.SubnetID,
+	}
+	for _, route := range r.Routes {
+		out.Routes = append(out.Routes, newRouteEntryOutput(route))
+	}
+	return out
+}
+
+// MarshalJSON writes the routes with the same field names as MarshalYAML
+func (r *RouteContainer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.output())
+}
+
+// MarshalYAML writes the routes with the same field names as MarshalJSON
+func (r *RouteContainer) MarshalYAML() (interface{}, error) {
+	return r.output(), nil
+}
+
+type interfaceStateOutput struct {
+	SubnetID           string                  `json:"subnetId" yaml:"subnetId"`
+	DeviceIndex        int64                   `json:"deviceIndex" yaml:"deviceIndex"`
+	PublicIP           string                  `json:"publicIp,omitempty" yaml:"publicIp,omitempty"`
+	PrivateIPAddresses []string                `json:"privateIpAddresses,omitempty" yaml:"privateIpAddresses,omitempty"`
+	IPv6Addresses      []string                `json:"ipv6Addresses,omitempty" yaml:"ipv6Addresses,omitempty"`
+	SecurityGroups     []groupIdentifierOutput `json:"securityGroups,omitempty" yaml:"securityGroups,omitempty"`
+}
+
+func (i *interfaceState) output() interfaceStateOutput {
+	return interfaceStateOutput{
+		SubnetID:           i.SubnetID,
+		DeviceIndex:        i.DeviceIndex,
+		PublicIP:           i.PublicIP,
+		PrivateIPAddresses: i.PrivateIPAddresses,
+		IPv6Addresses:      i.IPv6Addresses,
+		SecurityGroups:     newGroupIdentifierOutputs(i.SecurityGroups),
+	}
+}
+
+// MarshalJSON writes the interface with the same field names as MarshalYAML
+func (i *interfaceState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(i.output())
+}
+
+// MarshalYAML writes the interface with the same field names as MarshalJSON
+func (i *interfaceState) MarshalYAML() (interface{}, error) {
+	return i.output(), nil
+}
+
+type instanceStateOutput struct {
+	resourceLocation `yaml:",inline"`
+	InstanceId       string     `json:"instanceId" yaml:"instanceId"`
+	IAMProfile       string     `json:"iamProfile,omitempty" yaml:"iamProfile,omitempty"`
+	State            string     `json:"state" yaml:"state"`
+	LaunchTime       *time.Time `json:"launchTime,omitempty" yaml:"launchTime,omitempty"`
+	KeyName          string     `json:"keyName,omitempty" yaml:"keyName,omitempty"`
+	Name             string     `json:"name" yaml:"name"`
+
+	Tags               []tagOutput             `json:"tags,omitempty" yaml:"tags,omitempty"`
+	VpcID              string                  `json:"vpcId,omitempty" yaml:"vpcId,omitempty"`
+	PublicIP           string                  `json:"publicIp,omitempty" yaml:"publicIp,omitempty"`
+	SecurityGroups     []groupIdentifierOutput `json:"securityGroups,omitempty" yaml:"securityGroups,omitempty"`
+	SecurityGroupRules []*SecurityGroupRule    `json:"securityGroupRules,omitempty" yaml:"securityGroupRules,omitempty"`
+
+	SubnetIds             []string                           `json:"subnetIds,omitempty" yaml:"subnetIds,omitempty"`
+	NetworkInterfaces     map[string]string                  `json:"networkInterfaces,omitempty" yaml:"networkInterfaces,omitempty"`
+	Interfaces            map[string]*interfaceState         `json:"interfaces,omitempty" yaml:"interfaces,omitempty"`
+	AddressSecurityGroups map[string][]groupIdentifierOutput `json:"addressSecurityGroups,omitempty" yaml:"addressSecurityGroups,omitempty"`
+
+	PrivateIPAddresses []string `json:"privateIpAddresses,omitempty" yaml:"privateIpAddresses,omitempty"`
+	IPv6Addresses      []string `json:"ipv6Addresses,omitempty" yaml:"ipv6Addresses,omitempty"`
+
+	SubnetCIDRs     map[string]string            `json:"subnetCidrs,omitempty" yaml:"subnetCidrs,omitempty"`
+	SubnetIPv6CIDRs map[string]string            `json:"subnetIpv6Cidrs,omitempty" yaml:"subnetIpv6Cidrs,omitempty"`
+	NetworkAcls     map[string]*networkACLOutput `json:"networkAcls,omitempty" yaml:"networkAcls,omitempty"`
+
+	Routes      []*RouteContainer   `json:"routes,omitempty" yaml:"routes,omitempty"`
+	PrefixLists map[string][]string `json:"prefixLists,omitempty" yaml:"prefixLists,omitempty"`
+
+	PeeringConnections        map[string]*peeringConnectionOutput        `json:"peeringConnections,omitempty" yaml:"peeringConnections,omitempty"`
+	TransitGatewayAttachments map[string]*transitGatewayAttachmentOutput `json:"transitGatewayAttachments,omitempty" yaml:"transitGatewayAttachments,omitempty"`
+	TransitGatewayRoutes      map[string][]transitGatewayRouteOutput     `json:"transitGatewayRoutes,omitempty" yaml:"transitGatewayRoutes,omitempty"`
+}
+
+func (s *instanceState) output() instanceStateOutput {
+	out := instanceStateOutput{
+		resourceLocation:   s.resourceLocation,
+		InstanceId:         s.InstanceId,
+		IAMProfile:         s.IAMProfile,
+		State:              s.State,
+		LaunchTime:         s.LaunchTime,
+		KeyName:            s.KeyName,
+		Name:               s.Name,
+		Tags:               newTagOutputs(s.Tags),
+		VpcID:              s.VpcID,
+		PublicIP:           s.PublicIP,
+		SecurityGroups:     newGroupIdentifierOutputs(s.SecurityGroups),
+		SecurityGroupRules: s.SecurityGroupRules,
+		SubnetIds:          s.SubnetIds,
+		NetworkInterfaces:  s.NetworkInterfaces,
+		Interfaces:         s.Interfaces,
+		PrivateIPAddresses: s.PrivateIPAddresses,
+		IPv6Addresses:      s.IPv6Addresses,
+		SubnetCIDRs:        s.SubnetCIDRs,
+		SubnetIPv6CIDRs:    s.SubnetIPv6CIDRs,
+		Routes:             s.Routes,
+		PrefixLists:        s.PrefixLists,
+	}
+	if len(s.AddressSecurityGroups) != 0 {
+		out.AddressSecurityGroups = make(map[string][]groupIdentifierOutput)
+		for address, groups := range s.AddressSecurityGroups {
+			out.AddressSecurityGroups[address] = newGroupIdentifierOutputs(groups)
+		}
+	}
+	if len(s.NetworkAcls) != 0 {
+		out.NetworkAcls = make(map[string]*networkACLOutput)
+		for subnetID, acl := range s.NetworkAcls {
+			out.NetworkAcls[subnetID] = newNetworkACLOutput(acl)
+		}
+	}
+	if len(s.PeeringConnections) != 0 {
+		out.PeeringConnections = make(map[string]*peeringConnectionOutput)
+		for id, p := range s.PeeringConnections {
+			out.PeeringConnections[id] = newPeeringConnectionOutput(p)
+		}
+	}
+	if len(s.TransitGatewayAttachments) != 0 {
+		out.TransitGatewayAttachments = make(map[string]*transitGatewayAttachmentOutput)
+		for id, a := range s.TransitGatewayAttachments {
+			out.TransitGatewayAttachments[id] = newTransitGatewayAttachmentOutput(a)
+		}
+	}
+	if len(s.TransitGatewayRoutes) != 0 {
+		out.TransitGatewayRoutes = make(map[string][]transitGatewayRouteOutput)
+		for id, routes := range s.TransitGatewayRoutes {
+			for _, r := range routes {
+				out.TransitGatewayRoutes[id] = append(out.TransitGatewayRoutes[id], newTransitGatewayRouteOutput(r))
+			}
+		}
+	}
+	return out
+}
+
+// MarshalJSON writes the instance state with the same field names as
+// MarshalYAML
+func (s *instanceState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.output())
+}
+
+// MarshalYAML writes the instance state with the same field names as
+// MarshalJSON
+func (s *instanceState) MarshalYAML() (interface{}, error) {
+	return s.output(), nil
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func captureOutput(format string, f func()) string {
+	var buf bytes.Buffer
+	oldWriter, oldType := outputWriter, outputType
+	outputWriter, outputType = &buf, format
+	defer func() {
+		outputWriter, outputType = oldWriter, oldType
+	}()
+	f()
+	return buf.String()
+}
+
+func TestRenderItemsTable(t *testing.T) {
+	table := newTableOutput("Name", "VPCID")
+	table.addRow("default", "vpc-1234")
+
+	out := captureOutput(outputTable, func() {
+		assert.NoError(t, renderItems("VpcList", []vpcOutput{}, table))
+	})
+	lines := strings.Split(out, "\n")
+	assert.Equal(t, "Name     VPCID     ", lines[0])
+	assert.Equal(t, "----     -----     ", lines[1])
+	assert.Equal(t, "default  vpc-1234  ", lines[2])
+}
+
+func TestRenderItemsJSON(t *testing.T) {
+	items := []vpcOutput{{Name: "default", VpcId: "vpc-1234", CidrBlock: "172.31.0.0/16", IsDefault: true}}
+
+	out := captureOutput(outputJSON, func() {
+		assert.NoError(t, renderItems("VpcList", items, nil))
+	})
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(out), &doc))
+	assert.Equal(t, outputAPIVersion, doc["apiVersion"])
+	assert.Equal(t, "VpcList", doc["kind"])
+	assert.Nil(t, doc["result"])
+
+	vpc := doc["items"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "vpc-1234", vpc["vpcId"])
+	assert.Equal(t, true, vpc["isDefault"])
+}
+
+func TestRenderCheckResultsYAML(t *testing.T) {
+	r := newCheckResult()
+	r.DisplayText = "Security Group at Source allows Egress Traffic"
+
+	out := captureOutput(outputYAML, func() {
+		assert.Equal(t, errCheckFailed, renderCheckResults("ConnectivityReport", &r))
+	})
+
+	var doc struct {
+		APIVersion string `yaml:"apiVersion"`
+		Kind       string `yaml:"kind"`
+		Result     bool   `yaml:"result"`
+		Items      []struct {
+			Result      bool   `yaml:"result"`
+			DisplayText string `yaml:"displayText"`
+		} `yaml:"items"`
+	}
+	assert.NoError(t, yaml.Unmarshal([]byte(out), &doc))
+	assert.Equal(t, outputAPIVersion, doc.APIVersion)
+	assert.False(t, doc.Result)
+	assert.Equal(t, r.DisplayText, doc.Items[0].DisplayText)
+}
+
+// documentKeys collects the path of every key in a decoded JSON or YAML
+// document
+func documentKeys(v interface{}, path string, keys map[string]bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			keys[path+"."+k] = true
+			documentKeys(child, path+"."+k, keys)
+		}
+	case map[interface{}]interface{}:
+		for k, child := range v {
+			key := fmt.Sprintf("%s.%v", path, k)
+			keys[key] = true
+			documentKeys(child, key, keys)
+		}
+	case []interface{}:
+		for _, child := range v {
+			documentKeys(child, path+"[]", keys)
+		}
+	}
+}
+
+func TestRenderInstanceStateSameSchema(t *testing.T) {
+	c := newConnectivityFixture()
+	state := loadInstanceState(t, c, "i-dst")
+	state.Tags = []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("db")}}
+
+	var jsonDoc, yamlDoc interface{}
+	out := captureOutput(outputJSON, func() {
+		assert.NoError(t, renderItems("InstanceList", []*instanceState{state}, nil))
+	})
+	assert.NoError(t, json.Unmarshal([]byte(out), &jsonDoc))
+	out = captureOutput(outputYAML, func() {
+		assert.NoError(t, renderItems("InstanceList", []*instanceState{state}, nil))
+	})
+	assert.NoError(t, yaml.Unmarshal([]byte(out), &yamlDoc))
+
+	jsonKeys, yamlKeys := make(map[string]bool), make(map[string]bool)
+	documentKeys(jsonDoc, "", jsonKeys)
+	documentKeys(yamlDoc, "", yamlKeys)
+	assert.Equal(t, jsonKeys, yamlKeys)
+	assert.True(t, jsonKeys[".items[].securityGroups[].groupId"])
+	assert.True(t, jsonKeys[".items[].routes[].routes[].destinationCidrBlock"])
+	assert.True(t, jsonKeys[".items[].networkAcls.subnet-b.entries[].ruleAction"])
+	assert.True(t, jsonKeys[".items[].securityGroupRules[].permission.userIdGroupPairs[].groupId"])
+}
+
+func TestValidateOutputType(t *testing.T) {
+	outputType = "xml"
+	defer func() { outputType = outputTable }()
+	assert.Error(t, validateOutputType())
+}
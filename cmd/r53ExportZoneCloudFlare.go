@@ -15,23 +15,21 @@
 package cmd
 
 import (
+	"fmt"
 	"log"
-	"os"
 
-	"fmt"
 	"strings"
 
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/cloudflare/cloudflare-go"
 	"github.com/spf13/cobra"
 )
 
-func AddRecordsToCloudflare(cfClient *cloudflare.API, zoneName string, recordSet *route53.ListResourceRecordSetsOutput) []*cloudflare.DNSRecordResponse {
+func AddRecordsToCloudflare(cfClient *cloudflare.API, zoneName string, recordSet *route53.ListResourceRecordSetsOutput) ([]*cloudflare.DNSRecordResponse, error) {
 
 	zoneID, err := cfClient.ZoneIDByName(zoneName)
 	if err != nil {
-		log.Fatal("Cloudflare: " + err.Error())
+		return nil, fmt.Errorf("Cloudflare: %w", err)
 	}
 
 	var cfDNSRecords []*cloudflare.DNSRecordResponse
@@ -53,7 +51,7 @@ func AddRecordsToCloudflare(cfClient *cloudflare.API, zoneName string, recordSet
 
 			records, err := cfClient.DNSRecords(zoneID, cFlareRecord)
 			if err != nil {
-				log.Fatal(err)
+				return nil, fmt.Errorf("Cloudflare: %w", err)
 			}
 			if len(records) != 0 {
 				log.Printf("Found existing record(s) for %s\n", cFlareRecord.Name)
@@ -73,7 +71,7 @@ func AddRecordsToCloudflare(cfClient *cloudflare.API, zoneName string, recordSet
 					log.Printf("Creating DNS record in cloudflare: %v\n", cFlareRecord)
 					r, err := cfClient.CreateDNSRecord(zoneID, cFlareRecord)
 					if err != nil {
-						log.Fatal(err)
+						return nil, fmt.Errorf("Cloudflare: %w", err)
 					}
 					cfDNSRecords = append(cfDNSRecords, r)
 				}
@@ -81,39 +79,67 @@ func AddRecordsToCloudflare(cfClient *cloudflare.API, zoneName string, recordSet
 		}
 	}
 
-	return cfDNSRecords
+	return cfDNSRecords, nil
 }
 
 var exportR53ZoneCloudflareCmd = &cobra.Command{
 	Use:   "export-zone-cloudflare",
 	Short: "Export zone and record sets to cloudflare",
 	Long: `Create zone and copy DNS records to CloudFlare DNS
-		
+
+	The Cloudflare credentials are read from --cloudflare-api-key and --cloudflare-email, the
+	CF_API_KEY and CF_API_EMAIL environment variables or the config file:
+
+	[commands."r53 export-zone-cloudflare"]
+	cloudflare-email = "admin@example.com"
 	`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		if len(r53ZoneName) == 0 {
-			log.Fatal("Specify zone name")
+			return newUsageError("Specify zone name")
+		}
+		if len(cloudflareAPIKey) == 0 || len(cloudflareEmail) == 0 {
+			return newUsageError("Specify the Cloudflare API key and email")
 		}
 
-		r53ZoneId, err := GetRoute53ZoneID(r53ZoneName)
+		svc := getClients().Route53()
+		r53ZoneId, err := GetRoute53ZoneID(svc, r53ZoneName)
+		if err != nil {
+			return err
+		}
+		cfClient, err := cloudflare.New(cloudflareAPIKey, cloudflareEmail)
+		if err != nil {
+			return err
+		}
+		recordSets, err := ListR53RecordSets(svc, r53ZoneId)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		svc := route53.New(session.New())
-		cfClient, err := cloudflare.New(os.Getenv("CF_API_KEY"), os.Getenv("CF_API_EMAIL"))
+		res, err := AddRecordsToCloudflare(cfClient, r53ZoneName, recordSets)
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+		if res == nil {
+			res = []*cloudflare.DNSRecordResponse{}
 		}
-		res := AddRecordsToCloudflare(cfClient, r53ZoneName, ListR53RecordSets(svc, r53ZoneId))
-		fmt.Println(res)
 
+		table := newTableOutput("Name", "Type", "Content")
+		for _, r := range res {
+			table.addRow(r.Result.Name, r.Result.Type, r.Result.Content)
+		}
+		return renderItems("CloudflareRecordList", res, table)
 	},
 }
 
-var r53ZoneName string
+var (
+	r53ZoneName      string
+	cloudflareAPIKey string
+	cloudflareEmail  string
+)
 
 func init() {
 	r53Cmd.AddCommand(exportR53ZoneCloudflareCmd)
 	exportR53ZoneCloudflareCmd.Flags().StringVarP(&r53ZoneName, "zone-name", "", "", "Zone name to export records for")
+	exportR53ZoneCloudflareCmd.Flags().StringVarP(&cloudflareAPIKey, "cloudflare-api-key", "", "", "Cloudflare API key (defaults to CF_API_KEY or the config file)")
+	exportR53ZoneCloudflareCmd.Flags().StringVarP(&cloudflareEmail, "cloudflare-email", "", "", "Cloudflare account email (defaults to CF_API_EMAIL or the config file)")
 }
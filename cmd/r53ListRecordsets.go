@@ -16,13 +16,51 @@ package cmd
 
 import (
 	"fmt"
-	"log"
+	"strings"
 
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/spf13/cobra"
 )
 
+type r53RecordOutput struct {
+	Name        string   `json:"name" yaml:"name"`
+	Type        string   `json:"type" yaml:"type"`
+	TTL         int64    `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	Values      []string `json:"values,omitempty" yaml:"values,omitempty"`
+	AliasTarget string   `json:"aliasTarget,omitempty" yaml:"aliasTarget,omitempty"`
+}
+
+func displayR53RecordSets(recordSets *route53.ListResourceRecordSetsOutput) error {
+	items := []r53RecordOutput{}
+	table := newTableOutput("Name", "Type", "TTL", "Value")
+
+	if recordSets != nil {
+		for _, rs := range recordSets.ResourceRecordSets {
+			item := r53RecordOutput{
+				Name: *rs.Name,
+				Type: *rs.Type,
+			}
+			if rs.TTL != nil {
+				item.TTL = *rs.TTL
+			}
+			for _, r := range rs.ResourceRecords {
+				item.Values = append(item.Values, *r.Value)
+			}
+			if rs.AliasTarget != nil {
+				item.AliasTarget = *rs.AliasTarget.DNSName
+			}
+			items = append(items, item)
+
+			value := strings.Join(item.Values, ",")
+			if len(item.AliasTarget) != 0 {
+				value = "ALIAS " + item.AliasTarget
+			}
+			table.addRow(item.Name, item.Type, fmt.Sprintf("%d", item.TTL), value)
+		}
+	}
+	return renderItems("ResourceRecordSetList", items, table)
+}
+
 // listVpcsCmd represents the list vpc command
 var listR53RecordsCmd = &cobra.Command{
 	Use:   "list-records",
@@ -34,14 +72,17 @@ var listR53RecordsCmd = &cobra.Command{
 		$ yawsi r53 list-records --zone-id example.com
 	
 	`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		if len(r53zoneId) == 0 {
-			log.Fatal("Specify zone-name")
+			return newUsageError("Specify zone-id")
+		}
+		svc := getClients().Route53()
+		r, err := ListR53RecordSets(svc, r53zoneId)
+		if err != nil {
+			return err
 		}
-		svc := route53.New(session.New())
-		r := ListR53RecordSets(svc, r53zoneId)
-		fmt.Println(r)
+		return displayR53RecordSets(r)
 	},
 }
 
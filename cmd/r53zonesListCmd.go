@@ -29,8 +29,8 @@ var listR53ZonesCmd = &cobra.Command{
 		$ yawsi r53 list-zones		
 	
 	`,
-	Run: func(cmd *cobra.Command, args []string) {
-		ListR53Zones()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return ListR53Zones(getClients().Route53())
 	},
 }
 
@@ -0,0 +1,210 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+)
+
+// The port of each engine, used when RDS doesn't tell us (e.g. while the
+// instance is being created)
+var defaultEnginePorts = map[string]int64{
+	"aurora":            3306,
+	"aurora-mysql":      3306,
+	"aurora-postgresql": 5432,
+	"mariadb":           3306,
+	"mysql":             3306,
+	"postgres":          5432,
+	"oracle-ee":         1521,
+	"oracle-se":         1521,
+	"oracle-se1":        1521,
+	"oracle-se2":        1521,
+	"sqlserver-ee":      1433,
+	"sqlserver-se":      1433,
+	"sqlserver-ex":      1433,
+	"sqlserver-web":     1433,
+}
+
+// lookupHost resolves the endpoint of a database, tests replace it
+var lookupHost = net.LookupHost
+
+// databaseTarget is an RDS DB instance or Aurora cluster as a connectivity
+// destination
+type databaseTarget struct {
+	state    *instanceState
+	port     int64
+	endpoint string
+}
+
+// isDatabaseDestination reports whether a destination is a database, either
+// db:<identifier> or the ARN of a DB instance or cluster
+func isDatabaseDestination(destination string) bool {
+	if strings.HasPrefix(destination, "db:") {
+		return true
+	}
+	parsed, err := arn.Parse(destination)
+	return err == nil && parsed.Service == "rds" &&
+		(strings.HasPrefix(parsed.Resource, "db:") || strings.HasPrefix(parsed.Resource, "cluster:"))
+}
+
+func isDBNotFound(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && (aerr.Code() == rds.ErrCodeDBInstanceNotFoundFault || aerr.Code() == rds.ErrCodeDBClusterNotFoundFault)
+}
+
+// getDatabaseTarget returns the database for db:<identifier>, which is
+// looked up as a DB instance and then as a cluster, or an ARN
+func getDatabaseTarget(svc rdsiface.RDSAPI, destination string) (*databaseTarget, error) {
+	identifier := strings.TrimPrefix(destination, "db:")
+	lookupInstance, lookupCluster := true, true
+	if parsed, err := arn.Parse(destination); err == nil {
+		kind := strings.SplitN(parsed.Resource, ":", 2)
+		identifier = kind[1]
+		lookupInstance, lookupCluster = kind[0] == "db", kind[0] == "cluster"
+	}
+
+	if lookupInstance {
+		result, err := svc.DescribeDBInstances(&rds.DescribeDBInstancesInput{DBInstanceIdentifier: aws.String(identifier)})
+		if err == nil && len(result.DBInstances) == 1 {
+			return newDBInstanceTarget(result.DBInstances[0]), nil
+		}
+		if err != nil && !isDBNotFound(err) {
+			return nil, fmt.Errorf("Couldn't describe DB instance %s: %w", identifier, err)
+		}
+	}
+	if lookupCluster {
+		result, err := svc.DescribeDBClusters(&rds.DescribeDBClustersInput{DBClusterIdentifier: aws.String(identifier)})
+		if err == nil && len(result.DBClusters) == 1 {
+			return newDBClusterTarget(svc, result.DBClusters[0])
+		}
+		if err != nil && !isDBNotFound(err) {
+			return nil, fmt.Errorf("Couldn't describe DB cluster %s: %w", identifier, err)
+		}
+	}
+	return nil, newNotFoundError("Database %s not found", identifier)
+}
+
+func databaseSecurityGroups(memberships []*rds.VpcSecurityGroupMembership) []*ec2.GroupIdentifier {
+	var groups []*ec2.GroupIdentifier
+	for _, m := range memberships {
+		groups = append(groups, &ec2.GroupIdentifier{GroupId: m.VpcSecurityGroupId})
+	}
+	return groups
+}
+
+func databasePort(port *int64, engine *string) int64 {
+	if aws.Int64Value(port) != 0 {
+		return *port
+	}
+	return defaultEnginePorts[aws.StringValue(engine)]
+}
+
+func newDBInstanceTarget(db *rds.DBInstance) *databaseTarget {
+	target := &databaseTarget{
+		state: &instanceState{
+			InstanceId:     *db.DBInstanceIdentifier,
+			Name:           *db.DBInstanceIdentifier,
+			State:          aws.StringValue(db.DBInstanceStatus),
+			SecurityGroups: databaseSecurityGroups(db.VpcSecurityGroups),
+		},
+		port: databasePort(db.DbInstancePort, db.Engine),
+	}
+	if db.Endpoint != nil {
+		target.endpoint = aws.StringValue(db.Endpoint.Address)
+		target.port = databasePort(db.Endpoint.Port, db.Engine)
+	}
+	if db.DBSubnetGroup != nil {
+		target.state.VpcID = aws.StringValue(db.DBSubnetGroup.VpcId)
+		for _, subnet := range db.DBSubnetGroup.Subnets {
+			target.state.SubnetIds = append(target.state.SubnetIds, *subnet.SubnetIdentifier)
+		}
+	}
+	return target
+}
+
+// newDBClusterTarget returns the target for the cluster (writer) endpoint of
+// an Aurora cluster. Unlike a DB instance, the subnet group of a cluster is
+// only returned by name.
+func newDBClusterTarget(svc rdsiface.RDSAPI, cluster *rds.DBCluster) (*databaseTarget, error) {
+	target := &databaseTarget{
+		state: &instanceState{
+			InstanceId:     *cluster.DBClusterIdentifier,
+			Name:           *cluster.DBClusterIdentifier,
+			State:          aws.StringValue(cluster.Status),
+			SecurityGroups: databaseSecurityGroups(cluster.VpcSecurityGroups),
+		},
+		port:     databasePort(cluster.Port, cluster.Engine),
+		endpoint: aws.StringValue(cluster.Endpoint),
+	}
+	if cluster.DBSubnetGroup == nil {
+		return target, nil
+	}
+	result, err := svc.DescribeDBSubnetGroups(&rds.DescribeDBSubnetGroupsInput{DBSubnetGroupName: cluster.DBSubnetGroup})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe DB subnet group %s: %w", *cluster.DBSubnetGroup, err)
+	}
+	for _, group := range result.DBSubnetGroups {
+		target.state.VpcID = aws.StringValue(group.VpcId)
+		for _, subnet := range group.Subnets {
+			target.state.SubnetIds = append(target.state.SubnetIds, *subnet.SubnetIdentifier)
+		}
+	}
+	return target, nil
+}
+
+// resolveNetworks returns the addresses to check connectivity to, once the
+// state has been enriched. These are the addresses the endpoint resolves to
+// in the subnet group or, when we can't resolve it, any address of the
+// subnet group since the database can be moved to any of them (e.g. on
+// failover). The resolved addresses are added to the state.
+func (t *databaseTarget) resolveNetworks() []*net.IPNet {
+	var subnets, addresses []*net.IPNet
+	for _, subnetID := range t.state.SubnetIds {
+		if network, err := parseNetwork(t.state.SubnetCIDRs[subnetID]); err == nil {
+			subnets = append(subnets, network)
+		}
+	}
+
+	if len(t.endpoint) != 0 {
+		ips, _ := lookupHost(t.endpoint)
+		for _, ip := range ips {
+			address, err := parseNetwork(ip)
+			if err != nil {
+				continue
+			}
+			for _, subnet := range subnets {
+				if networkContains(subnet, address) {
+					addresses = append(addresses, address)
+					t.state.PrivateIPAddresses = append(t.state.PrivateIPAddresses, ip)
+					break
+				}
+			}
+		}
+	}
+	if len(addresses) != 0 {
+		return addresses
+	}
+	return subnets
+}
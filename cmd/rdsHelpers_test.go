@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/stretchr/testify/assert"
+)
+
+// newDatabaseFixture adds the orders DB instance and the reports Aurora
+// cluster in subnet-b of the connectivity fixture, which allow TCP 5432
+// from sg-src
+func newDatabaseFixture() *fakeClients {
+	c := newConnectivityFixture()
+	c.ec2.SecurityGroups = append(c.ec2.SecurityGroups, &ec2.SecurityGroup{
+		GroupId: aws.String("sg-db"),
+		VpcId:   aws.String("vpc-1"),
+		IpPermissions: []*ec2.IpPermission{
+			{
+				IpProtocol:       aws.String("tcp"),
+				FromPort:         aws.Int64(5432),
+				ToPort:           aws.Int64(5432),
+				UserIdGroupPairs: []*ec2.UserIdGroupPair{{GroupId: aws.String("sg-src")}},
+			},
+		},
+	})
+	subnetGroup := &rds.DBSubnetGroup{
+		DBSubnetGroupName: aws.String("db-subnets"),
+		VpcId:             aws.String("vpc-1"),
+		Subnets:           []*rds.Subnet{{SubnetIdentifier: aws.String("subnet-b")}},
+	}
+	securityGroups := []*rds.VpcSecurityGroupMembership{{VpcSecurityGroupId: aws.String("sg-db")}}
+	c.rds.SubnetGroups = []*rds.DBSubnetGroup{subnetGroup}
+	c.rds.DBInstances = []*rds.DBInstance{{
+		DBInstanceIdentifier: aws.String("orders"),
+		Engine:               aws.String("postgres"),
+		Endpoint:             &rds.Endpoint{Address: aws.String("orders.example.com"), Port: aws.Int64(5432)},
+		DBSubnetGroup:        subnetGroup,
+		VpcSecurityGroups:    securityGroups,
+	}}
+	c.rds.DBClusters = []*rds.DBCluster{{
+		DBClusterIdentifier: aws.String("reports"),
+		Engine:              aws.String("aurora-postgresql"),
+		DBSubnetGroup:       aws.String("db-subnets"),
+		VpcSecurityGroups:   securityGroups,
+	}}
+	return c
+}
+
+func TestGetDatabaseTarget(t *testing.T) {
+	c := newDatabaseFixture()
+
+	assert.True(t, isDatabaseDestination("db:orders"))
+	assert.True(t, isDatabaseDestination("arn:aws:rds:us-east-1:123456789012:cluster:reports"))
+	assert.False(t, isDatabaseDestination("arn:aws:s3:::bucket"))
+
+	target, err := getDatabaseTarget(c.RDS(), "db:orders")
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(5432), target.port)
+		assert.Equal(t, []string{"subnet-b"}, target.state.SubnetIds)
+	}
+
+	// The port of the cluster comes from the engine
+	target, err = getDatabaseTarget(c.RDS(), "arn:aws:rds:us-east-1:123456789012:cluster:reports")
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(5432), target.port)
+		assert.Equal(t, "vpc-1", target.state.VpcID)
+		assert.Equal(t, []string{"subnet-b"}, target.state.SubnetIds)
+	}
+
+	_, err = getDatabaseTarget(c.RDS(), "arn:aws:rds:us-east-1:123456789012:db:reports")
+	assert.Equal(t, exitNotFound, exitCode(err))
+}
+
+func TestConnectivityChecksToDatabase(t *testing.T) {
+	defer func(f func(string) ([]string, error)) { lookupHost = f }(lookupHost)
+	c := newDatabaseFixture()
+	src := loadInstanceState(t, c, "i-src")
+	port := &ec2.PortRange{From: aws.Int64(5432), To: aws.Int64(5432)}
+
+	target, err := getDatabaseTarget(c.RDS(), "db:orders")
+	if !assert.NoError(t, err) || !assert.NoError(t, enrichInstanceStates(c.EC2(), target.state)) {
+		return
+	}
+
+	// The whole subnet group is checked when the endpoint doesn't resolve
+	lookupHost = func(host string) ([]string, error) { return nil, errors.New("no such host") }
+	networks := target.resolveNetworks()
+	if assert.Len(t, networks, 1) {
+		assert.Equal(t, "10.0.2.0/24", networks[0].String())
+	}
+
+	lookupHost = func(host string) ([]string, error) { return []string{"10.0.2.30"}, nil }
+	networks = target.resolveNetworks()
+	if !assert.Len(t, networks, 1) {
+		return
+	}
+	assert.Equal(t, []string{"10.0.2.30"}, target.state.PrivateIPAddresses)
+	assert.True(t, allPassed(checkConnectivityToState(src, addressNetworks(src.PrivateIPAddresses), target.state, networks[0], "tcp", port, &defaultEphermalPortRange)))
+
+	port = &ec2.PortRange{From: aws.Int64(3306), To: aws.Int64(3306)}
+	assert.False(t, allPassed(checkConnectivityToState(src, addressNetworks(src.PrivateIPAddresses), target.state, networks[0], "tcp", port, &defaultEphermalPortRange)))
+}
@@ -24,12 +24,67 @@ import (
 var RootCmd = &cobra.Command{
 	Use:   "yawsi",
 	Short: "Yet Another AWS Command Line Interface",
+	Long: `Yet Another AWS Command Line Interface
+
+Exit codes:
+
+	0	Success
+	1	One or more checks (e.g. connectivity) failed
+	2	Invalid usage
+	3	AWS authentication or permission error
+	4	Resource not found
+	5	AWS API throttling
+	6	Any other error
+`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyConfig(cmd); err != nil {
+			return &usageError{err.Error()}
+		}
+		if err := validateOutputType(); err != nil {
+			return &usageError{err.Error()}
+		}
+		if err := validateSessionFlags(); err != nil {
+			return &usageError{err.Error()}
+		}
+		if err := validateCacheFlags(); err != nil {
+			return &usageError{err.Error()}
+		}
+		// Errors from here on aren't about how the command was used
+		commandStarted = true
+		cmd.SilenceUsage = true
+		return nil
+	},
+	SilenceErrors: true,
 }
 
+// commandStarted is set once the flags and arguments have been validated
+var commandStarted bool
+
 // Execute is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := RootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	err := RootCmd.Execute()
+	if err != nil && !commandStarted {
+		// Unknown command/flag or invalid arguments
+		if _, ok := err.(*usageError); !ok {
+			err = &usageError{err.Error()}
+		}
 	}
+	if err != nil && err != errCheckFailed {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+	os.Exit(exitCode(err))
+}
+
+func init() {
+	RootCmd.PersistentFlags().StringVarP(&configFilePath, "config", "", "", "Config file (defaults to YAWSI_CONFIG or ~/.config/yawsi/config.toml)")
+	RootCmd.PersistentFlags().StringVarP(&configEnvironment, "env", "", "", "Environment from the config file to use (defaults to YAWSI_ENV)")
+	RootCmd.PersistentFlags().StringVarP(&outputType, "output", "", outputTable, "Output format (table, json, yaml)")
+	RootCmd.PersistentFlags().StringVarP(&awsProfile, "profile", "", "", "AWS profile to use (defaults to AWS_PROFILE)")
+	RootCmd.PersistentFlags().StringVarP(&awsRegion, "region", "", "", "AWS region to use (defaults to the profile's region or AWS_REGION)")
+	RootCmd.PersistentFlags().StringVarP(&roleArn, "role-arn", "", "", "ARN of an IAM role to assume")
+	RootCmd.PersistentFlags().StringVarP(&roleExternalID, "external-id", "", "", "External ID to use when assuming --role-arn")
+	RootCmd.PersistentFlags().StringVarP(&roleSessionName, "role-session-name", "", "", "Session name to use when assuming --role-arn")
+	RootCmd.PersistentFlags().BoolVarP(&noCache, "no-cache", "", false, "Don't use the response cache of the interactive pickers and shell completion")
+	RootCmd.PersistentFlags().BoolVarP(&refreshCache, "refresh", "", false, "Ignore the cached responses and fetch them again")
+	RootCmd.PersistentFlags().DurationVarP(&cacheTTL, "cache-ttl", "", defaultCacheTTL, "How long the cached responses are used for, 0 to not use the cache")
 }
@@ -0,0 +1,88 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+const defaultRegion = "us-east-1"
+
+// Set via the global flags on RootCmd
+var (
+	awsProfile      string
+	awsRegion       string
+	roleArn         string
+	roleExternalID  string
+	roleSessionName string
+)
+
+func validateSessionFlags() error {
+	if len(roleArn) == 0 && (len(roleExternalID) != 0 || len(roleSessionName) != 0) {
+		return errors.New("--external-id and --role-session-name can only be used with --role-arn")
+	}
+	return nil
+}
+
+// createSession is the only place we create AWS sessions. The region is
+// picked from (in order) the region argument, --region, the profile/
+// environment and finally defaults to us-east-1. The profile is --profile
+// or AWS_PROFILE. If --role-arn is specified, the role is assumed using the
+// credentials of the profile.
+func createSession(region ...string) *session.Session {
+	opts := session.Options{
+		SharedConfigState:       session.SharedConfigEnable,
+		Profile:                 awsProfile,
+		AssumeRoleTokenProvider: stscreds.StdinTokenProvider,
+	}
+	if len(region) == 1 && len(region[0]) != 0 {
+		opts.Config.Region = aws.String(region[0])
+	} else if len(awsRegion) != 0 {
+		opts.Config.Region = aws.String(awsRegion)
+	}
+
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		exitWithError(fmt.Errorf("Couldn't create a session to talk to AWS: %w", err))
+	}
+	if len(aws.StringValue(sess.Config.Region)) == 0 {
+		sess.Config.Region = aws.String(defaultRegion)
+	}
+
+	if len(roleArn) != 0 {
+		creds := assumeRoleCredentials(sess, roleArn, roleExternalID, roleSessionName)
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+	}
+	return sess
+}
+
+// assumeRoleCredentials returns credentials for the role which are refreshed
+// using the credentials of sess as they expire
+func assumeRoleCredentials(sess *session.Session, arn string, externalID string, sessionName string) *credentials.Credentials {
+	return stscreds.NewCredentials(sess, arn, func(p *stscreds.AssumeRoleProvider) {
+		if len(externalID) != 0 {
+			p.ExternalID = aws.String(externalID)
+		}
+		if len(sessionName) != 0 {
+			p.RoleSessionName = sessionName
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateSessionRegion(t *testing.T) {
+	for _, name := range []string{"AWS_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION", "AWS_CONFIG_FILE"} {
+		if value, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, value)
+		} else {
+			defer os.Unsetenv(name)
+		}
+		os.Unsetenv(name)
+	}
+	os.Setenv("AWS_CONFIG_FILE", os.DevNull)
+	defer func(region string) { awsRegion = region }(awsRegion)
+
+	awsRegion = ""
+	assert.Equal(t, defaultRegion, aws.StringValue(createSession().Config.Region))
+
+	os.Setenv("AWS_REGION", "ap-southeast-2")
+	assert.Equal(t, "ap-southeast-2", aws.StringValue(createSession().Config.Region))
+
+	awsRegion = "eu-west-1"
+	assert.Equal(t, "eu-west-1", aws.StringValue(createSession().Config.Region))
+	assert.Equal(t, "us-west-2", aws.StringValue(createSession("us-west-2").Config.Region))
+}
+
+func TestValidateSessionFlags(t *testing.T) {
+	defer func(arn, externalID string) {
+		roleArn, roleExternalID = arn, externalID
+	}(roleArn, roleExternalID)
+
+	roleArn, roleExternalID = "", "abc"
+	assert.Error(t, validateSessionFlags())
+
+	roleArn = "arn:aws:iam::123456789012:role/audit"
+	assert.NoError(t, validateSessionFlags())
+}
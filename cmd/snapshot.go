@@ -0,0 +1,325 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/spf13/cobra"
+)
+
+const snapshotKind = "NetworkSnapshot"
+
+// networkSnapshot is the network state of an account and region as
+// returned by the EC2 API. It is replayed via snapshotClients, so the commands
+// analyse a snapshot using the same code as when talking to AWS.
+type networkSnapshot struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	resourceLocation
+	CapturedAt time.Time `json:"capturedAt"`
+
+	Instances             []*ec2.Instance             `json:"instances"`
+	NetworkInterfaces     []*ec2.NetworkInterface     `json:"networkInterfaces"`
+	Subnets               []*ec2.Subnet               `json:"subnets"`
+	RouteTables           []*ec2.RouteTable           `json:"routeTables"`
+	NetworkAcls           []*ec2.NetworkAcl           `json:"networkAcls"`
+	SecurityGroups        []*ec2.SecurityGroup        `json:"securityGroups"`
+	Vpcs                  []*ec2.Vpc                  `json:"vpcs"`
+	VpcPeeringConnections []*ec2.VpcPeeringConnection `json:"vpcPeeringConnections"`
+	PrefixLists           []*ec2.PrefixList           `json:"prefixLists"`
+
+	ManagedPrefixLists []*ec2.ManagedPrefixList `json:"managedPrefixLists,omitempty"`
+	// Map of customer managed prefix list ID to its entries
+	PrefixListEntries map[string][]*ec2.PrefixListEntry `json:"prefixListEntries,omitempty"`
+
+	TransitGatewayAttachments []*ec2.TransitGatewayAttachment `json:"transitGatewayAttachments,omitempty"`
+	// Map of transit gateway route table ID to its routes
+	TransitGatewayRoutes map[string][]*ec2.TransitGatewayRoute `json:"transitGatewayRoutes,omitempty"`
+}
+
+// Set via --from-snapshot
+var snapshotFilePath string
+
+// captureSnapshot describes the network resources in the region of svc.
+// The account is the one of the credentials, which stsSvc looks up.
+func captureSnapshot(svc ec2iface.EC2API, stsSvc stsiface.STSAPI) (*networkSnapshot, error) {
+	s := &networkSnapshot{APIVersion: outputAPIVersion, Kind: snapshotKind, CapturedAt: time.Now().UTC()}
+
+	identity, err := stsSvc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't look up the account: %w", err)
+	}
+	s.Account = aws.StringValue(identity.Account)
+
+	// Each describe is retried from its first page when it's throttled, so
+	// the pages are only kept once they have all been described
+	err = retryThrottled(func() error {
+		var instances []*ec2.Instance
+		err := svc.DescribeInstancesPages(&ec2.DescribeInstancesInput{}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, r := range page.Reservations {
+				instances = append(instances, r.Instances...)
+			}
+			return true
+		})
+		s.Instances = instances
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe instances: %w", err)
+	}
+	err = retryThrottled(func() error {
+		var interfaces []*ec2.NetworkInterface
+		err := svc.DescribeNetworkInterfacesPages(&ec2.DescribeNetworkInterfacesInput{}, func(page *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+			interfaces = append(interfaces, page.NetworkInterfaces...)
+			return true
+		})
+		s.NetworkInterfaces = interfaces
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe network interfaces: %w", err)
+	}
+	err = retryThrottled(func() error {
+		var subnets []*ec2.Subnet
+		err := svc.DescribeSubnetsPages(&ec2.DescribeSubnetsInput{}, func(page *ec2.DescribeSubnetsOutput, lastPage bool) bool {
+			subnets = append(subnets, page.Subnets...)
+			return true
+		})
+		s.Subnets = subnets
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe subnets: %w", err)
+	}
+	err = retryThrottled(func() error {
+		var routeTables []*ec2.RouteTable
+		err := svc.DescribeRouteTablesPages(&ec2.DescribeRouteTablesInput{}, func(page *ec2.DescribeRouteTablesOutput, lastPage bool) bool {
+			routeTables = append(routeTables, page.RouteTables...)
+			return true
+		})
+		s.RouteTables = routeTables
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe route tables: %w", err)
+	}
+	err = retryThrottled(func() error {
+		var acls []*ec2.NetworkAcl
+		err := svc.DescribeNetworkAclsPages(&ec2.DescribeNetworkAclsInput{}, func(page *ec2.DescribeNetworkAclsOutput, lastPage bool) bool {
+			acls = append(acls, page.NetworkAcls...)
+			return true
+		})
+		s.NetworkAcls = acls
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe network ACLs: %w", err)
+	}
+	err = retryThrottled(func() error {
+		var groups []*ec2.SecurityGroup
+		err := svc.DescribeSecurityGroupsPages(&ec2.DescribeSecurityGroupsInput{}, func(page *ec2.DescribeSecurityGroupsOutput, lastPage bool) bool {
+			groups = append(groups, page.SecurityGroups...)
+			return true
+		})
+		s.SecurityGroups = groups
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe security groups: %w", err)
+	}
+	err = retryThrottled(func() error {
+		var vpcs []*ec2.Vpc
+		err := svc.DescribeVpcsPages(&ec2.DescribeVpcsInput{}, func(page *ec2.DescribeVpcsOutput, lastPage bool) bool {
+			vpcs = append(vpcs, page.Vpcs...)
+			return true
+		})
+		s.Vpcs = vpcs
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe VPCs: %w", err)
+	}
+	err = retryThrottled(func() error {
+		var peerings []*ec2.VpcPeeringConnection
+		err := svc.DescribeVpcPeeringConnectionsPages(&ec2.DescribeVpcPeeringConnectionsInput{}, func(page *ec2.DescribeVpcPeeringConnectionsOutput, lastPage bool) bool {
+			peerings = append(peerings, page.VpcPeeringConnections...)
+			return true
+		})
+		s.VpcPeeringConnections = peerings
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe VPC peering connections: %w", err)
+	}
+	err = retryThrottled(func() error {
+		var prefixLists []*ec2.PrefixList
+		err := svc.DescribePrefixListsPages(&ec2.DescribePrefixListsInput{}, func(page *ec2.DescribePrefixListsOutput, lastPage bool) bool {
+			prefixLists = append(prefixLists, page.PrefixLists...)
+			return true
+		})
+		s.PrefixLists = prefixLists
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe prefix lists: %w", err)
+	}
+	err = retryThrottled(func() error {
+		var managedPrefixLists []*ec2.ManagedPrefixList
+		err := svc.DescribeManagedPrefixListsPages(&ec2.DescribeManagedPrefixListsInput{}, func(page *ec2.DescribeManagedPrefixListsOutput, lastPage bool) bool {
+			managedPrefixLists = append(managedPrefixLists, page.PrefixLists...)
+			return true
+		})
+		s.ManagedPrefixLists = managedPrefixLists
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe managed prefix lists: %w", err)
+	}
+	// The entries of the AWS managed prefix lists are in PrefixLists
+	for _, pl := range s.ManagedPrefixLists {
+		if aws.StringValue(pl.OwnerId) == "AWS" {
+			continue
+		}
+		var entries []*ec2.PrefixListEntry
+		err = retryThrottled(func() error {
+			var pageEntries []*ec2.PrefixListEntry
+			err := svc.GetManagedPrefixListEntriesPages(&ec2.GetManagedPrefixListEntriesInput{PrefixListId: pl.PrefixListId}, func(page *ec2.GetManagedPrefixListEntriesOutput, lastPage bool) bool {
+				pageEntries = append(pageEntries, page.Entries...)
+				return true
+			})
+			entries = pageEntries
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't get the entries of prefix list %s: %w", aws.StringValue(pl.PrefixListId), err)
+		}
+		if s.PrefixListEntries == nil {
+			s.PrefixListEntries = make(map[string][]*ec2.PrefixListEntry)
+		}
+		s.PrefixListEntries[aws.StringValue(pl.PrefixListId)] = entries
+	}
+	err = retryThrottled(func() error {
+		var attachments []*ec2.TransitGatewayAttachment
+		err := svc.DescribeTransitGatewayAttachmentsPages(&ec2.DescribeTransitGatewayAttachmentsInput{}, func(page *ec2.DescribeTransitGatewayAttachmentsOutput, lastPage bool) bool {
+			attachments = append(attachments, page.TransitGatewayAttachments...)
+			return true
+		})
+		s.TransitGatewayAttachments = attachments
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't describe transit gateway attachments: %w", err)
+	}
+	for _, attachment := range s.TransitGatewayAttachments {
+		if attachment.Association == nil {
+			continue
+		}
+		routeTableID := aws.StringValue(attachment.Association.TransitGatewayRouteTableId)
+		if _, ok := s.TransitGatewayRoutes[routeTableID]; ok {
+			continue
+		}
+		var routes []*ec2.TransitGatewayRoute
+		err = retryThrottled(func() (err error) {
+			routes, err = searchTransitGatewayRoutes(svc, routeTableID)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+		if s.TransitGatewayRoutes == nil {
+			s.TransitGatewayRoutes = make(map[string][]*ec2.TransitGatewayRoute)
+		}
+		s.TransitGatewayRoutes[routeTableID] = routes
+	}
+
+	return s, nil
+}
+
+func saveSnapshot(filePath string, s *networkSnapshot) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, append(data, '\n'), 0600)
+}
+
+func loadSnapshot(filePath string) (*networkSnapshot, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, newNotFoundError("Snapshot %s not found", filePath)
+		}
+		return nil, err
+	}
+	var s networkSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, newUsageError("Error reading snapshot %s: %v", filePath, err)
+	}
+	if s.Kind != snapshotKind || s.APIVersion != outputAPIVersion {
+		return nil, newUsageError("%s is not a %s snapshot", filePath, outputAPIVersion)
+	}
+	return &s, nil
+}
+
+// useSnapshot makes the command read the snapshot specified via
+// --from-snapshot instead of talking to AWS
+func useSnapshot(cmd *cobra.Command, args []string) error {
+	if len(snapshotFilePath) == 0 {
+		return nil
+	}
+	if isMultiTarget() {
+		return newUsageError("--from-snapshot cannot be used when listing across accounts or regions")
+	}
+	s, err := loadSnapshot(snapshotFilePath)
+	if err != nil {
+		return err
+	}
+	c, err := newSnapshotClients(s)
+	if err != nil {
+		return err
+	}
+	clients = c
+	return nil
+}
+
+// addSnapshotFlag adds --from-snapshot to a command. The snapshot is loaded
+// after the command's own pre-run hook.
+func addSnapshotFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&snapshotFilePath, "from-snapshot", "", "", "Analyse a snapshot created via `snapshot capture` instead of talking to AWS")
+	cmd.MarkFlagFilename("from-snapshot", "json")
+
+	// cobra only runs PreRun when there is no PreRunE
+	preRunE, preRun := cmd.PreRunE, cmd.PreRun
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if preRunE != nil {
+			if err := preRunE(cmd, args); err != nil {
+				return err
+			}
+		} else if preRun != nil {
+			preRun(cmd, args)
+		}
+		return useSnapshot(cmd, args)
+	}
+}
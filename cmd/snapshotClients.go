@@ -0,0 +1,571 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// snapshotClients serve the EC2 calls of the offline analysis from a
+// snapshot (see networkSnapshot). The calls a snapshot can't answer, such as
+// those to the other services, fail instead of talking to AWS.
+type snapshotClients struct {
+	*sessionClients
+	ec2 *snapshotEC2
+}
+
+func newSnapshotClients(s *networkSnapshot) (*snapshotClients, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(s.Region), Credentials: credentials.AnonymousCredentials})
+	if err != nil {
+		return nil, err
+	}
+	sess.Handlers.Validate.Clear()
+	sess.Handlers.Validate.PushBack(func(r *request.Request) {
+		r.Error = newUsageError("%s %s can't be answered from a snapshot", r.ClientInfo.ServiceName, r.Operation.Name)
+	})
+	c := &snapshotClients{sessionClients: newSessionClients(sess)}
+	c.ec2 = &snapshotEC2{EC2API: c.sessionClients.EC2(), networkSnapshot: s}
+	return c, nil
+}
+
+func (c *snapshotClients) EC2() ec2iface.EC2API       { return c.ec2 }
+func (c *snapshotClients) CachedEC2() ec2iface.EC2API { return c.ec2 }
+
+// matchesFilterValue supports the "*" and "?" wildcards like the EC2 API
+func matchesFilterValue(wanted []*string, values []string) bool {
+	for _, w := range wanted {
+		for _, v := range values {
+			if ok, _ := path.Match(*w, v); ok || *w == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesFilters reports whether a resource matches all the filters. values
+// returns the values of the resource for a filter name.
+func matchesFilters(filters []*ec2.Filter, tags []*ec2.Tag, values func(name string) []string) bool {
+	for _, f := range filters {
+		var resourceValues []string
+		switch {
+		case strings.HasPrefix(*f.Name, "tag:"):
+			for _, tag := range tags {
+				if *tag.Key == strings.TrimPrefix(*f.Name, "tag:") {
+					resourceValues = append(resourceValues, *tag.Value)
+				}
+			}
+		case *f.Name == "tag-key":
+			for _, tag := range tags {
+				resourceValues = append(resourceValues, *tag.Key)
+			}
+		default:
+			resourceValues = values(*f.Name)
+		}
+		if !matchesFilterValue(f.Values, resourceValues) {
+			return false
+		}
+	}
+	return true
+}
+
+// selectedByID reports whether id is one of ids, an empty ids selects everything
+func selectedByID(ids []*string, id *string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	for _, i := range ids {
+		if aws.StringValue(i) == aws.StringValue(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAllFound returns the NotFound errors returned by AWS when one of the
+// requested IDs doesn't exist
+func checkAllFound(code string, ids []*string, found func(id string) bool) error {
+	for _, id := range ids {
+		if !found(*id) {
+			return awserr.New(code, fmt.Sprintf("The ID '%s' does not exist", *id), nil)
+		}
+	}
+	return nil
+}
+
+// snapshotEC2 implements the describe calls of the analysis with the
+// resources of the snapshot, filtered the way the API filters them. The
+// other calls go to the embedded interface.
+type snapshotEC2 struct {
+	ec2iface.EC2API
+	*networkSnapshot
+}
+
+func (e *snapshotEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	reservation := &ec2.Reservation{}
+	for _, i := range e.Instances {
+		if !selectedByID(input.InstanceIds, i.InstanceId) {
+			continue
+		}
+		match := matchesFilters(input.Filters, i.Tags, func(name string) []string {
+			switch name {
+			case "instance-id":
+				return []string{aws.StringValue(i.InstanceId)}
+			case "vpc-id":
+				return []string{aws.StringValue(i.VpcId)}
+			case "subnet-id":
+				return []string{aws.StringValue(i.SubnetId)}
+			case "instance-state-name":
+				return []string{aws.StringValue(i.State.Name)}
+			case "private-ip-address":
+				return []string{aws.StringValue(i.PrivateIpAddress)}
+			case "ip-address":
+				return []string{aws.StringValue(i.PublicIpAddress)}
+			}
+			return nil
+		})
+		if match {
+			reservation.Instances = append(reservation.Instances, i)
+		}
+	}
+
+	err := checkAllFound("InvalidInstanceID.NotFound", input.InstanceIds, func(id string) bool {
+		for _, i := range e.Instances {
+			if *i.InstanceId == id {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	output := &ec2.DescribeInstancesOutput{}
+	if len(reservation.Instances) != 0 {
+		output.Reservations = []*ec2.Reservation{reservation}
+	}
+	return output, nil
+}
+
+func (e *snapshotEC2) DescribeInstancesPages(input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	output, err := e.DescribeInstances(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (e *snapshotEC2) DescribeNetworkInterfaces(input *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	output := &ec2.DescribeNetworkInterfacesOutput{}
+	for _, ni := range e.NetworkInterfaces {
+		if !selectedByID(input.NetworkInterfaceIds, ni.NetworkInterfaceId) {
+			continue
+		}
+		match := matchesFilters(input.Filters, ni.TagSet, func(name string) []string {
+			switch name {
+			case "network-interface-id":
+				return []string{aws.StringValue(ni.NetworkInterfaceId)}
+			case "description":
+				return []string{aws.StringValue(ni.Description)}
+			case "subnet-id":
+				return []string{aws.StringValue(ni.SubnetId)}
+			case "vpc-id":
+				return []string{aws.StringValue(ni.VpcId)}
+			case "group-id":
+				var groups []string
+				for _, g := range ni.Groups {
+					groups = append(groups, *g.GroupId)
+				}
+				return groups
+			case "attachment.instance-id":
+				if ni.Attachment != nil {
+					return []string{aws.StringValue(ni.Attachment.InstanceId)}
+				}
+			case "addresses.private-ip-address":
+				var addresses []string
+				for _, a := range ni.PrivateIpAddresses {
+					addresses = append(addresses, *a.PrivateIpAddress)
+				}
+				return addresses
+			case "association.public-ip":
+				if ni.Association != nil {
+					return []string{aws.StringValue(ni.Association.PublicIp)}
+				}
+			}
+			return nil
+		})
+		if match {
+			output.NetworkInterfaces = append(output.NetworkInterfaces, ni)
+		}
+	}
+	return output, nil
+}
+
+func (e *snapshotEC2) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	output := &ec2.DescribeSubnetsOutput{}
+	for _, s := range e.Subnets {
+		if !selectedByID(input.SubnetIds, s.SubnetId) {
+			continue
+		}
+		match := matchesFilters(input.Filters, s.Tags, func(name string) []string {
+			switch name {
+			case "subnet-id":
+				return []string{aws.StringValue(s.SubnetId)}
+			case "vpc-id":
+				return []string{aws.StringValue(s.VpcId)}
+			case "cidr-block":
+				return []string{aws.StringValue(s.CidrBlock)}
+			}
+			return nil
+		})
+		if match {
+			output.Subnets = append(output.Subnets, s)
+		}
+	}
+	err := checkAllFound("InvalidSubnetID.NotFound", input.SubnetIds, func(id string) bool {
+		for _, s := range e.Subnets {
+			if *s.SubnetId == id {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (e *snapshotEC2) DescribeRouteTables(input *ec2.DescribeRouteTablesInput) (*ec2.DescribeRouteTablesOutput, error) {
+	output := &ec2.DescribeRouteTablesOutput{}
+	for _, rt := range e.RouteTables {
+		if !selectedByID(input.RouteTableIds, rt.RouteTableId) {
+			continue
+		}
+		match := matchesFilters(input.Filters, rt.Tags, func(name string) []string {
+			var values []string
+			switch name {
+			case "route-table-id":
+				values = append(values, aws.StringValue(rt.RouteTableId))
+			case "vpc-id":
+				values = append(values, aws.StringValue(rt.VpcId))
+			case "association.subnet-id":
+				for _, a := range rt.Associations {
+					if a.SubnetId != nil {
+						values = append(values, *a.SubnetId)
+					}
+				}
+			case "association.main":
+				for _, a := range rt.Associations {
+					values = append(values, fmt.Sprintf("%v", aws.BoolValue(a.Main)))
+				}
+			}
+			return values
+		})
+		if match {
+			output.RouteTables = append(output.RouteTables, rt)
+		}
+	}
+	return output, nil
+}
+
+func (e *snapshotEC2) DescribeNetworkAcls(input *ec2.DescribeNetworkAclsInput) (*ec2.DescribeNetworkAclsOutput, error) {
+	output := &ec2.DescribeNetworkAclsOutput{}
+	for _, acl := range e.NetworkAcls {
+		if !selectedByID(input.NetworkAclIds, acl.NetworkAclId) {
+			continue
+		}
+		match := matchesFilters(input.Filters, acl.Tags, func(name string) []string {
+			var values []string
+			switch name {
+			case "network-acl-id":
+				values = append(values, aws.StringValue(acl.NetworkAclId))
+			case "vpc-id":
+				values = append(values, aws.StringValue(acl.VpcId))
+			case "association.subnet-id":
+				for _, a := range acl.Associations {
+					values = append(values, aws.StringValue(a.SubnetId))
+				}
+			}
+			return values
+		})
+		if match {
+			output.NetworkAcls = append(output.NetworkAcls, acl)
+		}
+	}
+	return output, nil
+}
+
+func (e *snapshotEC2) DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	output := &ec2.DescribeSecurityGroupsOutput{}
+	for _, sg := range e.SecurityGroups {
+		if !selectedByID(input.GroupIds, sg.GroupId) {
+			continue
+		}
+		match := matchesFilters(input.Filters, sg.Tags, func(name string) []string {
+			switch name {
+			case "group-id":
+				return []string{aws.StringValue(sg.GroupId)}
+			case "group-name":
+				return []string{aws.StringValue(sg.GroupName)}
+			case "vpc-id":
+				return []string{aws.StringValue(sg.VpcId)}
+			}
+			return nil
+		})
+		if match {
+			output.SecurityGroups = append(output.SecurityGroups, sg)
+		}
+	}
+	err := checkAllFound("InvalidGroup.NotFound", input.GroupIds, func(id string) bool {
+		for _, sg := range e.SecurityGroups {
+			if *sg.GroupId == id {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (e *snapshotEC2) DescribeVpcs(input *ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
+	output := &ec2.DescribeVpcsOutput{}
+	for _, vpc := range e.Vpcs {
+		if !selectedByID(input.VpcIds, vpc.VpcId) {
+			continue
+		}
+		match := matchesFilters(input.Filters, vpc.Tags, func(name string) []string {
+			switch name {
+			case "vpc-id":
+				return []string{aws.StringValue(vpc.VpcId)}
+			case "cidr":
+				return []string{aws.StringValue(vpc.CidrBlock)}
+			}
+			return nil
+		})
+		if match {
+			output.Vpcs = append(output.Vpcs, vpc)
+		}
+	}
+	return output, nil
+}
+
+func (e *snapshotEC2) DescribeVpcPeeringConnections(input *ec2.DescribeVpcPeeringConnectionsInput) (*ec2.DescribeVpcPeeringConnectionsOutput, error) {
+	output := &ec2.DescribeVpcPeeringConnectionsOutput{}
+	for _, pcx := range e.VpcPeeringConnections {
+		if selectedByID(input.VpcPeeringConnectionIds, pcx.VpcPeeringConnectionId) {
+			output.VpcPeeringConnections = append(output.VpcPeeringConnections, pcx)
+		}
+	}
+	return output, nil
+}
+
+func (e *snapshotEC2) DescribePrefixLists(input *ec2.DescribePrefixListsInput) (*ec2.DescribePrefixListsOutput, error) {
+	output := &ec2.DescribePrefixListsOutput{}
+	for _, pl := range e.PrefixLists {
+		if selectedByID(input.PrefixListIds, pl.PrefixListId) {
+			output.PrefixLists = append(output.PrefixLists, pl)
+		}
+	}
+	return output, nil
+}
+
+func (e *snapshotEC2) DescribeManagedPrefixLists(input *ec2.DescribeManagedPrefixListsInput) (*ec2.DescribeManagedPrefixListsOutput, error) {
+	output := &ec2.DescribeManagedPrefixListsOutput{}
+	for _, pl := range e.ManagedPrefixLists {
+		if selectedByID(input.PrefixListIds, pl.PrefixListId) {
+			output.PrefixLists = append(output.PrefixLists, pl)
+		}
+	}
+	return output, nil
+}
+
+func (e *snapshotEC2) GetManagedPrefixListEntries(input *ec2.GetManagedPrefixListEntriesInput) (*ec2.GetManagedPrefixListEntriesOutput, error) {
+	entries, ok := e.PrefixListEntries[aws.StringValue(input.PrefixListId)]
+	if !ok {
+		return nil, awserr.New("InvalidPrefixListID.NotFound", fmt.Sprintf("The prefix list ID '%s' does not exist", aws.StringValue(input.PrefixListId)), nil)
+	}
+	return &ec2.GetManagedPrefixListEntriesOutput{Entries: entries}, nil
+}
+
+func (e *snapshotEC2) DescribeTransitGatewayAttachments(input *ec2.DescribeTransitGatewayAttachmentsInput) (*ec2.DescribeTransitGatewayAttachmentsOutput, error) {
+	output := &ec2.DescribeTransitGatewayAttachmentsOutput{}
+	for _, attachment := range e.TransitGatewayAttachments {
+		if !selectedByID(input.TransitGatewayAttachmentIds, attachment.TransitGatewayAttachmentId) {
+			continue
+		}
+		match := matchesFilters(input.Filters, attachment.Tags, func(name string) []string {
+			switch name {
+			case "transit-gateway-id":
+				return []string{aws.StringValue(attachment.TransitGatewayId)}
+			case "resource-id":
+				return []string{aws.StringValue(attachment.ResourceId)}
+			case "resource-type":
+				return []string{aws.StringValue(attachment.ResourceType)}
+			case "state":
+				return []string{aws.StringValue(attachment.State)}
+			}
+			return nil
+		})
+		if match {
+			output.TransitGatewayAttachments = append(output.TransitGatewayAttachments, attachment)
+		}
+	}
+	return output, nil
+}
+
+func (e *snapshotEC2) SearchTransitGatewayRoutes(input *ec2.SearchTransitGatewayRoutesInput) (*ec2.SearchTransitGatewayRoutesOutput, error) {
+	routes, ok := e.TransitGatewayRoutes[aws.StringValue(input.TransitGatewayRouteTableId)]
+	if !ok {
+		return nil, awserr.New("InvalidRouteTableID.NotFound", "The transit gateway route table ID does not exist", nil)
+	}
+	output := &ec2.SearchTransitGatewayRoutesOutput{AdditionalRoutesAvailable: aws.Bool(false)}
+	for _, route := range routes {
+		match := matchesFilters(input.Filters, nil, func(name string) []string {
+			if name == "state" {
+				return []string{aws.StringValue(route.State)}
+			}
+			return nil
+		})
+		if match {
+			output.Routes = append(output.Routes, route)
+		}
+	}
+	return output, nil
+}
+
+// All the results are returned as a single page
+
+func (e *snapshotEC2) DescribeNetworkInterfacesPages(input *ec2.DescribeNetworkInterfacesInput, fn func(*ec2.DescribeNetworkInterfacesOutput, bool) bool) error {
+	output, err := e.DescribeNetworkInterfaces(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (e *snapshotEC2) DescribeSubnetsPages(input *ec2.DescribeSubnetsInput, fn func(*ec2.DescribeSubnetsOutput, bool) bool) error {
+	output, err := e.DescribeSubnets(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (e *snapshotEC2) DescribeRouteTablesPages(input *ec2.DescribeRouteTablesInput, fn func(*ec2.DescribeRouteTablesOutput, bool) bool) error {
+	output, err := e.DescribeRouteTables(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (e *snapshotEC2) DescribeNetworkAclsPages(input *ec2.DescribeNetworkAclsInput, fn func(*ec2.DescribeNetworkAclsOutput, bool) bool) error {
+	output, err := e.DescribeNetworkAcls(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (e *snapshotEC2) DescribeSecurityGroupsPages(input *ec2.DescribeSecurityGroupsInput, fn func(*ec2.DescribeSecurityGroupsOutput, bool) bool) error {
+	output, err := e.DescribeSecurityGroups(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (e *snapshotEC2) DescribeVpcsPages(input *ec2.DescribeVpcsInput, fn func(*ec2.DescribeVpcsOutput, bool) bool) error {
+	output, err := e.DescribeVpcs(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (e *snapshotEC2) DescribeVpcPeeringConnectionsPages(input *ec2.DescribeVpcPeeringConnectionsInput, fn func(*ec2.DescribeVpcPeeringConnectionsOutput, bool) bool) error {
+	output, err := e.DescribeVpcPeeringConnections(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (e *snapshotEC2) DescribePrefixListsPages(input *ec2.DescribePrefixListsInput, fn func(*ec2.DescribePrefixListsOutput, bool) bool) error {
+	output, err := e.DescribePrefixLists(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (e *snapshotEC2) DescribeManagedPrefixListsPages(input *ec2.DescribeManagedPrefixListsInput, fn func(*ec2.DescribeManagedPrefixListsOutput, bool) bool) error {
+	output, err := e.DescribeManagedPrefixLists(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (e *snapshotEC2) GetManagedPrefixListEntriesPages(input *ec2.GetManagedPrefixListEntriesInput, fn func(*ec2.GetManagedPrefixListEntriesOutput, bool) bool) error {
+	output, err := e.GetManagedPrefixListEntries(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (e *snapshotEC2) DescribeTransitGatewayAttachmentsPages(input *ec2.DescribeTransitGatewayAttachmentsInput, fn func(*ec2.DescribeTransitGatewayAttachmentsOutput, bool) bool) error {
+	output, err := e.DescribeTransitGatewayAttachments(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (e *snapshotEC2) DescribeRegions(input *ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
+	output := &ec2.DescribeRegionsOutput{}
+	if selectedByID(input.RegionNames, aws.String(e.Region)) {
+		output.Regions = append(output.Regions, &ec2.Region{RegionName: aws.String(e.Region)})
+	}
+	return output, nil
+}
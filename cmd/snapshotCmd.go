@@ -0,0 +1,68 @@
+// Copyright © 2018 Amit Saha <amitsaha.in@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/spf13/cobra"
+)
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Capture the network state of an account",
+}
+
+var snapshotCaptureCmd = &cobra.Command{
+	Use:   "capture",
+	Short: "Save the network state of an account and region to a file",
+	Long: `Save the instances, network interfaces, subnets, route tables, network ACLs, security groups,
+VPCs, VPC peering connections, prefix lists (including the entries of customer managed ones) and transit
+gateway attachments and routes of an account and region to a file:
+
+	$ yawsi --profile production snapshot capture -o prod.json
+
+The snapshot can then be analysed without access to the account:
+
+	$ yawsi ec2 inspect i-06d80024e0df241da --public-egress --from-snapshot prod.json
+	$ yawsi ec2 inspect connectivity i-06d80024e0df241da --to i-0a1b2c3d4e5f67890 --dport 5432 --protocol tcp --from-snapshot prod.json
+	$ yawsi ec2 inspect routing-tables i-06d80024e0df241da --from-snapshot prod.json
+	$ yawsi vpc list-subnets --vpc-id vpc-20988a4 --from-snapshot prod.json
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(snapshotOutputPath) == 0 {
+			return newUsageError("Specify the file to save the snapshot to via -o")
+		}
+		s, err := captureSnapshot(getClients().EC2(), getClients().STS())
+		if err != nil {
+			return err
+		}
+		s.Region = aws.StringValue(createSession().Config.Region)
+		if err := saveSnapshot(snapshotOutputPath, s); err != nil {
+			return fmt.Errorf("Couldn't save the snapshot: %w", err)
+		}
+		return nil
+	},
+	Args: cobra.NoArgs,
+}
+
+var snapshotOutputPath string
+
+func init() {
+	RootCmd.AddCommand(snapshotCmd)
+	snapshotCmd.AddCommand(snapshotCaptureCmd)
+	snapshotCaptureCmd.Flags().StringVarP(&snapshotOutputPath, "output-file", "o", "", "File to save the snapshot to")
+}
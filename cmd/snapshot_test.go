@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yawsi-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := path.Join(dir, "prod.json")
+
+	c := newConnectivityFixture()
+	// vpc-1 is shared with the account by another one
+	c.ec2.Vpcs = []*ec2.Vpc{{VpcId: aws.String("vpc-1"), CidrBlock: aws.String("10.0.0.0/16"), OwnerId: aws.String("210987654321")}}
+	c.sts.Account = "123456789012"
+	s, err := captureSnapshot(c.EC2(), c.STS())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "123456789012", s.Account)
+	assert.Len(t, s.Instances, 2)
+	s.Region = "eu-west-1"
+	if !assert.NoError(t, saveSnapshot(filePath, s)) {
+		return
+	}
+
+	s, err = loadSnapshot(filePath)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, resourceLocation{Account: "123456789012", Region: "eu-west-1"}, s.resourceLocation)
+
+	// The analysis of the snapshot is the same as of the live state
+	live := loadInstanceState(t, c, "i-src")
+	offlineClients, err := newSnapshotClients(s)
+	if !assert.NoError(t, err) {
+		return
+	}
+	offline := loadInstanceState(t, offlineClients, "i-src")
+	assert.Equal(t, live.SubnetCIDRs, offline.SubnetCIDRs)
+	assert.Equal(t, live.PrivateIPAddresses, offline.PrivateIPAddresses)
+	assert.Equal(t, len(live.SecurityGroupRules), len(offline.SecurityGroupRules))
+	if assert.Len(t, offline.Routes, 1) {
+		assert.Equal(t, live.Routes[0].RouteTableId, offline.Routes[0].RouteTableId)
+	}
+
+	// The calls the snapshot can't answer fail without talking to AWS
+	_, err = offlineClients.EC2().DescribeImages(&ec2.DescribeImagesInput{})
+	assert.Equal(t, exitUsage, exitCode(err))
+	_, err = offlineClients.RDS().DescribeDBInstances(&rds.DescribeDBInstancesInput{})
+	assert.Equal(t, exitUsage, exitCode(err))
+
+	_, err = loadSnapshot(path.Join(dir, "missing.json"))
+	assert.Equal(t, exitNotFound, exitCode(err))
+	ioutil.WriteFile(filePath, []byte(`{"apiVersion": "yawsi/v1", "kind": "VpcList"}`), 0600)
+	_, err = loadSnapshot(filePath)
+	assert.Equal(t, exitUsage, exitCode(err))
+}
+
+func TestCaptureSnapshotRetry(t *testing.T) {
+	defer func(backoff time.Duration) { throttleBackoff = backoff }(throttleBackoff)
+	throttleBackoff = 0
+
+	c := newConnectivityFixture()
+	c.ec2.TransitGatewayAttachments = []*ec2.TransitGatewayAttachment{{
+		TransitGatewayAttachmentId: aws.String("tgw-attach-1"),
+		TransitGatewayId:           aws.String("tgw-1"),
+		ResourceId:                 aws.String("vpc-1"),
+		ResourceType:               aws.String("vpc"),
+		State:                      aws.String("available"),
+	}}
+
+	// The page described before the throttling isn't kept twice
+	svc := &pageThrottlingEC2{EC2API: c.EC2()}
+	s, err := captureSnapshot(svc, c.STS())
+	if assert.NoError(t, err) {
+		assert.Len(t, s.TransitGatewayAttachments, 1)
+	}
+	assert.True(t, svc.throttled)
+}
+
+func TestAddSnapshotFlagKeepsPreRun(t *testing.T) {
+	defer func() { snapshotFilePath = "" }()
+
+	var ran []string
+	cmd := &cobra.Command{
+		Use:     "test",
+		PreRunE: func(cmd *cobra.Command, args []string) error { ran = append(ran, "PreRunE"); return nil },
+		RunE:    func(cmd *cobra.Command, args []string) error { return nil },
+	}
+	addSnapshotFlag(cmd)
+	cmd.SetArgs([]string{"--from-snapshot", path.Join(os.TempDir(), "yawsi-missing-snapshot.json")})
+	err := cmd.Execute()
+	assert.Equal(t, []string{"PreRunE"}, ran)
+	assert.Equal(t, exitNotFound, exitCode(err))
+
+	ran = nil
+	cmd = &cobra.Command{
+		Use:    "test",
+		PreRun: func(cmd *cobra.Command, args []string) { ran = append(ran, "PreRun") },
+		RunE:   func(cmd *cobra.Command, args []string) error { return nil },
+	}
+	addSnapshotFlag(cmd)
+	cmd.SetArgs([]string{})
+	assert.NoError(t, cmd.Execute())
+	assert.Equal(t, []string{"PreRun"}, ran)
+}
@@ -3,7 +3,6 @@ package cmd
 import (
 	"errors"
 	"fmt"
-	"log"
 	"net"
 	"os"
 	"path"
@@ -73,7 +72,7 @@ func renderRule(rule naclRule) string {
 	return rendered
 }
 
-func generateTfNaclRules(naclRules []naclRule) {
+func generateTfNaclRules(naclRules []naclRule) error {
 
 	funcMap := template.FuncMap{
 		"getResourceName": getResourceName,
@@ -92,31 +91,30 @@ func generateTfNaclRules(naclRules []naclRule) {
 {{end}}
 `)
 	if err != nil {
-		log.Fatal("Error parsing template: ", err)
-
+		return fmt.Errorf("Error parsing template: %w", err)
 	}
 
 	outputFile, err := os.Create(path.Join(".", fmt.Sprintf("%s_nacls.tf", subnetName)))
 	if err != nil {
-		log.Fatal("Error creating output file", err)
+		return fmt.Errorf("Error creating output file: %w", err)
 	}
+	defer outputFile.Close()
 
 	err = tmpl.Execute(outputFile, naclRules)
 	if err != nil {
-		log.Fatal("Error executing template: ", err)
-
+		return fmt.Errorf("Error executing template: %w", err)
 	}
+	return nil
 }
 
 var tfNaclCmd = &cobra.Command{
 	Use:   "generate-nacl-rules",
 	Short: "General NACL rules",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		var naclRules naclRulesSpec
 		if _, err := toml.DecodeFile(naclSpecPath, &naclRules); err != nil {
-			fmt.Println("Error", err)
-			return
+			return newUsageError("Couldn't read the NACL spec: %v", err)
 		}
 		subnetName = naclRules.SubnetName
 		// We use the index only pattern here so that
@@ -124,11 +122,11 @@ var tfNaclCmd = &cobra.Command{
 		// static value for NetworkAclID
 		for i := range naclRules.Rules {
 			if result, err := naclRules.Rules[i].Validate(); !result {
-				log.Fatalf("Invalid rule specification: %#v\n%v\n", naclRules.Rules[i], err)
+				return newUsageError("Invalid rule specification: %#v\n%v", naclRules.Rules[i], err)
 			}
 			naclRules.Rules[i].NetworkACLID = fmt.Sprintf(`${lookup(local.network_acl_ids_map, "%s")}`, subnetName)
 		}
-		generateTfNaclRules(naclRules.Rules)
+		return generateTfNaclRules(naclRules.Rules)
 	},
 }
 
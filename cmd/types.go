@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -13,51 +14,106 @@ var debugOutput bool
 // RouteContainer represents a set of routes and whether it
 // is associated to the VPC main route table or not
 type RouteContainer struct {
-	Main         bool
-	RouteTableId string
-	Routes       []*ec2.Route
+	Main         bool         `json:"main" yaml:"main"`
+	RouteTableId string       `json:"routeTableId" yaml:"routeTableId"`
+	Routes       []*ec2.Route `json:"routes" yaml:"routes"`
+	// The subnet the route table was looked up for, see getRoutes
+	SubnetID string `json:"subnetId,omitempty" yaml:"subnetId,omitempty"`
 }
 
 // Embdes ec2.IpPermission and adds an additional field
 // to mark whether this is an inbound or outbound security group rule
 type SecurityGroupRule struct {
+	groupID    string
 	permission *ec2.IpPermission
 	egress     bool
 }
 
+type securityGroupRuleOutput struct {
+	Egress     bool                `json:"egress" yaml:"egress"`
+	Permission *ipPermissionOutput `json:"permission" yaml:"permission"`
+}
+
+// MarshalJSON exposes the unexported fields in JSON output
+func (r *SecurityGroupRule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(securityGroupRuleOutput{Egress: r.egress, Permission: newIPPermissionOutput(r.permission)})
+}
+
+// MarshalYAML exposes the unexported fields in YAML output
+func (r *SecurityGroupRule) MarshalYAML() (interface{}, error) {
+	return securityGroupRuleOutput{Egress: r.egress, Permission: newIPPermissionOutput(r.permission)}, nil
+}
+
 type instanceState struct {
-	InstanceId string
-	IAMProfile string
-	State      string
-	LaunchTime *time.Time
-	KeyName    string
-	Name       string
+	resourceLocation `yaml:",inline"`
+	InstanceId       string     `json:"instanceId" yaml:"instanceId"`
+	IAMProfile       string     `json:"iamProfile,omitempty" yaml:"iamProfile,omitempty"`
+	State            string     `json:"state" yaml:"state"`
+	LaunchTime       *time.Time `json:"launchTime,omitempty" yaml:"launchTime,omitempty"`
+	KeyName          string     `json:"keyName,omitempty" yaml:"keyName,omitempty"`
+	Name             string     `json:"name" yaml:"name"`
 
-	Tags               []*ec2.Tag
-	VpcID              string
-	PublicIP           string
-	SecurityGroups     []*ec2.GroupIdentifier
-	SecurityGroupRules []*SecurityGroupRule
+	Tags               []*ec2.Tag             `json:"tags,omitempty" yaml:"tags,omitempty"`
+	VpcID              string                 `json:"vpcId,omitempty" yaml:"vpcId,omitempty"`
+	PublicIP           string                 `json:"publicIp,omitempty" yaml:"publicIp,omitempty"`
+	SecurityGroups     []*ec2.GroupIdentifier `json:"securityGroups,omitempty" yaml:"securityGroups,omitempty"`
+	SecurityGroupRules []*SecurityGroupRule   `json:"securityGroupRules,omitempty" yaml:"securityGroupRules,omitempty"`
 
-	SubnetIds []string
+	SubnetIds []string `json:"subnetIds,omitempty" yaml:"subnetIds,omitempty"`
 
 	// Map of network interface id to subnet id
-	NetworkInterfaces map[string]string
+	NetworkInterfaces map[string]string `json:"networkInterfaces,omitempty" yaml:"networkInterfaces,omitempty"`
+	// Map of network interface id to its subnet, addresses and security
+	// groups, see forInterface
+	Interfaces map[string]*interfaceState `json:"interfaces,omitempty" yaml:"interfaces,omitempty"`
+	// Map of IP address to the security groups of the network interface with
+	// the address
+	AddressSecurityGroups map[string][]*ec2.GroupIdentifier `json:"addressSecurityGroups,omitempty" yaml:"addressSecurityGroups,omitempty"`
 
-	PrivateIPAddresses []string
+	PrivateIPAddresses []string `json:"privateIpAddresses,omitempty" yaml:"privateIpAddresses,omitempty"`
+	IPv6Addresses      []string `json:"ipv6Addresses,omitempty" yaml:"ipv6Addresses,omitempty"`
 
 	// Map of subnet ID to SubnetCIDR
-	SubnetCIDRs map[string]string
+	SubnetCIDRs map[string]string `json:"subnetCidrs,omitempty" yaml:"subnetCidrs,omitempty"`
+	// Map of subnet ID to the IPv6 CIDR of dual-stack subnets
+	SubnetIPv6CIDRs map[string]string `json:"subnetIpv6Cidrs,omitempty" yaml:"subnetIpv6Cidrs,omitempty"`
 	// Map of subnet ID to NetworkACLs
-	NetworkAcls map[string]*ec2.NetworkAcl
+	NetworkAcls map[string]*ec2.NetworkAcl `json:"networkAcls,omitempty" yaml:"networkAcls,omitempty"`
+
+	Routes []*RouteContainer `json:"routes,omitempty" yaml:"routes,omitempty"`
+
+	// Map of prefix list ID to CIDR blocks, for the prefix lists referenced by
+	// the security group rules and the routes
+	PrefixLists map[string][]string `json:"prefixLists,omitempty" yaml:"prefixLists,omitempty"`
+
+	// Map of VPC peering connection ID to the peering connections the routes
+	// use
+	PeeringConnections map[string]*ec2.VpcPeeringConnection `json:"peeringConnections,omitempty" yaml:"peeringConnections,omitempty"`
+	// Map of transit gateway ID to the attachment of the VPC, for the transit
+	// gateways the routes use
+	TransitGatewayAttachments map[string]*ec2.TransitGatewayAttachment `json:"transitGatewayAttachments,omitempty" yaml:"transitGatewayAttachments,omitempty"`
+	// Map of transit gateway route table ID to its routes, for the route
+	// tables associated with the attachments
+	TransitGatewayRoutes map[string][]*ec2.TransitGatewayRoute `json:"transitGatewayRoutes,omitempty" yaml:"transitGatewayRoutes,omitempty"`
+}
 
-	Routes []*RouteContainer
+// interfaceState is the subnet, addresses and security groups of one
+// network interface of an instance
+type interfaceState struct {
+	SubnetID           string                 `json:"subnetId" yaml:"subnetId"`
+	DeviceIndex        int64                  `json:"deviceIndex" yaml:"deviceIndex"`
+	PublicIP           string                 `json:"publicIp,omitempty" yaml:"publicIp,omitempty"`
+	PrivateIPAddresses []string               `json:"privateIpAddresses,omitempty" yaml:"privateIpAddresses,omitempty"`
+	IPv6Addresses      []string               `json:"ipv6Addresses,omitempty" yaml:"ipv6Addresses,omitempty"`
+	SecurityGroups     []*ec2.GroupIdentifier `json:"securityGroups,omitempty" yaml:"securityGroups,omitempty"`
 }
 
 type checkResult struct {
-	Result      bool
-	DisplayText string
-	Metadata    map[string]interface{}
+	Result      bool                   `json:"result" yaml:"result"`
+	DisplayText string                 `json:"displayText" yaml:"displayText"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	// Where on the path of the traffic the check is, see explain.go
+	Stage string `json:"stage,omitempty" yaml:"stage,omitempty"`
 }
 
 func newCheckResult() checkResult {
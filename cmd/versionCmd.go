@@ -6,6 +6,8 @@ import (
 	"github.com/spf13/cobra"
 )
 
+const version = "v0.2.3"
+
 func init() {
 	RootCmd.AddCommand(versionCmd)
 }
@@ -13,7 +15,11 @@ func init() {
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print the version number of yawsi",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Yet Another AWS CLI v0.2.3")
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if isTableOutput() {
+			fmt.Fprintf(outputWriter, "Yet Another AWS CLI %s\n", version)
+			return nil
+		}
+		return renderItems("Version", map[string]string{"version": version}, nil)
 	},
 }
@@ -16,36 +16,59 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"text/tabwriter"
 
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/spf13/cobra"
 )
 
-func listVpcDetails(vpcs *ec2.DescribeVpcsOutput) {
-	var vpcName string
+type vpcOutput struct {
+	resourceLocation `yaml:",inline"`
+	Name             string            `json:"name" yaml:"name"`
+	VpcId            string            `json:"vpcId" yaml:"vpcId"`
+	CidrBlock        string            `json:"cidrBlock" yaml:"cidrBlock"`
+	IsDefault        bool              `json:"isDefault" yaml:"isDefault"`
+	Tags             map[string]string `json:"tags" yaml:"tags"`
+}
 
-	w := new(tabwriter.Writer)
-	w.Init(os.Stdout, 35, 0, 1, ' ', 0)
+func buildVpcList(loc resourceLocation, vpcs []*ec2.Vpc) ([]vpcOutput, *tableOutput) {
+	items := []vpcOutput{}
+	table := newTableOutput("Name", "VPCID", "CIDRBlock", "Default?", "Tags").in(loc)
 
-	fmt.Fprintln(w, "Name  \tVPCID  \tCIDRBlock\tDefault?\tTags\t")
-	fmt.Fprintln(w, "-----\t----------\t--------\t------\t---------\t")
-	for _, v := range vpcs.Vpcs {
+	for _, v := range vpcs {
+		vpcName := ""
 		for _, tag := range v.Tags {
 			if *tag.Key == "Name" {
 				vpcName = *tag.Value
 			}
 		}
 
-		fmt.Fprintf(w, "%s\t", vpcName)
-		fmt.Fprintf(w, "%s\t", *v.VpcId)
-		fmt.Fprintf(w, "%s\t", *v.CidrBlock)
-		fmt.Fprintf(w, "%v\t", *v.IsDefault)
-		fmt.Fprintf(w, "%s\t\n", getTagsAsString(v.Tags, " "))
+		items = append(items, vpcOutput{
+			resourceLocation: loc,
+			Name:             vpcName,
+			VpcId:            *v.VpcId,
+			CidrBlock:        *v.CidrBlock,
+			IsDefault:        *v.IsDefault,
+			Tags:             tagsToMap(v.Tags),
+		})
+		table.addRow(vpcName, *v.VpcId, *v.CidrBlock, fmt.Sprintf("%v", *v.IsDefault), getTagsAsString(v.Tags, " "))
 	}
-	fmt.Fprintln(w)
-	w.Flush()
+	return items, table
+}
+
+func listVpcDetails(vpcs *ec2.DescribeVpcsOutput) error {
+	items, table := buildVpcList(resourceLocation{}, vpcs.Vpcs)
+	return renderItems("VpcList", items, table)
+}
+
+func listAllVpcs() error {
+	return renderAll("VpcList", func(loc resourceLocation, c awsClientProvider) (interface{}, *tableOutput, error) {
+		result, err := c.EC2().DescribeVpcs(&ec2.DescribeVpcsInput{})
+		if err != nil {
+			return nil, nil, err
+		}
+		items, table := buildVpcList(loc, result.Vpcs)
+		return items, table, nil
+	})
 }
 
 // listVpcsCmd represents the list vpc command
@@ -61,16 +84,30 @@ var listVpcsCmd = &cobra.Command{
 	To show further details in an interactive window:
 
 	    $ yawsi vpc list --details
+
+	To list the VPCs in all the regions enabled for the account:
+
+	    $ yawsi vpc list --all-regions
 	
 	`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		if isMultiTarget() {
+			if vpcDetails {
+				return newUsageError("--details cannot be used when listing across accounts or regions")
+			}
+			return listAllVpcs()
+		}
 
-		if !vpcDetails {
-			vpcs := getVpcs()
-			listVpcDetails(vpcs)
-		} else {
-			displayVPCDetails()
+		svc := getClients().EC2()
+		if vpcDetails {
+			return displayVPCDetails(getClients().CachedEC2())
+		}
+		vpcs, err := getVpcs(svc)
+		if err != nil {
+			return err
 		}
+		return listVpcDetails(vpcs)
 	},
 }
 
@@ -79,4 +116,5 @@ var vpcDetails bool
 func init() {
 	vpcCmd.AddCommand(listVpcsCmd)
 	listVpcsCmd.Flags().BoolVarP(&vpcDetails, "details", "", false, "Show VPC details")
+	addListingFlags(listVpcsCmd)
 }
@@ -16,14 +16,11 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 
 	"html/template"
-	"log"
 	"strconv"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/spf13/cobra"
 )
@@ -52,7 +49,7 @@ func getIcmpType(icmpTypeCode *ec2.IcmpTypeCode) string {
 	return strconv.FormatInt(*icmpTypeCode.Type, 10)
 }
 
-func outputTerraformInline(entries []*ec2.NetworkAclEntry) {
+func outputTerraformInline(entries []*ec2.NetworkAclEntry) error {
 
 	egressRule := `
 egress {
@@ -84,15 +81,13 @@ ingress {
 	egressTmpl := template.New("egress").Funcs(funcMap)
 	egressTmpl, err := egressTmpl.Parse(egressRule)
 	if err != nil {
-		log.Fatal("Error Parsing template: ", err)
-		return
+		return fmt.Errorf("Error parsing template: %w", err)
 	}
 
 	ingressTmpl := template.New("ingress").Funcs(funcMap)
 	ingressTmpl, err = ingressTmpl.Parse(ingressRule)
 	if err != nil {
-		log.Fatal("Error Parsing template: ", err)
-		return
+		return fmt.Errorf("Error parsing template: %w", err)
 	}
 
 	for _, entry := range entries {
@@ -102,23 +97,22 @@ ingress {
 			continue
 		}
 		if *entry.Egress {
-			err1 := egressTmpl.Execute(os.Stdout, entry)
+			err1 := egressTmpl.Execute(outputWriter, entry)
 			if err1 != nil {
-				log.Fatal("Error executing template: ", err1)
-
+				return fmt.Errorf("Error executing template: %w", err1)
 			}
 		} else {
 
-			err1 := ingressTmpl.Execute(os.Stdout, entry)
+			err1 := ingressTmpl.Execute(outputWriter, entry)
 			if err1 != nil {
-				log.Fatal("Error executing template: ", err1)
-
+				return fmt.Errorf("Error executing template: %w", err1)
 			}
 		}
 	}
+	return nil
 }
 
-func outputTerraformResource(aclId string, entries []*ec2.NetworkAclEntry) {
+func outputTerraformResource(aclId string, entries []*ec2.NetworkAclEntry) error {
 
 	type Rule struct {
 		NetworkACLId string
@@ -127,7 +121,7 @@ func outputTerraformResource(aclId string, entries []*ec2.NetworkAclEntry) {
 
 	resource := `
 resource "aws_network_acl_rule" "rule_{{ .RuleNumber }}" {
-  network_acl_id = "{{ .NetworkACLId }}
+  network_acl_id = "{{ .NetworkACLId }}"
   egress         = {{ .Egress }}
   protocol   = "{{.Protocol }}"
   rule_number    = "{{ .RuleNumber }}"
@@ -152,8 +146,7 @@ resource "aws_network_acl_rule" "rule_{{ .RuleNumber }}" {
 	tmpl := template.New("resource").Funcs(funcMap)
 	tmpl, err := tmpl.Parse(resource)
 	if err != nil {
-		log.Fatal("Error Parsing template: ", err)
-		return
+		return fmt.Errorf("Error parsing template: %w", err)
 	}
 
 	for _, entry := range entries {
@@ -167,12 +160,62 @@ resource "aws_network_acl_rule" "rule_{{ .RuleNumber }}" {
 			NetworkAclEntry: entry,
 		}
 
-		err1 := tmpl.Execute(os.Stdout, rule)
+		err1 := tmpl.Execute(outputWriter, rule)
 		if err1 != nil {
-			log.Fatal("Error executing template: ", err1)
+			return fmt.Errorf("Error executing template: %w", err1)
+		}
+
+	}
+	return nil
+}
+
+type naclEntryOutput struct {
+	RuleNumber    int64  `json:"ruleNumber" yaml:"ruleNumber"`
+	Egress        bool   `json:"egress" yaml:"egress"`
+	Protocol      string `json:"protocol" yaml:"protocol"`
+	RuleAction    string `json:"ruleAction" yaml:"ruleAction"`
+	CidrBlock     string `json:"cidrBlock,omitempty" yaml:"cidrBlock,omitempty"`
+	Ipv6CidrBlock string `json:"ipv6CidrBlock,omitempty" yaml:"ipv6CidrBlock,omitempty"`
+	FromPort      int64  `json:"fromPort" yaml:"fromPort"`
+	ToPort        int64  `json:"toPort" yaml:"toPort"`
+}
+
+func displayNACLEntries(entries []*ec2.NetworkAclEntry) error {
+	items := []naclEntryOutput{}
+	table := newTableOutput("RuleNumber", "Egress", "Protocol", "Action", "CIDRBlock", "Ports")
+
+	for _, entry := range entries {
+		item := naclEntryOutput{
+			RuleNumber:    *entry.RuleNumber,
+			Egress:        *entry.Egress,
+			Protocol:      protocolMapping[*entry.Protocol],
+			RuleAction:    *entry.RuleAction,
+			CidrBlock:     aws.StringValue(entry.CidrBlock),
+			Ipv6CidrBlock: aws.StringValue(entry.Ipv6CidrBlock),
+		}
+		if len(item.Protocol) == 0 {
+			item.Protocol = *entry.Protocol
+		}
+		if entry.PortRange != nil {
+			item.FromPort = *entry.PortRange.From
+			item.ToPort = *entry.PortRange.To
 		}
+		items = append(items, item)
 
+		cidr := item.CidrBlock
+		if len(cidr) == 0 {
+			cidr = item.Ipv6CidrBlock
+		}
+		table.addRow(
+			fmt.Sprintf("%d", item.RuleNumber),
+			fmt.Sprintf("%v", item.Egress),
+			item.Protocol,
+			item.RuleAction,
+			cidr,
+			getPortFrom(entry.PortRange)+"-"+getPortTo(entry.PortRange),
+		)
 	}
+	return renderItems("NetworkAclEntryList", items, table)
 }
 
 var listNACLEntriesCmd = &cobra.Command{
@@ -193,10 +236,12 @@ var listNACLEntriesCmd = &cobra.Command{
 
 
 	`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(naclID) == 0 {
-			cmd.Usage()
-			os.Exit(1)
+			return newUsageError("Specify the Network ACL via --nacl-id")
+		}
+		if len(outputFormat) != 0 && outputFormat != "tf_inline" && outputFormat != "tf_resource" {
+			return newUsageError("Invalid output format specified: %s", outputFormat)
 		}
 		input := &ec2.DescribeNetworkAclsInput{
 			NetworkAclIds: []*string{
@@ -204,35 +249,21 @@ var listNACLEntriesCmd = &cobra.Command{
 			},
 		}
 
-		sess := createSession()
-		svc := ec2.New(sess)
+		svc := getClients().EC2()
 		result, err := svc.DescribeNetworkAcls(input)
 		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok {
-				switch aerr.Code() {
-				default:
-					fmt.Println(aerr.Error())
-				}
-			} else {
-				// Print the error, cast err to awserr.Error to get the Code and
-				// Message from an error.
-				fmt.Println(err.Error())
-			}
-		} else {
-			if len(result.NetworkAcls) == 1 {
-				if len(outputFormat) == 0 {
-					log.Printf("%v\n", result.NetworkAcls[0].Entries)
-				} else {
-					if outputFormat == "tf_inline" {
-						outputTerraformInline(result.NetworkAcls[0].Entries)
-					} else if outputFormat == "tf_resource" {
-						outputTerraformResource(naclID, result.NetworkAcls[0].Entries)
-					} else {
-						log.Fatal("Invalid output format specified")
-					}
-				}
-			}
+			return fmt.Errorf("Couldn't describe the Network ACL: %w", err)
+		}
+		if len(result.NetworkAcls) != 1 {
+			return newNotFoundError("Network ACL %s not found", naclID)
+		}
+		switch outputFormat {
+		case "tf_inline":
+			return outputTerraformInline(result.NetworkAcls[0].Entries)
+		case "tf_resource":
+			return outputTerraformResource(naclID, result.NetworkAcls[0].Entries)
 		}
+		return displayNACLEntries(result.NetworkAcls[0].Entries)
 	},
 }
 
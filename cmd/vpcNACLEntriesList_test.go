@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputTerraformResource(t *testing.T) {
+	c := newConnectivityFixture()
+
+	result, err := c.EC2().DescribeNetworkAcls(&ec2.DescribeNetworkAclsInput{
+		NetworkAclIds: []*string{aws.String("acl-b")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := captureOutput(outputTable, func() {
+		assert.NoError(t, outputTerraformResource("acl-b", result.NetworkAcls[0].Entries))
+	})
+
+	assert.Contains(t, output, `resource "aws_network_acl_rule" "rule_100"`)
+	assert.Contains(t, output, `network_acl_id = "acl-b"`)
+	assert.Contains(t, output, `cidr_block = "10.0.1.0/24"`)
+	// The default rules are managed by AWS
+	assert.NotContains(t, output, "32767")
+}
@@ -15,36 +15,35 @@
 package cmd
 
 import (
-	"fmt"
-	"os"
-
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/spf13/cobra"
-
-	"text/tabwriter"
 )
 
-func getSubnetType(subnetID *string) string {
-	routes := getRoutes(*subnetID)
+func getSubnetType(svc ec2iface.EC2API, subnetID *string) (string, error) {
+	routes, err := getRoutes(svc, *subnetID)
+	if err != nil {
+		return "", err
+	}
 	for _, route := range routes {
 		for _, r := range route.Routes {
 			if r.DestinationCidrBlock != nil && *r.DestinationCidrBlock == "0.0.0.0/0" {
 				if r.GatewayId != nil && len(*r.GatewayId) != 0 {
-					return "public"
+					return "public", nil
 				}
 
 				if r.NetworkInterfaceId != nil && len(*r.NetworkInterfaceId) > 0 && strings.HasPrefix(*r.NetworkInterfaceId, "eni-") {
 					// TODO: would be nice to return the NAT instance ID?
-					return "private"
+					return "private", nil
 				}
 			}
 		}
 	}
 	// default to private considering the absence of a route to 0.0.0.0/0 CIDR
-	return "private"
+	return "private", nil
 }
 
 func getSubnetName(tags []*ec2.Tag) string {
@@ -56,23 +55,66 @@ func getSubnetName(tags []*ec2.Tag) string {
 	return "Subnet Name"
 }
 
-func displaySubnetDetails(subnets []*ec2.Subnet) {
-	w := new(tabwriter.Writer)
+type subnetOutput struct {
+	resourceLocation `yaml:",inline"`
+	Name             string            `json:"name" yaml:"name"`
+	SubnetId         string            `json:"subnetId" yaml:"subnetId"`
+	CidrBlock        string            `json:"cidrBlock" yaml:"cidrBlock"`
+	SubnetType       string            `json:"subnetType" yaml:"subnetType"`
+	Tags             map[string]string `json:"tags" yaml:"tags"`
+}
+
+func buildSubnetList(svc ec2iface.EC2API, loc resourceLocation, subnets []*ec2.Subnet) ([]subnetOutput, *tableOutput, error) {
+	items := []subnetOutput{}
+	table := newTableOutput("Name", "SubnetID", "CIDRBlock", "SubnetType", "Tags").in(loc)
 
-	// Format in tab-separated columns with a tab stop of 8.
-	//w.Init(os.Stdout, 0, 40, 0, '\t', tabwriter.AlignRight)
-	w.Init(os.Stdout, 35, 0, 1, ' ', 0)
-	fmt.Fprintln(w, "Name                  \tSubnetID  \tCIDRBlock\tSubnetType\tTags\t")
-	fmt.Fprintln(w, "---------------------\t----------\t--------\t---------\t------\t")
 	for _, subnet := range subnets {
-		fmt.Fprintf(w, "%s\t", getSubnetName(subnet.Tags))
-		fmt.Fprintf(w, "%s\t", *subnet.SubnetId)
-		fmt.Fprintf(w, "%s\t", *subnet.CidrBlock)
-		fmt.Fprintf(w, "%s\t", getSubnetType(subnet.SubnetId))
-		fmt.Fprintf(w, "%s\t\n", getTagsAsString(subnet.Tags, " "))
+		subnetType, err := getSubnetType(svc, subnet.SubnetId)
+		if err != nil {
+			return nil, nil, err
+		}
+		item := subnetOutput{
+			resourceLocation: loc,
+			Name:             getSubnetName(subnet.Tags),
+			SubnetId:         *subnet.SubnetId,
+			CidrBlock:        *subnet.CidrBlock,
+			SubnetType:       subnetType,
+			Tags:             tagsToMap(subnet.Tags),
+		}
+		items = append(items, item)
+		table.addRow(item.Name, item.SubnetId, item.CidrBlock, item.SubnetType, getTagsAsString(subnet.Tags, " "))
 	}
-	fmt.Fprintln(w)
-	w.Flush()
+	return items, table, nil
+}
+
+func displaySubnetDetails(svc ec2iface.EC2API, subnets []*ec2.Subnet) error {
+	items, table, err := buildSubnetList(svc, resourceLocation{}, subnets)
+	if err != nil {
+		return err
+	}
+	return renderItems("SubnetList", items, table)
+}
+
+// listAllSubnets lists the subnets in the VPC, or all the subnets when
+// no VPC is specified, in each region
+func listAllSubnets(vpcID string) error {
+	return renderAll("SubnetList", func(loc resourceLocation, c awsClientProvider) (interface{}, *tableOutput, error) {
+		svc := c.EC2()
+		input := &ec2.DescribeSubnetsInput{}
+		if len(vpcID) != 0 {
+			input.Filters = []*ec2.Filter{
+				{
+					Name:   aws.String("vpc-id"),
+					Values: []*string{aws.String(vpcID)},
+				},
+			}
+		}
+		result, err := svc.DescribeSubnets(input)
+		if err != nil {
+			return nil, nil, err
+		}
+		return buildSubnetList(svc, loc, result.Subnets)
+	})
 }
 
 // listAsgCmd represents the listAsg command
@@ -87,11 +129,28 @@ var listSubnetsCmd = &cobra.Command{
 		
 	To list subnets in a specific VPC:
 
-	    $ yawsi vpc list-subnets --vpc-id <vpc-id>	
+	    $ yawsi vpc list-subnets --vpc-id <vpc-id>
+
+	To list all the subnets in specific regions:
+
+	    $ yawsi vpc list-subnets --regions us-east-1,eu-west-1
+
+	To list the subnets in a snapshot created via "yawsi snapshot capture":
+
+	    $ yawsi vpc list-subnets --vpc-id <vpc-id> --from-snapshot prod.json
 	`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if isMultiTarget() {
+			return listAllSubnets(vpcId)
+		}
+
+		svc := getClients().EC2()
 		if len(vpcId) == 0 {
-			vpcId = selectVPCInteractive()
+			var err error
+			vpcId, err = selectVPCInteractive(getClients().CachedEC2())
+			if err != nil {
+				return err
+			}
 		}
 		input := &ec2.DescribeSubnetsInput{
 			Filters: []*ec2.Filter{
@@ -104,8 +163,11 @@ var listSubnetsCmd = &cobra.Command{
 			},
 		}
 
-		subnets := getSubnets(input)
-		displaySubnetDetails(subnets)
+		subnets, err := getSubnets(svc, input)
+		if err != nil {
+			return err
+		}
+		return displaySubnetDetails(svc, subnets)
 	},
 }
 
@@ -114,4 +176,7 @@ var vpcId string
 func init() {
 	vpcCmd.AddCommand(listSubnetsCmd)
 	listSubnetsCmd.Flags().StringVarP(&vpcId, "vpc-id", "", "", "List subnets in a specific VPC")
+	listSubnetsCmd.MarkFlagCustom("vpc-id", "__yawsi_vpc_ids")
+	addListingFlags(listSubnetsCmd)
+	addSnapshotFlag(listSubnetsCmd)
 }
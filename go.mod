@@ -2,13 +2,14 @@ module github.com/amitsaha/yawsi
 
 require (
 	github.com/BurntSushi/toml v0.3.1
-	github.com/aws/aws-sdk-go v1.23.7
+	github.com/aws/aws-sdk-go v1.55.8
 	github.com/cloudflare/cloudflare-go v0.10.1
 	github.com/cpuguy83/go-md2man v1.0.10 // indirect
 	github.com/fatih/color v1.7.0
 	github.com/go-ini/ini v1.32.0 // indirect
 	github.com/gopherjs/gopherjs v0.0.0-20181103185306-d547d1d9531e // indirect
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/jtolds/gls v4.2.1+incompatible // indirect
 	github.com/ktr0731/go-fuzzyfinder v0.1.3-0.20190810113839-b156d38c0f2b
 	github.com/mattn/go-colorable v0.0.9 // indirect
@@ -24,5 +25,5 @@ require (
 	golang.org/x/net v0.0.0-20190213061140-3a22650c66bd // indirect
 	golang.org/x/text v0.0.0-20171227012246-e19ae1496984 // indirect
 	gopkg.in/ini.v1 v1.42.0 // indirect
-	gopkg.in/yaml.v2 v2.2.2
+	gopkg.in/yaml.v2 v2.2.8
 )